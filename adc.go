@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ads1115"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+)
+
+// adcConfig is one ADS1115 or ADS1015 in the adcs section of the config file.
+type adcConfig struct {
+	Channel   *int             `yaml:"channel" doc:"mux channel; omitted without a mux"`
+	Chip      string           `yaml:"chip" doc:"ads1115 (default) or ads1015"`
+	Address   string           `yaml:"address" doc:"0x48 (default) to 0x4B"`
+	Name      string           `yaml:"name" doc:"friendly device label, replacing the generated one"`
+	FullScale float64          `yaml:"full_scale" doc:"gain, as the full-scale range in Volts: 6.144, 4.096, 2.048 (default), 1.024, 0.512 or 0.256"`
+	DataRate  int              `yaml:"data_rate" doc:"samples per second; 128 (ADS1115) or 1600 (ADS1015) by default"`
+	Inputs    []adcInputConfig `yaml:"inputs" doc:"the inputs to poll, one entry each"`
+	Interval  time.Duration    `yaml:"interval" doc:"as in sensors"`
+	Jitter    time.Duration    `yaml:"jitter" doc:"as in sensors"`
+}
+
+// adcInputConfig is one polled single-ended input. Its value is the measured
+// voltage times scale, plus offset.
+type adcInputConfig struct {
+	Input  int      `yaml:"input" doc:"0 to 3, for AIN0 to AIN3"`
+	Name   string   `yaml:"name" doc:"input label; ain<input> by default"`
+	Scale  *float64 `yaml:"scale" doc:"e.g. 11 for a 100k/10k voltage divider; 1 by default"`
+	Offset float64  `yaml:"offset" doc:"added after scaling, e.g. for a sensor with a 0.5 V zero point"`
+}
+
+// validate checks the settings and fills in the defaults.
+func (a *adcConfig) validate() error {
+	if a.Chip == "" {
+		a.Chip = ads1115.ADS1115.Name
+	}
+	model, err := ads1115.ModelByName(a.Chip)
+	if err != nil {
+		return err
+	}
+	if a.Address == "" {
+		a.Address = fmt.Sprintf("0x%02X", ads1115.Address)
+	}
+	if _, err := a.addr(); err != nil {
+		return err
+	}
+	if a.FullScale != 0 {
+		if _, err := ads1115.FullScaleCode(a.FullScale); err != nil {
+			return err
+		}
+	}
+	if a.DataRate != 0 {
+		if _, err := model.DataRateCode(a.DataRate); err != nil {
+			return err
+		}
+	}
+	if err := checkSchedule(a.Interval, a.Jitter); err != nil {
+		return err
+	}
+	if len(a.Inputs) == 0 {
+		return fmt.Errorf("at least one input is required")
+	}
+	inputs := make(map[int]bool)
+	names := make(map[string]bool)
+	for i := range a.Inputs {
+		in := &a.Inputs[i]
+		if in.Input < 0 || in.Input >= ads1115.Inputs {
+			return fmt.Errorf("input %d: invalid input %d: must be between 0 and %d", i, in.Input, ads1115.Inputs-1)
+		}
+		if inputs[in.Input] {
+			return fmt.Errorf("input %d: AIN%d is used more than once", i, in.Input)
+		}
+		inputs[in.Input] = true
+		if in.Name == "" {
+			in.Name = "ain" + strconv.Itoa(in.Input)
+		}
+		if names[in.Name] {
+			return fmt.Errorf("input %d: name %q is used more than once", i, in.Name)
+		}
+		names[in.Name] = true
+		if in.Scale == nil {
+			one := 1.0
+			in.Scale = &one
+		} else if *in.Scale == 0 {
+			return fmt.Errorf("input %d: scale must not be 0", i)
+		}
+	}
+	return nil
+}
+
+// addr returns the parsed I2C address.
+func (a *adcConfig) addr() (uint16, error) {
+	addr, err := strconv.ParseUint(a.Address, 0, 16)
+	if err != nil || uint16(addr) < ads1115.Address || uint16(addr) > ads1115.LastAddress {
+		return 0, fmt.Errorf("invalid address %q: must be between 0x%02X and 0x%02X", a.Address, ads1115.Address, ads1115.LastAddress)
+	}
+	return uint16(addr), nil
+}
+
+// adcMonitor is one polled ADS1115 or ADS1015, like driverMonitor. Every input is
+// converted in turn under one hold of busMu.
+type adcMonitor struct {
+	sensor   *ads1115.Sensor
+	inputs   []adcInputConfig
+	device   string
+	gate     *muxGate
+	schedule pollSchedule
+	logger   *slog.Logger
+	metrics  *exporter.ADCMetrics
+	quiet    bool // do not print readings to stdout
+}
+
+// newADCMonitor returns the monitor of an ADC of the config file, on its mux
+// channel or, without one, on the bus itself.
+func newADCMonitor(w *channelWiring, cfg adcConfig, schedule pollSchedule) *adcMonitor {
+	model, _ := ads1115.ModelByName(cfg.Chip) // checked by loadConfig
+	addr, _ := cfg.addr()
+	a := &adcMonitor{
+		sensor:   &ads1115.Sensor{Dev: &i2c.Dev{Bus: w.bus, Addr: addr}, Model: model, FullScale: cfg.FullScale, DataRate: cfg.DataRate},
+		inputs:   cfg.Inputs,
+		device:   cfg.Chip,
+		schedule: schedule,
+		quiet:    w.quiet,
+	}
+	if cfg.Channel != nil {
+		a.device, a.gate, a.logger = w.place(*cfg.Channel, cfg.Chip, cfg.Name, nil)
+	} else {
+		if cfg.Name != "" {
+			a.device = cfg.Name
+		}
+		a.logger = slog.With("device", a.device)
+	}
+	a.metrics = exporter.NewADCMetrics(w.hostname, a.device)
+	return a
+}
+
+// init checks that the converter answers and applies its gain and data rate.
+func (a *adcMonitor) init() error {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if err := a.gate.open(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := a.gate.close(); err != nil {
+			a.logger.Warn("Failed to close mux channel", "err", err)
+		}
+	}()
+	return a.sensor.Init()
+}
+
+// poll converts every input, publishes them to the adc_input_* gauges and prints
+// them unless quiet. A failed input fails the whole poll.
+func (a *adcMonitor) poll() error {
+	volts := make([]float64, len(a.inputs))
+	busMu.Lock()
+	err := a.gate.open()
+	for i := 0; err == nil && i < len(a.inputs); i++ {
+		volts[i], err = a.sensor.Read(a.inputs[i].Input)
+	}
+	now := time.Now()
+	if cerr := a.gate.close(); cerr != nil {
+		a.logger.Warn("Failed to close mux channel", "err", cerr)
+	}
+	busMu.Unlock()
+	if err != nil {
+		a.logger.Error("Failed to read sensor", "err", err)
+		a.metrics.Failed()
+		return err
+	}
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s %s", a.device, now.Format(exporter.TextTimeFormat))
+	for i, in := range a.inputs {
+		value := volts[i]**in.Scale + in.Offset
+		a.metrics.PublishInput(in.Name, volts[i], value)
+		if i > 0 {
+			line.WriteString(",")
+		}
+		fmt.Fprintf(&line, " %s: %.4f (%.4f V)", in.Name, value, volts[i])
+	}
+	a.metrics.Up()
+	if !a.quiet {
+		publishMu.Lock()
+		fmt.Println(line.String())
+		publishMu.Unlock()
+	}
+	return nil
+}
+
+// run polls the converter until ctx is cancelled, like monitor.run.
+func (a *adcMonitor) run(ctx context.Context, errorBackoff time.Duration) {
+	pollEvery(ctx, a.schedule, errorBackoff, a.poll)
+}
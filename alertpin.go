@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+var (
+	alertPinEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_alert_pin_events_total",
+		Help: "Number of times the INA260 alert function flag of the device was found set after the --alert-gpio pin was asserted.",
+	}, []string{"hostname", "device", "function"})
+	alertPinAsserted = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_alert_asserted",
+		Help: "1 while the INA260 alert function flag of the device is set, as last checked by the --alert-gpio watcher, 0 otherwise.",
+	}, []string{"hostname", "device", "function"})
+)
+
+// alertPinRecheck is how often the flags are read again while an alert is
+// asserted, to notice when it clears: a latched pin is released by the read
+// itself, and a transparent one may already be high again when it does.
+const alertPinRecheck = time.Second
+
+// alertPinWatcher watches the GPIO pin the INA260 ALERT outputs are wired to.
+// The outputs are open drain, so the pins of several sensors can share one GPIO;
+// each assertion is attributed by reading the alert function flag of every
+// INA260, which also clears a latched alert.
+type alertPinWatcher struct {
+	pin    gpio.PinIn
+	alert  ina260.Alert
+	fleet  *fleet
+	alerts *alertSink // nil without alerts in the config file
+
+	asserted map[*monitor]bool
+}
+
+// newAlertPinWatcher resolves the pin and sets it up as an input with a pull
+// towards the inactive level, reporting edges into the active one.
+func newAlertPinWatcher(name string, alert ina260.Alert, fleet *fleet, alerts *alertSink) (*alertPinWatcher, error) {
+	pin := gpioreg.ByName(name)
+	if pin == nil {
+		return nil, fmt.Errorf("unknown GPIO pin %q", name)
+	}
+	pull, edge := gpio.PullUp, gpio.FallingEdge
+	if alert.ActiveHigh {
+		pull, edge = gpio.PullDown, gpio.RisingEdge
+	}
+	if err := pin.In(pull, edge); err != nil {
+		return nil, fmt.Errorf("failed to set up GPIO pin %s as an input: %w", name, err)
+	}
+	return &alertPinWatcher{pin: pin, alert: alert, fleet: fleet, alerts: alerts, asserted: make(map[*monitor]bool)}, nil
+}
+
+// active reports whether the pin is at its asserted level.
+func (w *alertPinWatcher) active() bool {
+	return w.pin.Read() == gpio.Level(w.alert.ActiveHigh)
+}
+
+// run checks the flags on every edge, and every alertPinRecheck while the pin
+// is active or an alert is asserted, until ctx is cancelled.
+func (w *alertPinWatcher) run(ctx context.Context) {
+	slog.Info("Watching the INA260 ALERT pin", "gpio", w.pin.Name(), "function", w.alert.Function, "limit", w.alert.Limit, "latch", w.alert.Latch)
+	for ctx.Err() == nil {
+		// WaitForEdge has no context; the timeout bounds how late cancellation is seen
+		edge := w.pin.WaitForEdge(alertPinRecheck)
+		if edge || w.active() || len(w.asserted) > 0 {
+			w.check()
+		}
+	}
+	w.pin.Halt()
+}
+
+// check reads the flag of every INA260, counting and acting on the devices that
+// became asserted or cleared since the last check.
+func (w *alertPinWatcher) check() {
+	monitors := w.fleet.list()
+	for m := range w.asserted {
+		if !slices.Contains(monitors, m) {
+			delete(w.asserted, m) // removed by a reload
+		}
+	}
+	for _, m := range monitors {
+		if m.shunt != nil {
+			continue
+		}
+		set, err := m.alertFlag()
+		if err != nil {
+			m.logger.Warn("Failed to read INA260 alert flag", "err", err)
+			continue
+		}
+		if set == w.asserted[m] {
+			continue
+		}
+		labels := []string{m.export.Hostname, m.export.Device, w.alert.Function}
+		if set {
+			w.asserted[m] = true
+			alertPinEvents.WithLabelValues(labels...).Inc()
+			alertPinAsserted.WithLabelValues(labels...).Set(1)
+			m.logger.Warn("INA260 alert asserted", "function", w.alert.Function, "limit", w.alert.Limit)
+		} else {
+			delete(w.asserted, m)
+			alertPinAsserted.WithLabelValues(labels...).Set(0)
+			m.logger.Info("INA260 alert cleared", "function", w.alert.Function)
+		}
+		if w.alerts != nil {
+			last, _, _ := m.status.snapshot()
+			last.Time = time.Now()
+			publishMu.Lock()
+			err := w.alerts.pinChanged(m.export, last, set)
+			publishMu.Unlock()
+			if err != nil {
+				m.logger.Warn("Failed to run alert action", "err", err)
+			}
+		}
+	}
+}
+
+// alertFlag reads the alert function flag of the sensor, which clears it if it
+// is latched.
+func (m *monitor) alertFlag() (bool, error) {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if err := m.gate.open(); err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := m.gate.close(); err != nil {
+			m.logger.Warn("Failed to close mux channel", "err", err)
+		}
+	}()
+	return m.sensor.AlertFlag()
+}
+
+// pinRule fills in the quantity and threshold of a pin alert from the alert
+// function the INA260s are set up with, for its log lines and payloads.
+func pinRule(c alertConfig, alert ina260.Alert) alertConfig {
+	c.Quantity = alert.Quantity()
+	limit := alert.Limit
+	if alert.Over() {
+		c.Above = &limit
+	} else {
+		c.Below = &limit
+	}
+	return c
+}
+
+// pinChanged runs the pin alerts of the device when its flag is set or clears.
+func (k *alertSink) pinChanged(s *exporter.Sensor, r ina260.Reading, set bool) error {
+	var errs []error
+	for _, rule := range k.rules {
+		if !rule.Pin || rule.Device != "" && rule.Device != s.Device {
+			continue
+		}
+		state := k.state(rule, s)
+		if state.firing == set {
+			continue
+		}
+		state.firing = set
+		status := "resolved"
+		if set {
+			status = "firing"
+			state.gauge.Set(1)
+		} else {
+			state.gauge.Set(0)
+		}
+		errs = append(errs, k.act(rule, s, r, rule.value(r), status))
+	}
+	return errors.Join(errs...)
+}
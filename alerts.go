@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+var alertFiring = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ina260_alert_firing",
+	Help: "1 while the alert from the config file is firing for the device, 0 otherwise.",
+}, []string{"hostname", "device", "alert"})
+
+// Quantities an alert can watch, as named in the config file.
+const (
+	quantityCurrent = "current"
+	quantityVoltage = "voltage"
+	quantityPower   = "power"
+)
+
+// alertConfig is one entry of the alerts section of the config file: a threshold
+// on one quantity that has to hold for a while before the actions run, or with
+// pin, the INA260 alert function of --alert-function, seen by --alert-gpio.
+type alertConfig struct {
+	Name     string        `yaml:"name" doc:"alert label, required and unique"`
+	Device   string        `yaml:"device" doc:"device label; empty for every power monitor"`
+	Pin      bool          `yaml:"pin" doc:"fires while the INA260 alert flag is set, in place of quantity and a threshold"`
+	Quantity string        `yaml:"quantity" doc:"current, voltage or power"`
+	Above    *float64      `yaml:"above" doc:"fires while the value is above this, in A, V or W"`
+	Below    *float64      `yaml:"below" doc:"or while it is below this"`
+	For      time.Duration `yaml:"for" doc:"how long the condition has to hold; 0 fires on the first reading"`
+	GPIO     string        `yaml:"gpio" doc:"pin driven high while firing and low once resolved, e.g. GPIO27"`
+	Webhook  string        `yaml:"webhook" doc:"URL sent a JSON POST when the alert fires and resolves"`
+	MQTT     bool          `yaml:"mqtt" doc:"publish to <prefix>/<hostname>/<device>/alert, with --mqtt.broker"`
+}
+
+func (a *alertConfig) validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if a.Pin {
+		if a.Quantity != "" || a.Above != nil || a.Below != nil || a.For != 0 {
+			return fmt.Errorf("quantity, above, below and for do not apply with pin, which takes the INA260 alert function")
+		}
+		return nil
+	}
+	switch a.Quantity {
+	case quantityCurrent, quantityVoltage, quantityPower:
+	default:
+		return fmt.Errorf("invalid quantity %q: must be %s, %s or %s", a.Quantity, quantityCurrent, quantityVoltage, quantityPower)
+	}
+	if (a.Above == nil) == (a.Below == nil) {
+		return fmt.Errorf("exactly one of above and below is required")
+	}
+	if a.For < 0 {
+		return fmt.Errorf("for must not be negative, got %s", a.For)
+	}
+	return nil
+}
+
+// threshold describes the condition, e.g. "> 3".
+func (a *alertConfig) threshold() string {
+	if a.Above != nil {
+		return fmt.Sprintf("> %g", *a.Above)
+	}
+	return fmt.Sprintf("< %g", *a.Below)
+}
+
+func (a *alertConfig) matches(value float64) bool {
+	if a.Above != nil {
+		return value > *a.Above
+	}
+	return value < *a.Below
+}
+
+func (a *alertConfig) value(r ina260.Reading) float64 {
+	switch a.Quantity {
+	case quantityVoltage:
+		return r.Voltage
+	case quantityPower:
+		return r.Power
+	default:
+		return r.Current
+	}
+}
+
+// alertPublisher is implemented by the MQTT sink.
+type alertPublisher interface {
+	PublishAlert(device string, payload []byte) error
+}
+
+// alertEvent is the webhook and MQTT payload of an alert firing or resolving.
+type alertEvent struct {
+	Alert     string  `json:"alert"`
+	State     string  `json:"state"` // firing or resolved
+	Time      string  `json:"time"`
+	Hostname  string  `json:"hostname"`
+	Device    string  `json:"device"`
+	Quantity  string  `json:"quantity"`
+	Value     float64 `json:"value"`
+	Threshold string  `json:"threshold"` // e.g. "> 3"
+}
+
+// webhookTimeout bounds each webhook call; calls run in the background, so a slow
+// receiver never holds up polling.
+const webhookTimeout = 5 * time.Second
+
+// alertRule is one configured alert with its resolved GPIO pin. A rule without
+// a device covers every power monitor, so its pin follows the number of devices
+// it is firing for rather than the last one that changed.
+type alertRule struct {
+	alertConfig
+	pin    gpio.PinOut // nil without a gpio action
+	firing int         // devices the rule is firing for; the pin is high while above 0
+}
+
+// alertState tracks one rule for one device.
+type alertState struct {
+	pendingSince time.Time // when the condition started holding; zero while it does not
+	firing       bool
+	gauge        prometheus.Gauge
+}
+
+// alertSink evaluates the alerts on every reading. It is a sink so it sees the
+// readings of every device after they are taken, like the other outputs.
+type alertSink struct {
+	rules  []*alertRule
+	mqtt   alertPublisher // nil without --mqtt.broker
+	client *http.Client
+	states map[*alertRule]map[string]*alertState // by rule and device; only touched under publishMu
+}
+
+// newAlertSink resolves the GPIO pins of the alerts and drives them low. mqtt may
+// be nil, in which case alerts with an mqtt action are rejected, and pinAlert is
+// nil without --alert-gpio, in which case pin alerts are.
+func newAlertSink(configs []alertConfig, mqtt alertPublisher, pinAlert *ina260.Alert) (*alertSink, error) {
+	k := &alertSink{mqtt: mqtt, client: &http.Client{Timeout: webhookTimeout}, states: make(map[*alertRule]map[string]*alertState)}
+	for _, c := range configs {
+		if c.Pin {
+			if pinAlert == nil {
+				return nil, fmt.Errorf("alert %s: pin needs --alert-function and --alert-gpio", c.Name)
+			}
+			c = pinRule(c, *pinAlert)
+		}
+		rule := &alertRule{alertConfig: c}
+		if c.MQTT && mqtt == nil {
+			return nil, fmt.Errorf("alert %s: the mqtt action needs --mqtt.broker", c.Name)
+		}
+		if c.GPIO != "" {
+			pin := gpioreg.ByName(c.GPIO)
+			if pin == nil {
+				return nil, fmt.Errorf("alert %s: unknown GPIO pin %q", c.Name, c.GPIO)
+			}
+			if err := pin.Out(gpio.Low); err != nil {
+				return nil, fmt.Errorf("alert %s: failed to drive GPIO pin %s low: %w", c.Name, c.GPIO, err)
+			}
+			rule.pin = pin
+		}
+		k.rules = append(k.rules, rule)
+		k.states[rule] = make(map[string]*alertState)
+	}
+	return k, nil
+}
+
+func (k *alertSink) Name() string { return "alerts" }
+
+func (k *alertSink) Publish(s *exporter.Sensor, r ina260.Reading) error {
+	var errs []error
+	for _, rule := range k.rules {
+		if rule.Pin || rule.Device != "" && rule.Device != s.Device {
+			continue
+		}
+		state := k.state(rule, s)
+		value := rule.value(r)
+		if !rule.matches(value) {
+			state.pendingSince = time.Time{}
+			if state.firing {
+				state.firing = false
+				state.gauge.Set(0)
+				errs = append(errs, k.act(rule, s, r, value, "resolved"))
+			}
+			continue
+		}
+		if state.pendingSince.IsZero() {
+			state.pendingSince = r.Time
+		}
+		if !state.firing && r.Time.Sub(state.pendingSince) >= rule.For {
+			state.firing = true
+			state.gauge.Set(1)
+			errs = append(errs, k.act(rule, s, r, value, "firing"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// state returns the state of rule for the sensor, creating it on first use.
+func (k *alertSink) state(rule *alertRule, s *exporter.Sensor) *alertState {
+	state, ok := k.states[rule][s.Device]
+	if !ok {
+		state = &alertState{gauge: alertFiring.WithLabelValues(s.Hostname, s.Device, rule.Name)}
+		state.gauge.Set(0)
+		k.states[rule][s.Device] = state
+	}
+	return state
+}
+
+// act runs the actions of a rule for a state change: the GPIO right away, the
+// webhook and MQTT publish in the background.
+func (k *alertSink) act(rule *alertRule, s *exporter.Sensor, r ina260.Reading, value float64, state string) error {
+	threshold := rule.threshold()
+	logger := slog.With("alert", rule.Name, "device", s.Device, rule.Quantity, value, "threshold", threshold)
+	if state == "firing" {
+		logger.Warn("Alert firing", "for", rule.For)
+	} else {
+		logger.Info("Alert resolved")
+	}
+
+	err := k.count(rule, state == "firing")
+	if rule.Webhook == "" && !rule.MQTT {
+		return err
+	}
+	payload, jerr := json.Marshal(alertEvent{
+		Alert:     rule.Name,
+		State:     state,
+		Time:      r.Time.Format(exporter.ReadingTimeFormat),
+		Hostname:  s.Hostname,
+		Device:    s.Device,
+		Quantity:  rule.Quantity,
+		Value:     value,
+		Threshold: threshold,
+	})
+	if jerr != nil {
+		return jerr
+	}
+	if rule.Webhook != "" {
+		go callWebhook(k.client, logger, rule.Webhook, payload)
+	}
+	if rule.MQTT {
+		device := s.Device
+		go func() {
+			if err := k.mqtt.PublishAlert(device, payload); err != nil {
+				logger.Warn("Failed to publish alert to MQTT", "err", err)
+			}
+		}()
+	}
+	return err
+}
+
+// count adds a device to the ones rule is firing for, or takes one away, and
+// drives the pin high for the first and low once none is left.
+func (k *alertSink) count(rule *alertRule, firing bool) error {
+	if firing {
+		rule.firing++
+	} else if rule.firing > 0 {
+		rule.firing--
+	}
+	if rule.pin == nil || firing && rule.firing != 1 || !firing && rule.firing != 0 {
+		return nil
+	}
+	level := gpio.Low
+	if firing {
+		level = gpio.High
+	}
+	if err := rule.pin.Out(level); err != nil {
+		return fmt.Errorf("alert %s: failed to drive GPIO pin %s: %w", rule.Name, rule.GPIO, err)
+	}
+	return nil
+}
+
+// forget drops the states of a device that is no longer polled, removed by a
+// reload of the config file or gone from discovery, with their series. A rule
+// still firing for it stops counting it, without sending a resolved event, since
+// nothing was read.
+func (k *alertSink) forget(device string) {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	for _, rule := range k.rules {
+		state, ok := k.states[rule][device]
+		if !ok {
+			continue
+		}
+		delete(k.states[rule], device)
+		alertFiring.DeletePartialMatch(prometheus.Labels{"device": device, "alert": rule.Name})
+		if state.firing {
+			if err := k.count(rule, false); err != nil {
+				slog.Warn("Failed to release alert of a removed device", "alert", rule.Name, "device", device, "err", err)
+			}
+		}
+	}
+}
+
+// callWebhook posts a JSON payload to url, logging any failure.
+func callWebhook(client *http.Client, logger *slog.Logger, url string, payload []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		logger.Warn("Failed to call webhook", "url", url, "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Warn("Failed to call webhook", "url", url, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Warn("Webhook returned an error", "url", url, "status", resp.Status)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// api serves the JSON HTTP API under /api/v1: the device inventory and each
+// device's latest reading, or a fresh one read on demand, and with capture set,
+// burst captures of an INA260.
+type api struct {
+	chip    string
+	capture bool
+	fleet   *fleet
+	opts    pollOptions
+	polling atomic.Bool // set once every sensor is set up; fresh reads wait for it
+}
+
+// apiDevice is one entry of GET /api/v1/devices.
+type apiDevice struct {
+	Name        string            `json:"name"`
+	Hostname    string            `json:"hostname"`
+	Chip        string            `json:"chip"`
+	Bus         string            `json:"bus,omitempty"` // omitted for a sensor on the --bus bus
+	Mux         string            `json:"mux,omitempty"` // e.g. 0x70; omitted for a directly connected sensor
+	Channel     *int              `json:"channel,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"` // extra labels from the config file
+	Up          bool              `json:"up"`
+	Readings    uint64            `json:"readings"`
+	ReadErrors  uint64            `json:"read_errors"`
+	LastReading json.RawMessage   `json:"last_reading,omitempty"` // in the --fifo JSON format
+}
+
+// apiError is the body of every error response.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (a *api) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/devices", a.handleDevices)
+	mux.HandleFunc("GET /api/v1/devices/{name}", a.handleDevice)
+	mux.HandleFunc("GET /api/v1/devices/{name}/reading", a.handleReading)
+	if a.capture {
+		mux.HandleFunc("POST /api/v1/devices/{name}/capture", a.handleCapture)
+	}
+}
+
+func (a *api) device(m *monitor) apiDevice {
+	last, readings, readErrors := m.status.snapshot()
+	d := apiDevice{
+		Name:       m.export.Device,
+		Hostname:   m.export.Hostname,
+		Chip:       a.chip,
+		Bus:        m.bus,
+		Mux:        m.muxSpec,
+		Labels:     m.export.Labels,
+		Up:         m.health.isUp(),
+		Readings:   readings,
+		ReadErrors: readErrors,
+	}
+	if m.channel >= 0 {
+		d.Channel = &m.channel
+	}
+	if readings > 0 {
+		d.LastReading, _ = exporter.MarshalReadingJSON(m.export, last)
+	}
+	return d
+}
+
+func (a *api) lookup(w http.ResponseWriter, r *http.Request) *monitor {
+	name := r.PathValue("name")
+	for _, m := range a.fleet.list() {
+		if m.export.Device == name {
+			return m
+		}
+	}
+	writeJSON(w, http.StatusNotFound, apiError{Error: "unknown device " + strconv.Quote(name)})
+	return nil
+}
+
+func (a *api) handleDevices(w http.ResponseWriter, r *http.Request) {
+	monitors := a.fleet.list()
+	devices := make([]apiDevice, 0, len(monitors))
+	for _, m := range monitors {
+		devices = append(devices, a.device(m))
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (a *api) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if m := a.lookup(w, r); m != nil {
+		writeJSON(w, http.StatusOK, a.device(m))
+	}
+}
+
+// handleReading returns the latest reading of the polling loop or, with
+// ?fresh=true, takes a new one right away. A fresh reading is not published to
+// the sinks and does not count towards the device's health.
+func (a *api) handleReading(w http.ResponseWriter, r *http.Request) {
+	m := a.lookup(w, r)
+	if m == nil {
+		return
+	}
+	fresh, err := strconv.ParseBool(r.URL.Query().Get("fresh"))
+	if err != nil && r.URL.Query().Has("fresh") {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid fresh parameter: must be true or false"})
+		return
+	}
+	if !fresh {
+		last, readings, _ := m.status.snapshot()
+		if readings == 0 {
+			writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "no reading yet"})
+			return
+		}
+		writeReading(w, m.export, last)
+		return
+	}
+	if !a.polling.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "sensors are still being set up"})
+		return
+	}
+	reading, _, _, err := m.read(a.opts)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, apiError{Error: err.Error()})
+		return
+	}
+	writeReading(w, m.export, reading)
+}
+
+func writeReading(w http.ResponseWriter, s *exporter.Sensor, r ina260.Reading) {
+	body, err := exporter.MarshalReadingJSON(s, r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write API response", "err", err)
+	}
+}
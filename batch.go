@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// batchSink gathers the readings of the polled power monitors for --batch-publish
+// and publishes them to its sinks together, so the push outputs get the readings
+// of one cycle at once and time-aligned, instead of as each sensor is read.
+//
+// A batch is published once it holds a reading of every monitor of the fleet, or
+// when a sensor is read again before then, as a sensor that is down or polled less
+// often never completes it. Like every sink it is only used under publishMu.
+type batchSink struct {
+	sinks   []exporter.Sink
+	size    func() int // monitors a complete batch holds a reading of
+	pending []exporter.Published
+}
+
+func (b *batchSink) Name() string { return "batch" }
+
+func (b *batchSink) Publish(s *exporter.Sensor, r ina260.Reading) error {
+	if slices.ContainsFunc(b.pending, func(p exporter.Published) bool { return p.Sensor == s }) {
+		b.flush()
+	}
+	b.pending = append(b.pending, exporter.Published{Sensor: s, Reading: r})
+	if len(b.pending) >= b.size() {
+		b.flush()
+	}
+	return nil
+}
+
+// flush publishes the pending readings, if any.
+func (b *batchSink) flush() {
+	if len(b.pending) == 0 {
+		return
+	}
+	exporter.PublishBatch(b.sinks, b.pending)
+	b.pending = nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// recordingSink records the devices of each call it gets: one per Publish, and
+// all of a batch for PublishBatch when batches is set.
+type recordingSink struct {
+	batches bool
+	calls   [][]string
+}
+
+func (r *recordingSink) Name() string { return "recording" }
+
+func (r *recordingSink) Publish(s *exporter.Sensor, _ ina260.Reading) error {
+	r.calls = append(r.calls, []string{s.Device})
+	return nil
+}
+
+type recordingBatchSink struct{ recordingSink }
+
+func (r *recordingBatchSink) PublishBatch(batch []exporter.Published) error {
+	var devices []string
+	for _, p := range batch {
+		devices = append(devices, p.Sensor.Device)
+	}
+	r.calls = append(r.calls, devices)
+	return nil
+}
+
+// TestBatchSink checks that a batch is published once it has a reading of every
+// sensor, or early when a sensor is read again, and that a BatchSink gets each
+// batch in one call while another sink gets its readings one by one.
+func TestBatchSink(t *testing.T) {
+	a := &exporter.Sensor{Device: "a"}
+	b := &exporter.Sensor{Device: "b"}
+	c := &exporter.Sensor{Device: "c"}
+	plain, batched := &recordingSink{}, &recordingBatchSink{}
+	sink := &batchSink{sinks: []exporter.Sink{plain, batched}, size: func() int { return 3 }}
+	r := ina260.Reading{Time: time.Now()}
+
+	// A complete cycle, then one where c is down and a is read again
+	for _, s := range []*exporter.Sensor{a, b, c, b, a, a} {
+		sink.Publish(s, r)
+	}
+	want := [][]string{{"a", "b", "c"}, {"b", "a"}}
+	if !slices.EqualFunc(batched.calls, want, slices.Equal) {
+		t.Errorf("batches %v, want %v", batched.calls, want)
+	}
+	if want := [][]string{{"a"}, {"b"}, {"c"}, {"b"}, {"a"}}; !slices.EqualFunc(plain.calls, want, slices.Equal) {
+		t.Errorf("readings %v, want %v", plain.calls, want)
+	}
+
+	// The last reading of a stays pending until flushed
+	sink.flush()
+	if last := batched.calls[len(batched.calls)-1]; !slices.Equal(last, []string{"a"}) {
+		t.Errorf("flushed batch %v, want [a]", last)
+	}
+	sink.flush()
+	if len(batched.calls) != 3 {
+		t.Errorf("flush without pending readings published %d batches, want 3", len(batched.calls))
+	}
+}
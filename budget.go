@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+var (
+	budgetExceeded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_budget_exceeded",
+		Help: "1 while the summed power of the devices of the budget from the config file is above its limit, 0 otherwise.",
+	}, []string{"hostname", "budget"})
+	budgetPower = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_budget_used",
+		Help: "Sum of the latest power readings of the devices of the budget in Watts.",
+	}, []string{"hostname", "budget"})
+	budgetLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_budget_limit",
+		Help: "Limit of the budget from the config file in Watts.",
+	}, []string{"hostname", "budget"})
+	budgetExceededTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_power_budget_exceeded_total",
+		Help: "Number of times the budget from the config file was exceeded.",
+	}, []string{"hostname", "budget"})
+)
+
+// budgetConfig is one entry of the power_budgets section of the config file: a
+// group of devices sharing a supply, whose summed power must stay within a limit.
+type budgetConfig struct {
+	Name    string        `yaml:"name" doc:"budget label, required and unique"`
+	Devices []string      `yaml:"devices" doc:"device labels whose power is summed"`
+	Limit   float64       `yaml:"limit" doc:"in W"`
+	For     time.Duration `yaml:"for" doc:"how long the sum has to stay above the limit; 0 acts on the first reading"`
+	GPIO    string        `yaml:"gpio" doc:"pin driven high at startup and low once the budget is exceeded, e.g. a relay enabling the supply"`
+	Webhook string        `yaml:"webhook" doc:"URL sent a JSON POST when the budget is exceeded and when it is back within the limit"`
+	MQTT    *budgetMQTT   `yaml:"mqtt" doc:"command published once the budget is exceeded, with --mqtt.broker"`
+}
+
+// budgetMQTT is the MQTT command of a budget, e.g. switching off a smart plug.
+type budgetMQTT struct {
+	Topic   string `yaml:"topic" doc:"e.g. zigbee2mqtt/bench_plug/set"`
+	Payload string `yaml:"payload" doc:"e.g. {\"state\": \"OFF\"}"`
+}
+
+func (b *budgetConfig) validate() error {
+	if b.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(b.Devices) == 0 {
+		return errors.New("at least one device is required")
+	}
+	for i, d := range b.Devices {
+		if d == "" {
+			return fmt.Errorf("device %d is empty", i)
+		}
+		if slices.Contains(b.Devices[:i], d) {
+			return fmt.Errorf("device %q is listed more than once", d)
+		}
+	}
+	if b.Limit <= 0 {
+		return fmt.Errorf("limit must be positive, got %g", b.Limit)
+	}
+	if b.For < 0 {
+		return fmt.Errorf("for must not be negative, got %s", b.For)
+	}
+	if b.MQTT != nil && b.MQTT.Topic == "" {
+		return errors.New("mqtt needs a topic")
+	}
+	return nil
+}
+
+// budgetPublisher is implemented by the MQTT sink.
+type budgetPublisher interface {
+	PublishCommand(topic string, payload []byte) error
+}
+
+// budgetEvent is the webhook payload of a budget being exceeded or back within
+// its limit.
+type budgetEvent struct {
+	Budget   string             `json:"budget"`
+	State    string             `json:"state"` // exceeded or resolved
+	Time     string             `json:"time"`
+	Hostname string             `json:"hostname"`
+	Power    float64            `json:"power"` // summed, in W
+	Limit    float64            `json:"limit"`
+	Devices  map[string]float64 `json:"devices"` // latest power of each device that has a reading
+}
+
+// budget is one configured budget with its state.
+type budget struct {
+	budgetConfig
+	pin gpio.PinOut // nil without a gpio action
+
+	power         map[string]float64 // latest power by device
+	pendingSince  time.Time          // when the sum went above the limit; zero while it is within
+	exceeded      bool
+	exceededGauge prometheus.Gauge
+	usedGauge     prometheus.Gauge
+	exceededCount prometheus.Counter
+}
+
+// budgetSink sums the power of the devices of each budget on every reading of
+// one of them, and runs the actions of a budget once the sum has been above its
+// limit for its for duration. The sum uses the latest reading of every device,
+// so a device that stops answering keeps counting with its last power. The GPIO
+// and MQTT actions cut the supply and are never undone by the exporter: once
+// the power is gone the sum drops back within the limit, and switching the
+// supply on again is left to someone who has looked at the bench.
+type budgetSink struct {
+	hostname string
+	byDevice map[string][]*budget // the budgets each device counts towards
+	mqtt     budgetPublisher      // nil without --mqtt.broker
+	client   *http.Client
+}
+
+// newBudgetSink resolves the GPIO pins of the budgets and drives them high. mqtt
+// may be nil, in which case budgets with an mqtt action are rejected.
+func newBudgetSink(hostname string, configs []budgetConfig, mqtt budgetPublisher) (*budgetSink, error) {
+	k := &budgetSink{hostname: hostname, byDevice: make(map[string][]*budget), mqtt: mqtt, client: &http.Client{Timeout: webhookTimeout}}
+	for _, c := range configs {
+		if c.MQTT != nil && mqtt == nil {
+			return nil, fmt.Errorf("budget %s: the mqtt action needs --mqtt.broker", c.Name)
+		}
+		b := &budget{
+			budgetConfig:  c,
+			power:         make(map[string]float64),
+			exceededGauge: budgetExceeded.WithLabelValues(hostname, c.Name),
+			usedGauge:     budgetPower.WithLabelValues(hostname, c.Name),
+			exceededCount: budgetExceededTotal.WithLabelValues(hostname, c.Name),
+		}
+		if c.GPIO != "" {
+			pin := gpioreg.ByName(c.GPIO)
+			if pin == nil {
+				return nil, fmt.Errorf("budget %s: unknown GPIO pin %q", c.Name, c.GPIO)
+			}
+			if err := pin.Out(gpio.High); err != nil {
+				return nil, fmt.Errorf("budget %s: failed to drive GPIO pin %s high: %w", c.Name, c.GPIO, err)
+			}
+			b.pin = pin
+		}
+		b.exceededGauge.Set(0)
+		budgetLimit.WithLabelValues(hostname, c.Name).Set(c.Limit)
+		for _, d := range c.Devices {
+			k.byDevice[d] = append(k.byDevice[d], b)
+		}
+	}
+	return k, nil
+}
+
+func (k *budgetSink) Name() string { return "power_budgets" }
+
+func (k *budgetSink) Publish(s *exporter.Sensor, r ina260.Reading) error {
+	var errs []error
+	for _, b := range k.byDevice[s.Device] {
+		b.power[s.Device] = r.Power
+		var sum float64
+		for _, p := range b.power {
+			sum += p
+		}
+		b.usedGauge.Set(sum)
+		if sum <= b.Limit {
+			b.pendingSince = time.Time{}
+			if b.exceeded {
+				b.exceeded = false
+				b.exceededGauge.Set(0)
+				errs = append(errs, k.act(b, r.Time, sum, "resolved"))
+			}
+			continue
+		}
+		if b.pendingSince.IsZero() {
+			b.pendingSince = r.Time
+		}
+		if !b.exceeded && r.Time.Sub(b.pendingSince) >= b.For {
+			b.exceeded = true
+			b.exceededGauge.Set(1)
+			b.exceededCount.Inc()
+			errs = append(errs, k.act(b, r.Time, sum, "exceeded"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// act runs the actions of a budget for a state change: the GPIO right away, the
+// webhook and MQTT command in the background.
+func (k *budgetSink) act(b *budget, t time.Time, sum float64, state string) error {
+	logger := slog.With("budget", b.Name, "power", sum, "limit", b.Limit)
+	if state == "resolved" {
+		logger.Info("Power budget back within its limit")
+	} else {
+		logger.Warn("Power budget exceeded", "for", b.For, "devices", b.power)
+	}
+
+	var err error
+	if b.pin != nil && state == "exceeded" {
+		if err = b.pin.Out(gpio.Low); err != nil {
+			err = fmt.Errorf("budget %s: failed to drive GPIO pin %s low: %w", b.Name, b.GPIO, err)
+		}
+	}
+	if b.MQTT != nil && state == "exceeded" {
+		topic, payload := b.MQTT.Topic, []byte(b.MQTT.Payload)
+		go func() {
+			if err := k.mqtt.PublishCommand(topic, payload); err != nil {
+				logger.Warn("Failed to publish power budget command to MQTT", "topic", topic, "err", err)
+			}
+		}()
+	}
+	if b.Webhook == "" {
+		return err
+	}
+	payload, jerr := json.Marshal(budgetEvent{
+		Budget:   b.Name,
+		State:    state,
+		Time:     t.Format(exporter.ReadingTimeFormat),
+		Hostname: k.hostname,
+		Power:    sum,
+		Limit:    b.Limit,
+		Devices:  maps.Clone(b.power),
+	})
+	if jerr != nil {
+		return jerr
+	}
+	go callWebhook(k.client, logger, b.Webhook, payload)
+	return err
+}
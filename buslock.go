@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// busLock is the type of busMu: a mutex that, with --bus-lock-dir, also holds an
+// exclusive flock on a lock file per bus for as long as it is locked, so other
+// processes taking the same locks, such as `flock /var/lock/i2c-1 i2cget ...` or
+// a second exporter, never interleave with a mux selection and the sensor
+// transactions behind it. busMu serializes every bus of the process, so every
+// lock file is held together, always in the same order.
+type busLock struct {
+	mu    sync.Mutex
+	files []*os.File
+}
+
+// addFile opens the lock file of bus in dir, creating it if missing, and takes
+// it along with the others from now on.
+func (l *busLock) addFile(dir, bus string) error {
+	path := filepath.Join(dir, filepath.Base(bus))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open bus lock file: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = append(l.files, f)
+	slog.Info("Locking the bus with flock around every access", "sensor_bus", bus, "lock_file", path)
+	return nil
+}
+
+// Lock waits for the mutex, then for each lock file.
+func (l *busLock) Lock() {
+	l.mu.Lock()
+	for _, f := range l.files {
+		if err := flock(f, syscall.LOCK_EX); err != nil {
+			// Going ahead unlocked beats stopping polling over an advisory lock
+			slog.Warn("Failed to lock the bus lock file", "lock_file", f.Name(), "err", err)
+		}
+	}
+}
+
+// Unlock releases the lock files in reverse order, then the mutex.
+func (l *busLock) Unlock() {
+	for i := len(l.files) - 1; i >= 0; i-- {
+		if err := flock(l.files[i], syscall.LOCK_UN); err != nil {
+			slog.Warn("Failed to unlock the bus lock file", "lock_file", l.files[i].Name(), "err", err)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// idle reports whether no goroutine of the process is using the bus right now.
+func (l *busLock) idle() bool {
+	if !l.mu.TryLock() {
+		return false
+	}
+	l.mu.Unlock()
+	return true
+}
+
+// flock applies how to f, retrying when a signal interrupts the wait.
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
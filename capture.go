@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// maxCaptureDuration bounds a capture, which holds the bus throughout: at a few
+// thousand samples per second a minute is already some megabytes of CSV. A
+// capture for the API counts as polling progress for the systemd watchdog, so
+// it can last longer than WatchdogSec.
+const maxCaptureDuration = time.Minute
+
+// parseCaptureDuration parses the duration of a capture, from --duration or the
+// duration parameter of the API.
+func parseCaptureDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 || d > maxCaptureDuration {
+		return 0, fmt.Errorf("must be more than 0 and at most %s", maxCaptureDuration)
+	}
+	return d, nil
+}
+
+// runCapture implements the capture subcommand: it samples the --channel INA260
+// as fast as it converts for --duration and writes the samples as CSV, for
+// looking at the inrush current of a device under test as it powers on. Ctrl-C
+// ends the capture early and still writes what was sampled.
+func runCapture(args []string) int {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	sf := addSensorFlags(fs, "Capture")
+	durationFlag := fs.String("duration", "1s", "How long to sample for, at most 1m (default: 1s)")
+	outputFlag := fs.String("output", "-", "File to write the CSV to, - for stdout (default: -)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s capture [flags]\n\nSample an INA260 with no averaging and the shortest conversion times for --duration, and write seconds,voltage,current,power CSV. Exits 0 if the capture completed, 1 otherwise, 2 on invalid flags.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	duration, err := parseCaptureDuration(*durationFlag)
+	if err != nil {
+		slog.Error("Invalid --duration", "duration", *durationFlag, "err", err)
+		return 2
+	}
+	out := os.Stdout
+	if *outputFlag != "-" {
+		if out, err = os.Create(*outputFlag); err != nil {
+			slog.Error("Failed to create --output", "err", err)
+			return 1
+		}
+		defer out.Close()
+	}
+
+	sensor, label, closeSensor, code := sf.open()
+	if sensor == nil {
+		return code
+	}
+	defer closeSensor()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	samples, err := sensor.Capture(ctx, duration, nil)
+	code = 0
+	if err != nil {
+		slog.Error("Capture failed", "device", label, "samples", len(samples), "err", err)
+		code = 1
+	}
+	w := bufio.NewWriter(out)
+	if err := ina260.WriteCaptureCSV(w, samples); err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		slog.Error("Failed to write capture", "err", err)
+		return 1
+	}
+	if len(samples) > 0 {
+		last := samples[len(samples)-1].Offset
+		slog.Info("Captured", "device", label, "samples", len(samples), "duration", last, "rate_hz", int(float64(len(samples))/last.Seconds()))
+	}
+	return code
+}
+
+// handleCapture runs a capture of ?duration= (default 1s) on an INA260 and
+// returns the CSV. It holds the bus with the device's channel selected from
+// start to end, so every other sensor misses its polls meanwhile; hence
+// --capture-api.
+func (a *api) handleCapture(w http.ResponseWriter, r *http.Request) {
+	m := a.lookup(w, r)
+	if m == nil {
+		return
+	}
+	duration := time.Second
+	if r.URL.Query().Has("duration") {
+		var err error
+		if duration, err = parseCaptureDuration(r.URL.Query().Get("duration")); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid duration parameter: " + err.Error()})
+			return
+		}
+	}
+	if !a.polling.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "sensors are still being set up"})
+		return
+	}
+	m.logger.Info("Capturing for the API; polling pauses until it ends", "duration", duration, "remote", r.RemoteAddr)
+	samples, err := captureDevice(r.Context(), m, duration)
+	if err != nil && len(samples) == 0 {
+		writeJSON(w, http.StatusBadGateway, apiError{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		// The status line is gone with the first sample; the header tells a cut-short capture apart
+		w.Header().Set("X-Capture-Error", err.Error())
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(m.export.Device+".csv"))
+	bw := bufio.NewWriter(w)
+	if err := ina260.WriteCaptureCSV(bw, samples); err == nil {
+		bw.Flush()
+	}
+}
+
+// captureDevice holds the bus and the channel of m for a capture. Polling pauses
+// meanwhile, so each sample stands in for a finished poll to keep feeding the
+// systemd watchdog; a capture stuck on the bus stops feeding it like a poll.
+func captureDevice(ctx context.Context, m *monitor, duration time.Duration) ([]ina260.Sample, error) {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if err := m.gate.open(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := m.gate.close(); err != nil {
+			m.logger.Warn("Failed to close mux channel", "err", err)
+		}
+	}()
+	return m.sensor.Capture(ctx, duration, func() { lastPollDone.Store(time.Now().UnixNano()) })
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+)
+
+// Descriptors of the metrics scrapeCollector emits. The reading gauges keep the
+// names and help of the polled ones, so dashboards work with either mode.
+var (
+	scrapeCurrentDesc  = prometheus.NewDesc("ina260_current", "Current measured by INA260 sensor in Amperes.", []string{"hostname", "device"}, nil)
+	scrapeVoltageDesc  = prometheus.NewDesc("ina260_voltage", "Bus voltage measured by INA260 sensor in Volts.", []string{"hostname", "device"}, nil)
+	scrapePowerDesc    = prometheus.NewDesc("ina260_power", "Power measured by INA260 sensor in Watts.", []string{"hostname", "device"}, nil)
+	scrapeDurationDesc = prometheus.NewDesc("ina260_scrape_duration_seconds", "Time the last --read-on-scrape reading of the sensor took, including waiting for the bus, in seconds.", []string{"hostname", "device"}, nil)
+	scrapeErrorsDesc   = prometheus.NewDesc("ina260_scrape_errors_total", "Number of --read-on-scrape readings of the sensor that failed.", []string{"hostname", "device"}, nil)
+)
+
+// scrapeState is what scrapeCollector remembers of one sensor between scrapes.
+type scrapeState struct {
+	readAt   time.Time // when the cached reading was taken
+	ok       bool      // whether it succeeded
+	duration time.Duration
+	errors   uint64
+}
+
+// scrapeCollector reads every power monitor when /metrics is scraped, as the
+// official exporters do, instead of publishing what the polling loop read last.
+// A reading younger than ttl is reused, so several Prometheus servers scraping
+// at once do not multiply the bus traffic. Each reading goes through monitor.poll,
+// so ina260_up, the JSON API and the other sinks see it like a polled one.
+//
+// The collector is unchecked: its Describe sends nothing, since the reading gauges
+// share their names with the polled GaugeVecs, which stay empty in this mode.
+type scrapeCollector struct {
+	fleet *fleet
+	opts  pollOptions
+	sinks []exporter.Sink // every sink except the Prometheus one
+	batch *batchSink      // with --batch-publish, the one sink, published at the end of each scrape
+	ttl   time.Duration
+
+	mu     sync.Mutex // one scrape reads the sensors at a time
+	states map[*monitor]*scrapeState
+}
+
+func (c *scrapeCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	monitors := c.fleet.list()
+	states := make(map[*monitor]*scrapeState, len(monitors))
+	for _, m := range monitors {
+		state, ok := c.states[m]
+		if !ok {
+			state = &scrapeState{}
+		}
+		states[m] = state
+		if time.Since(state.readAt) >= c.ttl {
+			start := time.Now()
+			err := m.poll(c.opts, c.sinks)
+			state.readAt, state.ok, state.duration = time.Now(), err == nil, time.Since(start)
+			if err != nil {
+				state.errors++
+			}
+		}
+
+		hostname, device := m.export.Hostname, m.export.Device
+		if state.ok {
+			last, _, _ := m.status.snapshot()
+			ch <- prometheus.MustNewConstMetric(scrapeCurrentDesc, prometheus.GaugeValue, last.Current, hostname, device)
+			ch <- prometheus.MustNewConstMetric(scrapeVoltageDesc, prometheus.GaugeValue, last.Voltage, hostname, device)
+			ch <- prometheus.MustNewConstMetric(scrapePowerDesc, prometheus.GaugeValue, last.Power, hostname, device)
+		}
+		ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, state.duration.Seconds(), hostname, device)
+		ch <- prometheus.MustNewConstMetric(scrapeErrorsDesc, prometheus.CounterValue, float64(state.errors), hostname, device)
+	}
+	// Sensors removed by a reload or discovery are forgotten
+	c.states = states
+	if c.batch != nil {
+		publishMu.Lock()
+		c.batch.flush()
+		publishMu.Unlock()
+	}
+}
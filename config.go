@@ -0,0 +1,715 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gopkg.in/yaml.v3"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/driver"
+)
+
+// fileConfig is the --config file: the wiring of the bus, and of any other buses
+// power monitors sit on, so a fleet with different topologies does not need long
+// host-specific command lines.
+type fileConfig struct {
+	Bus          string         `yaml:"bus" doc:"the main bus, e.g. /dev/i2c-1"`
+	PollInterval time.Duration  `yaml:"poll_interval" doc:"e.g. 500ms"`
+	Mux          *muxConfig     `yaml:"mux" doc:"omitted when the sensors are connected directly"`
+	Sensors      []sensorConfig `yaml:"sensors" doc:"the sensors to poll, at least one"`
+	INA260       *ina260Config  `yaml:"ina260" doc:"Configuration register settings shared by every INA260"`
+	Alerts       []alertConfig  `yaml:"alerts" doc:"thresholds on the power monitor readings, with their actions"`
+	PowerBudgets []budgetConfig `yaml:"power_budgets" doc:"limits on the summed power of groups of devices, with their actions"`
+	ADCs         []adcConfig    `yaml:"adcs" doc:"ADS1115 and ADS1015 converters, each on a mux channel of its own"`
+}
+
+// ina260Config sets the INA260 Configuration register fields, as the flags of the same names do.
+type ina260Config struct {
+	Averaging           int                `yaml:"averaging" doc:"e.g. 16"`
+	BusConversionTime   time.Duration      `yaml:"bus_conversion_time" doc:"e.g. 1.1ms"`
+	ShuntConversionTime time.Duration      `yaml:"shunt_conversion_time" doc:"e.g. 1.1ms"`
+	OperatingMode       string             `yaml:"operating_mode" doc:"e.g. continuous"`
+	Alert               *ina260AlertConfig `yaml:"alert" doc:"the ALERT pin, as the --alert-* flags"`
+}
+
+// ina260AlertConfig sets up the INA260 ALERT pin, as the --alert-* flags do.
+type ina260AlertConfig struct {
+	Function   string   `yaml:"function" doc:"e.g. over-current"`
+	Limit      *float64 `yaml:"limit" doc:"in A, V or W"`
+	ActiveHigh bool     `yaml:"active_high" doc:"drive the pin high when asserted"`
+	Latch      bool     `yaml:"latch" doc:"keep it asserted until the flag is read"`
+	GPIO       string   `yaml:"gpio" doc:"pin the ALERT outputs are wired to, e.g. GPIO22"`
+}
+
+// muxConfig describes the TCA9548As the sensors sit behind. Sensors on another bus
+// with a channel sit behind muxes at the same addresses on that bus.
+type muxConfig struct {
+	Type       string `yaml:"type" doc:"tca9548a (default), pca9548a, tca9546a, pca9546a or pca9545a"`
+	Address    string `yaml:"address" doc:"e.g. 0x70, or 0x70,0x71 for several muxes numbered like --tca-address"`
+	ResetGPIO  string `yaml:"reset_gpio" doc:"e.g. GPIO17"`
+	Verify     bool   `yaml:"verify" doc:"read the control register back, as --mux.verify"`
+	SelectMode string `yaml:"select_mode" doc:"sticky (default) or all-off, as --mux.select-mode"`
+}
+
+// sensorConfig describes one sensor.
+type sensorConfig struct {
+	Channel *int   `yaml:"channel" doc:"mux channel; omitted without a mux"`
+	MuxPath string `yaml:"mux_path" doc:"channels of nested muxes instead, e.g. \"0x70/3 -> 0x71/5\"; power monitors only"`
+	Chip    string `yaml:"chip" doc:"ina260 (default), ina219, ina226, ina3221, or a registered driver: bme280 for a BME280/BMP280, mcp9808, tmp117 or another one"`
+	Address string `yaml:"address" doc:"I2C address of a sensor of a registered driver, e.g. 0x45; the driver's own by default, or --bme280-address, --mcp9808-address or --tmp117-address"`
+	Name    string `yaml:"name" doc:"friendly device label, replacing the generated one"`
+	Bus     string `yaml:"bus" doc:"another bus than the main one, e.g. /dev/i2c-3; power monitors only"`
+	PEC     bool   `yaml:"pec" doc:"SMBus packet error checking, for a driver from another module whose chip supports it"`
+
+	Labels map[string]string `yaml:"labels" doc:"static extra labels, e.g. rack: r1; added to every series and reading of the sensor"`
+
+	Interval time.Duration `yaml:"interval" doc:"poll interval of this sensor instead of poll_interval, at least 100ms"`
+	Jitter   time.Duration `yaml:"jitter" doc:"random delay before each poll instead of --poll-jitter"`
+}
+
+// checkSchedule checks a per-sensor interval and jitter, where 0 keeps the global setting.
+func checkSchedule(interval, jitter time.Duration) error {
+	if interval != 0 && interval < minSensorInterval {
+		return fmt.Errorf("interval must be at least %s, got %s", minSensorInterval, interval)
+	}
+	if jitter < 0 {
+		return fmt.Errorf("jitter must not be negative, got %s", jitter)
+	}
+	if interval != 0 && jitter >= interval {
+		return fmt.Errorf("jitter %s must be shorter than the interval %s", jitter, interval)
+	}
+	return nil
+}
+
+// withSchedule returns defaults with the per-sensor interval and jitter that are set.
+func withSchedule(defaults pollSchedule, interval, jitter time.Duration) pollSchedule {
+	if interval != 0 {
+		defaults.interval = interval
+	}
+	if jitter != 0 {
+		defaults.jitter = jitter
+	}
+	return defaults
+}
+
+// loadConfig reads and validates a --config file. Unknown keys are rejected, so
+// a misspelled setting fails loudly instead of being ignored. With failFast false,
+// invalid entries are dropped and returned in skipped instead, for logSkipped.
+func loadConfig(path string, failFast bool) (cfg *fileConfig, skipped []error, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	cfg = new(fileConfig)
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if skipped, err = cfg.validate(failFast); err != nil {
+		return nil, nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, skipped, nil
+}
+
+var configSkippedEntries = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "config_skipped_entries",
+	Help: "Number of invalid entries of the config file skipped at the last load under --fail-fast-on-config-error=false.",
+})
+
+// logSkipped logs the entries of the config file at path that loadConfig skipped,
+// and counts them in config_skipped_entries.
+func logSkipped(path string, skipped []error) {
+	for _, err := range skipped {
+		slog.Warn("Skipping invalid entry of the config file", "config", path, "err", err)
+	}
+	configSkippedEntries.Set(float64(len(skipped)))
+}
+
+// validate checks the config and fills in the defaults. An invalid sensor, ADC,
+// alert or power budget fails the file, or with failFast false, is dropped and
+// returned in skipped so the valid entries still run.
+func (c *fileConfig) validate(failFast bool) (skipped []error, err error) {
+	if c.PollInterval < 0 {
+		return nil, fmt.Errorf("poll_interval must not be negative, got %s", c.PollInterval)
+	}
+	if len(c.Sensors) == 0 {
+		return nil, fmt.Errorf("at least one sensor is required")
+	}
+	// skip returns err, or without failFast, keeps it in skipped to drop the entry
+	skip := func(err error) error {
+		if failFast {
+			return err
+		}
+		skipped = append(skipped, err)
+		return nil
+	}
+	var seen configSeen
+	sensors := c.Sensors[:0]
+	for i := range c.Sensors {
+		if err := c.validateSensor(i, &seen); err != nil {
+			if err := skip(fmt.Errorf("sensor %d: %w", i, err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seen.useSensor(c.Sensors[i])
+		sensors = append(sensors, c.Sensors[i])
+	}
+	c.Sensors = sensors
+	adcs := c.ADCs[:0]
+	for i := range c.ADCs {
+		if err := c.validateADC(&c.ADCs[i], &seen); err != nil {
+			if err := skip(fmt.Errorf("adc %d: %w", i, err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seen.useADC(c.ADCs[i])
+		adcs = append(adcs, c.ADCs[i])
+	}
+	c.ADCs = adcs
+	alerts := c.Alerts[:0]
+	alertNames := make(map[string]bool)
+	for i := range c.Alerts {
+		a := &c.Alerts[i]
+		err := a.validate()
+		if err == nil && alertNames[a.Name] {
+			err = fmt.Errorf("name %q is used more than once", a.Name)
+		}
+		if err != nil {
+			if err := skip(fmt.Errorf("alert %d: %w", i, err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		alertNames[a.Name] = true
+		alerts = append(alerts, *a)
+	}
+	c.Alerts = alerts
+	budgets := c.PowerBudgets[:0]
+	budgetNames := make(map[string]bool)
+	for i := range c.PowerBudgets {
+		b := &c.PowerBudgets[i]
+		err := b.validate()
+		if err == nil && budgetNames[b.Name] {
+			err = fmt.Errorf("name %q is used more than once", b.Name)
+		}
+		if err != nil {
+			if err := skip(fmt.Errorf("power budget %d: %w", i, err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		budgetNames[b.Name] = true
+		budgets = append(budgets, *b)
+	}
+	c.PowerBudgets = budgets
+	if len(c.powerSensors()) == 0 {
+		return nil, fmt.Errorf("at least one power monitor on the main bus is required besides the sensors of registered drivers")
+	}
+	if seen.power == chipINA3221 && (len(c.Sensors) > 1 || len(c.ADCs) > 0) {
+		return nil, fmt.Errorf("only one %s sensor is supported, and no other sensor next to it", chipINA3221)
+	}
+	return skipped, nil
+}
+
+// configSeen is what the valid sensors and ADCs before an entry use, for the
+// checks across entries.
+type configSeen struct {
+	channels  map[string]map[int]bool // by bus
+	direct    map[string]bool         // buses with a sensor without a channel
+	adcDirect bool                    // an ADC without a channel
+	names     map[string]bool
+	paths     map[string]bool
+	power     string // the chip of the power monitors
+}
+
+// validateSensor checks the i-th sensor against the entries seen before it and
+// fills in its defaults.
+func (c *fileConfig) validateSensor(i int, seen *configSeen) error {
+	s := &c.Sensors[i]
+	if s.Chip == "" {
+		s.Chip = chipINA260
+	} else if !isBuiltinChip(s.Chip) && !isDriverChip(s.Chip) {
+		return fmt.Errorf("invalid chip %q: must be %s, %s, %s, %s or a registered driver: %s", s.Chip, chipINA260, chipINA219, chipINA226, chipINA3221, strings.Join(driver.Names(), ", "))
+	}
+	if s.Address != "" {
+		if !isDriverChip(s.Chip) {
+			return fmt.Errorf("address only applies to the sensors of a registered driver")
+		}
+		if _, err := s.addr(); err != nil {
+			return err
+		}
+	}
+	if s.PEC && !supportsPEC(s.Chip) {
+		return fmt.Errorf("the %s does not support packet error checking; only drivers from other modules that set driver.Driver.PEC do", s.Chip)
+	}
+	if isDriverChip(s.Chip) {
+		if c.Mux == nil {
+			return fmt.Errorf("a %s needs a mux", s.Chip)
+		}
+	} else if seen.power != "" && s.Chip != seen.power {
+		return fmt.Errorf("all power monitors must use the same chip")
+	}
+	if s.Bus != "" {
+		if s.Bus == c.Bus {
+			return fmt.Errorf("bus %s is the main bus; leave bus out", s.Bus)
+		}
+		if isDriverChip(s.Chip) || s.Chip == chipINA3221 {
+			return fmt.Errorf("only %s, %s and %s sensors can be on another bus", chipINA260, chipINA219, chipINA226)
+		}
+	}
+	if err := checkSchedule(s.Interval, s.Jitter); err != nil {
+		return err
+	}
+	if s.MuxPath != "" {
+		if err := c.validateMuxPath(i); err != nil {
+			return err
+		}
+	} else if c.Mux != nil && s.Channel == nil && s.Bus == "" {
+		// Sensors on another bus may be connected to it directly even with a mux on the main bus
+		return fmt.Errorf("channel is required behind a mux")
+	}
+	if c.Mux == nil && s.Channel != nil {
+		return fmt.Errorf("channel is set but there is no mux")
+	}
+	switch {
+	case s.MuxPath != "":
+		if seen.paths[s.MuxPath] {
+			return fmt.Errorf("mux_path %q is used more than once", s.MuxPath)
+		}
+	case s.Channel != nil:
+		if seen.channels[s.Bus][*s.Channel] {
+			return fmt.Errorf("channel %d is used more than once", *s.Channel)
+		}
+	case seen.direct[s.Bus]:
+		return fmt.Errorf("several sensors on one bus need a mux")
+	}
+	if s.Name != "" && seen.names[s.Name] {
+		return fmt.Errorf("name %q is used more than once", s.Name)
+	}
+	return checkLabels(s.Labels)
+}
+
+// useSensor records the valid sensor s for the checks of the entries after it.
+func (seen *configSeen) useSensor(s sensorConfig) {
+	if !isDriverChip(s.Chip) && seen.power == "" {
+		seen.power = s.Chip
+	}
+	switch {
+	case s.MuxPath != "":
+		if seen.paths == nil {
+			seen.paths = make(map[string]bool)
+		}
+		seen.paths[s.MuxPath] = true
+	case s.Channel != nil:
+		seen.useChannel(s.Bus, *s.Channel)
+	default:
+		if seen.direct == nil {
+			seen.direct = make(map[string]bool)
+		}
+		seen.direct[s.Bus] = true
+	}
+	seen.useName(s.Name)
+}
+
+// validateADC checks a against the entries seen before it and fills in its defaults.
+func (c *fileConfig) validateADC(a *adcConfig, seen *configSeen) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	if c.Mux != nil && a.Channel == nil {
+		return fmt.Errorf("channel is required behind a mux")
+	}
+	if c.Mux == nil && a.Channel != nil {
+		return fmt.Errorf("channel is set but there is no mux")
+	}
+	if a.Channel != nil {
+		if seen.channels[""][*a.Channel] {
+			return fmt.Errorf("channel %d is used more than once", *a.Channel)
+		}
+	} else if seen.adcDirect {
+		return fmt.Errorf("several ADCs on one bus need a mux")
+	}
+	if a.Name != "" && seen.names[a.Name] {
+		return fmt.Errorf("name %q is used more than once", a.Name)
+	}
+	return nil
+}
+
+// useADC records the valid ADC a for the checks of the entries after it.
+func (seen *configSeen) useADC(a adcConfig) {
+	if a.Channel != nil {
+		seen.useChannel("", *a.Channel)
+	} else {
+		seen.adcDirect = true
+	}
+	seen.useName(a.Name)
+}
+
+func (seen *configSeen) useChannel(bus string, channel int) {
+	if seen.channels == nil {
+		seen.channels = make(map[string]map[int]bool)
+	}
+	if seen.channels[bus] == nil {
+		seen.channels[bus] = make(map[int]bool)
+	}
+	seen.channels[bus][channel] = true
+}
+
+func (seen *configSeen) useName(name string) {
+	if name == "" {
+		return
+	}
+	if seen.names == nil {
+		seen.names = make(map[string]bool)
+	}
+	seen.names[name] = true
+}
+
+// labelName matches a valid Prometheus label name.
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// checkLabels checks the extra labels of a sensor. The labels every series
+// already has cannot be replaced, and names starting with __ are reserved
+// for Prometheus.
+func checkLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q: must be letters, digits and underscores, not starting with a digit or __", name)
+		}
+		if name == "hostname" || name == "device" {
+			return fmt.Errorf("label %s is set by the exporter; use name for the device label", name)
+		}
+	}
+	return nil
+}
+
+// validateMuxPath checks the mux_path of the i-th sensor and puts it in the
+// canonical form, so the same path is not used twice under different spellings.
+func (c *fileConfig) validateMuxPath(i int) error {
+	s := &c.Sensors[i]
+	if c.Mux == nil {
+		return errors.New("mux_path is set but there is no mux")
+	}
+	if s.Channel != nil || s.Bus != "" {
+		return errors.New("mux_path replaces channel and is only supported on the main bus")
+	}
+	if isDriverChip(s.Chip) || s.Chip == chipINA3221 {
+		return fmt.Errorf("only %s, %s and %s sensors can sit behind nested muxes", chipINA260, chipINA219, chipINA226)
+	}
+	hops, err := parseMuxPath(s.MuxPath)
+	if err != nil {
+		return err
+	}
+	s.MuxPath = formatMuxPath(hops)
+	return nil
+}
+
+// muxHop is one mux of a mux_path and the channel taken on it.
+type muxHop struct {
+	addr    uint16
+	channel int
+}
+
+// parseMuxPath parses a mux_path such as "0x70/3 -> 0x71/5": the channel of a
+// mux of the main bus, then the channel of each mux cascaded behind the one
+// before, down to the sensor's.
+func parseMuxPath(spec string) ([]muxHop, error) {
+	var hops []muxHop
+	for _, part := range strings.Split(spec, "->") {
+		addr, channel, ok := strings.Cut(strings.TrimSpace(part), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid mux_path %q: each hop must be an address and a channel, as in 0x70/3", spec)
+		}
+		a, err := strconv.ParseUint(addr, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mux_path %q: invalid address %q", spec, addr)
+		}
+		ch, err := strconv.Atoi(channel)
+		if err != nil || ch < 0 {
+			return nil, fmt.Errorf("invalid mux_path %q: invalid channel %q", spec, channel)
+		}
+		hops = append(hops, muxHop{uint16(a), ch})
+	}
+	if len(hops) < 2 {
+		return nil, fmt.Errorf("invalid mux_path %q: a path needs at least two muxes; use channel for a sensor behind one", spec)
+	}
+	for i, h := range hops {
+		for _, before := range hops[:i] {
+			if h.addr == before.addr {
+				return nil, fmt.Errorf("invalid mux_path %q: mux 0x%02X is on the path twice", spec, h.addr)
+			}
+		}
+	}
+	return hops, nil
+}
+
+// formatMuxPath formats hops in the canonical form of a mux_path.
+func formatMuxPath(hops []muxHop) string {
+	parts := make([]string, len(hops))
+	for i, h := range hops {
+		parts[i] = fmt.Sprintf("0x%02X/%d", h.addr, h.channel)
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// isBuiltinChip reports whether chip is a power monitor, supported without a
+// registered driver.
+func isBuiltinChip(chip string) bool {
+	switch chip {
+	case chipINA260, chipINA219, chipINA226, chipINA3221:
+		return true
+	}
+	return false
+}
+
+// isDriverChip reports whether chip is polled through a registered driver. The
+// built-in chips take precedence over a driver of the same name.
+func isDriverChip(chip string) bool {
+	_, ok := driver.Lookup(chip)
+	return ok && !isBuiltinChip(chip)
+}
+
+// supportsPEC reports whether chip is polled through a registered driver of a
+// chip with SMBus packet error checking. None of the built-in chips or drivers
+// has it, so only a driver from another module can.
+func supportsPEC(chip string) bool {
+	d, _ := driver.Lookup(chip)
+	return isDriverChip(chip) && d.PEC
+}
+
+// addr returns the I2C address of a sensor of a registered driver.
+func (s *sensorConfig) addr() (uint16, error) {
+	if s.Address == "" {
+		d, _ := driver.Lookup(s.Chip)
+		return d.Address, nil
+	}
+	addr, err := strconv.ParseUint(s.Address, 0, 16)
+	if err != nil || addr < 0x03 || addr > 0x77 {
+		return 0, fmt.Errorf("invalid address %q: must be between 0x03 and 0x77", s.Address)
+	}
+	return uint16(addr), nil
+}
+
+// powerSensors returns the power monitors on the main bus, leaving out the
+// sensors of registered drivers, the sensors on other buses and behind nested muxes.
+func (c *fileConfig) powerSensors() []sensorConfig {
+	var sensors []sensorConfig
+	for _, s := range c.Sensors {
+		if !isDriverChip(s.Chip) && s.Bus == "" && s.MuxPath == "" {
+			sensors = append(sensors, s)
+		}
+	}
+	return sensors
+}
+
+// nestedSensors returns the power monitors behind nested muxes, by mux_path.
+func (c *fileConfig) nestedSensors() []sensorConfig {
+	var sensors []sensorConfig
+	for _, s := range c.Sensors {
+		if s.MuxPath != "" {
+			sensors = append(sensors, s)
+		}
+	}
+	return sensors
+}
+
+// otherBusSensors returns the power monitors on other buses than the main one.
+func (c *fileConfig) otherBusSensors() []sensorConfig {
+	var sensors []sensorConfig
+	for _, s := range c.Sensors {
+		if s.Bus != "" {
+			sensors = append(sensors, s)
+		}
+	}
+	return sensors
+}
+
+// apply sets every flag the config file covers that was not given on the command
+// line, so command-line flags always take precedence. It returns the friendly
+// names of the sensors on the main bus by mux channel (-1 for a directly
+// connected sensor).
+func (c *fileConfig) apply(setFlags map[string]bool) (map[int]string, error) {
+	power := c.powerSensors()
+	values := map[string]string{
+		"chip": power[0].Chip,
+	}
+	if c.Bus != "" {
+		values["bus"] = c.Bus
+	}
+	if c.PollInterval > 0 && !setFlags["poll-hz"] {
+		values["poll-interval"] = c.PollInterval.String()
+	}
+	if c.Mux == nil {
+		values["without-multiplexer"] = "true"
+	} else {
+		if c.Mux.Type != "" {
+			values["mux.type"] = c.Mux.Type
+		}
+		if c.Mux.Address != "" {
+			values["tca-address"] = c.Mux.Address
+		}
+		if c.Mux.ResetGPIO != "" {
+			values["mux-reset-gpio"] = c.Mux.ResetGPIO
+		}
+		if c.Mux.Verify {
+			values["mux.verify"] = "true"
+		}
+		if c.Mux.SelectMode != "" {
+			values["mux.select-mode"] = c.Mux.SelectMode
+		}
+		if len(power) == 1 {
+			values["channel"] = strconv.Itoa(*power[0].Channel)
+		} else if !setFlags["channel"] {
+			var channels []string
+			for _, s := range power {
+				channels = append(channels, strconv.Itoa(*s.Channel))
+			}
+			values["channels"] = strings.Join(channels, ",")
+		}
+	}
+	if c.INA260 != nil {
+		if c.INA260.Averaging != 0 {
+			values["averaging"] = strconv.Itoa(c.INA260.Averaging)
+		}
+		if c.INA260.BusConversionTime != 0 {
+			values["bus-conversion-time"] = c.INA260.BusConversionTime.String()
+		}
+		if c.INA260.ShuntConversionTime != 0 {
+			values["shunt-conversion-time"] = c.INA260.ShuntConversionTime.String()
+		}
+		if c.INA260.OperatingMode != "" {
+			values["operating-mode"] = c.INA260.OperatingMode
+		}
+		if a := c.INA260.Alert; a != nil {
+			values["alert-function"] = a.Function
+			if a.Limit != nil {
+				values["alert-limit"] = strconv.FormatFloat(*a.Limit, 'g', -1, 64)
+			}
+			values["alert-active-high"] = strconv.FormatBool(a.ActiveHigh)
+			values["alert-latch"] = strconv.FormatBool(a.Latch)
+			if a.GPIO != "" {
+				values["alert-gpio"] = a.GPIO
+			}
+		}
+	}
+	for name, value := range values {
+		if setFlags[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+	}
+
+	names := make(map[int]string)
+	for _, s := range c.Sensors {
+		if s.Name == "" || s.Bus != "" || s.MuxPath != "" {
+			continue
+		}
+		names[s.channel()] = s.Name
+	}
+	return names, nil
+}
+
+// channel returns the mux channel of s, or -1 for a directly connected sensor.
+func (s sensorConfig) channel() int {
+	if s.Channel == nil {
+		return -1
+	}
+	return *s.Channel
+}
+
+// deviceLabels returns the extra labels of the sensors on the main bus by mux
+// channel (-1 for a directly connected sensor), like the names of apply.
+func (c *fileConfig) deviceLabels() map[int]map[string]string {
+	labels := make(map[int]map[string]string)
+	for _, s := range c.Sensors {
+		if len(s.Labels) > 0 && s.Bus == "" && s.MuxPath == "" {
+			labels[s.channel()] = s.Labels
+		}
+	}
+	return labels
+}
+
+// printConfigSchema writes the structure of a --config file for --print-config-schema:
+// every key with its type, and the description of its doc tag.
+func printConfigSchema(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# --config file; durations are like 500ms or 1.1ms\n")
+	writeSchema(&b, reflect.TypeOf(fileConfig{}), "", "")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSchema writes a line for each yaml key of the struct t, the first indented
+// with first (which starts a list entry) and the others with indent.
+func writeSchema(b *strings.Builder, t reflect.Type, first, indent string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("yaml")
+		if key == "" {
+			continue
+		}
+		line := indent
+		if i == 0 {
+			line = first
+		}
+		line += key + ":"
+		typ := field.Type
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		var nested reflect.Type
+		var list bool
+		switch {
+		case typ.Kind() == reflect.Struct:
+			nested = typ
+		case typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Struct:
+			nested, list = typ.Elem(), true
+		case typ.Kind() == reflect.Slice:
+			line += " [<" + schemaType(typ.Elem()) + ">, ...]"
+		case typ.Kind() == reflect.Map:
+			line += " {<" + schemaType(typ.Key()) + ">: <" + schemaType(typ.Elem()) + ">, ...}"
+		default:
+			line += " <" + schemaType(typ) + ">"
+		}
+		if doc := field.Tag.Get("doc"); doc != "" {
+			line += "  # " + doc
+		}
+		b.WriteString(line + "\n")
+		switch {
+		case list:
+			writeSchema(b, nested, indent+"  - ", indent+"    ")
+		case nested != nil:
+			writeSchema(b, nested, indent+"  ", indent+"  ")
+		}
+	}
+}
+
+// schemaType names the YAML type of a config value.
+func schemaType(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return "float"
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestConfigSchemaDocumentsEveryKey checks that every key of --print-config-schema
+// has a description, so a new config field cannot ship without a doc tag.
+func TestConfigSchemaDocumentsEveryKey(t *testing.T) {
+	var b strings.Builder
+	if err := printConfigSchema(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	for _, line := range lines[1:] {
+		if !strings.Contains(line, "  # ") {
+			t.Errorf("key without a description: %q", line)
+		}
+	}
+	for _, key := range []string{"\nsensors:", "\n  - channel: <int>", "\n    interval: <duration>", "\n      - input: <int>"} {
+		if !strings.Contains(b.String(), key) {
+			t.Errorf("schema lacks %q", key)
+		}
+	}
+}
+
+// TestValidateBestEffort checks that without fail-fast, invalid entries are
+// dropped with their reason, and that a dropped entry does not take its
+// channel or name from the valid entries after it.
+func TestValidateBestEffort(t *testing.T) {
+	const file = `
+mux:
+  address: 0x70
+sensors:
+  - channel: 0
+    name: bad_labels
+    labels: {__reserved: x}
+  - channel: 0
+    name: first
+  - channel: 1
+    chip: nosuch
+  - channel: 2
+    name: first
+  - channel: 3
+    name: bad_labels
+alerts:
+  - name: power
+    quantity: power
+    above: 5
+  - name: power
+    quantity: power
+    above: 10
+`
+	var strict fileConfig
+	if err := yaml.Unmarshal([]byte(file), &strict); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.validate(true); err == nil || !strings.HasPrefix(err.Error(), "sensor 0: ") {
+		t.Errorf("fail-fast validate error = %v, want one for sensor 0", err)
+	}
+
+	var cfg fileConfig
+	if err := yaml.Unmarshal([]byte(file), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	skipped, err := cfg.validate(false)
+	if err != nil {
+		t.Fatalf("best-effort validate: %v", err)
+	}
+	var reasons []string
+	for _, err := range skipped {
+		reasons = append(reasons, strings.SplitN(err.Error(), ":", 2)[0])
+	}
+	if want := []string{"sensor 0", "sensor 2", "sensor 3", "alert 1"}; !slices.Equal(reasons, want) {
+		t.Errorf("skipped %v, want %v", reasons, want)
+	}
+	var kept []string
+	for _, s := range cfg.Sensors {
+		kept = append(kept, fmt.Sprintf("%s@%d", s.Name, *s.Channel))
+	}
+	if want := []string{"first@0", "bad_labels@3"}; !slices.Equal(kept, want) {
+		t.Errorf("kept sensors %v, want %v", kept, want)
+	}
+	if len(cfg.Alerts) != 1 || *cfg.Alerts[0].Above != 5 {
+		t.Errorf("kept alerts %+v, want the first one", cfg.Alerts)
+	}
+}
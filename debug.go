@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+// i2cDebugAPI serves POST /api/v1/debug/i2c, which reads or writes one register
+// of any device on the bus or behind a mux channel, like i2cget and i2cset. It is
+// only served with --debug-i2c-token-file, and every request needs that token.
+type i2cDebugAPI struct {
+	bus      i2c.Bus
+	muxes    *tca9548a.Group // nil without a mux
+	model    tca9548a.Model
+	deselect bool   // deselect all channels after each access, as --disable-after-read does
+	token    []byte // expected in an Authorization: Bearer header
+}
+
+// i2cDebugRequest is the body of POST /api/v1/debug/i2c. Numbers are strings so
+// they can be given in hex, e.g. "0x40".
+type i2cDebugRequest struct {
+	Op       string `json:"op"`       // read or write
+	Channel  *int   `json:"channel"`  // mux channel numbered across the muxes, as --channels; omitted for the main bus
+	Address  string `json:"address"`  // 7-bit device address
+	Register string `json:"register"` // register address
+	Width    int    `json:"width"`    // register width in bytes: 2 (default) for the big-endian TI registers, or 1
+	Value    string `json:"value"`    // value to write; write only
+}
+
+// i2cDebugResponse echoes the transaction with the value read or written, in hex.
+type i2cDebugResponse struct {
+	Op       string `json:"op"`
+	Channel  *int   `json:"channel,omitempty"`
+	Address  string `json:"address"`
+	Register string `json:"register"`
+	Width    int    `json:"width"`
+	Value    string `json:"value"`
+}
+
+func (d *i2cDebugAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/debug/i2c", d.handle)
+}
+
+// bypassesBasicAuth reports whether r is a debug request with a valid bearer
+// token. It takes the Authorization header that basic auth would need, so such a
+// request skips the basic auth of --web.config.file.
+func (d *i2cDebugAPI) bypassesBasicAuth(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.URL.Path == "/api/v1/debug/i2c" && d.authorized(r)
+}
+
+// authorized checks the bearer token in constant time.
+func (d *i2cDebugAPI) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), d.token) == 1
+}
+
+func (d *i2cDebugAPI) handle(w http.ResponseWriter, r *http.Request) {
+	if !d.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "missing or invalid bearer token"})
+		return
+	}
+	var req i2cDebugRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid request body: " + err.Error()})
+		return
+	}
+	addr, reg, value, err := d.validate(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	logger := slog.With("op", req.Op, "address", fmt.Sprintf("0x%02X", addr), "register", fmt.Sprintf("0x%02X", reg), "remote", r.RemoteAddr)
+	if req.Channel != nil {
+		logger = logger.With("channel", *req.Channel)
+	}
+	if req.Op == "write" {
+		// Writes change the state of a sensor behind the exporter's back, so they are always logged
+		logger.Warn("Debug API writing I2C register", "value", fmt.Sprintf("0x%0*X", req.Width*2, value))
+	}
+	value, err = d.transact(req, addr, reg, value)
+	if err != nil {
+		logger.Debug("Debug API I2C transaction failed", "err", err)
+		writeJSON(w, http.StatusBadGateway, apiError{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, i2cDebugResponse{
+		Op:       req.Op,
+		Channel:  req.Channel,
+		Address:  fmt.Sprintf("0x%02X", addr),
+		Register: fmt.Sprintf("0x%02X", reg),
+		Width:    req.Width,
+		Value:    fmt.Sprintf("0x%0*X", req.Width*2, value),
+	})
+}
+
+// validate checks req, fills in the default width and parses its numbers.
+func (d *i2cDebugAPI) validate(req *i2cDebugRequest) (addr uint16, reg byte, value uint16, err error) {
+	if req.Op != "read" && req.Op != "write" {
+		return 0, 0, 0, fmt.Errorf("invalid op %q: must be read or write", req.Op)
+	}
+	if req.Width == 0 {
+		req.Width = 2
+	}
+	if req.Width != 1 && req.Width != 2 {
+		return 0, 0, 0, fmt.Errorf("invalid width %d: must be 1 or 2", req.Width)
+	}
+	a, err := strconv.ParseUint(req.Address, 0, 7)
+	if err != nil || a < scanFirstAddress || a > scanLastAddress {
+		return 0, 0, 0, fmt.Errorf("invalid address %q: must be between 0x%02X and 0x%02X", req.Address, scanFirstAddress, scanLastAddress)
+	}
+	addr = uint16(a)
+	r, err := strconv.ParseUint(req.Register, 0, 8)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid register %q: must be between 0x00 and 0xFF", req.Register)
+	}
+	reg = byte(r)
+	if req.Op == "write" {
+		v, err := strconv.ParseUint(req.Value, 0, req.Width*8)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid value %q for a %d-byte register", req.Value, req.Width)
+		}
+		value = uint16(v)
+	} else if req.Value != "" {
+		return 0, 0, 0, fmt.Errorf("value is only used with op write")
+	}
+	if d.muxes == nil {
+		if req.Channel != nil {
+			return 0, 0, 0, fmt.Errorf("channel is set but there is no mux")
+		}
+		return addr, reg, value, nil
+	}
+	if req.Channel != nil && (*req.Channel < 0 || *req.Channel >= len(d.muxes.Muxes)*d.model.Channels) {
+		return 0, 0, 0, fmt.Errorf("invalid channel %d: must be between 0 and %d", *req.Channel, len(d.muxes.Muxes)*d.model.Channels-1)
+	}
+	// Selecting channels goes through the channel field, so the group's view of the muxes stays right
+	if slices.ContainsFunc(d.muxes.Muxes, func(m *tca9548a.Mux) bool { return m.Dev.Addr == addr }) {
+		return 0, 0, 0, fmt.Errorf("address 0x%02X is a mux; use the channel field to select its channels", addr)
+	}
+	return addr, reg, value, nil
+}
+
+// transact selects the channel, or deselects every channel for the main bus, and
+// runs the read or write under busMu, so it never interleaves with polling.
+func (d *i2cDebugAPI) transact(req i2cDebugRequest, addr uint16, reg byte, value uint16) (uint16, error) {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if d.muxes != nil {
+		var err error
+		if req.Channel != nil {
+			mask, _ := d.model.ChannelMask(*req.Channel % d.model.Channels) // Already validated
+			_, err = d.muxes.Select(*req.Channel/d.model.Channels, mask)
+		} else {
+			err = d.muxes.Deselect()
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to select mux channel: %w", err)
+		}
+		if d.deselect {
+			defer func() {
+				if err := d.muxes.Deselect(); err != nil {
+					slog.Warn("Failed to deselect mux channels", "err", err)
+				}
+			}()
+		}
+	}
+
+	dev := &i2c.Dev{Bus: d.bus, Addr: addr}
+	if req.Op == "write" {
+		w := []byte{reg, byte(value)}
+		if req.Width == 2 {
+			w = []byte{reg, byte(value >> 8), byte(value)}
+		}
+		if err := dev.Tx(w, nil); err != nil {
+			return 0, fmt.Errorf("failed to write register 0x%02X at 0x%02X: %w", reg, addr, err)
+		}
+		return value, nil
+	}
+	buf := make([]byte, req.Width)
+	if err := dev.Tx([]byte{reg}, buf); err != nil {
+		return 0, fmt.Errorf("failed to read register 0x%02X at 0x%02X: %w", reg, addr, err)
+	}
+	if req.Width == 2 {
+		return uint16(buf[0])<<8 | uint16(buf[1]), nil
+	}
+	return uint16(buf[0]), nil
+}
+
+// selectChannel selects a valid mux channel and leaves it selected, or deselects
+// every channel when channel is nil, for the SelectChannel gRPC call. It ignores
+// --disable-after-read, since the point is to keep the channel open.
+func (d *i2cDebugAPI) selectChannel(channel *int) error {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if channel == nil {
+		return d.muxes.Deselect()
+	}
+	mask, _ := d.model.ChannelMask(*channel % d.model.Channels) // Already validated
+	_, err := d.muxes.Select(*channel/d.model.Channels, mask)
+	return err
+}
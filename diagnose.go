@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/host/v3"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+// errSkipStep marks a diagnose step that does not apply to the current setup.
+var errSkipStep = errors.New("not applicable")
+
+// diagnoseStep is one check of the --diagnose sequence.
+type diagnoseStep struct {
+	name string
+	hint string // what to check when the step fails
+	run  func() error
+}
+
+// runDiagnose walks through bring-up one step at a time, printing PASS/FAIL with
+// the time each step took, and stops at the first failure since later steps
+// depend on it. It returns true if every step passed.
+func runDiagnose(busName string, skipHostInit bool, muxModel tca9548a.Model, tcaAddressStr string, channel int, withoutMux bool) bool {
+	var (
+		bus i2c.BusCloser
+		tca *i2c.Dev
+		dev *i2c.Dev
+	)
+	defer func() {
+		if bus != nil {
+			bus.Close()
+		}
+	}()
+
+	steps := []diagnoseStep{
+		{
+			name: "host init",
+			hint: "run as root or a user in the i2c/gpio groups",
+			run: func() error {
+				if skipHostInit {
+					return errSkipStep
+				}
+				_, err := host.Init()
+				return err
+			},
+		},
+		{
+			name: "bus open " + busName,
+			hint: "enable I2C (raspi-config or dtparam=i2c_arm=on) and check --bus",
+			run: func() error {
+				var err error
+				bus, err = i2creg.Open(busName)
+				return err
+			},
+		},
+		{
+			name: "mux presence",
+			hint: "check --tca-address, the A0-A2 strapping and the mux power supply",
+			run: func() error {
+				if withoutMux {
+					return errSkipStep
+				}
+				tcaAddress, err := strconv.ParseUint(tcaAddressStr, 0, 16)
+				if err != nil {
+					return fmt.Errorf("invalid TCA address: %w", err)
+				}
+				tca = &i2c.Dev{Bus: bus, Addr: uint16(tcaAddress)}
+				return tca9548a.Probe(tca)
+			},
+		},
+		{
+			name: fmt.Sprintf("channel %d select", channel),
+			hint: fmt.Sprintf("use a channel between 0 and %d and check the SDA/SCL wiring to the mux", muxModel.Channels-1),
+			run: func() error {
+				if withoutMux {
+					return errSkipStep
+				}
+				mask, err := muxModel.ChannelMask(channel)
+				if err != nil {
+					return err
+				}
+				if err := tca.Tx([]byte{mask}, nil); err != nil {
+					return err
+				}
+				// A different model answering at the address may ignore the write
+				control, err := muxModel.ReadControl(tca)
+				if err != nil {
+					return err
+				}
+				if control != mask {
+					return fmt.Errorf("control register reads 0x%02X after writing 0x%02X; check --mux.type", control, mask)
+				}
+				return nil
+			},
+		},
+		{
+			name: fmt.Sprintf("INA260 presence at 0x%X", ina260.Address),
+			hint: "check the sensor is on the selected channel, powered, and its A0/A1 pins are strapped to 0x40",
+			run: func() error {
+				dev = &i2c.Dev{Bus: bus, Addr: ina260.Address}
+				_, err := ina260.ReadReg(dev, ina260.RegManufID)
+				return err
+			},
+		},
+		{
+			name: "INA260 identity",
+			hint: "a different chip answers at 0x40; check the part number on the board",
+			run: func() error {
+				manufID, err := ina260.ReadReg(dev, ina260.RegManufID)
+				if err != nil {
+					return err
+				}
+				deviceID, err := ina260.ReadReg(dev, ina260.RegDeviceID)
+				if err != nil {
+					return err
+				}
+				if manufID != ina260.ManufacturerID || deviceID&^ina260.RevisionMask != ina260.DeviceID {
+					return fmt.Errorf("expected manufacturer/device ID 0x%X/0x%X, got 0x%X/0x%X", ina260.ManufacturerID, ina260.DeviceID, manufID, deviceID)
+				}
+				return nil
+			},
+		},
+		{
+			name: "test read",
+			hint: "intermittent failures here usually mean long wires or a missing pull-up",
+			run: func() error {
+				s := &ina260.Sensor{Dev: dev, Scale: ina260.DefaultScale}
+				r, err := s.Read()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("       Voltage: %.3f V, Current: %.3f A, Power: %.3f W\n", r.Voltage, r.Current, r.Power)
+				return nil
+			},
+		},
+	}
+
+	passed, skipped := 0, 0
+	for i, step := range steps {
+		start := time.Now()
+		err := step.run()
+		elapsed := time.Since(start).Round(time.Microsecond)
+		switch {
+		case errors.Is(err, errSkipStep):
+			skipped++
+			fmt.Printf("[SKIP] %s (%v)\n", step.name, err)
+		case err != nil:
+			fmt.Printf("[FAIL] %s (%s): %v\n", step.name, elapsed, err)
+			fmt.Printf("       Check: %s\n", step.hint)
+			fmt.Printf("Summary: %d passed, %d skipped, failed at step %d of %d (%s)\n", passed, skipped, i+1, len(steps), step.name)
+			return false
+		default:
+			passed++
+			fmt.Printf("[PASS] %s (%s)\n", step.name, elapsed)
+		}
+	}
+	fmt.Printf("Summary: %d passed, %d skipped, all checks OK\n", passed, skipped)
+	return true
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+var i2cDeviceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "i2c_device_up",
+	Help: "1 while the power monitor found on a mux channel by --discover-interval answers with its IDs, 0 once it is gone.",
+}, []string{"hostname", "device", "chip"})
+
+// discoverer scans the mux channels for power monitors every --discover-interval,
+// starts polling the ones that appear and stops polling the ones that disappear.
+// A disappeared sensor's series are removed, and its i2c_device_up series reads 0
+// until it answers again.
+type discoverer struct {
+	bus      i2c.Bus
+	muxes    *tca9548a.Group
+	specs    []string // --tca-address of each mux of the group
+	model    tca9548a.Model
+	deselect bool // deselect all channels after each probe, as --disable-after-read does
+	hostname string
+	chip     string
+	fleet    *fleet
+	channels map[int]*monitor // polled sensors, by mux channel numbered across the muxes
+	skip     map[int]bool     // channels of other sensors, never probed
+	// create sets up the monitor of a sensor that appeared, returning an error when it cannot be set up
+	create func(channel int) (*monitor, error)
+	// label returns the device label of the sensor on a channel
+	label func(channel int) string
+
+	gauges map[int]prometheus.Gauge // i2c_device_up, by channel
+}
+
+// watch scans right away, then every interval until ctx is cancelled.
+func (d *discoverer) watch(ctx context.Context, interval time.Duration) {
+	d.gauges = make(map[int]prometheus.Gauge)
+	for ch := range d.channels {
+		d.gauge(ch).Set(1)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			d.scan(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (d *discoverer) gauge(channel int) prometheus.Gauge {
+	g, ok := d.gauges[channel]
+	if !ok {
+		g = i2cDeviceUp.WithLabelValues(d.hostname, d.label(channel), d.chip)
+		d.gauges[channel] = g
+	}
+	return g
+}
+
+// scan probes every channel once and applies what changed.
+func (d *discoverer) scan(ctx context.Context) {
+	for ch := 0; ch < len(d.muxes.Muxes)*d.model.Channels; ch++ {
+		if d.skip[ch] {
+			continue
+		}
+		present, err := d.probe(ch)
+		m, polled := d.channels[ch]
+		switch {
+		case err != nil:
+			slog.Debug("Failed to probe mux channel", append(muxAttrs(d.specs[ch/d.model.Channels], ch%d.model.Channels), "err", err)...)
+		case present && !polled:
+			m, err := d.create(ch)
+			if err != nil {
+				slog.Warn("Failed to set up discovered sensor", "device", d.label(ch), "err", err)
+				continue
+			}
+			d.channels[ch] = m
+			d.fleet.add(m)
+			d.fleet.start(ctx, m)
+			d.gauge(ch).Set(1)
+			m.logger.Info("Discovered sensor, polling it")
+		case !present && polled:
+			d.fleet.stop(m)
+			delete(d.channels, ch)
+			d.gauge(ch).Set(0)
+			m.logger.Warn("Sensor disappeared, stopped polling it")
+		}
+	}
+}
+
+// probe reports whether a TI power monitor of --chip answers on the channel. The
+// chip is told by its Die ID, from the table of scan, so another model on the
+// channel is not adopted under the wrong register map.
+func (d *discoverer) probe(channel int) (bool, error) {
+	mask, err := d.model.ChannelMask(channel % d.model.Channels)
+	if err != nil {
+		return false, err
+	}
+	busMu.Lock()
+	defer busMu.Unlock()
+	if _, err := d.muxes.Select(channel/d.model.Channels, mask); err != nil {
+		return false, err
+	}
+	defer func() {
+		if d.deselect {
+			if err := d.muxes.Deselect(); err != nil {
+				slog.Warn("Failed to deselect mux channels", "err", err)
+			}
+		}
+	}()
+	dev := &i2c.Dev{Bus: d.bus, Addr: ina260.Address}
+	// A missing sensor does not ACK, which is what discovery looks for rather than an error
+	manufID, err := ina260.ReadReg(dev, ina260.RegManufID)
+	if err != nil {
+		return false, nil
+	}
+	dieID, err := ina260.ReadReg(dev, ina260.RegDeviceID)
+	if err != nil {
+		return false, nil
+	}
+	if manufID != ina260.ManufacturerID {
+		return false, nil
+	}
+	name, ok := tiDieIDs[dieID&^ina260.RevisionMask]
+	if ok && strings.ToLower(name) != d.chip {
+		slog.Debug("Ignoring a power monitor of another chip", append(muxAttrs(d.specs[channel/d.model.Channels], channel%d.model.Channels), "found", name, "chip", d.chip)...)
+	}
+	return ok && strings.ToLower(name) == d.chip, nil
+}
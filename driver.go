@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/driver"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/smbus"
+)
+
+// driverMonitor is one polled sensor of a registered driver, such as a BME280 or
+// an MCP9808, on a mux channel of its own next to the power monitors.
+type driverMonitor struct {
+	sensor   driver.Sensor
+	chip     string // name of the driver
+	device   string
+	gate     *muxGate
+	schedule pollSchedule
+	logger   *slog.Logger
+	metrics  *exporter.DriverMetrics
+	quiet    bool // do not print readings to stdout
+}
+
+// newDriverMonitor returns the monitor of a sensor of a registered driver, from
+// the config file or a --*-channels flag, with packet error checking for pec: true, or with allPEC (--pec) if
+// the driver supports it.
+func newDriverMonitor(w *channelWiring, sc sensorConfig, allPEC bool, schedule pollSchedule) (*driverMonitor, error) {
+	drv, _ := driver.Lookup(sc.Chip)
+	addr, _ := sc.addr() // checked by loadConfig
+	var bus i2c.Bus = w.bus
+	if sc.PEC || allPEC && drv.PEC {
+		bus = &smbus.Bus{Bus: w.bus}
+	}
+	sensor := drv.New(&i2c.Dev{Bus: bus, Addr: addr})
+	if w.tune != nil {
+		w.tune(sensor)
+	}
+	label, gate, logger := w.place(*sc.Channel, sc.Chip, sc.Name, sc.Labels)
+	metrics, err := exporter.NewDriverMetrics(w.hostname, label, sc.Chip, sensor.Metrics())
+	if err != nil {
+		return nil, err
+	}
+	return &driverMonitor{
+		sensor:   sensor,
+		chip:     sc.Chip,
+		device:   label,
+		gate:     gate,
+		schedule: schedule,
+		logger:   logger,
+		metrics:  metrics,
+		quiet:    w.quiet,
+	}, nil
+}
+
+// init probes the chip through its driver.
+func (d *driverMonitor) init() error {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if err := d.gate.open(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.gate.close(); err != nil {
+			d.logger.Warn("Failed to close mux channel", "err", err)
+		}
+	}()
+	return d.sensor.Probe()
+}
+
+// poll takes one reading, publishes it to the gauges of the driver's metrics and
+// prints it unless quiet.
+func (d *driverMonitor) poll() error {
+	busMu.Lock()
+	err := d.gate.open()
+	var values []float64
+	if err == nil {
+		values, err = d.sensor.Read()
+	}
+	now := time.Now()
+	if cerr := d.gate.close(); cerr != nil {
+		d.logger.Warn("Failed to close mux channel", "err", cerr)
+	}
+	busMu.Unlock()
+	if err == nil {
+		err = d.metrics.Publish(values)
+	}
+	if err != nil {
+		d.logger.Error("Failed to read sensor", "err", err)
+		d.metrics.Failed(err)
+		return err
+	}
+	if !d.quiet {
+		var fields []string
+		for i, m := range d.sensor.Metrics() {
+			if !math.IsNaN(values[i]) {
+				fields = append(fields, fmt.Sprintf("%s: %g", m.Name, values[i]))
+			}
+		}
+		publishMu.Lock()
+		fmt.Printf("%s %s %s\n", d.device, now.Format(exporter.TextTimeFormat), strings.Join(fields, " "))
+		publishMu.Unlock()
+	}
+	return nil
+}
+
+// run polls the sensor until ctx is cancelled, like monitor.run.
+func (d *driverMonitor) run(ctx context.Context, errorBackoff time.Duration) {
+	pollEvery(ctx, d.schedule, errorBackoff, d.poll)
+}
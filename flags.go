@@ -0,0 +1,791 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/bme280"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina219"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina226"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/mcp9808"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/simulate"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tmp117"
+)
+
+// options are the command line as parseFlags checked it, with the settings
+// that follow from it and from the config file.
+type options struct {
+	// The flags, as given on the command line or set by the config file
+	tcaAddress             string
+	channel                int
+	channelsSpec           string
+	muxType                string
+	muxSelectMode          string
+	muxVerify              bool
+	withoutMultiplexer     bool
+	chip                   string
+	shuntOhms              float64
+	maxCurrent             float64
+	bus                    string
+	busLockDir             string
+	muxResetGPIO           string
+	skipHostInit           bool
+	initRetries            int
+	initRetryInterval      time.Duration
+	exitOnNoMuxAck         bool
+	pollInterval           time.Duration
+	pollHz                 float64
+	pollJitter             time.Duration
+	busMaxRate             int
+	minInterval            time.Duration
+	publishInterval        time.Duration
+	setUpRetryInterval     time.Duration
+	errorBackoff           time.Duration
+	allowFast              bool
+	exportMicroamps        bool
+	compatMetrics          bool
+	exportDelta            bool
+	statsWindow            time.Duration
+	averageWindow          int
+	debugRegisters         bool
+	grpcListenAddress      string
+	webConfigFile          string
+	webTLSCert             string
+	webTLSKey              string
+	dashboard              bool
+	captureAPI             bool
+	debugI2CTokenFile      string
+	voltageLSB             float64
+	currentLSB             float64
+	powerLSB               float64
+	readRetries            int
+	retryBackoff           time.Duration
+	outputEngineering      bool
+	colorMode              string
+	outputStdout           bool
+	outputPrometheus       bool
+	batchPublish           bool
+	readOnScrape           bool
+	scrapeCacheTTL         time.Duration
+	outputFile             string
+	outputFileMaxSize      int64
+	outputFileKeep         int
+	outputFileFormat       string
+	outputFileOnly         bool
+	downAfterCycles        int
+	upAfterCycles          int
+	probeInterval          time.Duration
+	discoverInterval       time.Duration
+	i2cTimeout             time.Duration
+	readTimeoutPerRegister string
+	averaging              int
+	busConversionTime      time.Duration
+	shuntConversionTime    time.Duration
+	operatingMode          string
+	alertFunction          string
+	alertLimit             float64
+	alertActiveHigh        bool
+	alertLatch             bool
+	alertGPIO              string
+	coincident             bool
+	logTransitions         bool
+	timestampSource        string
+	fifo                   string
+	disableAfterRead       bool
+	verifyWrites           bool
+	pec                    bool
+	debugTiming            bool
+	staleAfter             time.Duration
+	directionDeadband      float64
+	logDirectionChanges    bool
+	warnOnSaturation       bool
+	logLevel               string
+	logFormat              string
+	bme280Channels         string
+	bme280Address          string
+	mcp9808Channels        string
+	mcp9808Address         string
+	mcp9808Resolution      float64
+	tmp117Channels         string
+	tmp117Address          string
+	tmp117Averaging        int
+	simulate               bool
+	simulateWaveform       string
+	simulatePeriod         time.Duration
+	simulateVoltage        float64
+	simulateCurrent        float64
+	simulateNoise          float64
+	config                 string
+	failFastOnConfigError  bool
+	helpRegisters          bool
+	printConfigSchema      bool
+	startupProbeAll        bool
+	diagnose               bool
+	push                   *pushFlags
+
+	setFlags      map[string]bool           // the flags given on the command line
+	cfg           *fileConfig               // nil without --config
+	deviceNames   map[int]string            // friendly device labels from --config, by channel
+	deviceLabels  map[int]map[string]string // extra labels from --config, by channel
+	alerts        []alertConfig             // alerts from --config
+	budgets       []budgetConfig            // power budgets from --config
+	logHandler    slog.Handler
+	muxModel      tca9548a.Model
+	muxAddresses  []muxAddress   // none with --without-multiplexer
+	channels      []int          // of --channels
+	envChannels   []int          // the channels of the sensors that are not power monitors
+	adcs          []adcConfig    // from --config
+	driverSensors []sensorConfig // sensors of registered drivers, from --config and the --*-channels flags
+	nested        []sensorConfig // power monitors of the config file behind nested muxes
+	poll          pollOptions
+
+	// Per-sensor schedules of the config file, by channel (-1 for a directly
+	// connected sensor); every other sensor polls on defaultSchedule
+	defaultSchedule pollSchedule
+	schedules       map[int]pollSchedule
+	slowest         pollSchedule // the longest wait between two polls of a sensor
+
+	scale             ina260.Scale
+	ina219Calibration ina219.Calibration
+	ina226Calibration ina226.Calibration
+	configChange      ina260.ConfigChange
+	pinAlert          *ina260.Alert // nil keeps the Mask/Enable and Alert Limit registers as they are
+	timeouts          ina260.Timeouts
+	color             exporter.Colorizer
+	quiet             bool // do not print readings to stdout
+	debugI2CToken     []byte
+	web               *webConfig
+	webTLS            *tls.Config // nil without TLS
+}
+
+// scheduleOf returns the poll schedule of the sensor on channel.
+func (o *options) scheduleOf(channel int) pollSchedule {
+	if sched, ok := o.schedules[channel]; ok {
+		return sched
+	}
+	return o.defaultSchedule
+}
+
+// parseFlags defines and parses the flags, applies the config file and checks
+// both, and sets up logging as they ask, so the warnings of the checks are
+// logged already. It returns as soon as --print-config-schema,
+// --help-registers or --diagnose is set, with the options that one needs.
+// Its errors are formatted sentences, logged as they are: they are about flags
+// and wiring, before there is a device to attach fields to.
+func parseFlags() (*options, error) {
+	o := &options{}
+	flag.StringVar(&o.tcaAddress, "tca-address", "0x70", "I2C address of the TCA9548A multiplexer, or a comma-separated list such as 0x70,0x71 for several muxes; channels of the second mux are numbered 8-15 and so on (default: 0x70)") // Initialize host and I2C bus
+	flag.IntVar(&o.channel, "channel", 0, "Channel number on the TCA9548A multiplexer (0-7, or up to 8 per mux with several --tca-address values, default: 0)")
+	flag.StringVar(&o.channelsSpec, "channels", "", "Poll several TCA9548A channels in turn instead of --channel, e.g. 0-7 or 0,2,5 (default: none)")
+	flag.StringVar(&o.muxType, "mux.type", tca9548a.TCA9548A.Name, "Multiplexer model at the --tca-address addresses: tca9548a, pca9548a (8 channels), tca9546a, pca9546a or pca9545a (4 channels); channels of the second mux start after the last channel of the first (default: tca9548a)")
+	flag.StringVar(&o.muxSelectMode, "mux.select-mode", muxSelectSticky, "How the mux channels are left after each device transaction: sticky keeps the channel selected, so the next access to the same device needs no write; all-off writes 0x00 to every mux after each transaction, so devices at one address on different channels can never answer together, at the cost of a write before and after every access (default: sticky)")
+	flag.BoolVar(&o.muxVerify, "mux.verify", false, "Read the multiplexer control register back after every channel write and before every access, and fail the access if another bus master changed it (default: false)")
+	flag.BoolVar(&o.withoutMultiplexer, "without-multiplexer", false, "Set to true if INA260 is connected directly without TCA9548A multiplexer (default: false)")
+	flag.StringVar(&o.chip, "chip", chipINA260, "Power monitor chip behind the multiplexer: ina260, ina219, ina226 or ina3221 (default: ina260)")
+	flag.Float64Var(&o.shuntOhms, "shunt-ohms", 0.1, "Shunt resistance in Ohms of an INA219, INA226 or INA3221 (default: 0.1)")
+	flag.Float64Var(&o.maxCurrent, "max-current", 0, "Largest current in Amperes the INA219/INA226 calibration has to cover; 0 uses the full shunt voltage range (default: 0)")
+	flag.StringVar(&o.bus, "bus", "/dev/i2c-1", "I2C bus to use (default: /dev/i2c-1)")
+	flag.StringVar(&o.busLockDir, "bus-lock-dir", "", "Hold an flock on a file named after each bus in this directory, e.g. /var/lock/i2c-1, around every access, for other tools taking the same lock (default: none)")
+	flag.StringVar(&o.muxResetGPIO, "mux-reset-gpio", "", "GPIO pin wired to the TCA9548A RESET line, pulsed at startup, e.g. GPIO17 (default: none)")
+	flag.BoolVar(&o.skipHostInit, "skip-host-init", false, "Do not call periph host.Init, for environments where it was already done (default: false)")
+	flag.IntVar(&o.initRetries, "init-retries", 0, "Extra attempts to initialize the host and open the I2C bus at startup (default: 0)")
+	flag.DurationVar(&o.initRetryInterval, "init-retry-interval", 2*time.Second, "Time between I2C initialization attempts (default: 2s)")
+	flag.BoolVar(&o.exitOnNoMuxAck, "exit-on-no-mux-ack", false, "Exit at startup if the TCA9548A multiplexer does not ACK its address (default: false)")
+	flag.DurationVar(&o.pollInterval, "poll-interval", 1*time.Second, "Time between INA260 readings (default: 1s)")
+	flag.Float64Var(&o.pollHz, "poll-hz", 0, "Readings per second, as an alternative to --poll-interval (default: none)")
+	flag.DurationVar(&o.pollJitter, "poll-jitter", 0, "Random delay of up to this much before each reading, so sensors polled at the same interval spread their bus load; must be shorter than --poll-interval (default: 0)")
+	flag.IntVar(&o.busMaxRate, "bus-max-rate", 0, "Most I2C transactions per second across every bus and sensor; transactions beyond it wait their turn, 0 is unlimited (default: 0)")
+	flag.DurationVar(&o.minInterval, "min-interval", 10*time.Millisecond, "Smallest poll interval allowed, to protect the shared bus (default: 10ms)")
+	flag.DurationVar(&o.publishInterval, "publish-interval", 0, "Update the Prometheus gauges at most this often with the latest reading; 0 updates on every reading (default: 0)")
+	flag.DurationVar(&o.setUpRetryInterval, "setup-retry-interval", 30*time.Second, "How often a sensor that failed to set up, such as one not answering at startup, is tried again while the others keep polling; it is reported down until then (default: 30s)")
+	flag.DurationVar(&o.errorBackoff, "error-backoff", 0, "Time to wait after a failed reading before retrying; 0 uses --poll-interval (default: 0)")
+	flag.BoolVar(&o.allowFast, "allow-fast", false, "Allow a poll interval below --min-interval (default: false)")
+	flag.BoolVar(&o.exportMicroamps, "export-microamps", false, "Also export the raw current and ina260_current_microamps for sub-milliamp loads (default: false)")
+	flag.BoolVar(&o.compatMetrics, "compat-metrics", false, "Also export ina260_current_milliamps, ina260_voltage_millivolts and ina260_power_milliwatts (default: false)")
+	flag.BoolVar(&o.exportDelta, "export-delta", false, "Export ina260_*_delta gauges with the rate of change between consecutive readings in A/s, V/s and W/s (default: false)")
+	flag.DurationVar(&o.statsWindow, "stats-window", 0, "Export ina260_*_window_min, _max and _avg gauges over the readings of this long a window, such as 60s, to catch spikes between scrapes; 0 disables (default: 0)")
+	flag.IntVar(&o.averageWindow, "average-window", 0, "Export ina260_*_avg gauges as the rolling mean of the last N readings; 0 disables (default: 0)")
+	flag.BoolVar(&o.debugRegisters, "debug-registers", false, "Serve all INA260 registers as JSON on /debug/registers (default: false)")
+	flag.StringVar(&o.grpcListenAddress, "grpc.listen-address", "", "Also serve the gRPC API of pkg/rpc/exporter.proto on this address, e.g. :9091, with the TLS settings and basic auth users of --web.config.file (default: none)")
+	flag.StringVar(&o.webConfigFile, "web.config.file", "", "exporter-toolkit web config file with the TLS settings and basic auth users of the metrics, API and gRPC servers (default: none)")
+	flag.StringVar(&o.webTLSCert, "web.tls-cert", "", "PEM certificate to serve the metrics and API over HTTPS, with --web.tls-key (default: none)")
+	flag.StringVar(&o.webTLSKey, "web.tls-key", "", "PEM key of --web.tls-cert (default: none)")
+	flag.BoolVar(&o.dashboard, "dashboard", true, "Serve a web page of live readings at /, fed by /api/v1/stream (default: true)")
+	flag.BoolVar(&o.captureAPI, "capture-api", false, "Serve POST /api/v1/devices/{name}/capture for burst captures of an INA260; polling of every sensor pauses during a capture (default: false)")
+	flag.StringVar(&o.debugI2CTokenFile, "debug-i2c-token-file", "", "Serve POST /api/v1/debug/i2c for reading and writing any register, to requests bearing the token in this file (default: none)")
+	flag.Float64Var(&o.voltageLSB, "voltage-lsb", ina260.VoltageLSB, "Bus voltage LSB override in mV for this sensor (default: 1.25)")
+	flag.Float64Var(&o.currentLSB, "current-lsb", ina260.CurrentLSB, "Current LSB override in mA for this sensor (default: 1.25)")
+	flag.Float64Var(&o.powerLSB, "power-lsb", ina260.PowerLSB, "Power LSB override in mW for this sensor (default: 10)")
+	flag.IntVar(&o.readRetries, "read-retries", 0, "Extra attempts for each sensor register read or write after a failure (default: 0)")
+	flag.DurationVar(&o.retryBackoff, "retry-backoff", 10*time.Millisecond, "Delay before the first retry of a failed register read or write, doubled for each further retry up to 1s (default: 10ms)")
+	flag.BoolVar(&o.outputEngineering, "output-engineering", false, "Print readings with SI prefixes (mA, µA, mW) chosen by magnitude (default: false)")
+	flag.StringVar(&o.colorMode, "color", "auto", "Color the terminal output: auto (only on a TTY), always or never (default: auto)")
+	flag.BoolVar(&o.outputStdout, "output-stdout", true, "Print readings to stdout (default: true)")
+	flag.BoolVar(&o.outputPrometheus, "output-prometheus", true, "Publish readings to the Prometheus gauges on /metrics (default: true)")
+	flag.BoolVar(&o.batchPublish, "batch-publish", false, "Publish the readings of the power monitors to every output but the Prometheus gauges together, once the cycle has a reading of each, instead of as each is read; MQTT and InfluxDB take each batch in one go (default: false)")
+	flag.BoolVar(&o.readOnScrape, "read-on-scrape", false, "Read the power monitors when /metrics is scraped instead of polling them; the other outputs get those readings (default: false)")
+	flag.DurationVar(&o.scrapeCacheTTL, "scrape-cache-ttl", time.Second, "Reuse a --read-on-scrape reading younger than this instead of reading again (default: 1s)")
+	flag.StringVar(&o.outputFile, "output-file", "", "Also write readings to this file (default: none)")
+	flag.Int64Var(&o.outputFileMaxSize, "output-file-max-size", 10*1024*1024, "Rotate --output-file once it reaches this many bytes; 0 disables rotation (default: 10485760)")
+	flag.IntVar(&o.outputFileKeep, "output-file-keep", 3, "Number of rotated --output-file files to keep (default: 3)")
+	flag.StringVar(&o.outputFileFormat, "output-file-format", "text", "Format of --output-file: text (as on stdout), csv (with a header line) or jsonl (the --fifo JSON lines) (default: text)")
+	flag.BoolVar(&o.outputFileOnly, "output-file-only", false, "Write readings only to --output-file, not to stdout; same as --output-stdout=false (default: false)")
+	flag.IntVar(&o.downAfterCycles, "down-after-cycles", 1, "Consecutive failed cycles before ina260_up drops to 0 (default: 1)")
+	flag.IntVar(&o.upAfterCycles, "up-after-cycles", 1, "Consecutive successful cycles before ina260_up returns to 1 (default: 1)")
+	flag.DurationVar(&o.probeInterval, "probe-interval", 0, "Check INA260 presence this often between readings to detect removal faster; 0 disables (default: 0)")
+	flag.DurationVar(&o.discoverInterval, "discover-interval", 0, "Scan every mux channel for power monitors this often, polling the ones that appear and stopping the ones that disappear; 0 disables (default: 0)")
+	flag.DurationVar(&o.i2cTimeout, "i2c-timeout", 0, "Timeout for each INA260 register read; 0 waits indefinitely (default: 0)")
+	flag.StringVar(&o.readTimeoutPerRegister, "read-timeout-per-register", "", "Per-register read timeouts overriding --i2c-timeout, e.g. current=5ms,power=10ms (default: none)")
+	flag.IntVar(&o.averaging, "averaging", 0, "INA260 samples averaged per reading: 1, 4, 16, 64, 128, 256, 512 or 1024; 0 keeps the current setting (default: 0)")
+	flag.DurationVar(&o.busConversionTime, "bus-conversion-time", 0, "INA260 bus voltage conversion time: 140us, 204us, 332us, 588us, 1.1ms, 2.116ms, 4.156ms or 8.244ms; 0 keeps the current setting (default: 0)")
+	flag.DurationVar(&o.shuntConversionTime, "shunt-conversion-time", 0, "INA260 shunt current conversion time, from the same values as --bus-conversion-time; 0 keeps the current setting (default: 0)")
+	flag.StringVar(&o.operatingMode, "operating-mode", "", "INA260 operating mode: continuous, triggered, shunt-continuous, bus-continuous, shunt-triggered, bus-triggered or power-down; empty keeps the current setting (default: none)")
+	flag.StringVar(&o.alertFunction, "alert-function", "", "INA260 alert function driving the ALERT pin: over-current, under-current, over-voltage, under-voltage or over-power; empty keeps the current setting (default: none)")
+	flag.Float64Var(&o.alertLimit, "alert-limit", 0, "Limit of --alert-function in Amperes, Volts or Watts (default: 0)")
+	flag.BoolVar(&o.alertActiveHigh, "alert-active-high", false, "Drive the INA260 ALERT pin high when asserted rather than low (default: false)")
+	flag.BoolVar(&o.alertLatch, "alert-latch", false, "Keep the INA260 ALERT pin asserted until the alert flag is read, so short events are not missed (default: false)")
+	flag.StringVar(&o.alertGPIO, "alert-gpio", "", "GPIO pin the INA260 ALERT outputs are wired to, e.g. GPIO22; each assertion is counted per device and runs the pin alerts of the config file (default: none)")
+	flag.BoolVar(&o.coincident, "coincident", false, "Sample current and voltage from one triggered conversion per reading; leaves the INA260 in triggered mode (default: false)")
+	flag.BoolVar(&o.logTransitions, "log-transitions", false, "Log each time ina260_up changes between up and down (default: false)")
+	flag.StringVar(&o.timestampSource, "timestamp-source", timestampEnd, "When a reading is timestamped: start (before the register reads), end (after them, when the data is available) or mid (default: end)")
+	o.push = registerPushFlags()
+	flag.StringVar(&o.fifo, "fifo", "", "Also write JSON-lines readings to this named pipe, created if missing; dropped while no reader is attached (default: none)")
+	flag.BoolVar(&o.disableAfterRead, "disable-after-read", false, "Deselect all TCA9548A channels after each reading and select the channel again before the next, for buses shared with other masters; the same as --mux.select-mode all-off (default: false)")
+	flag.BoolVar(&o.verifyWrites, "verify-writes", false, "Read back every INA260 register write and warn on mismatch (default: false)")
+	flag.BoolVar(&o.pec, "pec", false, "Use SMBus packet error checking with every config file sensor of a registered driver whose chip supports it, as pec: true does; none of the chips or drivers built into this binary do, so it needs a driver from another module (default: false)")
+	flag.BoolVar(&o.debugTiming, "debug-timing", false, "Log the monotonic time between consecutive readings (default: false)")
+	flag.DurationVar(&o.staleAfter, "stale-after", 0, "Remove a sensor's metrics after it has been unreadable for this long; 0 keeps the last value (default: 0)")
+	flag.Float64Var(&o.directionDeadband, "direction-deadband", 0.01, "Current in Amperes around zero where ina260_current_direction reads 0 instead of the noisy sign (default: 0.01)")
+	flag.BoolVar(&o.logDirectionChanges, "log-direction-changes", false, "Log when the current of a sensor reverses, e.g. a battery switching between charging and discharging (default: false)")
+	flag.BoolVar(&o.warnOnSaturation, "warn-on-saturation", false, "Warn and set ina260_voltage_saturated when the bus voltage register reaches full scale (default: false)")
+
+	flag.StringVar(&o.logLevel, "log.level", "info", "Minimum level of log messages: debug, info, warn or error (default: info)")
+	flag.StringVar(&o.logFormat, "log.format", "text", "Log message format on stderr: text (key=value) or json (default: text)")
+
+	flag.StringVar(&o.bme280Channels, "bme280-channels", "", "Also poll a BME280 or BMP280 on these mux channels, numbered like --channels, e.g. 6,7 (default: none)")
+	flag.StringVar(&o.bme280Address, "bme280-address", "0x76", "I2C address of the BME280/BMP280 sensors: 0x76 or 0x77 (default: 0x76)")
+	flag.StringVar(&o.mcp9808Channels, "mcp9808-channels", "", "Also poll an MCP9808 temperature sensor on these mux channels, numbered like --channels (default: none)")
+	flag.StringVar(&o.mcp9808Address, "mcp9808-address", "0x18", "I2C address of the MCP9808 sensors: 0x18 to 0x1F (default: 0x18)")
+	flag.Float64Var(&o.mcp9808Resolution, "mcp9808-resolution", mcp9808.DefaultResolution, "MCP9808 temperature step in °C: 0.5, 0.25, 0.125 or 0.0625; finer steps convert slower (default: 0.0625)")
+	flag.StringVar(&o.tmp117Channels, "tmp117-channels", "", "Also poll a TMP117 temperature sensor on these mux channels, numbered like --channels (default: none)")
+	flag.StringVar(&o.tmp117Address, "tmp117-address", "0x48", "I2C address of the TMP117 sensors: 0x48 to 0x4B (default: 0x48)")
+	flag.IntVar(&o.tmp117Averaging, "tmp117-averaging", tmp117.DefaultAveraging, "TMP117 conversions averaged per reading: 1, 8, 32 or 64 (default: 8)")
+	flag.BoolVar(&o.simulate, "simulate", false, "Replace the I2C bus with a simulated one: the --tca-address muxes with an INA260 on every channel, or one INA260 with --without-multiplexer (default: false)")
+	flag.StringVar(&o.simulateWaveform, "simulate.waveform", simulate.WaveformSine, "Waveform of the simulated current: constant, sine, square or triangle (default: sine)")
+	flag.DurationVar(&o.simulatePeriod, "simulate.period", time.Minute, "Period of the simulated waveform (default: 1m)")
+	flag.Float64Var(&o.simulateVoltage, "simulate.voltage", 5, "Nominal simulated bus voltage in Volts (default: 5)")
+	flag.Float64Var(&o.simulateCurrent, "simulate.current", 0.5, "Nominal simulated current in Amperes (default: 0.5)")
+	flag.Float64Var(&o.simulateNoise, "simulate.noise", 0.01, "Standard deviation of the simulated noise, relative to the nominal values (default: 0.01)")
+
+	flag.StringVar(&o.config, "config", "", "YAML file describing the bus, mux, sensors, poll interval and device names; command-line flags take precedence (default: none)")
+	flag.BoolVar(&o.failFastOnConfigError, "fail-fast-on-config-error", true, "Exit when a sensor, ADC, alert or power budget of --config is invalid; with false, skip each invalid entry with a warning and run the valid ones (default: true)")
+
+	flag.BoolVar(&o.helpRegisters, "help-registers", false, "Print the INA260 register map and exit (default: false)")
+	flag.BoolVar(&o.printConfigSchema, "print-config-schema", false, "Print the keys of the --config file with their types and descriptions, and exit (default: false)")
+	flag.BoolVar(&o.startupProbeAll, "startup-probe-all", false, "Log a summary of which configured sensors answered at startup, e.g. \"4/5 sensors present, tca9548a_0x70_ch3_ina260 missing\"; missing power monitors are retried and other missing sensors skipped either way (default: false)")
+	flag.BoolVar(&o.diagnose, "diagnose", false, "Run a step-by-step hardware check (host, bus, mux, channel, INA260) and exit (default: false)")
+
+	flag.Parse()
+	var err error
+
+	if o.printConfigSchema {
+		return o, nil
+	}
+	o.setFlags = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { o.setFlags[f.Name] = true })
+	var skippedEntries []error // invalid entries of --config, logged once logging is set up
+	if o.config != "" {
+		var err error
+		if o.cfg, skippedEntries, err = loadConfig(o.config, o.failFastOnConfigError); err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		if o.deviceNames, err = o.cfg.apply(o.setFlags); err != nil {
+			return nil, fmt.Errorf("Failed to apply config file %s: %v", o.config, err)
+		}
+		o.deviceLabels = o.cfg.deviceLabels()
+		o.alerts = o.cfg.Alerts
+		o.budgets = o.cfg.PowerBudgets
+	}
+	o.logHandler, err = newLogHandler(os.Stderr, o.logLevel, o.logFormat)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid logging flags: %v", err)
+	}
+	slog.SetDefault(slog.New(o.logHandler).With("bus", o.bus))
+	if o.cfg != nil {
+		logSkipped(o.config, skippedEntries)
+	}
+
+	if o.helpRegisters {
+		return o, nil
+	}
+	o.muxModel, err = tca9548a.ModelByName(o.muxType)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --mux.type: %v", err)
+	}
+	switch o.muxSelectMode {
+	case muxSelectSticky:
+		if o.disableAfterRead && o.setFlags["mux.select-mode"] {
+			return nil, fmt.Errorf("--disable-after-read conflicts with --mux.select-mode %s", muxSelectSticky)
+		}
+	case muxSelectAllOff:
+		// Every access then deselects through the --disable-after-read gates
+		o.disableAfterRead = true
+	default:
+		return nil, fmt.Errorf("Invalid --mux.select-mode %q: must be %s or %s", o.muxSelectMode, muxSelectSticky, muxSelectAllOff)
+	}
+	if !o.withoutMultiplexer {
+		var err error
+		if o.muxAddresses, err = parseMuxAddresses(o.tcaAddress, o.muxModel); err != nil {
+			return nil, fmt.Errorf("Invalid --tca-address: %v", err)
+		}
+		if o.channel < 0 || o.channel >= len(o.muxAddresses)*o.muxModel.Channels {
+			return nil, fmt.Errorf("Invalid --channel %d: must be between 0 and %d", o.channel, len(o.muxAddresses)*o.muxModel.Channels-1)
+		}
+	}
+	if o.diagnose {
+		return o, nil
+	}
+
+	if o.setFlags["poll-hz"] {
+		if o.setFlags["poll-interval"] {
+			return nil, fmt.Errorf("--poll-hz and --poll-interval are mutually exclusive")
+		}
+		if !(o.pollHz > 0) || math.IsInf(o.pollHz, 0) {
+			return nil, fmt.Errorf("Invalid poll rate %g Hz: must be positive", o.pollHz)
+		}
+		o.pollInterval = time.Duration(float64(time.Second) / o.pollHz)
+	}
+
+	// Refuse poll intervals that would hammer the bus unless explicitly allowed
+	if o.pollInterval <= 0 {
+		return nil, fmt.Errorf("Invalid poll interval %s: must be positive", o.pollInterval)
+	}
+	if o.pollInterval < o.minInterval {
+		if !o.allowFast {
+			return nil, fmt.Errorf("Poll interval %s is below the minimum of %s; use --allow-fast to override", o.pollInterval, o.minInterval)
+		}
+		slog.Warn("Poll interval is below the minimum; this may starve other devices on the bus", "poll_interval", o.pollInterval, "min_interval", o.minInterval)
+	}
+	if o.pollJitter < 0 || o.pollJitter >= o.pollInterval {
+		return nil, fmt.Errorf("Invalid --poll-jitter %s: must not be negative and must be shorter than the poll interval %s", o.pollJitter, o.pollInterval)
+	}
+	if o.busMaxRate < 0 {
+		return nil, fmt.Errorf("Invalid --bus-max-rate %d: must not be negative", o.busMaxRate)
+	}
+	o.defaultSchedule = pollSchedule{interval: o.pollInterval, jitter: o.pollJitter}
+	o.schedules = make(map[int]pollSchedule)
+	o.slowest = o.defaultSchedule
+	if o.cfg != nil {
+		for _, s := range o.cfg.Sensors {
+			sched := withSchedule(o.defaultSchedule, s.Interval, s.Jitter)
+			if sched.jitter >= sched.interval {
+				return nil, fmt.Errorf("The jitter %s of sensor %q must be shorter than its interval %s", sched.jitter, s.Name, sched.interval)
+			}
+			if s.MuxPath != "" {
+				// Scheduled with its target
+			} else if s.Bus == "" && s.Channel != nil {
+				o.schedules[*s.Channel] = sched
+			} else if s.Bus == "" {
+				o.schedules[-1] = sched
+			}
+			o.slowest.interval = max(o.slowest.interval, sched.interval)
+			o.slowest.jitter = max(o.slowest.jitter, sched.jitter)
+		}
+		for _, a := range o.cfg.ADCs {
+			sched := withSchedule(o.defaultSchedule, a.Interval, a.Jitter)
+			if sched.jitter >= sched.interval {
+				return nil, fmt.Errorf("The jitter %s of ADC %q must be shorter than its interval %s", sched.jitter, a.Name, sched.interval)
+			}
+			o.slowest.interval = max(o.slowest.interval, sched.interval)
+			o.slowest.jitter = max(o.slowest.jitter, sched.jitter)
+		}
+	}
+	o.scale = ina260.Scale{VoltageLSB: o.voltageLSB, CurrentLSB: o.currentLSB, PowerLSB: o.powerLSB}
+	if err := o.scale.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid scaling override: %v", err)
+	}
+	if o.scale != ina260.DefaultScale {
+		slog.Info("Using scaling overrides", "voltage_lsb_mv", o.scale.VoltageLSB, "current_lsb_ma", o.scale.CurrentLSB, "power_lsb_mw", o.scale.PowerLSB)
+	}
+	if o.initRetries < 0 {
+		return nil, fmt.Errorf("Invalid init retries %d: must not be negative", o.initRetries)
+	}
+	o.color, err = exporter.NewColorizer(o.colorMode, os.Stdout)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --color: %v", err)
+	}
+	switch o.outputFileFormat {
+	case outputFormatText, outputFormatCSV, outputFormatJSONLines:
+	default:
+		return nil, fmt.Errorf("Invalid --output-file-format %q: must be text, csv or jsonl", o.outputFileFormat)
+	}
+	if o.outputFileMaxSize < 0 || o.outputFileKeep < 0 {
+		return nil, fmt.Errorf("Invalid output file rotation: max size and keep count must not be negative")
+	}
+	if o.outputFile == "" && o.outputFileOnly {
+		return nil, fmt.Errorf("--output-file-only requires --output-file")
+	}
+	if o.i2cTimeout < 0 {
+		return nil, fmt.Errorf("Invalid I2C timeout %s: must not be negative", o.i2cTimeout)
+	}
+	timeoutOverrides, err := ina260.ParseTimeouts(o.readTimeoutPerRegister)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --read-timeout-per-register: %v", err)
+	}
+	o.timeouts = ina260.Timeouts{Global: o.i2cTimeout, Overrides: timeoutOverrides}
+	if o.readRetries < 0 {
+		return nil, fmt.Errorf("Invalid read retries %d: must not be negative", o.readRetries)
+	}
+	if o.retryBackoff <= 0 {
+		return nil, fmt.Errorf("Invalid --retry-backoff %s: must be positive", o.retryBackoff)
+	}
+	if o.probeInterval < 0 {
+		return nil, fmt.Errorf("Invalid probe interval %s: must not be negative", o.probeInterval)
+	}
+	if o.directionDeadband < 0 {
+		return nil, fmt.Errorf("Invalid direction deadband %g A: must not be negative", o.directionDeadband)
+	}
+	if o.downAfterCycles < 1 || o.upAfterCycles < 1 {
+		return nil, fmt.Errorf("Invalid up/down debounce: --down-after-cycles and --up-after-cycles must be at least 1")
+	}
+	o.configChange = ina260.ConfigChange{
+		Averaging:       o.averaging,
+		BusConversion:   o.busConversionTime,
+		ShuntConversion: o.shuntConversionTime,
+		Mode:            o.operatingMode,
+	}
+	// Check the values now rather than after the bus is opened
+	if _, err := o.configChange.Apply(0); err != nil {
+		return nil, fmt.Errorf("Invalid INA260 configuration: %v", err)
+	}
+	if !o.configChange.IsZero() && o.chip != chipINA260 {
+		return nil, fmt.Errorf("--averaging, --bus-conversion-time, --shunt-conversion-time and --operating-mode only apply to --chip %s", chipINA260)
+	}
+	if o.configChange.Mode != "" && o.coincident {
+		return nil, fmt.Errorf("--operating-mode cannot be combined with --coincident, which triggers each conversion itself")
+	}
+	if mode, ok := ina260.ModeNames[o.configChange.Mode]; ok && mode&0x4 == 0 {
+		slog.Warn("Operating mode does not convert continuously; readings will repeat the last conversion", "operating_mode", o.configChange.Mode)
+	}
+	alertFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { alertFlags[f.Name] = true }) // the config file sets flags too
+	if o.alertFunction != "" {
+		if o.chip != chipINA260 {
+			return nil, fmt.Errorf("--alert-function only applies to --chip %s", chipINA260)
+		}
+		if !alertFlags["alert-limit"] {
+			return nil, fmt.Errorf("--alert-function needs --alert-limit")
+		}
+		if o.coincident {
+			return nil, fmt.Errorf("--alert-function cannot be combined with --coincident, which reads the Mask/Enable register on every reading and so clears a latched alert")
+		}
+		o.pinAlert = &ina260.Alert{Function: o.alertFunction, Limit: o.alertLimit, ActiveHigh: o.alertActiveHigh, Latch: o.alertLatch}
+		if _, _, err := o.pinAlert.Registers(o.scale); err != nil {
+			return nil, fmt.Errorf("Invalid INA260 alert: %v", err)
+		}
+	} else if alertFlags["alert-limit"] || o.alertActiveHigh || o.alertLatch || o.alertGPIO != "" {
+		return nil, fmt.Errorf("--alert-limit, --alert-active-high, --alert-latch and --alert-gpio need --alert-function")
+	}
+	switch o.chip {
+	case chipINA260:
+	case chipINA219, chipINA226:
+		// No integrated shunt: the scale follows from the calibration instead of the LSB flags
+		if o.setFlags["voltage-lsb"] || o.setFlags["current-lsb"] || o.setFlags["power-lsb"] {
+			return nil, fmt.Errorf("--voltage-lsb, --current-lsb and --power-lsb only apply to --chip %s; the %s scale follows from --shunt-ohms and --max-current", chipINA260, o.chip)
+		}
+		if o.coincident || o.warnOnSaturation {
+			return nil, fmt.Errorf("--coincident and --warn-on-saturation only support --chip %s", chipINA260)
+		}
+		var err error
+		if o.chip == chipINA219 {
+			o.ina219Calibration, err = ina219.NewCalibration(o.shuntOhms, o.maxCurrent)
+			o.scale = o.ina219Calibration.Scale()
+		} else {
+			o.ina226Calibration, err = ina226.NewCalibration(o.shuntOhms, o.maxCurrent)
+			o.scale = o.ina226Calibration.Scale()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s calibration: %v", o.chip, err)
+		}
+	case chipINA3221:
+		if o.shuntOhms <= 0 {
+			return nil, fmt.Errorf("Invalid shunt resistance %g: must be positive", o.shuntOhms)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid --chip %q: must be ina260, ina219, ina226 or ina3221", o.chip)
+	}
+	switch o.timestampSource {
+	case timestampStart, timestampEnd, timestampMid:
+	default:
+		return nil, fmt.Errorf("Invalid --timestamp-source %q: must be start, end or mid", o.timestampSource)
+	}
+	if o.publishInterval < 0 {
+		return nil, fmt.Errorf("Invalid publish interval %s: must not be negative", o.publishInterval)
+	}
+	if o.publishInterval > 0 && o.publishInterval < o.pollInterval {
+		slog.Warn("Publish interval is shorter than the poll interval and has no effect", "publish_interval", o.publishInterval, "poll_interval", o.pollInterval)
+	}
+	if o.errorBackoff < 0 {
+		return nil, fmt.Errorf("Invalid error backoff %s: must not be negative", o.errorBackoff)
+	}
+	if o.channelsSpec != "" {
+		var err error
+		if o.channels, err = parseChannels(o.channelsSpec, max(len(o.muxAddresses), 1)*o.muxModel.Channels); err != nil {
+			return nil, fmt.Errorf("Invalid --channels: %v", err)
+		}
+		if o.withoutMultiplexer {
+			return nil, fmt.Errorf("--channels requires the TCA9548A multiplexer and cannot be used with --without-multiplexer")
+		}
+		if o.chip == chipINA3221 {
+			return nil, fmt.Errorf("--channels does not support --chip %s", chipINA3221)
+		}
+	}
+	if o.readOnScrape {
+		if o.chip == chipINA3221 {
+			return nil, fmt.Errorf("--read-on-scrape does not support --chip %s", chipINA3221)
+		}
+		if o.scrapeCacheTTL < 0 {
+			return nil, fmt.Errorf("Invalid scrape cache TTL %s: must not be negative", o.scrapeCacheTTL)
+		}
+	}
+	if o.discoverInterval < 0 {
+		return nil, fmt.Errorf("Invalid discover interval %s: must not be negative", o.discoverInterval)
+	}
+	if o.discoverInterval > 0 {
+		if o.withoutMultiplexer {
+			return nil, fmt.Errorf("--discover-interval requires the TCA9548A multiplexer and cannot be used with --without-multiplexer")
+		}
+		// Discovery recognizes a sensor by its Manufacturer ID and Die ID, which the INA219 and INA3221 lack or differ in
+		if o.chip != chipINA260 && o.chip != chipINA226 {
+			return nil, fmt.Errorf("--discover-interval does not support --chip %s", o.chip)
+		}
+	}
+	if o.captureAPI && o.chip != chipINA260 {
+		return nil, fmt.Errorf("--capture-api only supports --chip %s", chipINA260)
+	}
+	if o.debugI2CTokenFile != "" {
+		if o.chip == chipINA3221 {
+			return nil, fmt.Errorf("--debug-i2c-token-file does not support --chip %s", chipINA3221)
+		}
+		token, err := os.ReadFile(o.debugI2CTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read --debug-i2c-token-file: %v", err)
+		}
+		if o.debugI2CToken = bytes.TrimSpace(token); len(o.debugI2CToken) == 0 {
+			return nil, fmt.Errorf("--debug-i2c-token-file %s is empty", o.debugI2CTokenFile)
+		}
+	}
+	o.web = &webConfig{}
+	if o.webConfigFile != "" {
+		if o.web, err = loadWebConfig(o.webConfigFile); err != nil {
+			return nil, fmt.Errorf("Failed to load --web.config.file: %v", err)
+		}
+	}
+	if (o.webTLSCert != "") != (o.webTLSKey != "") {
+		return nil, fmt.Errorf("--web.tls-cert and --web.tls-key must be given together")
+	}
+	if o.webTLSCert != "" {
+		if o.web.TLSServerConfig != nil {
+			return nil, fmt.Errorf("--web.tls-cert conflicts with tls_server_config in --web.config.file %s", o.webConfigFile)
+		}
+		o.web.TLSServerConfig = &webTLSConfig{CertFile: o.webTLSCert, KeyFile: o.webTLSKey}
+	}
+	if o.web.TLSServerConfig != nil {
+		if o.webTLS, err = o.web.TLSServerConfig.serverTLS(); err != nil {
+			return nil, fmt.Errorf("Invalid TLS settings for the metrics server: %v", err)
+		}
+	}
+	if o.grpcListenAddress != "" && o.chip == chipINA3221 {
+		return nil, fmt.Errorf("--grpc.listen-address does not support --chip %s", chipINA3221)
+	}
+	// The environmental and temperature sensors each sit on a mux channel of their
+	// own, away from the power monitors and from one another
+	sensorChannels := func(name, value string) ([]int, error) {
+		if value == "" {
+			return nil, nil
+		}
+		chs, err := parseChannels(value, max(len(o.muxAddresses), 1)*o.muxModel.Channels)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --%s: %v", name, err)
+		}
+		if o.withoutMultiplexer {
+			return nil, fmt.Errorf("--%s requires the TCA9548A multiplexer and cannot be used with --without-multiplexer", name)
+		}
+		if o.chip == chipINA3221 {
+			return nil, fmt.Errorf("--%s does not support --chip %s", name, chipINA3221)
+		}
+		powerChannels := o.channels
+		if len(powerChannels) == 0 {
+			powerChannels = []int{o.channel}
+		}
+		for _, ch := range chs {
+			if slices.Contains(powerChannels, ch) {
+				return nil, fmt.Errorf("Channel %d is in --%s and polled for the %s as well", ch, name, o.chip)
+			}
+			if slices.Contains(o.envChannels, ch) {
+				return nil, fmt.Errorf("Channel %d is in --%s and polled for another sensor as well", ch, name)
+			}
+		}
+		o.envChannels = append(o.envChannels, chs...)
+		return chs, nil
+	}
+	sensorAddress := func(name, value string, first, last uint16) (uint16, error) {
+		addr, err := strconv.ParseUint(value, 0, 16)
+		if err != nil || uint16(addr) < first || uint16(addr) > last {
+			return 0, fmt.Errorf("Invalid --%s %q: must be between 0x%02X and 0x%02X", name, value, first, last)
+		}
+		return uint16(addr), nil
+	}
+	bme280Channels, err := sensorChannels("bme280-channels", o.bme280Channels)
+	if err != nil {
+		return nil, err
+	}
+	bme280Address, err := strconv.ParseUint(o.bme280Address, 0, 16)
+	if err != nil || (uint16(bme280Address) != bme280.Address && uint16(bme280Address) != bme280.AlternateAddress) {
+		return nil, fmt.Errorf("Invalid --bme280-address %q: must be 0x76 or 0x77", o.bme280Address)
+	}
+	mcp9808Channels, err := sensorChannels("mcp9808-channels", o.mcp9808Channels)
+	if err != nil {
+		return nil, err
+	}
+	mcp9808Address, err := sensorAddress("mcp9808-address", o.mcp9808Address, mcp9808.Address, mcp9808.LastAddress)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := mcp9808.ResolutionCode(o.mcp9808Resolution); err != nil {
+		return nil, fmt.Errorf("Invalid --mcp9808-resolution: %v", err)
+	}
+	tmp117Channels, err := sensorChannels("tmp117-channels", o.tmp117Channels)
+	if err != nil {
+		return nil, err
+	}
+	tmp117Address, err := sensorAddress("tmp117-address", o.tmp117Address, tmp117.Address, tmp117.LastAddress)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp117.AveragingCode(o.tmp117Averaging); err != nil {
+		return nil, fmt.Errorf("Invalid --tmp117-averaging: %v", err)
+	}
+	if o.cfg != nil {
+		o.adcs = o.cfg.ADCs
+	}
+	for _, a := range o.adcs {
+		if o.chip == chipINA3221 {
+			return nil, fmt.Errorf("The adcs of the config file do not support --chip %s", chipINA3221)
+		}
+		if a.Channel == nil {
+			continue
+		}
+		ch := *a.Channel
+		if o.withoutMultiplexer {
+			return nil, fmt.Errorf("The ADC on channel %d requires the TCA9548A multiplexer and cannot be used with --without-multiplexer", ch)
+		}
+		if total := max(len(o.muxAddresses), 1) * o.muxModel.Channels; ch < 0 || ch >= total {
+			return nil, fmt.Errorf("Invalid ADC channel %d: must be between 0 and %d", ch, total-1)
+		}
+		if slices.Contains(o.channels, ch) || (len(o.channels) == 0 && ch == o.channel) {
+			return nil, fmt.Errorf("Channel %d has an ADC and is polled for the %s as well", ch, o.chip)
+		}
+		if slices.Contains(o.envChannels, ch) {
+			return nil, fmt.Errorf("Channel %d has an ADC and is polled for another sensor as well", ch)
+		}
+		o.envChannels = append(o.envChannels, ch)
+	}
+	// The BME280 and temperature sensors of the flags are polled through their
+	// drivers like the sensors of the config file, at the addresses of the flags
+	flagSensors := []struct {
+		chip     string
+		channels []int
+		addr     string
+	}{
+		{chipBME280, bme280Channels, fmt.Sprintf("0x%02X", bme280Address)},
+		{chipMCP9808, mcp9808Channels, fmt.Sprintf("0x%02X", mcp9808Address)},
+		{chipTMP117, tmp117Channels, fmt.Sprintf("0x%02X", tmp117Address)},
+	}
+	if o.cfg != nil {
+		o.driverSensors = slices.DeleteFunc(slices.Clone(o.cfg.Sensors), func(s sensorConfig) bool {
+			// --bme280-channels and the like replace the sensors of their chip in the config file
+			return !isDriverChip(s.Chip) || o.setFlags[s.Chip+"-channels"]
+		})
+	}
+	for i, s := range o.driverSensors {
+		for _, f := range flagSensors {
+			if s.Chip == f.chip && s.Address == "" {
+				o.driverSensors[i].Address = f.addr
+			}
+		}
+	}
+	for _, s := range o.driverSensors {
+		ch := *s.Channel // a mux and a channel are required by loadConfig
+		if o.withoutMultiplexer {
+			return nil, fmt.Errorf("The %s on channel %d requires the TCA9548A multiplexer and cannot be used with --without-multiplexer", s.Chip, ch)
+		}
+		if o.chip == chipINA3221 {
+			return nil, fmt.Errorf("The %s on channel %d does not support --chip %s", s.Chip, ch, chipINA3221)
+		}
+		if total := max(len(o.muxAddresses), 1) * o.muxModel.Channels; ch < 0 || ch >= total {
+			return nil, fmt.Errorf("Invalid channel %d of the %s: must be between 0 and %d", ch, s.Chip, total-1)
+		}
+		if slices.Contains(o.channels, ch) || (len(o.channels) == 0 && ch == o.channel) {
+			return nil, fmt.Errorf("Channel %d has a %s and is polled for the %s as well", ch, s.Chip, o.chip)
+		}
+		if slices.Contains(o.envChannels, ch) {
+			return nil, fmt.Errorf("Channel %d has a %s and is polled for another sensor as well", ch, s.Chip)
+		}
+		o.envChannels = append(o.envChannels, ch)
+	}
+	for _, f := range flagSensors {
+		for _, ch := range f.channels {
+			o.driverSensors = append(o.driverSensors, sensorConfig{Chip: f.chip, Channel: &ch, Address: f.addr, Name: o.deviceNames[ch], Labels: o.deviceLabels[ch]})
+		}
+	}
+	if o.pec && !slices.ContainsFunc(o.driverSensors, func(s sensorConfig) bool { return supportsPEC(s.Chip) }) {
+		return nil, fmt.Errorf("--pec needs a config file sensor of a registered driver that supports packet error checking; only drivers from other modules do, not --chip %s or the built-in drivers", o.chip)
+	}
+	if o.errorBackoff == 0 {
+		o.errorBackoff = o.pollInterval
+	}
+	if o.averageWindow < 0 {
+		return nil, fmt.Errorf("Invalid average window %d: must not be negative", o.averageWindow)
+	}
+	if o.statsWindow < 0 {
+		return nil, fmt.Errorf("Invalid stats window %s: must not be negative", o.statsWindow)
+	}
+	if o.statsWindow > 0 && o.statsWindow < o.pollInterval {
+		slog.Warn("Stats window is shorter than the poll interval and only ever holds one reading", "stats_window", o.statsWindow, "poll_interval", o.pollInterval)
+	}
+	if o.cfg != nil {
+		o.nested = o.cfg.nestedSensors()
+	}
+	for _, s := range o.nested {
+		hops, _ := parseMuxPath(s.MuxPath) // Already validated by loadConfig
+		if _, err := checkMuxPath(hops, o.muxAddresses, o.muxModel); err != nil {
+			return nil, fmt.Errorf("Invalid mux_path %q of sensor %q: %v", s.MuxPath, s.Name, err)
+		}
+	}
+	if o.simulate && o.chip != chipINA260 {
+		return nil, fmt.Errorf("--simulate only simulates the %s, not --chip %s", chipINA260, o.chip)
+	}
+	o.quiet = !o.outputStdout || o.outputFileOnly
+	o.poll = pollOptions{
+		coincident:       o.coincident,
+		timestampSource:  o.timestampSource,
+		staleAfter:       o.staleAfter,
+		warnOnSaturation: o.warnOnSaturation,
+		debugTiming:      o.debugTiming,
+		deadband:         o.directionDeadband,
+		logDirection:     o.logDirectionChanges,
+	}
+	return o, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/rpc"
+)
+
+// grpcServer implements the Exporter gRPC service of pkg/rpc/exporter.proto on
+// top of the JSON API: the same devices and readings, and the same stream.
+type grpcServer struct {
+	rpc.UnimplementedExporterServer
+	api    *api
+	stream *readingStream
+	debug  *i2cDebugAPI // nil without --debug-i2c-token-file, which SelectChannel needs
+}
+
+// newGRPCServer returns a gRPC server with the Exporter service and server
+// reflection, so grpcurl can list and call it without the .proto file. Like the
+// HTTP server, it serves TLS with tlsConfig unless that is nil, and requires one
+// of the basic_auth_users of web on every call.
+func newGRPCServer(s *grpcServer, web *webConfig, tlsConfig *tls.Config) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if len(web.BasicAuthUsers) > 0 {
+		auth := web.auth()
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := grpcAuthorize(auth, ctx, info.FullMethod); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := grpcAuthorize(auth, ss.Context(), info.FullMethod); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}))
+	}
+	server := grpc.NewServer(opts...)
+	rpc.RegisterExporterServer(server, s)
+	reflection.Register(server)
+	return server
+}
+
+// grpcAuthorize checks the basic auth in the authorization metadata of a call
+// to method. SelectChannel carries the bearer token of the debug API there
+// instead and checks it itself, as a debug request over HTTP does.
+func grpcAuthorize(auth *basicAuth, ctx context.Context, method string) error {
+	if method == rpc.Exporter_SelectChannel_FullMethodName {
+		return nil
+	}
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) == 1 {
+		if user, password, ok := parseBasicAuth(values[0]); ok && auth.check(user, password) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid basic auth credentials")
+}
+
+// parseBasicAuth parses the value of an Authorization header with basic auth.
+func parseBasicAuth(value string) (user, password string, ok bool) {
+	encoded, ok := strings.CutPrefix(value, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func (s *grpcServer) ListDevices(ctx context.Context, req *rpc.ListDevicesRequest) (*rpc.ListDevicesResponse, error) {
+	monitors := s.api.fleet.list()
+	resp := &rpc.ListDevicesResponse{Devices: make([]*rpc.Device, 0, len(monitors))}
+	for _, m := range monitors {
+		resp.Devices = append(resp.Devices, s.device(m))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) device(m *monitor) *rpc.Device {
+	last, readings, readErrors := m.status.snapshot()
+	d := &rpc.Device{
+		Name:       m.export.Device,
+		Hostname:   m.export.Hostname,
+		Chip:       s.api.chip,
+		Bus:        m.bus,
+		Mux:        m.muxSpec,
+		Up:         m.health.isUp(),
+		Readings:   readings,
+		ReadErrors: readErrors,
+	}
+	if m.channel >= 0 {
+		channel := int32(m.channel)
+		d.Channel = &channel
+	}
+	if readings > 0 {
+		d.LastReading = grpcReading(m.export, last)
+	}
+	return d
+}
+
+// GetReading returns the latest reading like GET /api/v1/devices/{name}/reading.
+func (s *grpcServer) GetReading(ctx context.Context, req *rpc.GetReadingRequest) (*rpc.Reading, error) {
+	var m *monitor
+	for _, o := range s.api.fleet.list() {
+		if o.export.Device == req.Device {
+			m = o
+			break
+		}
+	}
+	if m == nil {
+		return nil, status.Errorf(codes.NotFound, "unknown device %q", req.Device)
+	}
+	if !req.Fresh {
+		last, readings, _ := m.status.snapshot()
+		if readings == 0 {
+			return nil, status.Error(codes.Unavailable, "no reading yet")
+		}
+		return grpcReading(m.export, last), nil
+	}
+	if !s.api.polling.Load() {
+		return nil, status.Error(codes.Unavailable, "sensors are still being set up")
+	}
+	reading, _, _, err := m.read(s.api.opts)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return grpcReading(m.export, reading), nil
+}
+
+// StreamReadings sends the readings of /api/v1/stream until the client cancels
+// or the exporter shuts down.
+func (s *grpcServer) StreamReadings(req *rpc.StreamReadingsRequest, server grpc.ServerStreamingServer[rpc.Reading]) error {
+	c := s.stream.subscribe(req.Device)
+	if c == nil {
+		return status.Error(codes.Unavailable, "shutting down")
+	}
+	defer s.stream.unsubscribe(c)
+	for {
+		select {
+		case <-server.Context().Done():
+			return nil
+		case sr, ok := <-c.readings:
+			if !ok {
+				return status.Error(codes.Unavailable, "shutting down")
+			}
+			if err := server.Send(grpcReading(sr.sensor, sr.reading)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SelectChannel selects a mux channel for another bus master, with the token of
+// the debug API.
+func (s *grpcServer) SelectChannel(ctx context.Context, req *rpc.SelectChannelRequest) (*rpc.SelectChannelResponse, error) {
+	if s.debug == nil {
+		return nil, status.Error(codes.PermissionDenied, "SelectChannel needs --debug-i2c-token-file")
+	}
+	var token string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) == 1 {
+		token, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), s.debug.token) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+	if s.debug.muxes == nil {
+		return nil, status.Error(codes.FailedPrecondition, "there is no mux")
+	}
+	if total := len(s.debug.muxes.Muxes) * s.debug.model.Channels; req.Channel != nil && (*req.Channel < 0 || int(*req.Channel) >= total) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid channel %d: must be between 0 and %d", *req.Channel, total-1)
+	}
+	var channel *int
+	logger := slog.With("op", "select")
+	if p, ok := peer.FromContext(ctx); ok {
+		logger = logger.With("remote", p.Addr.String())
+	}
+	if req.Channel != nil {
+		ch := int(*req.Channel)
+		channel = &ch
+		logger = logger.With("channel", ch)
+	}
+	// Like a register write, a selection changes the bus behind the exporter's back
+	logger.Warn("gRPC API selecting mux channel")
+	if err := s.debug.selectChannel(channel); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to select mux channel: %v", err)
+	}
+	return &rpc.SelectChannelResponse{}, nil
+}
+
+func grpcReading(s *exporter.Sensor, r ina260.Reading) *rpc.Reading {
+	return &rpc.Reading{
+		Time:     timestamppb.New(r.Time),
+		Hostname: s.Hostname,
+		Device:   s.Device,
+		Voltage:  r.Voltage,
+		Current:  r.Current,
+		Power:    r.Power,
+	}
+}
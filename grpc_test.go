@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/rpc"
+)
+
+func TestGRPCAuthorize(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := (&webConfig{BasicAuthUsers: map[string]string{"alice": string(hash)}}).auth()
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	tests := []struct {
+		name          string
+		method        string
+		authorization []string
+		ok            bool
+	}{
+		{"valid user", rpc.Exporter_ListDevices_FullMethodName, []string{basic("alice:secret")}, true},
+		{"valid user, stream", rpc.Exporter_StreamReadings_FullMethodName, []string{basic("alice:secret")}, true},
+		{"no credentials", rpc.Exporter_GetReading_FullMethodName, nil, false},
+		{"wrong password", rpc.Exporter_GetReading_FullMethodName, []string{basic("alice:wrong")}, false},
+		{"unknown user", rpc.Exporter_GetReading_FullMethodName, []string{basic("bob:secret")}, false},
+		{"not base64", rpc.Exporter_GetReading_FullMethodName, []string{"Basic !!!"}, false},
+		{"bearer token", rpc.Exporter_ListDevices_FullMethodName, []string{"Bearer token"}, false},
+		{"two entries", rpc.Exporter_ListDevices_FullMethodName, []string{basic("alice:secret"), basic("alice:secret")}, false},
+		{"reflection", "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", nil, false},
+		{"SelectChannel checks its own token", rpc.Exporter_SelectChannel_FullMethodName, []string{"Bearer token"}, true},
+	}
+	for _, tt := range tests {
+		md := metadata.MD{}
+		if tt.authorization != nil {
+			md["authorization"] = tt.authorization
+		}
+		err := grpcAuthorize(auth, metadata.NewIncomingContext(context.Background(), md), tt.method)
+		if tt.ok && err != nil {
+			t.Errorf("%s: grpcAuthorize = %v, want nil", tt.name, err)
+		}
+		if !tt.ok && status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s: grpcAuthorize = %v, want Unauthenticated", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+// health serves /healthz and /readyz for liveness and readiness probes, e.g.
+// under Kubernetes. /healthz checks that the bus is open and every mux ACKs its
+// address; /readyz also needs polling to have started and every power monitor
+// to have answered its last poll.
+type health struct {
+	bus     string
+	tcas    []*i2c.Dev
+	fleet   *fleet
+	polling *atomic.Bool // set once polling starts, shared with the API
+}
+
+// healthCheck is one entry of a probe response; the name says what was checked.
+type healthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// healthResponse is the body of /healthz and /readyz: status is "ok", or
+// "unavailable" with a 503 when any check failed.
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+func (h *health) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.live())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, append(h.live(), h.ready()...))
+	})
+}
+
+// live checks the bus and probes the muxes. The server only runs while the bus
+// is open, so the bus check itself cannot fail.
+func (h *health) live() []healthCheck {
+	checks := []healthCheck{{Name: "bus " + h.bus, OK: true}}
+	busMu.Lock()
+	defer busMu.Unlock()
+	for _, tca := range h.tcas {
+		c := healthCheck{Name: fmt.Sprintf("mux 0x%X", tca.Addr), OK: true}
+		if err := tca9548a.Probe(tca); err != nil {
+			c.OK, c.Error = false, err.Error()
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// ready checks that polling has started and that each power monitor's last poll succeeded.
+func (h *health) ready() []healthCheck {
+	if !h.polling.Load() {
+		return []healthCheck{{Name: "polling", Error: "sensors are still being set up"}}
+	}
+	checks := []healthCheck{{Name: "polling", OK: true}}
+	for _, m := range h.fleet.list() {
+		c := healthCheck{Name: "device " + m.export.Device, OK: true}
+		polled, err := m.status.lastPoll()
+		switch {
+		case err != nil:
+			c.OK, c.Error = false, err.Error()
+		case !polled:
+			c.OK, c.Error = false, "not polled yet"
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+func writeHealth(w http.ResponseWriter, checks []healthCheck) {
+	for _, c := range checks {
+		if !c.OK {
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Checks: checks})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok", Checks: checks})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/sqlite"
+)
+
+// runHistory implements the history subcommand: it exports the readings stored
+// with --history.db as JSON lines, for example to backfill another store after
+// an outage.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	dbFlag := fs.String("db", "", "SQLite database written with --history.db (required)")
+	sinceFlag := fs.Duration("since", 0, "Only export readings from this long ago onwards, e.g. 24h; 0 exports everything (default: 0)")
+	deviceFlag := fs.String("device", "", "Only export readings of this device label (default: every device)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s history --db <file> [flags]\n\nWrite the stored readings to stdout as JSON lines, oldest first.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *dbFlag == "" {
+		slog.Error("--db is required")
+		return 2
+	}
+	if *sinceFlag < 0 {
+		slog.Error("Invalid --since: must not be negative", "since", *sinceFlag)
+		return 2
+	}
+	var since time.Time
+	if *sinceFlag > 0 {
+		since = time.Now().Add(-*sinceFlag)
+	}
+	n, err := sqlite.Export(os.Stdout, *dbFlag, since, *deviceFlag)
+	if err != nil {
+		slog.Error("Failed to export readings", "db", *dbFlag, "err", err)
+		return 1
+	}
+	slog.Info("Exported readings", "db", *dbFlag, "readings", n)
+	return 0
+}
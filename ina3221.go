@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// INA3221 Register Addresses. Each of the three lines has a shunt voltage
+// register followed by a bus voltage register, starting at 0x01.
+const (
+	ina3221RegConfig       byte = 0x00 // Configuration Register
+	ina3221RegShuntVoltage byte = 0x01 // Line 1 Shunt Voltage Register; line n is at 0x01 + 2*(n-1)
+	ina3221RegBusVoltage   byte = 0x02 // Line 1 Bus Voltage Register; line n is at 0x02 + 2*(n-1)
+	ina3221RegManufID      byte = 0xFE // Manufacturer ID Register
+	ina3221RegDieID        byte = 0xFF // Die ID Register
+)
+
+// INA3221 Scaling Factors. Both voltage registers are left-aligned 13-bit
+// two's complement values in bits 15:3.
+const (
+	ina3221ShuntVoltageLSB = 40e-6 // V/LSB for Shunt Voltage Registers
+	ina3221BusVoltageLSB   = 8e-3  // V/LSB for Bus Voltage Registers
+	ina3221Lines           = 3     // Number of monitored lines per chip
+)
+
+// Define Prometheus gauges for the INA3221, labeled by line (1-3)
+var (
+	ina3221BusVoltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina3221_bus_voltage",
+		Help: "Bus voltage measured by INA3221 sensor in Volts.",
+	}, []string{"hostname", "device", "line"})
+	ina3221ShuntVoltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina3221_shunt_voltage",
+		Help: "Shunt voltage measured by INA3221 sensor in Volts.",
+	}, []string{"hostname", "device", "line"})
+	ina3221Current = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina3221_current",
+		Help: "Current computed from the INA3221 shunt voltage and --shunt-ohms in Amperes.",
+	}, []string{"hostname", "device", "line"})
+	ina3221Power = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina3221_power",
+		Help: "Power computed from the INA3221 bus voltage and current in Watts.",
+	}, []string{"hostname", "device", "line"})
+)
+
+// ina3221Reading holds the measurements of one INA3221 line.
+type ina3221Reading struct {
+	Line         int     // 1-3
+	ShuntVoltage float64 // Volts
+	BusVoltage   float64 // Volts
+	Current      float64 // Amperes
+	Power        float64 // Watts
+}
+
+// readINA3221 reads all three lines of an INA3221. Unlike the INA260 the chip has
+// no integrated shunt, so current and power are computed from shuntOhms.
+func readINA3221(dev *i2c.Dev, shuntOhms float64) ([ina3221Lines]ina3221Reading, error) {
+	var readings [ina3221Lines]ina3221Reading
+	for i := range readings {
+		shuntReg := ina3221RegShuntVoltage + byte(2*i)
+		busReg := ina3221RegBusVoltage + byte(2*i)
+		rawShunt, err := ina260.ReadReg(dev, shuntReg) // Same 16-bit Big-Endian register access as the INA260
+		if err != nil {
+			return readings, fmt.Errorf("failed to read line %d shunt voltage: %w", i+1, err)
+		}
+		rawBus, err := ina260.ReadReg(dev, busReg)
+		if err != nil {
+			return readings, fmt.Errorf("failed to read line %d bus voltage: %w", i+1, err)
+		}
+		r := &readings[i]
+		r.Line = i + 1
+		// Arithmetic shift of the signed value drops the three unused low bits
+		r.ShuntVoltage = float64(int16(rawShunt)>>3) * ina3221ShuntVoltageLSB
+		r.BusVoltage = float64(int16(rawBus)>>3) * ina3221BusVoltageLSB
+		r.Current = r.ShuntVoltage / shuntOhms
+		r.Power = r.BusVoltage * r.Current
+	}
+	return readings, nil
+}
+
+// runINA3221 verifies the chip identity and then polls all three lines until ctx
+// is cancelled, printing them unless quiet and exporting the ina3221_* gauges.
+// It returns an error only when the identity cannot be read.
+func runINA3221(ctx context.Context, dev *i2c.Dev, hostname, device string, shuntOhms float64, pollInterval time.Duration, quiet bool) error {
+	manufID, err := ina260.ReadReg(dev, ina3221RegManufID)
+	if err != nil {
+		return fmt.Errorf("failed to read INA3221 Manufacturer ID: %w", err)
+	}
+	dieID, err := ina260.ReadReg(dev, ina3221RegDieID)
+	if err != nil {
+		return fmt.Errorf("failed to read INA3221 Die ID: %w", err)
+	}
+	logger := slog.With("device", device)
+	logger.Info("Identified INA3221", "manufacturer_id", fmt.Sprintf("0x%X", manufID), "die_id", fmt.Sprintf("0x%X", dieID))
+	if manufID != 0x5449 || dieID != 0x3220 {
+		logger.Warn("Unexpected INA3221 Manufacturer ID or Die ID", "expected", "0x5449/0x3220", "got", fmt.Sprintf("0x%X/0x%X", manufID, dieID))
+	}
+
+	logger.Info("Reading INA3221 values (Bus Voltage, Shunt Voltage, Current, Power) on 3 lines")
+	for ; ctx.Err() == nil; sleepContext(ctx, pollInterval) {
+		busMu.Lock()
+		readings, err := readINA3221(dev, shuntOhms)
+		busMu.Unlock()
+		if err != nil {
+			logger.Error("Failed to read INA3221", "err", err)
+			continue
+		}
+		for _, r := range readings {
+			if !quiet {
+				fmt.Printf("Line %d: Voltage: %.3f V, Shunt: %.3f mV, Current: %.3f A, Power: %.3f W\n",
+					r.Line, r.BusVoltage, r.ShuntVoltage*1000, r.Current, r.Power)
+			}
+			line := fmt.Sprint(r.Line)
+			ina3221BusVoltage.WithLabelValues(hostname, device, line).Set(r.BusVoltage)
+			ina3221ShuntVoltage.WithLabelValues(hostname, device, line).Set(r.ShuntVoltage)
+			ina3221Current.WithLabelValues(hostname, device, line).Set(r.Current)
+			ina3221Power.WithLabelValues(hostname, device, line).Set(r.Power)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// startupInventory is the result of setting up every configured sensor at startup,
+// for the --startup-probe-all summary line. Missing power monitors are polled in
+// degraded mode and retried, and missing sensors of the other kinds are skipped.
+type startupInventory struct {
+	present  int
+	retrying []string // device labels of the power monitors that did not answer
+	skipped  []string // device labels of the other sensors that did not answer
+}
+
+// record counts the setup of a power monitor, or with retried false, of a sensor
+// that is skipped when missing.
+func (v *startupInventory) record(device string, retried bool, err error) {
+	switch {
+	case err == nil:
+		v.present++
+	case retried:
+		v.retrying = append(v.retrying, device)
+	default:
+		v.skipped = append(v.skipped, device)
+	}
+}
+
+// summary returns e.g. "4/5 sensors present, tca9548a_0x70_ch3_ina260 missing".
+func (v *startupInventory) summary() string {
+	missing := append(append([]string(nil), v.retrying...), v.skipped...)
+	s := fmt.Sprintf("%d/%d sensors present", v.present, v.present+len(missing))
+	if len(missing) > 0 {
+		s += ", " + strings.Join(missing, ", ") + " missing"
+	}
+	return s
+}
+
+// log logs the summary, as a warning when a sensor is missing.
+func (v *startupInventory) log() {
+	if len(v.retrying) == 0 && len(v.skipped) == 0 {
+		slog.Info("Startup probe: " + v.summary())
+		return
+	}
+	var args []any
+	if len(v.retrying) > 0 {
+		args = append(args, "retrying", v.retrying)
+	}
+	if len(v.skipped) > 0 {
+		args = append(args, "skipped", v.skipped)
+	}
+	slog.Warn("Startup probe: "+v.summary(), args...)
+}
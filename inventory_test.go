@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStartupInventorySummary(t *testing.T) {
+	var v startupInventory
+	if got, want := v.summary(), "0/0 sensors present"; got != want {
+		t.Errorf("empty summary = %q, want %q", got, want)
+	}
+	missing := errors.New("no ACK")
+	v.record("psu_5v", true, nil)
+	v.record("tca9548a_0x70_ch3_ina260", true, missing)
+	v.record("psu_12v", true, nil)
+	v.record("board_temp", false, missing)
+	v.record("ambient", false, nil)
+	if got, want := v.summary(), "3/5 sensors present, tca9548a_0x70_ch3_ina260, board_temp missing"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+}
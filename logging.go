@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// newLogHandler returns the slog handler selected by --log.level and --log.format.
+func newLogHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", format)
+	}
+}
+
+// labelAttrs returns the extra labels of a device from the config file as one
+// log attribute group, e.g. labels.rack=r1, or none without any.
+func labelAttrs(labels map[string]string) []any {
+	if len(labels) == 0 {
+		return nil
+	}
+	var attrs []any
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		attrs = append(attrs, name, labels[name])
+	}
+	return []any{slog.Group("labels", attrs...)}
+}
+
+// muxAttrs returns the log attributes locating a sensor behind a mux, or none
+// for a directly connected one.
+func muxAttrs(mux string, channel int) []any {
+	if mux == "" || channel < 0 {
+		return nil
+	}
+	return []any{"mux", mux, "channel", channel}
+}
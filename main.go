@@ -1,250 +1,647 @@
 package main
 
 import (
-	"encoding/binary" // For binary.BigEndian
-	"flag"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http" // New import for HTTP server
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"sync"
+	"syscall"
 	"time" // For time.Sleep
 
 	"periph.io/x/conn/v3/i2c"
 	"periph.io/x/conn/v3/i2c/i2creg"
 	"periph.io/x/host/v3"
 
-	"github.com/prometheus/client_golang/prometheus"          // New import for Prometheus metrics
-	"github.com/prometheus/client_golang/prometheus/promauto" // New import for auto-registering metrics
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp" // New import for HTTP handler
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+// Supported power monitor chips for --chip
+const (
+	chipINA260  = "ina260"
+	chipINA219  = "ina219"
+	chipINA226  = "ina226"
+	chipINA3221 = "ina3221"
+	chipBME280  = "bme280"  // the environmental sensor polled next to the power monitors, also covering the BMP280
+	chipMCP9808 = "mcp9808" // temperature sensors polled next to the power monitors
+	chipTMP117  = "tmp117"
 )
 
-// INA260 I2C address
-const ina260Address = uint16(0x40) // Default INA260 I2C address
+// busMu serializes access to the I2C bus between the per-sensor polling
+// goroutines and HTTP handlers. It is held from the mux channel selection to the
+// last register transaction, so no other access can re-route the bus in between.
+// With --bus-lock-dir it also keeps other processes off the bus.
+var busMu busLock
 
-// INA260 Register Addresses
+// publishMu serializes publishing, since the sinks are shared by every sensor's goroutine.
+var publishMu sync.Mutex
+
+// Formats for --output-file-format
 const (
-	ina260RegConfig     byte = 0x00 // Configuration Register
-	ina260RegCurrent    byte = 0x01 // Current Register
-	ina260RegBusVoltage byte = 0x02 // Bus Voltage Register
-	ina260RegPower      byte = 0x03 // Power Register
-	ina260RegManufID    byte = 0xFE // Manufacturer ID Register
-	ina260RegDeviceID   byte = 0xFF // Device ID Register
+	outputFormatText      = "text"
+	outputFormatCSV       = "csv"
+	outputFormatJSONLines = "jsonl"
 )
 
-// INA260 Scaling Factors
+// Modes of --mux.select-mode
 const (
-	voltageLSB = 1.25 // mV/LSB for Bus Voltage Register
-	currentLSB = 1.25 // mA/LSB for Current Register
-	powerLSB   = 10.0 // mW/LSB for Power Register
+	muxSelectSticky = "sticky"  // leave the channel selected until another is needed
+	muxSelectAllOff = "all-off" // deselect every channel after each transaction
 )
 
-// Define Prometheus gauges with labels
-var (
-	ina260Current = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "ina260_current",
-		Help: "Current measured by INA260 sensor in Amperes.",
-	}, []string{"hostname", "device"}) // Added labels: hostname, device
-	ina260Voltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "ina260_voltage",
-		Help: "Bus voltage measured by INA260 sensor in Volts.",
-	}, []string{"hostname", "device"}) // Added labels: hostname, device
-	ina260Power = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "ina260_power",
-		Help: "Power measured by INA260 sensor in Watts.",
-	}, []string{"hostname", "device"}) // Added labels: hostname, device
+// Timestamp sources for --timestamp-source
+const (
+	timestampStart = "start" // before the first register read of the cycle
+	timestampEnd   = "end"   // after the last register read, when all data is available
+	timestampMid   = "mid"   // halfway between start and end
 )
 
-// readINA260Reg reads a 16-bit value from the specified INA260 register.
-// The INA260 returns data in Big-Endian format.
-func readINA260Reg(dev *i2c.Dev, reg byte) (uint16, error) {
-	writeBuf := []byte{reg}
-	readBuf := make([]byte, 2) // 16-bit (2 bytes)
+// readingTimestamp picks the timestamp of a cycle that began at start and ended at end.
+func readingTimestamp(source string, start, end time.Time) time.Time {
+	switch source {
+	case timestampStart:
+		return start
+	case timestampMid:
+		return start.Add(end.Sub(start) / 2)
+	default:
+		return end
+	}
+}
+
+// sensorHealth debounces the up/down state of a sensor: it goes down only after
+// downAfter consecutive failed checks and comes back up only after upAfter
+// consecutive successful ones, so a single flaky cycle does not flap ina260_up.
+// Checks come from both the polling loop and the presence prober.
+type sensorHealth struct {
+	mu        sync.Mutex
+	up        bool
+	failures  int // consecutive failed checks
+	successes int // consecutive successful checks
+	downAfter int
+	upAfter   int
+	gauge     prometheus.Gauge // ina260_up series of the sensor
+
+	logger         *slog.Logger // the monitor's logger, for transition log lines
+	logTransitions bool         // log every up/down transition
+}
 
-	// Perform the transaction: write register address, then read 2 bytes
-	if err := dev.Tx(writeBuf, readBuf); err != nil {
-		return 0, err
+// record feeds one check result into the state, updates the up gauge and
+// reports whether the state changed.
+func (h *sensorHealth) record(ok bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ok {
+		h.failures = 0
+		h.successes++
+		if !h.up && h.successes >= h.upAfter {
+			h.up = true
+			h.gauge.Set(1)
+			if h.logTransitions {
+				h.logger.Info("Sensor went up", "successful_checks", h.successes)
+			}
+			return true
+		}
+		return false
+	}
+	h.successes = 0
+	h.failures++
+	if h.up && h.failures >= h.downAfter {
+		h.up = false
+		h.gauge.Set(0)
+		if h.logTransitions {
+			h.logger.Warn("Sensor went down", "failed_checks", h.failures)
+		}
+		return true
 	}
+	return false
+}
 
-	return binary.BigEndian.Uint16(readBuf), nil
+// down marks the sensor down right away, as for one that failed to set up.
+func (h *sensorHealth) down() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.up, h.successes, h.failures = false, 0, h.downAfter
+	h.gauge.Set(0)
 }
 
-func initializeI2C(busFlag string) (i2c.BusCloser, error) {
-	if _, err := host.Init(); err != nil {
-		return nil, fmt.Errorf("failed to initialize host: %w", err)
+// isUp reports whether the sensor is currently considered up.
+func (h *sensorHealth) isUp() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.up
+}
+
+// registerDump is the JSON form of every INA260 register of one sensor.
+type registerDump struct {
+	Device    string            `json:"device"`
+	Registers map[string]string `json:"registers,omitempty"` // register name -> "0xABCD"
+	Error     string            `json:"error,omitempty"`
+}
+
+// dumpINA260Registers reads all INA260 registers of a sensor and formats them as hex.
+// gate selects the sensor's mux channel for the duration of the dump.
+func dumpINA260Registers(dev *i2c.Dev, device string, gate *muxGate) registerDump {
+	busMu.Lock()
+	defer busMu.Unlock()
+
+	if err := gate.open(); err != nil {
+		return registerDump{Device: device, Error: err.Error()}
 	}
-	bus, err := i2creg.Open(busFlag) // Opens the default I2C bus
-	if err != nil {
-		return nil, fmt.Errorf("failed to open I2C bus: %w", err)
+	defer func() {
+		if err := gate.close(); err != nil {
+			slog.Warn("Failed to deselect mux channel", "device", device, "err", err)
+		}
+	}()
+
+	dump := registerDump{Device: device, Registers: make(map[string]string, len(ina260.Registers))}
+	for _, reg := range ina260.Registers {
+		value, err := ina260.ReadReg(dev, reg.Addr)
+		if err != nil {
+			return registerDump{Device: device, Error: fmt.Sprintf("failed to read register 0x%02X (%s): %v", reg.Addr, reg.Name, err)}
+		}
+		dump.Registers[reg.Name] = fmt.Sprintf("0x%04X", value)
 	}
-	return bus, nil
+	return dump
 }
 
-func getDevice(bus i2c.BusCloser, tcaAddressStr string, channelStr string) (*i2c.Dev, error) {
+// errInitCancelled is returned by initializeI2C when its context ends before the bus is open.
+var errInitCancelled = errors.New("I2C initialization cancelled")
+
+// initializeI2C initializes the host drivers and opens the I2C bus, retrying up to
+// retries more times. With skipHostInit, host.Init is left to whoever embeds
+// the process and only the bus is opened. It gives up as soon as ctx is cancelled, even while an
+// attempt is still blocked in the driver; such a late bus is closed once it opens.
+func initializeI2C(ctx context.Context, busFlag string, skipHostInit bool, retries int, retryInterval time.Duration) (i2c.BusCloser, error) {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			slog.Warn("Failed to initialize I2C, retrying", "err", err, "retry_in", retryInterval, "attempt", attempt, "retries", retries)
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%w: %w", errInitCancelled, ctx.Err())
+			case <-time.After(retryInterval):
+			}
+		}
+
+		type result struct {
+			bus i2c.BusCloser
+			err error
+		}
+		done := make(chan result, 1)
+		go func() {
+			if !skipHostInit {
+				if _, err := host.Init(); err != nil {
+					done <- result{nil, fmt.Errorf("failed to initialize host: %w", err)}
+					return
+				}
+			}
+			bus, err := i2creg.Open(busFlag) // Opens the default I2C bus
+			if err != nil {
+				if skipHostInit {
+					err = fmt.Errorf("%w (host init was skipped; if no parent process initializes periph, drop --skip-host-init)", err)
+				}
+				done <- result{nil, fmt.Errorf("failed to open I2C bus: %w", err)}
+				return
+			}
+			done <- result{bus, nil}
+		}()
+
+		select {
+		case <-ctx.Done():
+			go func() {
+				if r := <-done; r.bus != nil {
+					r.bus.Close()
+				}
+			}()
+			return nil, fmt.Errorf("%w: %w", errInitCancelled, ctx.Err())
+		case r := <-done:
+			if r.err == nil {
+				return r.bus, nil
+			}
+			err = r.err
+		}
+	}
+	return nil, err
+}
+
+// muxGate selects a sensor's mux channel around each access, deselecting the
+// other muxes of the group first. It is needed when several channels are polled,
+// and with --disable-after-read: then close also deselects every channel, so the
+// muxes route no traffic between readings for other masters on the bus. Each
+// such deselect, and the re-select it forces before the next access, is an extra
+// write counted in ina260_mux_extra_writes_total. A nil *muxGate does nothing,
+// leaving the channel selected at startup in place.
+type muxGate struct {
+	muxes    *tca9548a.Group
+	index    int // mux of the group the sensor sits behind
+	mask     byte
+	path     []tca9548a.Hop     // cascaded muxes from that channel on to the sensor's, for a nested mux
+	deselect bool               // deselect all channels after each access
+	extra    prometheus.Counter // nil unless deselect is set
+}
+
+func (g *muxGate) open() error {
+	if g == nil {
+		return nil
+	}
+	wrote, err := g.muxes.SelectPath(g.index, g.mask, g.path...)
+	if wrote && g.deselect {
+		g.extra.Inc()
+	}
+	return err
+}
+
+func (g *muxGate) close() error {
+	if g == nil || !g.deselect {
+		return nil
+	}
+	g.extra.Inc()
+	return g.muxes.DeselectPath(g.path...)
+}
+
+var tca9548aChannelMask = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tca9548a_channel_mask",
+	Help: "Channels enabled in the multiplexer control register, bit n for channel n, as last written or read back with --mux.verify.",
+}, []string{"hostname", "bus", "mux"})
+
+// instrumentMuxes publishes the channels of every mux of g in
+// tca9548a_channel_mask, and turns on read-back verification with --mux.verify.
+// A cascaded mux is labelled with the mux and channel it sits behind, e.g.
+// "0x70/3 -> 0x71".
+func instrumentMuxes(g *tca9548a.Group, hostname, bus string, verify bool) {
+	instrument := func(m *tca9548a.Mux, label string) {
+		gauge := tca9548aChannelMask.WithLabelValues(hostname, bus, label)
+		m.Verify = verify
+		m.Observe = func(control byte) { gauge.Set(float64(control)) }
+	}
+	for _, m := range g.Muxes {
+		instrument(m, fmt.Sprintf("0x%02X", m.Dev.Addr))
+	}
+	for _, c := range g.Cascaded {
+		instrument(c.Mux, fmt.Sprintf("0x%02X/%d -> 0x%02X", c.Parent.Dev.Addr, c.Channel, c.Dev.Addr))
+	}
+}
+
+func getDevice(bus i2c.BusCloser, muxModel tca9548a.Model, tcaAddressStr string, channelStr string) (*i2c.Dev, error) {
 	if tcaAddressStr != "" && channelStr != "" {
 		tcaAddress64, err := strconv.ParseUint(tcaAddressStr, 0, 16) // 0 for auto-detection of base (0x prefix means hex)
 		if err != nil {
-			log.Fatalf("Invalid TCA address: %v", err)
+			return nil, fmt.Errorf("invalid TCA address: %w", err)
 		}
 		tcaAddress := uint16(tcaAddress64)
 
 		tca := &i2c.Dev{Bus: bus, Addr: tcaAddress}
-		fmt.Printf("Using TCA9548A at address: 0x%X\n", tcaAddress) // Confirm the address being used
 
 		// Get the channel number as argument and assign it to ina260Channel variable
 		channelInt, err := strconv.Atoi(channelStr)
 		if err != nil {
 			return nil, fmt.Errorf("invalid channel number: %w", err)
 		}
-		if channelInt < 0 || channelInt > 7 { // TCA9548A typically has 8 channels (0-7)
-			return nil, fmt.Errorf("channel number must be between 0 and 7, got %d", channelInt)
+		channelSelectionByte, err := muxModel.ChannelMask(channelInt)
+		if err != nil {
+			return nil, err
 		}
 		ina260Channel := byte(channelInt)
 		// Select the channel on the TCA9548A multiplexer
-		channelSelectionByte := byte(1 << ina260Channel)
 		if err := tca.Tx([]byte{channelSelectionByte}, nil); err != nil {
-			return nil, fmt.Errorf("failed to select channel %d on TCA9548A: %w", ina260Channel, err)
+			return nil, fmt.Errorf("failed to select channel %d on %s: %w", ina260Channel, muxModel, err)
 		}
-		fmt.Printf("TCA9548A: Selected channel %d\n", ina260Channel)
+		slog.Debug("Selected mux channel", "mux", tcaAddressStr, "channel", ina260Channel)
 	}
-	dev := &i2c.Dev{Bus: bus, Addr: ina260Address}
-	// Optionally, you can perform a quick check to see if the device responds
-	if err := dev.Tx([]byte{0}, nil); err != nil {
-		return nil, fmt.Errorf("failed to communicate with device at address 0x%X: %w", ina260Address, err)
+	dev := &i2c.Dev{Bus: bus, Addr: ina260.Address}
+	// Check that the device responds with a read of the Manufacturer ID register,
+	// which has no side effects (unlike a write aimed at the Configuration register)
+	if _, err := ina260.ReadReg(dev, ina260.RegManufID); err != nil {
+		return nil, fmt.Errorf("failed to communicate with device at address 0x%X: %w", ina260.Address, err)
 	}
 	return dev, nil
 }
 
 func main() {
-	// set flagged arguments for TCA9548A address and channel
-	tcaAddressFlag := flag.String("tca-address", "0x70", "I2C address of the TCA9548A multiplexer (default: 0x70)") // Initialize host and I2C bus
-	channelFlag := flag.Int("channel", 0, "Channel number on the TCA9548A multiplexer (0-7, default: 0)")
-	withoutMultiplexerFlag := flag.Bool("without-multiplexer", false, "Set to true if INA260 is connected directly without TCA9548A multiplexer (default: false)")
-	busFlag := flag.String("bus", "/dev/i2c-1", "I2C bus to use (default: /dev/i2c-1)")
-
-	flag.Parse()
-	bus, err := initializeI2C(*busFlag) // Initialize I2C bus
-	if err != nil {
-		log.Fatalf("Failed to initialize I2C: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "scan" {
+		os.Exit(runScan(os.Args[2:]))
 	}
-	defer bus.Close() // Ensure the bus is closed when done
-
-	// -------------------- Set Hostname Label --------------------
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Fatalf("Failed to get hostname: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "read" {
+		os.Exit(runRead(os.Args[2:]))
 	}
-
-	// --- Get TCA's address as argument and assign it to tcaAddress ---
-	// Get the TCA address and channel number as arguments
-	// Get the TCA address and channel number from flags
-	if *withoutMultiplexerFlag {
-		fmt.Println("Running without TCA9548A multiplexer, using INA260 directly.")
-		tcaAddressFlag = nil // Set to nil to skip TCA address usage
-		channelFlag = nil    // Set to nil to skip channel usage
-	} else {
-		fmt.Println("Running with TCA9548A multiplexer.")
+	if len(os.Args) > 1 && os.Args[1] == "capture" {
+		os.Exit(runCapture(os.Args[2:]))
 	}
-
-	var tcaAddressStr string = ""
-	var channelStr string = ""
-	if tcaAddressFlag == nil && channelFlag == nil {
-		fmt.Println("Running without TCA9548A multiplexer, using INA260 directly.")
-	} else {
-		// If TCA address and channel are provided, use them
-		// Check if TCA address is connected or not
-		tcaAddressStr = *tcaAddressFlag
-		channelStr = strconv.Itoa(*channelFlag)
-		fmt.Printf("Using TCA address: %s, Channel: %s\n", tcaAddressStr, channelStr)
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		os.Exit(runSelftest(os.Args[2:]))
 	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(runHistory(os.Args[2:]))
+	}
+	os.Exit(run())
+}
 
-	ina260, err := getDevice(bus, tcaAddressStr, channelStr)
+// run parses the flags, opens the hardware and serves it until SIGINT or
+// SIGTERM, and returns the exit code. Every deferred close has run by the time
+// it returns, which os.Exit would skip.
+func run() int {
+	started := time.Now() // reported as uptime on SIGUSR1
+	o, err := parseFlags()
 	if err != nil {
-		if *withoutMultiplexerFlag {
-			log.Fatalf("Failed to get INA260 device directly: %v", err)
-		} else {
-			log.Printf("Failed to get INA260 through TCA9548A: %v. Retrying without multiplexer...", err)
-			if ina260, err = getDevice(bus, "", ""); err != nil {
-				log.Fatalf("Failed to get INA260 device directly: %v", err)
-			}
-			fmt.Println("Successfully connected to INA260 directly.")
+		slog.Error(err.Error())
+		return 1
+	}
+	switch {
+	case o.printConfigSchema:
+		if err := printConfigSchema(os.Stdout); err != nil {
+			slog.Error("Failed to print config schema", "err", err)
+			return 1
 		}
-	} else {
-		fmt.Println("Successfully connected to INA260")
+		return 0
+	case o.helpRegisters:
+		if err := ina260.PrintRegisters(os.Stdout); err != nil {
+			slog.Error("Failed to print register map", "err", err)
+			return 1
+		}
+		return 0
+	case o.diagnose:
+		// Checks the path to the --channel sensor through its own mux
+		tcaAddressStr, channel := o.tcaAddress, o.channel
+		if len(o.muxAddresses) > 0 {
+			tcaAddressStr, channel = o.muxAddresses[channel/o.muxModel.Channels].spec, channel%o.muxModel.Channels
+		}
+		if !runDiagnose(o.bus, o.skipHostInit, o.muxModel, tcaAddressStr, channel, o.withoutMultiplexer) {
+			return 1
+		}
+		return 0
+	}
+	hw, err := openHardware(o)
+	if errors.Is(err, errInitCancelled) {
+		slog.Info("Startup interrupted", "err", err)
+		return 0
 	}
-
-	// -------------------- Set Device Label --------------------
-	deviceLabel := fmt.Sprintf("tca9548a_%s_ch%s_ina260", tcaAddressStr, channelStr)
-
-	// Optional: Read Manufacturer ID and Device ID to verify communication with INA260
-	// Expected Manufacturer ID: 0x5449 (TI), Device ID: 0x2260 (INA260)
-	manufID, err := readINA260Reg(ina260, ina260RegManufID)
 	if err != nil {
-		log.Fatalf("Failed to read INA260 Manufacturer ID: %v", err)
+		slog.Error(err.Error())
+		return 1
 	}
-	deviceID, err := readINA260Reg(ina260, ina260RegDeviceID)
+	defer hw.Close() // Ensure the buses are closed when done
+	return serve(o, hw, started)
+}
+
+// serve serves the metrics and the APIs, and polls the sensors of hw until
+// SIGINT or SIGTERM or a server fails, and returns the exit code.
+func serve(o *options, hw *hardware, started time.Time) int {
+	// Bind the metrics port before polling starts, so a port conflict fails startup cleanly
+	// instead of killing the process after readings have begun
+	port := ":9090"
+	listener, err := net.Listen("tcp", port)
 	if err != nil {
-		log.Fatalf("Failed to read INA260 Device ID: %v", err)
+		slog.Error(fmt.Sprintf("Failed to listen on port %s for Prometheus metrics (is another instance or exporter already using it?)", port), "err", err)
+		return 1
 	}
-	fmt.Printf("INA260: Manufacturer ID: 0x%X, Device ID: 0x%X\n", manufID, deviceID)
-	if manufID != 0x5449 || deviceID != 0x2260 {
-		fmt.Printf("Warning: Unexpected INA260 Manufacturer ID or Device ID. Expected 0x5449/0x2260, got 0x%X/0x%X\n", manufID, deviceID)
+	var grpcListener net.Listener
+	if o.grpcListenAddress != "" {
+		if grpcListener, err = net.Listen("tcp", o.grpcListenAddress); err != nil {
+			listener.Close()
+			slog.Error("Failed to listen for the gRPC API", "address", o.grpcListenAddress, "err", err)
+			return 1
+		}
 	}
 
-	// Start HTTP server for Prometheus metrics in a goroutine
+	// Handles the /metrics endpoint, with the extra labels of the config file
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(exporter.Gatherer, promhttp.HandlerOpts{})))
+	polled := hw.polled
+	api := &api{chip: o.chip, capture: o.captureAPI, fleet: polled, opts: o.poll}
+	var stream *readingStream
+	var debugAPI *i2cDebugAPI
+	if o.chip != chipINA3221 {
+		api.register(http.DefaultServeMux)
+		stream = newReadingStream()
+		stream.register(http.DefaultServeMux)
+		if o.dashboard {
+			serveDashboard(http.DefaultServeMux)
+		}
+		(&health{bus: o.bus, tcas: hw.tcas, fleet: polled, polling: &api.polling}).register(http.DefaultServeMux)
+		if o.debugI2CToken != nil {
+			debugAPI = &i2cDebugAPI{bus: hw.bus, muxes: hw.muxes, model: o.muxModel, deselect: o.disableAfterRead, token: o.debugI2CToken}
+			debugAPI.register(http.DefaultServeMux)
+			slog.Warn("Serving register reads and writes on /api/v1/debug/i2c", "token_file", o.debugI2CTokenFile)
+		}
+	}
+	if o.debugRegisters && o.chip == chipINA260 {
+		http.HandleFunc("/debug/registers", func(w http.ResponseWriter, r *http.Request) {
+			monitors := polled.list()
+			dumps := make([]registerDump, 0, len(monitors))
+			for _, m := range monitors {
+				dumps = append(dumps, dumpINA260Registers(m.sensor.Dev, m.export.Device, m.gate))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(dumps); err != nil {
+				slog.Error("Failed to write /debug/registers response", "err", err)
+			}
+		})
+	}
+
+	// SIGINT/SIGTERM end polling and serving; a second signal kills the process as before
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// So does a server that fails, so the outputs are still flushed and closed
+	ctx, cancel := context.WithCancelCause(sigCtx)
+	defer cancel(nil)
+	serveErr := make(chan error, 2) // of the HTTP and the gRPC server
 	go func() {
-		http.Handle("/metrics", promhttp.Handler()) // Handles the /metrics endpoint
-		port := ":9090"
-		log.Printf("Starting Prometheus metrics server on port %s", port)
-		if err := http.ListenAndServe(port, nil); err != nil {
-			log.Fatalf("Error starting HTTP server: %v", err)
+		select {
+		case err := <-serveErr:
+			slog.Error("Stopping after a server failed", "err", err)
+			cancel(err)
+		case <-ctx.Done():
 		}
+		stop()
 	}()
 
-	// Continuously read and display values from INA260
-	fmt.Println("Reading INA260 values (Voltage, Current, Power)...")
-	for {
-		// Read Current (Register 0x01)
-		rawCurrent, err := readINA260Reg(ina260, ina260RegCurrent)
-		if err != nil {
-			log.Printf("Error reading current from INA260: %v", err)
-			time.Sleep(1 * time.Second) // Wait before retrying
-			continue
+	// Serve Prometheus metrics in a goroutine
+	var bypassBasicAuth func(r *http.Request) bool
+	if debugAPI != nil {
+		bypassBasicAuth = debugAPI.bypassesBasicAuth
+	}
+	server := &http.Server{
+		Handler:   o.web.handler(http.DefaultServeMux, bypassBasicAuth),
+		TLSConfig: o.webTLS,
+	}
+	go func() {
+		slog.Info("Starting Prometheus metrics server", "port", port, "tls", o.webTLS != nil, "basic_auth_users", len(o.web.BasicAuthUsers))
+		serveHTTP := server.Serve
+		if o.webTLS != nil {
+			serveHTTP = func(l net.Listener) error { return server.ServeTLS(l, "", "") }
 		}
+		if err := serveHTTP(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- fmt.Errorf("error serving HTTP: %w", err)
+		}
+	}()
+	defer shutdown(server, slices.Concat(hw.tcas, hw.otherTCAs))
+	if grpcListener != nil {
+		grpcServer := newGRPCServer(&grpcServer{api: api, stream: stream, debug: debugAPI}, o.web, o.webTLS)
+		go func() {
+			slog.Info("Starting gRPC server", "address", grpcListener.Addr().String(), "tls", o.webTLS != nil, "basic_auth_users", len(o.web.BasicAuthUsers))
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				serveErr <- fmt.Errorf("error serving gRPC: %w", err)
+			}
+		}()
+		// Runs after the outputs close, which ends the StreamReadings calls
+		defer grpcServer.GracefulStop()
+	}
 
-		// The Current Register (0x01) is a 16-bit two's complement signed integer.
-		// `binary.BigEndian.Uint16` reads it as unsigned, so cast to `int16` to preserve sign.
-		// Convert raw current (mA) to Amperes (A)
-		current := float64(int16(rawCurrent)) * currentLSB / 1000.0
+	if o.chip == chipINA3221 {
+		if err := runINA3221(ctx, hw.targets[0].dev, hw.hostname, hw.targets[0].label, o.shuntOhms, o.pollInterval, o.quiet); err != nil {
+			slog.Error("Failed to set up the INA3221", "err", err)
+			return 1
+		}
+		return exitCode(ctx)
+	}
 
-		// Read Voltage (Register 0x02)
-		rawVoltage, err := readINA260Reg(ina260, ina260RegBusVoltage)
+	// The power monitors are set up first, so a startup that fails on them logs
+	// just their part of the inventory
+	monitors := hw.monitors
+	var inventory startupInventory
+	logInventory := func() {
+		if o.startupProbeAll {
+			inventory.log()
+		}
+	}
+	failed := 0
+	for _, m := range monitors {
+		err := m.setUpSensor(o.coincident)
+		inventory.record(m.export.Device, true, err)
 		if err != nil {
-			log.Printf("Error reading Bus Voltage from INA260: %v", err)
-			time.Sleep(1 * time.Second)
-			continue
+			if len(monitors) == 1 {
+				m.logger.Error("Failed to set up sensor", "err", err)
+				logInventory()
+				return 1
+			}
+			m.logger.Error("Failed to set up sensor; polling the others and retrying it", "retry_in", m.setUpRetry, "err", err)
+			m.health.down()
+			failed++
 		}
+	}
+	if failed > 0 && failed == len(monitors) {
+		slog.Error("Failed to set up any sensor")
+		logInventory()
+		return 1
+	}
+	hw.channelSensors.init(&inventory)
+	logInventory()
 
-		// Convert raw voltage (mV) to Volts (V)
-		voltage := float64(rawVoltage) * voltageLSB / 1000.0
+	// Every reading fans out to each enabled output sink
+	out, err := newOutputs(o, hw, stream)
+	if err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	defer out.Close()
+	if out.watcher != nil {
+		go out.watcher.run(ctx)
+	}
 
-		// Read Power (Register 0x03)
-		rawPower, err := readINA260Reg(ina260, ina260RegPower)
-		if err != nil {
-			log.Printf("Error reading power from INA260: %v", err)
-			time.Sleep(1 * time.Second)
-			continue
+	// Continuously read and display values from INA260
+	if o.readOnScrape {
+		slog.Info("Reading sensors on each scrape", "sensors", len(monitors), "scrape_cache_ttl", o.scrapeCacheTTL)
+	} else {
+		slog.Info("Polling sensors", "sensors", len(monitors), "poll_interval", o.pollInterval)
+	}
+	for _, m := range monitors {
+		if m.setUp {
+			m.health.gauge.Set(1)
 		}
-		// Convert raw power (mW) to Watts (W)
-		power := float64(rawPower) * powerLSB / 1000.0
+	}
+	dumpStateOnSIGUSR1(started, polled)
 
-		fmt.Printf("Voltage: %.3f V, Current: %.3f A, Power: %.3f W\n", voltage, current, power)
+	// Check presence in between readings so a removed sensor is noticed before the next poll
+	if o.probeInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(o.probeInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				for _, m := range polled.list() {
+					m.probe()
+				}
+			}
+		}()
+	}
+	api.polling.Store(true)
+	lastPollDone.Store(time.Now().UnixNano())
+	sdNotify("READY=1")
+	if timeout := sdWatchdogTimeout(); timeout > 0 {
+		// A failing sensor is retried every errorBackoff, which may be longer than the poll interval
+		go sdWatchdog(ctx, timeout, max(o.slowest.interval+o.slowest.jitter, o.errorBackoff)+timeout)
+		slog.Info("Feeding the systemd watchdog", "timeout", timeout)
+	}
+	// Each sensor polls on its own ticker, so a slow or failing one does not delay the others
+	polled.run = func(ctx context.Context, m *monitor) { m.run(ctx, o.poll, out.sinks, o.errorBackoff) }
+	if o.readOnScrape {
+		// The collector reads the sensors; their goroutines only wait to be stopped
+		polled.run = func(ctx context.Context, _ *monitor) { <-ctx.Done() }
+	}
+	for _, m := range monitors {
+		polled.start(ctx, m)
+	}
+	hw.watchChannels(ctx, o)
+	hw.channelSensors.run(ctx, o.errorBackoff)
+	// Reloads may stop every monitor and start new ones, so only the end of ctx ends polling
+	<-ctx.Done()
+	polled.wait()
+	return exitCode(ctx)
+}
 
-		// Update Prometheus gauges with label values
-		ina260Current.WithLabelValues(hostname, deviceLabel).Set(current)
-		ina260Voltage.WithLabelValues(hostname, deviceLabel).Set(voltage)
-		ina260Power.WithLabelValues(hostname, deviceLabel).Set(power)
+// exitCode is 1 when serve stopped because a server failed, 0 on a signal.
+func exitCode(ctx context.Context) int {
+	if !errors.Is(context.Cause(ctx), context.Canceled) {
+		return 1
+	}
+	return 0
+}
+
+// sleepContext waits for d, returning early if ctx is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
 
-		time.Sleep(1 * time.Second) // Wait for 1 second before the next reading
+// shutdownTimeout bounds how long in-flight HTTP requests may take to finish at exit.
+const shutdownTimeout = 5 * time.Second
+
+// shutdown runs once polling has stopped. It lets in-flight scrapes finish, so the
+// last readings are still served, then deselects every mux channel with the bus
+// lock held: a transaction still running in another goroutine completes first,
+// and none starts afterwards. The bus itself is closed by main's deferred Close.
+func shutdown(server *http.Server, tcas []*i2c.Dev) {
+	slog.Info("Shutting down")
+	sdNotify("STOPPING=1")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to stop the metrics server cleanly", "err", err)
+	}
+	busMu.Lock() // Held until exit
+	for _, tca := range tcas {
+		if err := tca9548a.Reset(tca, ""); err != nil {
+			slog.Warn("Failed to deselect TCA9548A", "mux", fmt.Sprintf("0x%X", tca.Addr), "err", err)
+		}
 	}
 }
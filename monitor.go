@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina226"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/otlp"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+// muxAddress is one --tca-address entry, keeping the address as given for device labels.
+type muxAddress struct {
+	spec string // e.g. "0x70"
+	addr uint16
+}
+
+// parseMuxAddresses parses a --tca-address list of addresses of muxes of the
+// model, e.g. "0x70" or "0x70,0x71". The channels of several muxes are numbered
+// consecutively in the order given: with two TCA9548As, channels 8-15 are
+// channels 0-7 of the second one, and with two TCA9546As channels 4-7 are.
+func parseMuxAddresses(spec string, model tca9548a.Model) ([]muxAddress, error) {
+	var addresses []muxAddress
+	seen := make(map[uint16]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		addr, err := strconv.ParseUint(part, 0, 16) // 0 for auto-detection of base (0x prefix means hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TCA address %q: %w", part, err)
+		}
+		if err := model.CheckAddress(uint16(addr)); err != nil {
+			return nil, fmt.Errorf("invalid TCA address %s: %w", part, err)
+		}
+		if seen[uint16(addr)] {
+			return nil, fmt.Errorf("TCA address %s is listed more than once", part)
+		}
+		seen[uint16(addr)] = true
+		addresses = append(addresses, muxAddress{spec: part, addr: uint16(addr)})
+	}
+	return addresses, nil
+}
+
+// checkMuxPath checks the hops of a mux_path against the muxes of the main bus
+// and their model, and returns the index of the mux it starts at. The cascaded
+// muxes must not share an address with those, which would answer alongside them.
+func checkMuxPath(hops []muxHop, muxes []muxAddress, model tca9548a.Model) (int, error) {
+	first := slices.IndexFunc(muxes, func(a muxAddress) bool { return a.addr == hops[0].addr })
+	if first < 0 {
+		return 0, fmt.Errorf("0x%02X is not one of the muxes of the bus", hops[0].addr)
+	}
+	for i, h := range hops {
+		if i > 0 {
+			if err := model.CheckAddress(h.addr); err != nil {
+				return 0, err
+			}
+			if slices.ContainsFunc(muxes, func(a muxAddress) bool { return a.addr == h.addr }) {
+				return 0, fmt.Errorf("cascaded mux 0x%02X has the address of a mux of the bus", h.addr)
+			}
+		}
+		if _, err := model.ChannelMask(h.channel); err != nil {
+			return 0, err
+		}
+	}
+	return first, nil
+}
+
+// parseChannels parses a --channels list of mux channels, e.g. "0-7" or "0,2,5"
+// or a mix such as "0-3,6", numbered across the muxes, which have the given
+// number of channels in total. Channels are returned in the order given.
+func parseChannels(spec string, total int) ([]int, error) {
+	var channels []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel %q: %w", part, err)
+		}
+		hi, err := strconv.Atoi(last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel %q: %w", part, err)
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("invalid channel range %q: start is after end", part)
+		}
+		for ch := lo; ch <= hi; ch++ {
+			if ch < 0 || ch >= total {
+				return nil, fmt.Errorf("channel number must be between 0 and %d, got %d", total-1, ch)
+			}
+			if seen[ch] {
+				return nil, fmt.Errorf("channel %d is listed more than once", ch)
+			}
+			seen[ch] = true
+			channels = append(channels, ch)
+		}
+	}
+	return channels, nil
+}
+
+// pollOptions are the polling settings shared by every monitor.
+type pollOptions struct {
+	coincident       bool
+	timestampSource  string
+	staleAfter       time.Duration
+	warnOnSaturation bool
+	debugTiming      bool
+	deadband         float64 // Amperes around zero where ina260_current_direction reads 0
+	logDirection     bool    // log when the current reverses
+}
+
+// minSensorInterval is the shortest per-sensor interval of the config file.
+const minSensorInterval = 100 * time.Millisecond
+
+// pollSchedule is how often a sensor is polled: every interval, each poll
+// delayed by a random 0 to jitter so sensors polled at the same interval do not
+// all hit the bus at once.
+type pollSchedule struct {
+	interval time.Duration
+	jitter   time.Duration
+}
+
+// directionName names a non-zero current direction for log messages.
+func directionName(direction int) string {
+	if direction < 0 {
+		return "reverse"
+	}
+	return "forward"
+}
+
+// shuntSensor is an INA219 or INA226, read in place of the INA260 with the
+// register access of the monitor's ina260.Sensor.
+type shuntSensor interface {
+	Configure() error // (re)writes the Calibration register
+	Read() (ina260.Reading, error)
+}
+
+// monitor is one polled INA260 with the state the loop keeps between its readings.
+type monitor struct {
+	sensor           *ina260.Sensor
+	logger           *slog.Logger     // carries the device, mux and channel fields
+	bus              string           // bus from the config file, "" for the --bus one
+	muxSpec          string           // address of the mux the sensor sits behind, "" when connected directly
+	channel          int              // channel of that mux, -1 when connected directly
+	shunt            shuntSensor      // nil for an INA260
+	configured       bool             // false until the shunt sensor's calibration is written, and again after a failed reading
+	export           *exporter.Sensor // labels and metric series of the sensor
+	gate             *muxGate         // nil when the sensor's channel stays selected
+	schedule         pollSchedule
+	health           *sensorHealth
+	status           *sensorStatus
+	configChange     ina260.ConfigChange // Configuration register fields set at startup
+	alert            *ina260.Alert       // ALERT pin set up at startup, nil to keep the registers
+	coincidentConfig uint16              // Configuration register kept by --coincident conversions
+	trace            *otlp.Trace         // of the read in progress with --otlp.traces, under busMu
+
+	setUpMu          sync.Mutex
+	setUp            bool          // identify succeeded; until then the sensor is down and identify is retried
+	setUpRetry       time.Duration // how often identify is retried, from --setup-retry-interval
+	lastSetUpAttempt time.Time
+
+	voltageSaturated bool
+	direction        int // last non-zero current direction, 0 before the first one
+	stale            bool
+	slowCycleWarned  bool
+	lastSuccess      time.Time
+	lastReading      time.Time
+}
+
+// errSetUpPending is returned by read for a sensor that failed to set up, until
+// the next attempt is due.
+var errSetUpPending = errors.New("sensor not set up yet")
+
+// setUpSensor identifies and configures the sensor, and records whether that
+// succeeded; a sensor that failed is polled in degraded mode, reported down,
+// while the others keep polling.
+func (m *monitor) setUpSensor(coincident bool) error {
+	m.setUpMu.Lock()
+	defer m.setUpMu.Unlock()
+	m.lastSetUpAttempt = time.Now()
+	err := m.identify(coincident)
+	m.setUp = err == nil
+	return err
+}
+
+// ensureSetUp retries setting up a sensor that failed to, once every setUpRetry,
+// and returns errSetUpPending in between.
+func (m *monitor) ensureSetUp(coincident bool) error {
+	m.setUpMu.Lock()
+	if m.setUp {
+		m.setUpMu.Unlock()
+		return nil
+	}
+	due := time.Since(m.lastSetUpAttempt) >= m.setUpRetry
+	m.setUpMu.Unlock()
+	if !due {
+		return errSetUpPending
+	}
+	if err := m.setUpSensor(coincident); err != nil {
+		return fmt.Errorf("failed to set up sensor, retrying in %s: %w", m.setUpRetry, err)
+	}
+	m.logger.Info("Set up sensor after an earlier failure")
+	return nil
+}
+
+// identify checks the chip identity, applies the configuration change, publishes
+// the Alert Limit register and, for coincident sampling, reads the configuration
+// triggered conversions keep. An INA219 or INA226 has its Calibration register
+// written instead.
+func (m *monitor) identify(coincident bool) error {
+	busMu.Lock()
+	defer busMu.Unlock()
+	if err := m.gate.open(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.gate.close(); err != nil {
+			m.logger.Warn("Failed to close mux channel", "err", err)
+		}
+	}()
+	if m.shunt != nil {
+		return m.identifyShunt()
+	}
+
+	// Read Manufacturer ID and Device ID to verify communication with INA260,
+	// ignoring the die revision in the low bits of the Device ID
+	manufID, err := m.sensor.ReadReg(ina260.RegManufID)
+	if err != nil {
+		return fmt.Errorf("failed to read INA260 Manufacturer ID: %w", err)
+	}
+	deviceID, err := m.sensor.ReadReg(ina260.RegDeviceID)
+	if err != nil {
+		return fmt.Errorf("failed to read INA260 Device ID: %w", err)
+	}
+	m.logger.Info("Identified INA260", "manufacturer_id", fmt.Sprintf("0x%X", manufID), "device_id", fmt.Sprintf("0x%X", deviceID))
+	if manufID != ina260.ManufacturerID || deviceID&^ina260.RevisionMask != ina260.DeviceID {
+		m.logger.Warn("Unexpected INA260 Manufacturer ID or Device ID", "expected", fmt.Sprintf("0x%X/0x%X", ina260.ManufacturerID, ina260.DeviceID), "got", fmt.Sprintf("0x%X/0x%X", manufID, deviceID))
+	}
+
+	if !m.configChange.IsZero() {
+		config, err := m.sensor.Configure(m.configChange)
+		if err != nil {
+			return fmt.Errorf("failed to configure INA260: %w", err)
+		}
+		m.logger.Info("Configured INA260", "config", fmt.Sprintf("0x%04X", config), "conversion", ina260.ConversionDuration(config))
+	}
+
+	if m.alert != nil {
+		if err := m.sensor.ConfigureAlert(*m.alert); err != nil {
+			return fmt.Errorf("failed to set up INA260 alert: %w", err)
+		}
+		m.logger.Info("Set up INA260 alert", "function", m.alert.Function, "limit", m.alert.Limit, "active_high", m.alert.ActiveHigh, "latch", m.alert.Latch)
+	}
+
+	// Read back the Alert Limit Register so the threshold in effect is visible in metrics
+	alertLimit, err := m.sensor.ReadReg(ina260.RegAlertLimit)
+	if err != nil {
+		m.logger.Warn("Failed to read INA260 Alert Limit register", "err", err)
+	} else {
+		m.logger.Info("Read INA260 Alert Limit", "alert_limit", fmt.Sprintf("0x%04X", alertLimit))
+		m.export.Metrics.AlertLimit().Set(float64(alertLimit))
+	}
+
+	// Triggered conversions keep the averaging and conversion times currently configured
+	if coincident {
+		if m.coincidentConfig, err = m.sensor.ReadReg(ina260.RegConfig); err != nil {
+			return fmt.Errorf("failed to read INA260 Configuration register: %w", err)
+		}
+		m.logger.Info("Coincident sampling", "conversion", ina260.ConversionDuration(m.coincidentConfig))
+	}
+	return nil
+}
+
+// identifyShunt checks the identity of an INA226 (the INA219 has no ID registers)
+// and writes the calibration of either chip.
+func (m *monitor) identifyShunt() error {
+	if s, ok := m.shunt.(*ina226.Sensor); ok {
+		manufID, dieID, err := s.Identify()
+		if err != nil {
+			return err
+		}
+		m.logger.Info("Identified INA226", "manufacturer_id", fmt.Sprintf("0x%X", manufID), "die_id", fmt.Sprintf("0x%X", dieID))
+		if manufID != ina226.ManufacturerID || dieID&^ina260.RevisionMask != ina226.DieID {
+			m.logger.Warn("Unexpected INA226 Manufacturer ID or Die ID", "expected", fmt.Sprintf("0x%X/0x%X", ina226.ManufacturerID, ina226.DieID), "got", fmt.Sprintf("0x%X/0x%X", manufID, dieID))
+		}
+	}
+	if err := m.shunt.Configure(); err != nil {
+		return err
+	}
+	m.configured = true
+	scale := m.export.Scale
+	m.logger.Info("Calibrated", "current_lsb_ma", scale.CurrentLSB, "power_lsb_mw", scale.PowerLSB)
+	return nil
+}
+
+// probe checks that the sensor still ACKs its address, between readings.
+func (m *monitor) probe() {
+	busMu.Lock()
+	err := m.gate.open()
+	if err == nil {
+		err = ina260.Probe(m.sensor.Dev)
+	}
+	if cerr := m.gate.close(); cerr != nil {
+		m.logger.Warn("Failed to close mux channel", "err", cerr)
+	}
+	busMu.Unlock()
+	m.health.record(err == nil)
+}
+
+// run polls the sensor until ctx is cancelled.
+func (m *monitor) run(ctx context.Context, opts pollOptions, sinks []exporter.Sink, errorBackoff time.Duration) {
+	pollEvery(ctx, m.schedule, errorBackoff, func() error { return m.poll(opts, sinks) })
+}
+
+// pollEvery calls poll until ctx is cancelled: every schedule interval, or every
+// errorBackoff while it fails, each time after a random delay of up to the
+// schedule jitter. A poll that takes longer than the interval delays the next one
+// rather than queueing up ticks.
+func pollEvery(ctx context.Context, schedule pollSchedule, errorBackoff time.Duration, poll func() error) {
+	interval := schedule.interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if schedule.jitter > 0 {
+			if sleepContext(ctx, rand.N(schedule.jitter)); ctx.Err() != nil {
+				return
+			}
+		}
+		next := schedule.interval
+		if err := poll(); err != nil {
+			next = errorBackoff // Wait before retrying
+		}
+		if next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read takes one timestamped reading with the bus locked and the sensor's mux
+// channel selected. It also returns the time the register reads took (cycle) and
+// how long the bus was held, mux writes included. It does not account for the
+// result in health or metrics; poll does, and an API read leaves it out.
+func (m *monitor) read(opts pollOptions) (reading ina260.Reading, cycle, held time.Duration, err error) {
+	if err = m.ensureSetUp(opts.coincident); err != nil {
+		return reading, 0, 0, err
+	}
+	s := m.sensor
+	busMu.Lock()
+	defer busMu.Unlock()
+	m.trace = m.startTrace()
+	defer func() {
+		otlpTracer.RecordTrace(m.trace.End(err))
+		m.trace = nil
+	}()
+	busStart := time.Now()
+	err = m.gate.open()
+	if m.gate != nil {
+		m.trace.Span("mux.select", busStart, err)
+	}
+	cycleStart := time.Now()
+	if err == nil && m.shunt != nil && !m.configured {
+		// The calibration is lost if the sensor lost power, so it is rewritten after every failure
+		if err = m.shunt.Configure(); err == nil {
+			m.configured = true
+		}
+	}
+	if err == nil {
+		switch {
+		case m.shunt != nil:
+			reading, err = m.shunt.Read()
+		case opts.coincident:
+			reading, err = s.ReadCoincident(m.coincidentConfig)
+		default:
+			reading, err = s.Read()
+		}
+	}
+	if err != nil {
+		m.configured = false
+	}
+	cycleEnd := time.Now()
+	if cerr := m.gate.close(); cerr != nil {
+		m.logger.Warn("Failed to close mux channel", "err", cerr)
+	}
+	reading.Time = readingTimestamp(opts.timestampSource, cycleStart, cycleEnd)
+	return reading, cycleEnd.Sub(cycleStart), time.Since(busStart), err
+}
+
+// poll takes one reading and publishes it to sinks. It returns the read error,
+// after accounting for it in the sensor's health and metrics.
+func (m *monitor) poll(opts pollOptions, sinks []exporter.Sink) error {
+	defer func() { lastPollDone.Store(time.Now().UnixNano()) }()
+	metrics := m.export.Metrics
+	reading, cycleTime, busTime, err := m.read(opts)
+	metrics.ObserveBusTime(busTime)
+	if !m.slowCycleWarned && cycleTime > m.schedule.interval {
+		m.logger.Warn("Reading took longer than the poll interval; the bus cannot keep up with the requested rate", "took", cycleTime, "poll_interval", m.schedule.interval)
+		m.slowCycleWarned = true
+	}
+	if err != nil {
+		if !errors.Is(err, errSetUpPending) {
+			m.logger.Error("Failed to read sensor", "err", err)
+		}
+		m.health.record(false)
+		m.status.recordError(err)
+		// Drop the series once the sensor has been down for longer than the grace period
+		if opts.staleAfter > 0 && !m.stale && time.Since(m.lastSuccess) >= opts.staleAfter {
+			metrics.Delete()
+			m.stale = true
+			m.logger.Warn("Sensor down past the grace period, removed its metrics until it recovers", "stale_after", opts.staleAfter)
+		}
+		return err
+	}
+	m.lastSuccess = time.Now()
+	m.health.record(true)
+	m.status.recordReading(reading)
+	if m.stale {
+		m.stale = false
+		m.logger.Info("Sensor recovered, publishing metrics again")
+	}
+
+	// Warn once when the bus voltage register enters (or leaves) saturation
+	if opts.warnOnSaturation {
+		saturated := ina260.IsVoltageSaturated(reading.RawVoltage)
+		if saturated && !m.voltageSaturated {
+			m.logger.Warn("Bus voltage register saturated; reading may be clamped", "raw", fmt.Sprintf("0x%04X", reading.RawVoltage), "voltage", reading.Voltage)
+		} else if !saturated && m.voltageSaturated {
+			m.logger.Info("Bus voltage back within range", "voltage", reading.Voltage)
+		}
+		m.voltageSaturated = saturated
+		saturatedGauge := metrics.VoltageSaturated()
+		if saturated {
+			saturatedGauge.Set(1)
+		} else {
+			saturatedGauge.Set(0)
+		}
+	}
+
+	// A reading within the deadband keeps the last direction, so noise around zero
+	// does not log a reversal on every crossing
+	direction := ina260.Direction(reading.Current, opts.deadband)
+	metrics.SetCurrentDirection(direction)
+	if direction != 0 {
+		if opts.logDirection && m.direction != 0 && direction != m.direction {
+			m.logger.Info("Current reversed", "direction", directionName(direction), "current", reading.Current)
+		}
+		m.direction = direction
+	}
+
+	if opts.debugTiming && !m.lastReading.IsZero() {
+		// Both times come from time.Now, so Sub uses the monotonic clock and ignores wall clock steps
+		m.logger.Debug("Sample delta", "delta", reading.Time.Sub(m.lastReading))
+	}
+	m.lastReading = reading.Time
+	publishMu.Lock()
+	exporter.PublishAll(sinks, m.export, reading)
+	publishMu.Unlock()
+	if opts.coincident {
+		metrics.SetCoincident(reading.Current, reading.Voltage)
+	}
+	return nil
+}
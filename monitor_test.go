@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/simulate"
+)
+
+// BenchmarkMonitorPoll measures one poll of an INA260 on a simulated bus,
+// publishing to the Prometheus gauges, so the overhead of the loop around the
+// register reads can be told apart from the bus time.
+func BenchmarkMonitorPoll(b *testing.B) {
+	bus, err := simulate.NewBus(simulate.Options{Waveform: simulate.WaveformConstant, Voltage: 5, Current: 0.5})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, sensors := range []int{1, 8} {
+		b.Run(fmt.Sprintf("sensors=%d", sensors), func(b *testing.B) {
+			monitors := make([]*monitor, sensors)
+			for i := range monitors {
+				export := exporter.NewSensor("bench", fmt.Sprintf("bench_poll_%d_%d", sensors, i), ina260.DefaultScale)
+				monitors[i] = &monitor{
+					sensor:      &ina260.Sensor{Dev: &i2c.Dev{Bus: bus, Addr: ina260.Address}},
+					logger:      slog.Default(),
+					channel:     -1,
+					export:      export,
+					schedule:    pollSchedule{interval: time.Second},
+					health:      &sensorHealth{up: true, downAfter: 1, upAfter: 1, gauge: export.Metrics.Up(), logger: slog.Default()},
+					status:      &sensorStatus{},
+					setUp:       true,
+					lastSuccess: time.Now(),
+				}
+			}
+			sinks := []exporter.Sink{exporter.NewPrometheusSink(exporter.PrometheusOptions{})}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				for _, m := range monitors {
+					if err := m.poll(pollOptions{}, sinks); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*sensors), "ns/reading")
+		})
+	}
+}
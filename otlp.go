@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/otlp"
+)
+
+// otlpTracer receives a trace of every sensor read with --otlp.traces, and is
+// nil otherwise.
+var otlpTracer *otlp.Exporter
+
+// startTrace starts the trace of a read of m, or returns nil without
+// --otlp.traces.
+func (m *monitor) startTrace() *otlp.Trace {
+	if otlpTracer == nil {
+		return nil
+	}
+	attributes := []string{"device", m.export.Device}
+	if m.channel >= 0 {
+		attributes = append(attributes, "mux", m.muxSpec, "channel", strconv.Itoa(m.channel))
+	}
+	return otlp.NewTrace("sensor.read", attributes...)
+}
+
+// traceRegisterRead adds a register read to the trace of the read in progress.
+func (m *monitor) traceRegisterRead(reg byte, start time.Time, err error) {
+	m.trace.Span("register.read", start, err, "register", fmt.Sprintf("0x%02X", reg))
+}
+
+// readOTLPHeaders reads an --otlp.headers-file: one "Name: value" header per
+// line, with blank lines and lines starting with # ignored.
+func readOTLPHeaders(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	headers := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("line %d: want Name: value", n)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers, scanner.Err()
+}
@@ -0,0 +1,182 @@
+// Package ads1115 reads the single-ended inputs of the Texas Instruments ADS1115
+// 16-bit and ADS1015 12-bit analog-to-digital converters. Each reading is one
+// single-shot conversion, so the converter powers down in between.
+package ads1115
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// I2C addresses: 0x48 with ADDR tied to GND, up to 0x4B.
+const (
+	Address     = uint16(0x48)
+	LastAddress = uint16(0x4B)
+)
+
+// Register Addresses
+const (
+	RegConversion byte = 0x00 // Conversion Register
+	RegConfig     byte = 0x01 // Config Register
+)
+
+// Inputs is the number of single-ended inputs, AIN0 to AIN3.
+const Inputs = 4
+
+// Config register fields
+const (
+	configOS          = 0x8000 // write: start a conversion; read: 0 while converting
+	configMuxSingle   = 0x4    // MUX code of AIN0 against GND; AIN1-AIN3 follow
+	configMuxShift    = 12
+	configPGAShift    = 9
+	configModeSingle  = 0x0100
+	configDRShift     = 5
+	configCompQueNone = 0x0003 // comparator off, ALERT/RDY high-impedance
+)
+
+// Model is one of the supported converters.
+type Model struct {
+	Name            string // lowercase part number, as in the config file
+	Bits            int    // resolution of a conversion
+	DataRates       [8]int // samples per second, indexed by the DR field code
+	DefaultDataRate int    // the power-up data rate
+}
+
+// Supported models
+var (
+	ADS1115 = Model{Name: "ads1115", Bits: 16, DataRates: [8]int{8, 16, 32, 64, 128, 250, 475, 860}, DefaultDataRate: 128}
+	ADS1015 = Model{Name: "ads1015", Bits: 12, DataRates: [8]int{128, 250, 490, 920, 1600, 2400, 3300, 3300}, DefaultDataRate: 1600}
+)
+
+// Models lists the supported models.
+var Models = []Model{ADS1115, ADS1015}
+
+// ModelByName returns the model with the given lowercase part number.
+func ModelByName(name string) (Model, error) {
+	var names []string
+	for _, m := range Models {
+		if m.Name == name {
+			return m, nil
+		}
+		names = append(names, m.Name)
+	}
+	return Model{}, fmt.Errorf("unknown ADC %q: must be one of %s", name, strings.Join(names, ", "))
+}
+
+// String returns the part number as printed on the chip, e.g. "ADS1115".
+func (m Model) String() string { return strings.ToUpper(m.Name) }
+
+// DataRateCode returns the DR field code of a data rate in samples per second,
+// or an error if the model does not support it.
+func (m Model) DataRateCode(sps int) (uint16, error) {
+	for code, v := range m.DataRates {
+		if v == sps {
+			return uint16(code), nil
+		}
+	}
+	// The ADS1015 has 3300 SPS twice
+	return 0, fmt.Errorf("unsupported data rate %d SPS for the %s: must be one of %v", sps, m, slices.Compact(slices.Clone(m.DataRates[:])))
+}
+
+// FullScales are the full-scale ranges of the gain amplifier in Volts,
+// indexed by the PGA field code. The inputs never exceed VDD + 0.3 V, whatever
+// the range.
+var FullScales = [6]float64{6.144, 4.096, 2.048, 1.024, 0.512, 0.256}
+
+// DefaultFullScale is the power-up range.
+const DefaultFullScale = 2.048
+
+// FullScaleCode returns the PGA field code of a full-scale range in Volts, or an
+// error if it is not one of FullScales.
+func FullScaleCode(volts float64) (uint16, error) {
+	for code, v := range FullScales {
+		if v == volts {
+			return uint16(code), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported full-scale range %g V: must be one of %v", volts, FullScales)
+}
+
+// Sensor is one ADS1115 or ADS1015. Call Init before Read.
+type Sensor struct {
+	Dev       *i2c.Dev
+	Model     Model
+	FullScale float64 // Volts, one of FullScales; 0 for DefaultFullScale
+	DataRate  int     // samples per second, one of Model.DataRates; 0 for Model.DefaultDataRate
+
+	config     uint16        // Config register value without OS and MUX
+	conversion time.Duration // one conversion at DataRate
+}
+
+// Init checks the settings and that the converter answers. The ADS1115 has no
+// ID register, so any device that ACKs a Config register read passes.
+func (s *Sensor) Init() error {
+	fullScale := s.FullScale
+	if fullScale == 0 {
+		fullScale = DefaultFullScale
+	}
+	pga, err := FullScaleCode(fullScale)
+	if err != nil {
+		return err
+	}
+	rate := s.DataRate
+	if rate == 0 {
+		rate = s.Model.DefaultDataRate
+	}
+	dr, err := s.Model.DataRateCode(rate)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if err := s.Dev.Tx([]byte{RegConfig}, buf); err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	s.FullScale, s.DataRate = fullScale, rate
+	s.config = pga<<configPGAShift | configModeSingle | dr<<configDRShift | configCompQueNone
+	s.conversion = time.Second / time.Duration(rate)
+	return nil
+}
+
+// Read converts one single-ended input, 0 to 3, and returns its voltage against GND.
+func (s *Sensor) Read(input int) (float64, error) {
+	if input < 0 || input >= Inputs {
+		return 0, fmt.Errorf("invalid input %d: must be between 0 and %d", input, Inputs-1)
+	}
+	config := s.config | configOS | uint16(configMuxSingle+input)<<configMuxShift
+	if err := s.Dev.Tx([]byte{RegConfig, byte(config >> 8), byte(config)}, nil); err != nil {
+		return 0, fmt.Errorf("failed to start conversion: %w", err)
+	}
+	start := time.Now()
+	// The internal oscillator may run up to 10% slow
+	time.Sleep(s.conversion + s.conversion/10)
+	buf := make([]byte, 2)
+	deadline := time.Now().Add(s.conversion + 10*time.Millisecond)
+	for {
+		if err := s.Dev.Tx([]byte{RegConfig}, buf); err != nil {
+			return 0, fmt.Errorf("failed to read config: %w", err)
+		}
+		if binary.BigEndian.Uint16(buf)&configOS != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("conversion of AIN%d still busy after %s", input, time.Since(start).Round(time.Millisecond))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Dev.Tx([]byte{RegConversion}, buf); err != nil {
+		return 0, fmt.Errorf("failed to read conversion: %w", err)
+	}
+	return s.Volts(binary.BigEndian.Uint16(buf)), nil
+}
+
+// Volts converts a Conversion register value at the full-scale range of s. The
+// ADS1015 result is 12 bits, left-justified.
+func (s *Sensor) Volts(raw uint16) float64 {
+	code := int16(raw) >> (16 - s.Model.Bits)
+	return float64(code) * s.FullScale / float64(int(1)<<(s.Model.Bits-1))
+}
@@ -0,0 +1,152 @@
+package ads1115
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/physic"
+)
+
+// fakeBus is a converter whose conversions complete at once: the Config
+// register reads back with OS set, and the Conversion register holds raw.
+type fakeBus struct {
+	config uint16 // the last Config register write
+	raw    uint16
+}
+
+func (b *fakeBus) String() string                  { return "fake" }
+func (b *fakeBus) SetSpeed(physic.Frequency) error { return nil }
+func (b *fakeBus) Tx(_ uint16, w, r []byte) error {
+	if len(w) == 3 {
+		b.config = binary.BigEndian.Uint16(w[1:])
+		return nil
+	}
+	switch w[0] {
+	case RegConfig:
+		binary.BigEndian.PutUint16(r, b.config|configOS)
+	case RegConversion:
+		binary.BigEndian.PutUint16(r, b.raw)
+	}
+	return nil
+}
+
+func TestFullScaleCode(t *testing.T) {
+	tests := []struct {
+		volts float64
+		code  uint16
+	}{
+		{6.144, 0},
+		{4.096, 1},
+		{2.048, 2},
+		{1.024, 3},
+		{0.512, 4},
+		{0.256, 5},
+	}
+	for _, tt := range tests {
+		if got, err := FullScaleCode(tt.volts); err != nil || got != tt.code {
+			t.Errorf("FullScaleCode(%g) = %d, %v; want %d", tt.volts, got, err, tt.code)
+		}
+	}
+	for _, volts := range []float64{0, 3.3, 0.128} {
+		if _, err := FullScaleCode(volts); err == nil {
+			t.Errorf("FullScaleCode(%g) succeeded, want an error", volts)
+		}
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	tests := []struct {
+		fullScale float64
+		pga       uint16
+	}{
+		{0, 2}, // DefaultFullScale
+		{6.144, 0},
+		{4.096, 1},
+		{2.048, 2},
+		{1.024, 3},
+		{0.512, 4},
+		{0.256, 5},
+	}
+	for _, m := range Models {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%g", m, tt.fullScale), func(t *testing.T) {
+				s := &Sensor{Dev: &i2c.Dev{Bus: &fakeBus{}, Addr: Address}, Model: m, FullScale: tt.fullScale}
+				if err := s.Init(); err != nil {
+					t.Fatal(err)
+				}
+				if got := s.config >> configPGAShift & 0x7; got != tt.pga {
+					t.Errorf("PGA field = %d, want %d", got, tt.pga)
+				}
+				// Both models power up at their DR code 4
+				if got := s.config >> configDRShift & 0x7; got != 4 || s.DataRate != m.DefaultDataRate {
+					t.Errorf("DR field = %d at %d SPS, want 4 at %d SPS", got, s.DataRate, m.DefaultDataRate)
+				}
+				if s.FullScale != FullScales[tt.pga] {
+					t.Errorf("FullScale = %g, want %g", s.FullScale, FullScales[tt.pga])
+				}
+			})
+		}
+	}
+}
+
+func TestVolts(t *testing.T) {
+	for _, fullScale := range FullScales {
+		tests := []struct {
+			model Model
+			raw   uint16
+			want  float64
+		}{
+			{ADS1115, 0x0000, 0},
+			{ADS1115, 0x0001, fullScale / 32768},
+			{ADS1115, 0x4000, fullScale / 2},
+			{ADS1115, 0x7FFF, fullScale * 32767 / 32768},
+			{ADS1115, 0x8000, -fullScale},
+			{ADS1115, 0xFFFF, -fullScale / 32768},
+			// The ADS1015 result is shifted out of the upper 12 bits, so the low nibble is ignored
+			{ADS1015, 0x000F, 0},
+			{ADS1015, 0x0010, fullScale / 2048},
+			{ADS1015, 0x4000, fullScale / 2},
+			{ADS1015, 0x7FF0, fullScale * 2047 / 2048},
+			{ADS1015, 0x8000, -fullScale},
+			{ADS1015, 0xFFF0, -fullScale / 2048},
+		}
+		for _, tt := range tests {
+			s := &Sensor{Model: tt.model, FullScale: fullScale}
+			if got := s.Volts(tt.raw); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("%s at %g V: Volts(0x%04X) = %g, want %g", tt.model, fullScale, tt.raw, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestReadStartsConversion(t *testing.T) {
+	tests := []struct {
+		model    Model
+		dataRate int
+		config   uint16 // written for AIN2 at 4.096 V
+	}{
+		{ADS1115, 860, 0xE3E3},
+		{ADS1015, 3300, 0xE3C3},
+	}
+	for _, tt := range tests {
+		bus := &fakeBus{raw: 0x4000}
+		s := &Sensor{Dev: &i2c.Dev{Bus: bus, Addr: Address}, Model: tt.model, FullScale: 4.096, DataRate: tt.dataRate}
+		if err := s.Init(); err != nil {
+			t.Fatal(err)
+		}
+		v, err := s.Read(2)
+		if err != nil {
+			t.Fatalf("%s: Read: %v", tt.model, err)
+		}
+		if bus.config != tt.config || v != 2.048 {
+			t.Errorf("%s: Read wrote config 0x%04X and returned %g V, want 0x%04X and 2.048 V", tt.model, bus.config, v, tt.config)
+		}
+	}
+	s := &Sensor{Model: ADS1115}
+	if _, err := s.Read(Inputs); err == nil {
+		t.Errorf("Read(%d) succeeded, want an error", Inputs)
+	}
+}
@@ -0,0 +1,240 @@
+// Package bme280 reads the Bosch BME280 temperature, humidity and pressure sensor
+// and the BMP280, which is the same chip without the humidity sensor. Each reading
+// is taken in forced mode, so the sensor sleeps in between and does not heat itself.
+package bme280
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// I2C addresses, selected by the SDO pin.
+const (
+	Address          = uint16(0x76) // SDO tied to GND
+	AlternateAddress = uint16(0x77) // SDO tied to VDDIO
+)
+
+// Register Addresses
+const (
+	RegCalib00   byte = 0x88 // first of the temperature and pressure calibration registers (0x88-0xA1)
+	RegChipID    byte = 0xD0 // Chip ID Register
+	RegReset     byte = 0xE0 // Soft reset Register
+	RegCalib26   byte = 0xE1 // first of the humidity calibration registers (0xE1-0xE7)
+	RegCtrlHum   byte = 0xF2 // Humidity oversampling
+	RegStatus    byte = 0xF3 // Measuring and NVM copy flags
+	RegCtrlMeas  byte = 0xF4 // Temperature and pressure oversampling, and mode
+	RegConfig    byte = 0xF5 // Standby time and IIR filter
+	RegPressMSB  byte = 0xF7 // first of the data registers (0xF7-0xFE)
+	resetCommand      = 0xB6
+)
+
+// Chip IDs
+const (
+	ChipIDBME280 byte = 0x60
+	ChipIDBMP280 byte = 0x58
+)
+
+// Register values: 1x oversampling of every measurement, no IIR filter.
+const (
+	ctrlHumX1       = 0x01
+	ctrlMeasSleep   = 0x24 // osrs_t = osrs_p = 1x, sleep mode
+	ctrlMeasForced  = 0x25 // osrs_t = osrs_p = 1x, forced mode
+	statusMeasuring = 0x08
+	statusIMUpdate  = 0x01
+)
+
+// measurementTimeout bounds a forced measurement; at 1x oversampling the
+// datasheet gives at most 9.3 ms.
+const measurementTimeout = 50 * time.Millisecond
+
+// calibration holds the trimming parameters of one chip, named as in the datasheet.
+type calibration struct {
+	t1                     uint16
+	t2, t3                 int16
+	p1                     uint16
+	p2, p3, p4, p5, p6, p7 int16
+	p8, p9                 int16
+	h1, h3                 uint8
+	h2, h4, h5             int16
+	h6                     int8
+}
+
+// Reading is one compensated measurement.
+type Reading struct {
+	Time        time.Time // when the reading was taken; set by the caller
+	Temperature float64   // degrees Celsius
+	Pressure    float64   // Pascals
+	Humidity    float64   // percent relative humidity; 0 on a BMP280
+	HasHumidity bool      // false on a BMP280
+}
+
+// Sensor is one BME280 or BMP280. Call Init before Read.
+type Sensor struct {
+	Dev *i2c.Dev
+
+	chipID byte
+	calib  calibration
+}
+
+// Model returns "BME280" or "BMP280", once Init has identified the chip.
+func (s *Sensor) Model() string {
+	if s.chipID == ChipIDBMP280 {
+		return "BMP280"
+	}
+	return "BME280"
+}
+
+// Init identifies the chip, resets it, reads its calibration and sets the oversampling.
+func (s *Sensor) Init() error {
+	id := make([]byte, 1)
+	if err := s.Dev.Tx([]byte{RegChipID}, id); err != nil {
+		return fmt.Errorf("failed to read chip ID: %w", err)
+	}
+	if id[0] != ChipIDBME280 && id[0] != ChipIDBMP280 {
+		return fmt.Errorf("unexpected chip ID 0x%02X: expected 0x%02X (BME280) or 0x%02X (BMP280)", id[0], ChipIDBME280, ChipIDBMP280)
+	}
+	s.chipID = id[0]
+
+	if err := s.Dev.Tx([]byte{RegReset, resetCommand}, nil); err != nil {
+		return fmt.Errorf("failed to reset: %w", err)
+	}
+	// The calibration is copied from NVM after a reset, which takes about 2 ms
+	if err := s.waitStatus(statusIMUpdate); err != nil {
+		return err
+	}
+	if err := s.readCalibration(); err != nil {
+		return err
+	}
+	// ctrl_hum only takes effect after a write to ctrl_meas
+	if s.chipID == ChipIDBME280 {
+		if err := s.Dev.Tx([]byte{RegCtrlHum, ctrlHumX1}, nil); err != nil {
+			return fmt.Errorf("failed to write ctrl_hum: %w", err)
+		}
+	}
+	if err := s.Dev.Tx([]byte{RegConfig, 0x00, RegCtrlMeas, ctrlMeasSleep}, nil); err != nil {
+		return fmt.Errorf("failed to write config and ctrl_meas: %w", err)
+	}
+	return nil
+}
+
+func (s *Sensor) readCalibration() error {
+	buf := make([]byte, 26)
+	if err := s.Dev.Tx([]byte{RegCalib00}, buf); err != nil {
+		return fmt.Errorf("failed to read calibration: %w", err)
+	}
+	le := binary.LittleEndian
+	c := &s.calib
+	c.t1 = le.Uint16(buf[0:])
+	c.t2 = int16(le.Uint16(buf[2:]))
+	c.t3 = int16(le.Uint16(buf[4:]))
+	c.p1 = le.Uint16(buf[6:])
+	c.p2 = int16(le.Uint16(buf[8:]))
+	c.p3 = int16(le.Uint16(buf[10:]))
+	c.p4 = int16(le.Uint16(buf[12:]))
+	c.p5 = int16(le.Uint16(buf[14:]))
+	c.p6 = int16(le.Uint16(buf[16:]))
+	c.p7 = int16(le.Uint16(buf[18:]))
+	c.p8 = int16(le.Uint16(buf[20:]))
+	c.p9 = int16(le.Uint16(buf[22:]))
+	c.h1 = buf[25]
+	if s.chipID != ChipIDBME280 {
+		return nil
+	}
+	hum := make([]byte, 7)
+	if err := s.Dev.Tx([]byte{RegCalib26}, hum); err != nil {
+		return fmt.Errorf("failed to read humidity calibration: %w", err)
+	}
+	c.h2 = int16(le.Uint16(hum[0:]))
+	c.h3 = hum[2]
+	// H4 and H5 are 12-bit values sharing the nibbles of 0xE5
+	c.h4 = int16(int8(hum[3]))<<4 | int16(hum[4]&0x0F)
+	c.h5 = int16(int8(hum[5]))<<4 | int16(hum[4]>>4)
+	c.h6 = int8(hum[6])
+	return nil
+}
+
+// waitStatus waits for the given status bits to clear.
+func (s *Sensor) waitStatus(bits byte) error {
+	deadline := time.Now().Add(measurementTimeout)
+	status := make([]byte, 1)
+	for {
+		if err := s.Dev.Tx([]byte{RegStatus}, status); err != nil {
+			return fmt.Errorf("failed to read status: %w", err)
+		}
+		if status[0]&bits == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("status 0x%02X still busy after %s", status[0], measurementTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Read takes one forced measurement and compensates it. The returned reading
+// has no Time; the caller stamps it.
+func (s *Sensor) Read() (Reading, error) {
+	if err := s.Dev.Tx([]byte{RegCtrlMeas, ctrlMeasForced}, nil); err != nil {
+		return Reading{}, fmt.Errorf("failed to start measurement: %w", err)
+	}
+	time.Sleep(2 * time.Millisecond) // the measuring flag is only set once the conversion has started
+	if err := s.waitStatus(statusMeasuring); err != nil {
+		return Reading{}, err
+	}
+	data := make([]byte, 8)
+	if err := s.Dev.Tx([]byte{RegPressMSB}, data); err != nil {
+		return Reading{}, fmt.Errorf("failed to read measurement: %w", err)
+	}
+	rawPress := int32(data[0])<<12 | int32(data[1])<<4 | int32(data[2])>>4
+	rawTemp := int32(data[3])<<12 | int32(data[4])<<4 | int32(data[5])>>4
+	rawHum := int32(data[6])<<8 | int32(data[7])
+	if rawTemp == 0x80000 || rawPress == 0x80000 {
+		return Reading{}, fmt.Errorf("measurement skipped (raw 0x%05X/0x%05X); was the chip reset?", rawTemp, rawPress)
+	}
+
+	c := &s.calib
+	tFine, temperature := c.temperature(rawTemp)
+	r := Reading{Temperature: temperature, Pressure: c.pressure(tFine, rawPress)}
+	if s.chipID == ChipIDBME280 {
+		r.Humidity, r.HasHumidity = c.humidity(tFine, rawHum), true
+	}
+	return r, nil
+}
+
+// The compensation formulas are the floating-point ones of the BME280 datasheet, section 8.1.
+
+func (c *calibration) temperature(raw int32) (tFine, celsius float64) {
+	adc := float64(raw)
+	var1 := (adc/16384.0 - float64(c.t1)/1024.0) * float64(c.t2)
+	var2 := (adc/131072.0 - float64(c.t1)/8192.0) * (adc/131072.0 - float64(c.t1)/8192.0) * float64(c.t3)
+	tFine = var1 + var2
+	return tFine, tFine / 5120.0
+}
+
+func (c *calibration) pressure(tFine float64, raw int32) float64 {
+	var1 := tFine/2.0 - 64000.0
+	var2 := var1 * var1 * float64(c.p6) / 32768.0
+	var2 = var2 + var1*float64(c.p5)*2.0
+	var2 = var2/4.0 + float64(c.p4)*65536.0
+	var1 = (float64(c.p3)*var1*var1/524288.0 + float64(c.p2)*var1) / 524288.0
+	var1 = (1.0 + var1/32768.0) * float64(c.p1)
+	if var1 == 0 {
+		return 0 // avoid a division by zero on an uncalibrated chip
+	}
+	p := 1048576.0 - float64(raw)
+	p = (p - var2/4096.0) * 6250.0 / var1
+	var1 = float64(c.p9) * p * p / 2147483648.0
+	var2 = p * float64(c.p8) / 32768.0
+	return p + (var1+var2+float64(c.p7))/16.0
+}
+
+func (c *calibration) humidity(tFine float64, raw int32) float64 {
+	h := tFine - 76800.0
+	h = (float64(raw) - (float64(c.h4)*64.0 + float64(c.h5)/16384.0*h)) *
+		(float64(c.h2) / 65536.0 * (1.0 + float64(c.h6)/67108864.0*h*(1.0+float64(c.h3)/67108864.0*h)))
+	h = h * (1.0 - float64(c.h1)*h/524288.0)
+	return min(max(h, 0), 100)
+}
@@ -0,0 +1,143 @@
+package bme280
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/physic"
+)
+
+// fakeBus is a BME280 on a bus: a register file that reads from consecutive
+// registers and takes writes as register and value pairs.
+type fakeBus struct {
+	regs [256]byte
+}
+
+func (b *fakeBus) String() string                  { return "fake" }
+func (b *fakeBus) SetSpeed(physic.Frequency) error { return nil }
+func (b *fakeBus) Tx(_ uint16, w, r []byte) error {
+	if len(r) > 0 {
+		copy(r, b.regs[w[0]:])
+		return nil
+	}
+	for i := 0; i+1 < len(w); i += 2 {
+		if w[i] != RegReset {
+			b.regs[w[i]] = w[i+1]
+		}
+	}
+	return nil
+}
+
+// datasheetCalibration is the example calibration of the compensation
+// formulas in the Bosch datasheet, section 3.12 of the BMP280 one.
+var datasheetCalibration = calibration{
+	t1: 27504, t2: 26435, t3: -1000,
+	p1: 36477, p2: -10685, p3: 3024, p4: 2855, p5: 140, p6: -7, p7: 15500, p8: -14600, p9: 6000,
+}
+
+// The raw values of the datasheet example, and what they compensate to.
+const (
+	datasheetRawTemp     = 519888
+	datasheetRawPress    = 415148
+	datasheetTFine       = 128422.2869
+	datasheetTemperature = 25.08     // °C
+	datasheetPressure    = 100653.27 // Pa
+)
+
+// newBus returns a chip of id with the datasheet calibration and the raw
+// values of the datasheet example in its data registers.
+func newBus(id byte) *fakeBus {
+	b := &fakeBus{}
+	b.regs[RegChipID] = id
+	calib := b.regs[RegCalib00:]
+	le := binary.LittleEndian
+	c := datasheetCalibration
+	for i, v := range []uint16{c.t1, uint16(c.t2), uint16(c.t3), c.p1, uint16(c.p2), uint16(c.p3), uint16(c.p4), uint16(c.p5), uint16(c.p6), uint16(c.p7), uint16(c.p8), uint16(c.p9)} {
+		le.PutUint16(calib[2*i:], v)
+	}
+	// The 20-bit values are left-justified in their three registers
+	data := b.regs[RegPressMSB:]
+	binary.BigEndian.PutUint32(data[0:], datasheetRawPress<<12)
+	binary.BigEndian.PutUint32(data[3:], datasheetRawTemp<<12)
+	return b
+}
+
+func TestCompensationDatasheetExample(t *testing.T) {
+	c := datasheetCalibration
+	tFine, celsius := c.temperature(datasheetRawTemp)
+	if math.Abs(tFine-datasheetTFine) > 0.001 {
+		t.Errorf("t_fine = %f, want %f", tFine, datasheetTFine)
+	}
+	if math.Abs(celsius-datasheetTemperature) > 0.005 {
+		t.Errorf("temperature(%d) = %f °C, want %.2f", datasheetRawTemp, celsius, datasheetTemperature)
+	}
+	if p := c.pressure(tFine, datasheetRawPress); math.Abs(p-datasheetPressure) > 0.005 {
+		t.Errorf("pressure(%d) = %f Pa, want %.2f", datasheetRawPress, p, datasheetPressure)
+	}
+}
+
+func TestReadDatasheetExample(t *testing.T) {
+	for _, id := range []byte{ChipIDBME280, ChipIDBMP280} {
+		s := &Sensor{Dev: &i2c.Dev{Bus: newBus(id), Addr: Address}}
+		if err := s.Init(); err != nil {
+			t.Fatalf("chip 0x%02X: Init: %v", id, err)
+		}
+		if s.calib.t1 != datasheetCalibration.t1 || s.calib.p9 != datasheetCalibration.p9 {
+			t.Errorf("chip 0x%02X: calibration = %+v, want %+v", id, s.calib, datasheetCalibration)
+		}
+		r, err := s.Read()
+		if err != nil {
+			t.Fatalf("chip 0x%02X: Read: %v", id, err)
+		}
+		if math.Abs(r.Temperature-datasheetTemperature) > 0.005 || math.Abs(r.Pressure-datasheetPressure) > 0.005 {
+			t.Errorf("chip 0x%02X: Read = %.2f °C, %.2f Pa; want %.2f °C, %.2f Pa", id, r.Temperature, r.Pressure, datasheetTemperature, datasheetPressure)
+		}
+		if r.HasHumidity != (id == ChipIDBME280) {
+			t.Errorf("chip 0x%02X: HasHumidity = %t", id, r.HasHumidity)
+		}
+	}
+}
+
+func TestReadCalibrationHumidity(t *testing.T) {
+	tests := []struct {
+		name   string
+		hum    []byte // 0xE1-0xE7
+		h2     int16
+		h4, h5 int16
+		h6     int8
+	}{
+		{"typical", []byte{0x6A, 0x01, 0x00, 0x13, 0x29, 0x03, 0x1E}, 362, 313, 50, 30},
+		{"negative", []byte{0x00, 0xFF, 0x00, 0xFF, 0xEF, 0x80, 0xF6}, -256, -1, -2034, -10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBus(ChipIDBME280)
+			copy(b.regs[RegCalib26:], tt.hum)
+			s := &Sensor{Dev: &i2c.Dev{Bus: b, Addr: Address}, chipID: ChipIDBME280}
+			if err := s.readCalibration(); err != nil {
+				t.Fatal(err)
+			}
+			c := s.calib
+			if c.h2 != tt.h2 || c.h4 != tt.h4 || c.h5 != tt.h5 || c.h6 != tt.h6 {
+				t.Errorf("H2, H4, H5, H6 = %d, %d, %d, %d; want %d, %d, %d, %d", c.h2, c.h4, c.h5, c.h6, tt.h2, tt.h4, tt.h5, tt.h6)
+			}
+		})
+	}
+}
+
+func TestHumidityClamped(t *testing.T) {
+	c := calibration{h1: 75, h2: 362, h4: 313, h5: 50, h6: 30}
+	for _, tt := range []struct {
+		raw  int32
+		want float64
+	}{
+		{0, 0},
+		{0xFFFF, 100},
+	} {
+		if got := c.humidity(datasheetTFine, tt.raw); got != tt.want {
+			t.Errorf("humidity(0x%04X) = %f %%, want %g", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package bme280
+
+import (
+	"math"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/driver"
+)
+
+func init() {
+	driver.Register(driver.Driver{
+		Name:    "bme280",
+		Address: Address,
+		New:     func(dev *i2c.Dev) driver.Sensor { return &driverSensor{Sensor{Dev: dev}} },
+	})
+}
+
+// metrics are the values of driverSensor.Read.
+var metrics = []driver.Metric{
+	{Name: "bme280_temperature_celsius", Help: "Temperature measured by a BME280 or BMP280 sensor in degrees Celsius."},
+	{Name: "bme280_humidity_percent", Help: "Relative humidity measured by a BME280 sensor in percent; absent for a BMP280."},
+	{Name: "bme280_pressure_pascals", Help: "Air pressure measured by a BME280 or BMP280 sensor in Pascals."},
+}
+
+// driverSensor is a Sensor as a driver.Sensor, whose Read returns the values of
+// a Reading.
+type driverSensor struct {
+	Sensor
+}
+
+// Probe is Init, for driver.Sensor.
+func (s *driverSensor) Probe() error { return s.Init() }
+
+// Read takes one forced-mode measurement, with NaN humidity on a BMP280.
+func (s *driverSensor) Read() ([]float64, error) {
+	r, err := s.Sensor.Read()
+	if err != nil {
+		return nil, err
+	}
+	humidity := math.NaN()
+	if r.HasHumidity {
+		humidity = r.Humidity
+	}
+	return []float64{r.Temperature, humidity, r.Pressure}, nil
+}
+
+// Metrics describes the values of Read.
+func (s *driverSensor) Metrics() []driver.Metric { return metrics }
@@ -0,0 +1,101 @@
+// Package driver is a registry of I2C sensor drivers, so a chip the exporter has
+// no built-in support for can be polled without changing it. A driver package
+// registers itself from an init function:
+//
+//	func init() {
+//		driver.Register(driver.Driver{
+//			Name:    "sht31",
+//			Address: 0x44,
+//			New:     func(dev *i2c.Dev) driver.Sensor { return &Sensor{Dev: dev} },
+//		})
+//	}
+//
+// and a binary that imports it, even with a blank import, can then poll the chip
+// from a config file sensor with chip: sht31. The BME280, MCP9808 and TMP117
+// packages of this module register themselves the same way.
+package driver
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// Metric describes one value of a Sensor reading. It is exported as a gauge with
+// the hostname and device labels, like the other sensors.
+type Metric struct {
+	Name string // e.g. sht31_humidity_percent
+	Help string
+}
+
+// Sensor is one chip of a registered driver. Its methods are only called with
+// the mux channel of the chip selected and the bus held, never concurrently.
+type Sensor interface {
+	// Probe checks that the chip answers with its IDs and sets it up. It is
+	// called once before polling starts; a sensor that fails it is skipped.
+	Probe() error
+	// Read takes one reading: a value for each of Metrics, in the same order.
+	// NaN is a value the chip does not measure, such as the humidity of a
+	// BMP280; its series is left out.
+	Read() ([]float64, error)
+	// Metrics describes the values of Read. It must not change.
+	Metrics() []Metric
+}
+
+// Driver makes the Sensors of one chip.
+type Driver struct {
+	Name    string                    // chip name in the config file, e.g. sht31; lower case
+	Address uint16                    // I2C address used unless the config file sets one
+	New     func(dev *i2c.Dev) Sensor // dev is the chip on its bus, at its address
+	// PEC is set for a chip that supports SMBus packet error checking. A sensor
+	// with pec: true in the config file then gets a dev on an smbus.Bus, which
+	// adds the PEC to every transaction, so the Sensor is written the same way.
+	// None of the chips with a driver in this module support it.
+	PEC bool
+}
+
+var (
+	mu      sync.RWMutex
+	drivers = make(map[string]Driver)
+)
+
+// Register makes a driver available by its name. It panics if the name is empty
+// or already registered, or if New is nil, like database/sql.Register, since
+// either is a programming error found on the first run.
+func Register(d Driver) {
+	if d.Name == "" || d.Name != strings.ToLower(d.Name) {
+		panic(fmt.Sprintf("driver: invalid name %q: must be non-empty and lower case", d.Name))
+	}
+	if d.New == nil {
+		panic("driver: New is nil for " + d.Name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := drivers[d.Name]; dup {
+		panic("driver: Register called twice for " + d.Name)
+	}
+	drivers[d.Name] = d
+}
+
+// Lookup returns the driver registered under name.
+func Lookup(name string) (Driver, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d, ok := drivers[name]
+	return d, ok
+}
+
+// Names returns the names of the registered drivers, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus gauges of the ADS1115 and ADS1015 inputs, labeled like the INA260
+// ones plus the input name
+var (
+	adcInputVolts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "adc_input_volts",
+		Help: "Voltage measured at an ADS1115 or ADS1015 input against GND in Volts, before scaling.",
+	}, []string{"hostname", "device", "input"})
+	adcInputValue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "adc_input_value",
+		Help: "Reading of an ADS1115 or ADS1015 input after its scale and offset, e.g. the voltage before a divider.",
+	}, []string{"hostname", "device", "input"})
+	adcUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "adc_up",
+		Help: "1 if the last reading of every input of an ADS1115 or ADS1015 succeeded, 0 if one failed.",
+	}, []string{"hostname", "device"})
+)
+
+// ADCMetrics are the series of one ADS1115 or ADS1015. The input series are
+// created by the first reading, so a converter that never answered has none.
+type ADCMetrics struct {
+	hostname, device string
+	volts, values    map[string]prometheus.Gauge // by input name
+	up               prometheus.Gauge
+}
+
+// NewADCMetrics returns the metric series of the converter with the given labels.
+func NewADCMetrics(hostname, device string) *ADCMetrics {
+	return &ADCMetrics{
+		hostname: hostname,
+		device:   device,
+		volts:    make(map[string]prometheus.Gauge),
+		values:   make(map[string]prometheus.Gauge),
+		up:       adcUp.WithLabelValues(hostname, device),
+	}
+}
+
+func (m *ADCMetrics) gauge(cached map[string]prometheus.Gauge, vec *prometheus.GaugeVec, input string) prometheus.Gauge {
+	g, ok := cached[input]
+	if !ok {
+		g = vec.WithLabelValues(m.hostname, m.device, input)
+		cached[input] = g
+	}
+	return g
+}
+
+// PublishInput sets the gauges of one input from its voltage and scaled value.
+func (m *ADCMetrics) PublishInput(input string, volts, value float64) {
+	m.gauge(m.volts, adcInputVolts, input).Set(volts)
+	m.gauge(m.values, adcInputValue, input).Set(value)
+}
+
+// Up marks the converter up, once every input was published.
+func (m *ADCMetrics) Up() {
+	m.up.Set(1)
+}
+
+// Failed marks the converter down, keeping the last values.
+func (m *ADCMetrics) Failed() {
+	m.up.Set(0)
+}
@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/driver"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/smbus"
+)
+
+var driverUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sensor_up",
+	Help: "1 if the last reading of a sensor of a registered driver succeeded, 0 if it failed.",
+}, []string{"hostname", "device", "chip"})
+
+// The gauges of the driver metrics, by name, shared by every sensor of a driver
+// and by drivers that name the same metric.
+var (
+	driverGaugesMu sync.Mutex
+	driverGauges   = make(map[string]*prometheus.GaugeVec)
+)
+
+// DriverMetrics are the series of one sensor of a registered driver. The value
+// series are created by the first reading, so a sensor that never answered has
+// none.
+type DriverMetrics struct {
+	hostname, device string
+	vecs             []*prometheus.GaugeVec // by index of the driver's Metrics
+	gauges           []prometheus.Gauge
+	up               prometheus.Gauge
+}
+
+// NewDriverMetrics registers the gauges of metrics, unless an earlier sensor did,
+// and returns the series of the sensor with the given labels. It fails if a
+// metric has an invalid name or clashes with another metric of the exporter.
+func NewDriverMetrics(hostname, device, chip string, metrics []driver.Metric) (*DriverMetrics, error) {
+	m := &DriverMetrics{hostname: hostname, device: device, gauges: make([]prometheus.Gauge, len(metrics)), up: driverUp.WithLabelValues(hostname, device, chip)}
+	driverGaugesMu.Lock()
+	defer driverGaugesMu.Unlock()
+	for _, d := range metrics {
+		vec, ok := driverGauges[d.Name]
+		if !ok {
+			vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: d.Name, Help: d.Help}, []string{"hostname", "device"})
+			if err := prometheus.Register(vec); err != nil {
+				var are prometheus.AlreadyRegisteredError
+				if errors.As(err, &are) {
+					return nil, fmt.Errorf("metric %s is already exported", d.Name)
+				}
+				return nil, fmt.Errorf("metric %s: %w", d.Name, err)
+			}
+			driverGauges[d.Name] = vec
+		}
+		m.vecs = append(m.vecs, vec)
+	}
+	return m, nil
+}
+
+// Publish sets the gauges from the values of a reading and marks the sensor up.
+// A NaN value sets no series.
+func (m *DriverMetrics) Publish(values []float64) error {
+	if len(values) != len(m.vecs) {
+		return fmt.Errorf("reading has %d values for %d metrics", len(values), len(m.vecs))
+	}
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if m.gauges[i] == nil {
+			m.gauges[i] = m.vecs[i].WithLabelValues(m.hostname, m.device)
+		}
+		m.gauges[i].Set(v)
+	}
+	m.up.Set(1)
+	return nil
+}
+
+// Failed marks the sensor down, keeping the last values, and counts err in
+// i2c_pec_errors_total if it is a PEC mismatch.
+func (m *DriverMetrics) Failed(err error) {
+	m.up.Set(0)
+	if pe := (*smbus.PECError)(nil); errors.As(err, &pe) {
+		i2cPECErrors.WithLabelValues(m.hostname, m.device, fmt.Sprintf("0x%02X", pe.Command)).Inc()
+	}
+}
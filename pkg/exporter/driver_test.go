@@ -0,0 +1,48 @@
+package exporter
+
+import (
+	"testing"
+
+	"periph.io/x/conn/v3/physic"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/driver"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/smbus"
+)
+
+// badPECBus answers every read with 0x00 bytes and a PEC of 0x00, which does
+// not match them.
+type badPECBus struct{}
+
+func (badPECBus) String() string                  { return "fake" }
+func (badPECBus) SetSpeed(physic.Frequency) error { return nil }
+func (badPECBus) Tx(_ uint16, _, r []byte) error {
+	clear(r)
+	return nil
+}
+
+// TestDriverMetricsCountsPECErrors checks that a read failing its PEC check on
+// an smbus.Bus marks the sensor down and counts in i2c_pec_errors_total.
+func TestDriverMetricsCountsPECErrors(t *testing.T) {
+	m, err := NewDriverMetrics("test", "pec_errors", "fake", []driver.Metric{{Name: "test_pec_value", Help: "A value."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Publish([]float64{1}); err != nil {
+		t.Fatal(err)
+	}
+	bus := &smbus.Bus{Bus: badPECBus{}}
+	for i := range 2 {
+		err := bus.Tx(0x58, []byte{0x05}, make([]byte, 2))
+		if err == nil {
+			t.Fatal("Tx succeeded with a bad PEC")
+		}
+		m.Failed(err)
+		got := series(t, "pec_errors")
+		if got["i2c_pec_errors_total"] != float64(i+1) {
+			t.Errorf("i2c_pec_errors_total = %g after %d failures, want %d", got["i2c_pec_errors_total"], i+1, i+1)
+		}
+		if got["sensor_up"] != 0 {
+			t.Errorf("sensor_up = %g after a PEC error, want 0", got["sensor_up"])
+		}
+	}
+}
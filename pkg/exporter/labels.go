@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// The static extra labels of each device, by device label, such as the rack or
+// slot a board sits in.
+var (
+	deviceLabelsMu sync.RWMutex
+	deviceLabels   = make(map[string]map[string]string)
+)
+
+// SetDeviceLabels sets the extra labels Gatherer adds to every series of
+// device, replacing the ones set before; empty labels remove them.
+func SetDeviceLabels(device string, labels map[string]string) {
+	deviceLabelsMu.Lock()
+	defer deviceLabelsMu.Unlock()
+	if len(labels) == 0 {
+		delete(deviceLabels, device)
+		return
+	}
+	deviceLabels[device] = maps.Clone(labels)
+}
+
+// Gatherer gathers the default registry and adds the extra labels of
+// SetDeviceLabels to the series with each device label. A label the series
+// already has is left as it is. Devices without extra labels, or with other
+// ones, keep fewer labels in the same family, which Prometheus stores as empty.
+var Gatherer prometheus.Gatherer = prometheus.GathererFunc(gatherWithDeviceLabels)
+
+func gatherWithDeviceLabels() ([]*dto.MetricFamily, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	deviceLabelsMu.RLock()
+	defer deviceLabelsMu.RUnlock()
+	if len(deviceLabels) == 0 {
+		return families, err
+	}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			i := slices.IndexFunc(m.GetLabel(), func(l *dto.LabelPair) bool { return l.GetName() == "device" })
+			if i < 0 {
+				continue
+			}
+			extra := deviceLabels[m.GetLabel()[i].GetValue()]
+			if len(extra) == 0 {
+				continue
+			}
+			for name, value := range extra {
+				if !slices.ContainsFunc(m.GetLabel(), func(l *dto.LabelPair) bool { return l.GetName() == name }) {
+					m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+				}
+			}
+			// Gathered labels are sorted by name
+			slices.SortFunc(m.Label, func(a, b *dto.LabelPair) int { return strings.Compare(a.GetName(), b.GetName()) })
+		}
+	}
+	return families, err
+}
@@ -0,0 +1,332 @@
+// Package exporter publishes sensor readings: through the Prometheus gauges of
+// the power monitors, ADCs, environmental sensors and registered drivers, and
+// through the Sink and BatchSink interfaces to text lines on a terminal or in a
+// rotating file, CSV, and JSON lines on a named pipe. The sinks that push to a
+// server or database live in packages of their own (mqtt, influxdb, otlp,
+// remotewrite, sqlite), so importing exporter does not pull in their clients.
+package exporter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus gauges with labels
+var (
+	ina260Current = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current",
+		Help: "Current measured by INA260 sensor in Amperes.",
+	}, []string{"hostname", "device"}) // Added labels: hostname, device
+	ina260Voltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage",
+		Help: "Bus voltage measured by INA260 sensor in Volts.",
+	}, []string{"hostname", "device"}) // Added labels: hostname, device
+	ina260Power = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power",
+		Help: "Power measured by INA260 sensor in Watts.",
+	}, []string{"hostname", "device"}) // Added labels: hostname, device
+	ina260VoltageSaturated = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage_saturated",
+		Help: "1 if the INA260 bus voltage register is at or near its full-scale code, 0 otherwise.",
+	}, []string{"hostname", "device"})
+	ina260CurrentRaw = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_raw",
+		Help: "Signed raw value of the INA260 Current Register in LSB counts.",
+	}, []string{"hostname", "device"})
+	ina260CurrentMicroamps = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_microamps",
+		Help: "Current measured by INA260 sensor in microamperes, an exact multiple of the 1250 µA LSB.",
+	}, []string{"hostname", "device"})
+	ina260CurrentAvg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_avg",
+		Help: "Rolling mean of the current over the last --average-window readings in Amperes.",
+	}, []string{"hostname", "device"})
+	ina260VoltageAvg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage_avg",
+		Help: "Rolling mean of the bus voltage over the last --average-window readings in Volts.",
+	}, []string{"hostname", "device"})
+	ina260PowerAvg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_avg",
+		Help: "Rolling mean of the power over the last --average-window readings in Watts.",
+	}, []string{"hostname", "device"})
+	ina260CurrentWindowMin = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_window_min",
+		Help: "Lowest current of the readings in the last --stats-window in Amperes.",
+	}, []string{"hostname", "device"})
+	ina260CurrentWindowMax = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_window_max",
+		Help: "Highest current of the readings in the last --stats-window in Amperes.",
+	}, []string{"hostname", "device"})
+	ina260CurrentWindowAvg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_window_avg",
+		Help: "Mean current of the readings in the last --stats-window in Amperes.",
+	}, []string{"hostname", "device"})
+	ina260VoltageWindowMin = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage_window_min",
+		Help: "Lowest bus voltage of the readings in the last --stats-window in Volts.",
+	}, []string{"hostname", "device"})
+	ina260VoltageWindowMax = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage_window_max",
+		Help: "Highest bus voltage of the readings in the last --stats-window in Volts.",
+	}, []string{"hostname", "device"})
+	ina260VoltageWindowAvg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage_window_avg",
+		Help: "Mean bus voltage of the readings in the last --stats-window in Volts.",
+	}, []string{"hostname", "device"})
+	ina260PowerWindowMin = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_window_min",
+		Help: "Lowest power of the readings in the last --stats-window in Watts.",
+	}, []string{"hostname", "device"})
+	ina260PowerWindowMax = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_window_max",
+		Help: "Highest power of the readings in the last --stats-window in Watts.",
+	}, []string{"hostname", "device"})
+	ina260PowerWindowAvg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_window_avg",
+		Help: "Mean power of the readings in the last --stats-window in Watts.",
+	}, []string{"hostname", "device"})
+	ina260CurrentDirection = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_direction",
+		Help: "Sign of the INA260 current: 1 flowing from IN+ to IN-, -1 flowing back (e.g. a charging battery), 0 within --direction-deadband of zero.",
+	}, []string{"hostname", "device"})
+	ina260AlertLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_alert_limit",
+		Help: "Raw value of the INA260 Alert Limit Register (0x07), read at startup.",
+	}, []string{"hostname", "device"})
+	ina260CoincidentCurrent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_coincident_current",
+		Help: "Current from the same triggered INA260 conversion as ina260_coincident_voltage, in Amperes.",
+	}, []string{"hostname", "device"})
+	ina260CoincidentVoltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_coincident_voltage",
+		Help: "Bus voltage from the same triggered INA260 conversion as ina260_coincident_current, in Volts.",
+	}, []string{"hostname", "device"})
+	ina260CurrentMilliamps = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_milliamps",
+		Help: "Current measured by INA260 sensor in milliamperes, for dashboards migrating from milli-unit exporters.",
+	}, []string{"hostname", "device"})
+	ina260VoltageMillivolts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage_millivolts",
+		Help: "Voltage measured by INA260 sensor in millivolts, for dashboards migrating from milli-unit exporters.",
+	}, []string{"hostname", "device"})
+	ina260PowerMilliwatts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_milliwatts",
+		Help: "Power measured by INA260 sensor in milliwatts, for dashboards migrating from milli-unit exporters.",
+	}, []string{"hostname", "device"})
+	ina260CurrentDelta = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_current_delta",
+		Help: "Rate of change of the INA260 current between consecutive readings in Amperes per second.",
+	}, []string{"hostname", "device"})
+	ina260VoltageDelta = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_voltage_delta",
+		Help: "Rate of change of the INA260 bus voltage between consecutive readings in Volts per second.",
+	}, []string{"hostname", "device"})
+	ina260PowerDelta = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_power_delta",
+		Help: "Rate of change of the INA260 power between consecutive readings in Watts per second.",
+	}, []string{"hostname", "device"})
+	ina260Up = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ina260_up",
+		Help: "1 if the INA260 sensor is considered up, 0 if it is down (debounced by --down-after-cycles/--up-after-cycles).",
+	}, []string{"hostname", "device"})
+	outputWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_output_write_errors_total",
+		Help: "Number of readings that could not be published, by output sink.",
+	}, []string{"sink"})
+	ina260WriteVerifyFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_write_verify_failures_total",
+		Help: "Number of INA260 register writes whose --verify-writes readback did not match.",
+	}, []string{"hostname", "device"})
+	ina260ConfigWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_config_writes_total",
+		Help: "Number of times the INA260 Configuration register was written with new averaging, conversion times or operating mode, at setup or for a capture.",
+	}, []string{"hostname", "device"})
+	ina260ConfigVerifyFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_config_verify_failures_total",
+		Help: "Number of INA260 Configuration register writes that did not read back as written, or could not be read back.",
+	}, []string{"hostname", "device"})
+	fifoDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ina260_fifo_dropped_total",
+		Help: "Number of readings dropped by the --fifo sink because no reader was connected or the pipe was full.",
+	})
+	muxExtraWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_mux_extra_writes_total",
+		Help: "TCA9548A control writes added by --disable-after-read: deselecting all channels after each access and selecting the channel again before the next.",
+	}, []string{"hostname", "device"})
+	ina260EnergyWh = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ina260_energy_wh_total",
+		Help: "Energy consumed since startup, integrated from consecutive INA260 power readings, in Watt-hours.",
+	}, []string{"hostname", "device"})
+	ina260BusTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ina260_bus_time_seconds",
+		Help:    "Time the I2C bus was held for one INA260 reading, including mux writes, in seconds.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 10), // 0.5ms to 256ms
+	}, []string{"hostname", "device"})
+	i2cTransactionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "i2c_transaction_errors_total",
+		Help: "Number of failed sensor register reads and writes, counting every attempt including the ones a retry recovered from.",
+	}, []string{"hostname", "device", "register"})
+	i2cPECErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "i2c_pec_errors_total",
+		Help: "Number of reads of a sensor with packet error checking whose SMBus PEC did not match, by the register or command code read.",
+	}, []string{"hostname", "device", "register"})
+	ina260ReadRetries = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ina260_read_retries",
+		Help:    "Number of retries each successful INA260 register read needed (0 for first-try success).",
+		Buckets: []float64{0, 1, 2, 3, 5, 8},
+	}, []string{"hostname", "device"})
+)
+
+// Metrics caches the series of one sensor, so the hot path does not look up
+// label values on every reading. Each series is resolved on first use, which keeps
+// optional gauges absent until their feature actually publishes them.
+type Metrics struct {
+	hostname string
+	device   string
+
+	current, voltage, power                              prometheus.Gauge
+	voltageSaturated                                     prometheus.Gauge
+	currentRaw, currentMicroamps                         prometheus.Gauge
+	currentDirection                                     prometheus.Gauge
+	currentAvg, voltageAvg, powerAvg                     prometheus.Gauge
+	currentWindow, voltageWindow, powerWindow            windowGauges
+	coincidentCurrent, coincidentVoltage                 prometheus.Gauge
+	currentDelta, voltageDelta, powerDelta               prometheus.Gauge
+	currentMilliamps, voltageMillivolts, powerMilliwatts prometheus.Gauge
+	energy                                               prometheus.Counter
+	readRetries                                          prometheus.Observer
+	busTime                                              prometheus.Observer
+}
+
+// windowGauges are the cached --stats-window series of one quantity.
+type windowGauges struct {
+	min, max, avg prometheus.Gauge
+}
+
+// setWindow publishes the statistics of one quantity into the series of the vecs.
+func (m *Metrics) setWindow(cached *windowGauges, minVec, maxVec, avgVec *prometheus.GaugeVec, stats windowStats) {
+	m.gauge(&cached.min, minVec).Set(stats.min)
+	m.gauge(&cached.max, maxVec).Set(stats.max)
+	m.gauge(&cached.avg, avgVec).Set(stats.mean)
+}
+
+// NewMetrics returns the metric series of the sensor with the given labels.
+func NewMetrics(hostname, device string) *Metrics {
+	return &Metrics{hostname: hostname, device: device}
+}
+
+// gauge returns the cached series of vec, resolving it into *cached on first use.
+func (m *Metrics) gauge(cached *prometheus.Gauge, vec *prometheus.GaugeVec) prometheus.Gauge {
+	if *cached == nil {
+		*cached = vec.WithLabelValues(m.hostname, m.device)
+	}
+	return *cached
+}
+
+// Up returns the ina260_up series of the sensor. It is not removed by Delete,
+// since a sensor that is gone should report 0 rather than disappear.
+func (m *Metrics) Up() prometheus.Gauge {
+	return ina260Up.WithLabelValues(m.hostname, m.device)
+}
+
+// AlertLimit returns the ina260_alert_limit series of the sensor.
+func (m *Metrics) AlertLimit() prometheus.Gauge {
+	return ina260AlertLimit.WithLabelValues(m.hostname, m.device)
+}
+
+// VoltageSaturated returns the ina260_voltage_saturated series of the sensor.
+func (m *Metrics) VoltageSaturated() prometheus.Gauge {
+	return m.gauge(&m.voltageSaturated, ina260VoltageSaturated)
+}
+
+// SetCurrentDirection publishes the sign of the current, as returned by ina260.Direction.
+func (m *Metrics) SetCurrentDirection(direction int) {
+	m.gauge(&m.currentDirection, ina260CurrentDirection).Set(float64(direction))
+}
+
+// SetCoincident publishes the current and voltage of one triggered conversion.
+func (m *Metrics) SetCoincident(current, voltage float64) {
+	m.gauge(&m.coincidentCurrent, ina260CoincidentCurrent).Set(current)
+	m.gauge(&m.coincidentVoltage, ina260CoincidentVoltage).Set(voltage)
+}
+
+// MuxExtraWrites returns the ina260_mux_extra_writes_total series of the sensor.
+func (m *Metrics) MuxExtraWrites() prometheus.Counter {
+	return muxExtraWrites.WithLabelValues(m.hostname, m.device)
+}
+
+// WriteVerifyFailed counts one register write whose readback did not match.
+func (m *Metrics) WriteVerifyFailed() {
+	ina260WriteVerifyFailures.WithLabelValues(m.hostname, m.device).Inc()
+}
+
+// ConfigWritten counts one Configuration register write, and a verify failure
+// unless verified.
+func (m *Metrics) ConfigWritten(verified bool) {
+	ina260ConfigWrites.WithLabelValues(m.hostname, m.device).Inc()
+	if !verified {
+		ina260ConfigVerifyFailures.WithLabelValues(m.hostname, m.device).Inc()
+	}
+}
+
+// AddEnergy adds wh Watt-hours to the ina260_energy_wh_total series of the sensor.
+func (m *Metrics) AddEnergy(wh float64) {
+	if m.energy == nil {
+		m.energy = ina260EnergyWh.WithLabelValues(m.hostname, m.device)
+	}
+	m.energy.Add(wh)
+}
+
+// TransactionFailed counts one failed read or write of register reg, labelled
+// with the register address in hex (e.g. 0x01), since the chips name them differently.
+func (m *Metrics) TransactionFailed(reg byte, _ error) {
+	i2cTransactionErrors.WithLabelValues(m.hostname, m.device, fmt.Sprintf("0x%02X", reg)).Inc()
+}
+
+// ObserveReadRetries records the retry count of one successful register read.
+func (m *Metrics) ObserveReadRetries(retries int) {
+	if m.readRetries == nil {
+		m.readRetries = ina260ReadRetries.WithLabelValues(m.hostname, m.device)
+	}
+	m.readRetries.Observe(float64(retries))
+}
+
+// ObserveBusTime records how long one reading held the bus.
+func (m *Metrics) ObserveBusTime(d time.Duration) {
+	if m.busTime == nil {
+		m.busTime = ina260BusTime.WithLabelValues(m.hostname, m.device)
+	}
+	m.busTime.Observe(d.Seconds())
+}
+
+// Delete removes every gauge series published for the sensor, so scrapes show it
+// as absent instead of holding the last value. The cache is cleared as well, since
+// a deleted series is detached from its GaugeVec; series are re-created on next use.
+// Counters and histograms are kept, so they never go backwards.
+func (m *Metrics) Delete() {
+	for _, g := range []*prometheus.GaugeVec{ina260Current, ina260Voltage, ina260Power, ina260VoltageSaturated, ina260CurrentRaw, ina260CurrentMicroamps, ina260CurrentDirection,
+		ina260CurrentAvg, ina260VoltageAvg, ina260PowerAvg,
+		ina260CurrentWindowMin, ina260CurrentWindowMax, ina260CurrentWindowAvg,
+		ina260VoltageWindowMin, ina260VoltageWindowMax, ina260VoltageWindowAvg,
+		ina260PowerWindowMin, ina260PowerWindowMax, ina260PowerWindowAvg, ina260CoincidentCurrent, ina260CoincidentVoltage,
+		ina260CurrentDelta, ina260VoltageDelta, ina260PowerDelta,
+		ina260CurrentMilliamps, ina260VoltageMillivolts, ina260PowerMilliwatts} {
+		g.DeleteLabelValues(m.hostname, m.device)
+	}
+	*m = Metrics{hostname: m.hostname, device: m.device, energy: m.energy, readRetries: m.readRetries, busTime: m.busTime}
+}
+
+// Remove removes every series of the sensor, counters, histograms and ina260_up
+// included, for a sensor that is no longer polled at all. The Metrics must not be
+// used afterwards.
+func (m *Metrics) Remove() {
+	m.Delete()
+	labels := prometheus.Labels{"hostname": m.hostname, "device": m.device}
+	for _, v := range []interface{ DeletePartialMatch(prometheus.Labels) int }{ina260Up, ina260AlertLimit,
+		ina260WriteVerifyFailures, ina260ConfigWrites, ina260ConfigVerifyFailures, muxExtraWrites, ina260EnergyWh, ina260BusTime, i2cTransactionErrors, ina260ReadRetries} {
+		v.DeletePartialMatch(labels)
+	}
+}
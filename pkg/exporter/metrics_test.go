@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// series returns the value of every series of the default registry with the
+// given device label, by metric name. Histograms and summaries are left out.
+func series(t *testing.T, device string) map[string]float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() != "device" || l.GetValue() != device {
+					continue
+				}
+				switch {
+				case m.GetGauge() != nil:
+					values[mf.GetName()] = m.GetGauge().GetValue()
+				case m.GetCounter() != nil:
+					values[mf.GetName()] = m.GetCounter().GetValue()
+				default:
+					values[mf.GetName()] = 0
+				}
+			}
+		}
+	}
+	return values
+}
+
+// reading returns a reading of the raw register values, scaled as ina260.Sensor.Read does.
+func reading(at time.Time, current, voltage, power uint16) ina260.Reading {
+	return scaledReading(ina260.DefaultScale, at, current, voltage, power)
+}
+
+func scaledReading(s ina260.Scale, at time.Time, current, voltage, power uint16) ina260.Reading {
+	return ina260.Reading{Time: at, RawCurrent: current, RawVoltage: voltage, RawPower: power,
+		Current: s.Milliamps(current) / 1000, Voltage: s.Millivolts(voltage) / 1000, Power: s.Milliwatts(power) / 1000}
+}
+
+func TestMetricsDeleteRecreate(t *testing.T) {
+	s := NewSensor("test", "delete_recreate", ina260.DefaultScale)
+	sink := NewPrometheusSink(PrometheusOptions{ExportMicroamps: true, CompatMetrics: true, AverageWindow: 4})
+	start := time.Now()
+	if err := sink.Publish(s, reading(start, 400, 4000, 200)); err != nil {
+		t.Fatal(err)
+	}
+	gauges := []string{"ina260_current", "ina260_voltage", "ina260_power", "ina260_current_microamps", "ina260_current_milliamps", "ina260_current_avg"}
+	got := series(t, "delete_recreate")
+	for _, name := range gauges {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s missing after the first reading", name)
+		}
+	}
+
+	s.Metrics.Delete()
+	got = series(t, "delete_recreate")
+	for _, name := range gauges {
+		if _, ok := got[name]; ok {
+			t.Errorf("%s still exported after Delete", name)
+		}
+	}
+
+	if err := sink.Publish(s, reading(start.Add(time.Second), 800, 4000, 400)); err != nil {
+		t.Fatal(err)
+	}
+	got = series(t, "delete_recreate")
+	for _, name := range gauges {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s missing after publishing again", name)
+		}
+	}
+	if want := 800 * ina260.CurrentLSB / 1000; got["ina260_current"] != want {
+		t.Errorf("ina260_current = %g after publishing again, want %g", got["ina260_current"], want)
+	}
+}
+
+func TestCompatMetricsMatchSI(t *testing.T) {
+	trimmed := ina260.Scale{VoltageLSB: 1.2525, CurrentLSB: 1.2475, PowerLSB: 10.05}
+	tests := []struct {
+		name                    string
+		scale                   ina260.Scale
+		current, voltage, power uint16
+	}{
+		{"zero", ina260.DefaultScale, 0, 0, 0},
+		{"typical", ina260.DefaultScale, 400, 4000, 200},
+		{"negative current", ina260.DefaultScale, 0xFF38, 9600, 240},
+		{"full scale", ina260.DefaultScale, 0x7FFF, 0x7FFF, 0xFFFF},
+		{"negative full scale", ina260.DefaultScale, 0x8000, 1, 1},
+		{"overridden LSBs", trimmed, 0x1234, 0x2345, 0x3456},
+		{"overridden LSBs, negative", trimmed, 0xEDCC, 0x2345, 0x3456},
+	}
+	sink := NewPrometheusSink(PrometheusOptions{CompatMetrics: true})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := "compat_" + tt.name
+			s := NewSensor("test", device, tt.scale)
+			if err := sink.Publish(s, scaledReading(tt.scale, time.Now(), tt.current, tt.voltage, tt.power)); err != nil {
+				t.Fatal(err)
+			}
+			got := series(t, device)
+			for _, pair := range []struct {
+				milli, si string
+				raw       float64
+			}{
+				{"ina260_current_milliamps", "ina260_current", tt.scale.Milliamps(tt.current)},
+				{"ina260_voltage_millivolts", "ina260_voltage", tt.scale.Millivolts(tt.voltage)},
+				{"ina260_power_milliwatts", "ina260_power", tt.scale.Milliwatts(tt.power)},
+			} {
+				milli, ok := got[pair.milli]
+				if !ok {
+					t.Errorf("%s missing", pair.milli)
+					continue
+				}
+				if milli != pair.raw {
+					t.Errorf("%s = %g, want %g from the raw register", pair.milli, milli, pair.raw)
+				}
+				if si := got[pair.si]; math.Abs(si*1000-milli) > 1e-9*math.Max(1, math.Abs(milli)) {
+					t.Errorf("%s = %g does not match %s = %g", pair.milli, milli, pair.si, si)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPrometheusPublish compares publishing through the series cached in
+// Metrics with looking each gauge up by its labels on every reading.
+func BenchmarkPrometheusPublish(b *testing.B) {
+	r := reading(time.Now(), 400, 4000, 200)
+	b.Run("cached", func(b *testing.B) {
+		s := NewSensor("bench", "bench_cached", ina260.DefaultScale)
+		sink := NewPrometheusSink(PrometheusOptions{})
+		b.ReportAllocs()
+		for i := range b.N {
+			r.Time = r.Time.Add(time.Duration(i) * time.Millisecond)
+			sink.Publish(s, r)
+		}
+	})
+	b.Run("lookup", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			ina260Current.WithLabelValues("bench", "bench_lookup").Set(r.Current)
+			ina260Voltage.WithLabelValues("bench", "bench_lookup").Set(r.Voltage)
+			ina260Power.WithLabelValues("bench", "bench_lookup").Set(r.Power)
+		}
+	})
+}
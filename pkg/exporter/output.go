@@ -0,0 +1,217 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// RotatingFile is an append-only log file that is rotated once it grows past
+// maxSize bytes. Rotated files are renamed to path.1 (newest) up to path.<keep>
+// (oldest); anything older is removed.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64 // 0 disables rotation
+	keep    int   // number of rotated files to keep
+	header  []byte
+	file    *os.File
+	size    int64
+}
+
+// OpenRotatingFile opens (or creates) path for appending.
+func OpenRotatingFile(path string, maxSize int64, keep int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file %s: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat output file %s: %w", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return f.writeHeader()
+}
+
+// writeHeader starts an empty file with the header.
+func (f *RotatingFile) writeHeader() error {
+	if f.size > 0 || len(f.header) == 0 {
+		return nil
+	}
+	n, err := f.file.Write(f.header)
+	f.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write header to output file %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// SetHeader sets a header, such as a CSV header line, that starts every new
+// file: the current one if it is still empty, and each one after a rotation.
+func (f *RotatingFile) SetHeader(header []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.header = header
+	if f.file == nil {
+		return nil
+	}
+	return f.writeHeader()
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1 and reopens an empty path.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close output file %s: %w", f.path, err)
+	}
+	if f.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.keep)) // The oldest file may not exist yet
+		for i := f.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate output file %s: %w", f.path, err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("failed to truncate output file %s: %w", f.path, err)
+	}
+	return f.open()
+}
+
+// Write appends p, rotating first if it would push the file past maxSize.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		// A previous rotation failed to reopen the file; try again
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			f.file = nil
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// siPrefixes are the engineering-notation prefixes used by formatSI, largest first.
+var siPrefixes = []struct {
+	symbol string
+	factor float64
+}{
+	{"k", 1e3},
+	{"", 1},
+	{"m", 1e-3},
+	{"µ", 1e-6},
+	{"n", 1e-9},
+}
+
+// formatSI formats value with three decimals and the SI prefix that keeps the
+// mantissa in [1, 1000), e.g. 0.0125 A becomes "12.500 mA". A mantissa that
+// rounds up to 1000 takes the next larger prefix, so 0.9999996 A is "1.000 A"
+// rather than "1000.000 mA". Values below the smallest prefix use that prefix;
+// zero is shown in the base unit.
+func formatSI(value float64, unit string) string {
+	magnitude := math.Abs(value)
+	i := len(siPrefixes) - 1
+	for j, p := range siPrefixes {
+		if magnitude >= p.factor {
+			i = j
+			break
+		}
+	}
+	if value == 0 {
+		i = 1 // show zero in the base unit
+	}
+	if i > 0 && math.Round(magnitude/siPrefixes[i].factor*1000) >= 1e6 {
+		i--
+	}
+	mantissa := math.Round(value/siPrefixes[i].factor*1000) / 1000
+	return fmt.Sprintf("%.3f %s%s", mantissa, siPrefixes[i].symbol, unit)
+}
+
+// ANSI escape codes used for terminal output
+const (
+	ANSIReset  = "\033[0m"
+	ANSIRed    = "\033[31m"
+	ANSIGreen  = "\033[32m"
+	ANSIYellow = "\033[33m"
+	ANSICyan   = "\033[36m"
+)
+
+// Colorizer wraps text in ANSI colors when enabled.
+type Colorizer struct {
+	Enabled bool
+}
+
+// Wrap returns text in the given color, or unchanged when c is disabled.
+func (c Colorizer) Wrap(color, text string) string {
+	if !c.Enabled {
+		return text
+	}
+	return color + text + ANSIReset
+}
+
+// isTerminal reports whether f is a character device such as a console or pseudo-terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// NewColorizer resolves a color mode (auto, always or never) for output written to f.
+func NewColorizer(mode string, f *os.File) (Colorizer, error) {
+	switch mode {
+	case "auto":
+		return Colorizer{Enabled: isTerminal(f) && os.Getenv("TERM") != "dumb"}, nil
+	case "always":
+		return Colorizer{Enabled: true}, nil
+	case "never":
+		return Colorizer{}, nil
+	default:
+		return Colorizer{}, fmt.Errorf("invalid color mode %q: must be auto, always or never", mode)
+	}
+}
+
+// TextTimeFormat is the timestamp layout of text output lines.
+const TextTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// formatReadingText formats a timestamped reading as one human-readable line, with fixed
+// V/A/W units or SI prefixes when engineering is set.
+func formatReadingText(r ina260.Reading, engineering bool, c Colorizer) string {
+	voltage := fmt.Sprintf("%.3f V", r.Voltage)
+	current := fmt.Sprintf("%.3f A", r.Current)
+	power := fmt.Sprintf("%.3f W", r.Power)
+	if engineering {
+		voltage, current, power = formatSI(r.Voltage, "V"), formatSI(r.Current, "A"), formatSI(r.Power, "W")
+	}
+	return fmt.Sprintf("%s Voltage: %s, Current: %s, Power: %s\n", r.Time.Format(TextTimeFormat),
+		c.Wrap(ANSICyan, voltage), c.Wrap(ANSIYellow, current), c.Wrap(ANSIGreen, power))
+}
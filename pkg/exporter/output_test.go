@@ -0,0 +1,34 @@
+package exporter
+
+import "testing"
+
+func TestFormatSI(t *testing.T) {
+	tests := []struct {
+		value float64
+		unit  string
+		want  string
+	}{
+		{0, "A", "0.000 A"},
+		{1.5, "A", "1.500 A"},
+		{12.6, "V", "12.600 V"},
+		{1500, "W", "1.500 kW"},
+		{5e6, "W", "5000.000 kW"}, // beyond the largest prefix
+		{0.0125, "A", "12.500 mA"},
+		{-0.25, "A", "-250.000 mA"},
+		{12e-6, "A", "12.000 µA"},
+		{3e-9, "A", "3.000 nA"},
+		{1e-12, "A", "0.001 nA"}, // below the smallest prefix
+		// Mantissas that round up to 1000 take the next prefix
+		{0.9999996, "A", "1.000 A"},
+		{-0.9999996, "A", "-1.000 A"},
+		{999.9996, "W", "1.000 kW"},
+		{0.0009999996, "A", "1.000 mA"},
+		{0.9994, "A", "999.400 mA"},
+		{0.9999994, "A", "999.999 mA"},
+	}
+	for _, tt := range tests {
+		if got := formatSI(tt.value, tt.unit); got != tt.want {
+			t.Errorf("formatSI(%g, %q) = %q, want %q", tt.value, tt.unit, got, tt.want)
+		}
+	}
+}
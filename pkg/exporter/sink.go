@@ -0,0 +1,501 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// Sensor identifies a published sensor: its labels, the scale its raw values
+// were read with, and its metric series.
+type Sensor struct {
+	Hostname string            // hostname label
+	Device   string            // device label
+	Labels   map[string]string // static extra labels, e.g. rack and slot; see SetDeviceLabels
+	Scale    ina260.Scale
+	Metrics  *Metrics
+}
+
+// NewSensor returns a Sensor with the metric series for its labels.
+func NewSensor(hostname, device string, scale ina260.Scale) *Sensor {
+	return &Sensor{Hostname: hostname, Device: device, Scale: scale, Metrics: NewMetrics(hostname, device)}
+}
+
+// Sink receives every reading published by the polling loop. Sinks are
+// independent: an error from one is counted and logged without affecting the others.
+type Sink interface {
+	// Name identifies the sink in logs and in the sink label of ina260_output_write_errors_total.
+	Name() string
+	// Publish delivers one reading of a sensor.
+	Publish(s *Sensor, r ina260.Reading) error
+}
+
+// TextOptions configure a text sink.
+type TextOptions struct {
+	Engineering bool // use SI prefixes instead of fixed V/A/W
+	Color       Colorizer
+	ShowDevice  bool // prefix lines with the device label, when several sensors are polled
+}
+
+// textSink writes human-readable lines, e.g. to stdout or a rotating file.
+type textSink struct {
+	name string
+	w    io.Writer
+	opts TextOptions
+}
+
+// NewTextSink returns a sink that writes one line per reading to w.
+func NewTextSink(name string, w io.Writer, opts TextOptions) Sink {
+	return &textSink{name: name, w: w, opts: opts}
+}
+
+func (t *textSink) Name() string { return t.name }
+
+func (t *textSink) Publish(s *Sensor, r ina260.Reading) error {
+	line := formatReadingText(r, t.opts.Engineering, t.opts.Color)
+	if t.opts.ShowDevice {
+		line = s.Device + " " + line
+	}
+	_, err := io.WriteString(t.w, line)
+	return err
+}
+
+// PrometheusOptions select the gauges a Prometheus sink updates.
+type PrometheusOptions struct {
+	ExportMicroamps bool          // also export the raw current and ina260_current_microamps
+	ExportDelta     bool          // export the rate of change gauges
+	CompatMetrics   bool          // also export milli-unit gauges
+	AverageWindow   int           // 0 disables the rolling mean gauges
+	StatsWindow     time.Duration // 0 disables the window min, max and mean gauges
+	PublishInterval time.Duration // 0 publishes every reading
+}
+
+// prometheusSink updates the INA260 gauges served on /metrics.
+//
+// With a publish interval the gauges are updated at most that often: each update
+// shows the latest reading, while the rolling mean gauges of the average window
+// still take in every reading polled in between, and so do the min, max and mean
+// gauges of the stats window, which keep a spike between updates. Likewise the delta gauges
+// always compare consecutive readings, not consecutive updates, and the energy
+// counter integrates every reading.
+type prometheusSink struct {
+	opts    PrometheusOptions
+	sensors map[*Sensor]*prometheusSensorState
+}
+
+// prometheusSensorState is the per-sensor publish state of a prometheusSink.
+type prometheusSensorState struct {
+	window      *readingWindow // rolling mean state, nil without an average window
+	stats       *timeWindow    // stats window state, nil without a stats window
+	lastPublish time.Time      // reading time of the last gauge update
+	previous    ina260.Reading // last reading, zero Time before the first one
+	delta       readingDelta   // rate of change ending at previous
+	hasDelta    bool           // false until two readings have been seen
+}
+
+// NewPrometheusSink returns a sink that updates the INA260 gauges.
+func NewPrometheusSink(opts PrometheusOptions) Sink {
+	return &prometheusSink{opts: opts, sensors: make(map[*Sensor]*prometheusSensorState)}
+}
+
+func (p *prometheusSink) Name() string { return "prometheus" }
+
+func (p *prometheusSink) Publish(s *Sensor, r ina260.Reading) error {
+	state, ok := p.sensors[s]
+	if !ok {
+		state = &prometheusSensorState{}
+		if p.opts.AverageWindow > 0 {
+			state.window = newReadingWindow(p.opts.AverageWindow)
+		}
+		if p.opts.StatsWindow > 0 {
+			state.stats = &timeWindow{length: p.opts.StatsWindow}
+		}
+		p.sensors[s] = state
+	}
+	if state.window != nil {
+		state.window.add(r)
+	}
+	if state.stats != nil {
+		state.stats.add(r)
+	}
+	// The first reading has no predecessor, so there is no delta or energy to publish yet
+	if !state.previous.Time.IsZero() {
+		if p.opts.ExportDelta {
+			if d, ok := deltaBetween(state.previous, r); ok {
+				state.delta, state.hasDelta = d, true
+			}
+		}
+		if wh := energyBetween(state.previous, r); wh > 0 {
+			s.Metrics.AddEnergy(wh)
+		}
+	}
+	state.previous = r
+	if p.opts.PublishInterval > 0 && !state.lastPublish.IsZero() && r.Time.Sub(state.lastPublish) < p.opts.PublishInterval {
+		return nil
+	}
+	state.lastPublish = r.Time
+
+	// Update the sensor's cached Prometheus gauges
+	m := s.Metrics
+	m.gauge(&m.current, ina260Current).Set(r.Current)
+	m.gauge(&m.voltage, ina260Voltage).Set(r.Voltage)
+	m.gauge(&m.power, ina260Power).Set(r.Power)
+	if p.opts.ExportMicroamps {
+		m.gauge(&m.currentRaw, ina260CurrentRaw).Set(float64(int16(r.RawCurrent)))
+		m.gauge(&m.currentMicroamps, ina260CurrentMicroamps).Set(float64(s.Scale.Microamps(r.RawCurrent)))
+	}
+	if p.opts.CompatMetrics {
+		// Scaled from the raw registers with the same LSBs, so they match the SI gauges exactly
+		m.gauge(&m.currentMilliamps, ina260CurrentMilliamps).Set(s.Scale.Milliamps(r.RawCurrent))
+		m.gauge(&m.voltageMillivolts, ina260VoltageMillivolts).Set(s.Scale.Millivolts(r.RawVoltage))
+		m.gauge(&m.powerMilliwatts, ina260PowerMilliwatts).Set(s.Scale.Milliwatts(r.RawPower))
+	}
+	if state.window != nil {
+		avgVoltage, avgCurrent, avgPower := state.window.mean()
+		m.gauge(&m.voltageAvg, ina260VoltageAvg).Set(avgVoltage)
+		m.gauge(&m.currentAvg, ina260CurrentAvg).Set(avgCurrent)
+		m.gauge(&m.powerAvg, ina260PowerAvg).Set(avgPower)
+	}
+	if state.stats != nil {
+		voltage, current, power := state.stats.stats()
+		m.setWindow(&m.voltageWindow, ina260VoltageWindowMin, ina260VoltageWindowMax, ina260VoltageWindowAvg, voltage)
+		m.setWindow(&m.currentWindow, ina260CurrentWindowMin, ina260CurrentWindowMax, ina260CurrentWindowAvg, current)
+		m.setWindow(&m.powerWindow, ina260PowerWindowMin, ina260PowerWindowMax, ina260PowerWindowAvg, power)
+	}
+	if state.hasDelta {
+		m.gauge(&m.currentDelta, ina260CurrentDelta).Set(state.delta.Current)
+		m.gauge(&m.voltageDelta, ina260VoltageDelta).Set(state.delta.Voltage)
+		m.gauge(&m.powerDelta, ina260PowerDelta).Set(state.delta.Power)
+	}
+	return nil
+}
+
+// readingWindow is a fixed-size ring buffer of the most recent readings.
+type readingWindow struct {
+	samples []ina260.Reading
+	next    int // index the next sample is written to
+	count   int // number of valid samples, up to len(samples)
+}
+
+func newReadingWindow(size int) *readingWindow {
+	return &readingWindow{samples: make([]ina260.Reading, size)}
+}
+
+// add stores a reading, overwriting the oldest one once the window is full.
+func (w *readingWindow) add(r ina260.Reading) {
+	w.samples[w.next] = r
+	w.next = (w.next + 1) % len(w.samples)
+	if w.count < len(w.samples) {
+		w.count++
+	}
+}
+
+// mean returns the average voltage, current and power of the samples held so far,
+// so a window that is not yet full averages only what is available.
+func (w *readingWindow) mean() (voltage, current, power float64) {
+	if w.count == 0 {
+		return 0, 0, 0
+	}
+	for _, r := range w.samples[:w.count] {
+		voltage += r.Voltage
+		current += r.Current
+		power += r.Power
+	}
+	n := float64(w.count)
+	return voltage / n, current / n, power / n
+}
+
+// timeWindow holds the readings of the last length, by reading time, oldest
+// first.
+type timeWindow struct {
+	length   time.Duration
+	readings []ina260.Reading
+}
+
+// windowStats summarizes one quantity of the readings in a timeWindow.
+type windowStats struct {
+	min, max, mean float64
+}
+
+// add appends a reading and drops the readings that are now older than the
+// window. The newest reading always stays, however long ago the one before was.
+func (w *timeWindow) add(r ina260.Reading) {
+	w.readings = append(w.readings, r)
+	drop := 0
+	for drop < len(w.readings)-1 && r.Time.Sub(w.readings[drop].Time) >= w.length {
+		drop++
+	}
+	if drop > 0 {
+		// Shift rather than reslice, so the backing array does not keep growing
+		w.readings = w.readings[:copy(w.readings, w.readings[drop:])]
+	}
+}
+
+// stats returns the min, max and mean voltage, current and power of the
+// readings held. There is always at least one once add was called.
+func (w *timeWindow) stats() (voltage, current, power windowStats) {
+	first := w.readings[0]
+	voltage = windowStats{first.Voltage, first.Voltage, 0}
+	current = windowStats{first.Current, first.Current, 0}
+	power = windowStats{first.Power, first.Power, 0}
+	for _, r := range w.readings {
+		for _, q := range []struct {
+			stats *windowStats
+			value float64
+		}{{&voltage, r.Voltage}, {&current, r.Current}, {&power, r.Power}} {
+			q.stats.min, q.stats.max = min(q.stats.min, q.value), max(q.stats.max, q.value)
+			q.stats.mean += q.value
+		}
+	}
+	n := float64(len(w.readings))
+	voltage.mean, current.mean, power.mean = voltage.mean/n, current.mean/n, power.mean/n
+	return voltage, current, power
+}
+
+// energyBetween returns the energy in Watt-hours consumed from prev to r, assuming
+// power changed linearly in between (the trapezoidal rule). Readings that are not
+// strictly ordered in time add nothing.
+func energyBetween(prev, r ina260.Reading) float64 {
+	hours := r.Time.Sub(prev.Time).Hours()
+	if hours <= 0 {
+		return 0
+	}
+	return (prev.Power + r.Power) / 2 * hours
+}
+
+// readingDelta is the rate of change between two readings, per second.
+type readingDelta struct {
+	Current float64 // A/s
+	Voltage float64 // V/s
+	Power   float64 // W/s
+}
+
+// deltaBetween returns the rate of change from prev to r. ok is false if the
+// readings are not strictly ordered in time, so no rate can be computed.
+func deltaBetween(prev, r ina260.Reading) (d readingDelta, ok bool) {
+	seconds := r.Time.Sub(prev.Time).Seconds()
+	if seconds <= 0 {
+		return readingDelta{}, false
+	}
+	return readingDelta{
+		Current: (r.Current - prev.Current) / seconds,
+		Voltage: (r.Voltage - prev.Voltage) / seconds,
+		Power:   (r.Power - prev.Power) / seconds,
+	}, true
+}
+
+// Published is one reading of a sensor in a batch.
+type Published struct {
+	Sensor  *Sensor
+	Reading ina260.Reading
+}
+
+// BatchSink is a Sink that delivers the readings of several sensors better
+// together than one at a time, e.g. without a round trip per reading.
+type BatchSink interface {
+	Sink
+	// PublishBatch delivers the readings of a batch, in order.
+	PublishBatch(batch []Published) error
+}
+
+// PublishAll fans a reading out to every sink, counting and logging failures per sink.
+func PublishAll(sinks []Sink, s *Sensor, r ina260.Reading) {
+	for _, k := range sinks {
+		publish(k, s, r)
+	}
+}
+
+// PublishBatch fans a batch of readings out to every sink like PublishAll: a
+// BatchSink gets the whole batch at once, any other sink each reading in turn.
+func PublishBatch(sinks []Sink, batch []Published) {
+	for _, k := range sinks {
+		b, ok := k.(BatchSink)
+		if !ok {
+			for _, p := range batch {
+				publish(k, p.Sensor, p.Reading)
+			}
+			continue
+		}
+		if err := b.PublishBatch(batch); err != nil {
+			outputWriteErrors.WithLabelValues(k.Name()).Inc()
+			slog.Warn("Failed to publish readings", "sink", k.Name(), "readings", len(batch), "err", err)
+		}
+	}
+}
+
+// WriteErrors returns the ina260_output_write_errors_total series of the sink
+// named sink, for the sinks of other packages to count what they drop.
+func WriteErrors(sink string) prometheus.Counter {
+	return outputWriteErrors.WithLabelValues(sink)
+}
+
+func publish(k Sink, s *Sensor, r ina260.Reading) {
+	if err := k.Publish(s, r); err != nil {
+		outputWriteErrors.WithLabelValues(k.Name()).Inc()
+		slog.Warn("Failed to publish reading", "sink", k.Name(), "device", s.Device, "err", err)
+	}
+}
+
+// ReadingTimeFormat is the timestamp layout of machine-readable output: RFC 3339
+// with a fixed six-digit fraction, so timestamps keep microsecond precision and
+// sort lexically. (time.RFC3339Nano trims trailing zeros and varies in width.)
+const ReadingTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// readingJSON is the JSON-lines form of a reading, shared by the machine-readable sinks.
+type readingJSON struct {
+	Time     string            `json:"time"`
+	Hostname string            `json:"hostname"`
+	Device   string            `json:"device"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Voltage  float64           `json:"voltage"` // Volts
+	Current  float64           `json:"current"` // Amperes
+	Power    float64           `json:"power"`   // Watts
+}
+
+// MarshalReadingJSON encodes a reading as one newline-terminated JSON object.
+func MarshalReadingJSON(s *Sensor, r ina260.Reading) ([]byte, error) {
+	line, err := json.Marshal(readingJSON{
+		Time:     r.Time.Format(ReadingTimeFormat),
+		Hostname: s.Hostname,
+		Device:   s.Device,
+		Labels:   s.Labels,
+		Voltage:  r.Voltage,
+		Current:  r.Current,
+		Power:    r.Power,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// CSVHeader is the header line of the CSV sink's output.
+const CSVHeader = "time,hostname,device,voltage,current,power\n"
+
+// csvSink writes one CSV record per reading, with the columns of CSVHeader:
+// timestamps in ReadingTimeFormat and values in V, A and W. It does not write
+// the header itself, so a file that is appended to across restarts gets it once.
+type csvSink struct {
+	name string
+	w    io.Writer
+}
+
+// NewCSVSink returns a sink that writes CSV records to w.
+func NewCSVSink(name string, w io.Writer) Sink {
+	return &csvSink{name: name, w: w}
+}
+
+func (c *csvSink) Name() string { return c.name }
+
+func (c *csvSink) Publish(s *Sensor, r ina260.Reading) error {
+	var b strings.Builder
+	cw := csv.NewWriter(&b)
+	cw.Write([]string{
+		r.Time.Format(ReadingTimeFormat),
+		s.Hostname,
+		s.Device,
+		strconv.FormatFloat(r.Voltage, 'g', -1, 64),
+		strconv.FormatFloat(r.Current, 'g', -1, 64),
+		strconv.FormatFloat(r.Power, 'g', -1, 64),
+	})
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.w, b.String())
+	return err
+}
+
+// jsonLinesSink writes one MarshalReadingJSON object per reading.
+type jsonLinesSink struct {
+	name string
+	w    io.Writer
+}
+
+// NewJSONLinesSink returns a sink that writes JSON lines to w.
+func NewJSONLinesSink(name string, w io.Writer) Sink {
+	return &jsonLinesSink{name: name, w: w}
+}
+
+func (j *jsonLinesSink) Name() string { return j.name }
+
+func (j *jsonLinesSink) Publish(s *Sensor, r ina260.Reading) error {
+	line, err := MarshalReadingJSON(s, r)
+	if err != nil {
+		return err
+	}
+	_, err = j.w.Write(line)
+	return err
+}
+
+// fifoSink writes JSON-lines readings to a named pipe for another local process.
+// It never blocks the polling loop: the pipe is opened non-blocking, so while no
+// reader has it open (ENXIO), the reader has gone away (EPIPE) or the pipe buffer
+// is full (EAGAIN), readings are dropped and counted in ina260_fifo_dropped_total.
+// Lines shorter than PIPE_BUF are written atomically, so readers never see partial lines.
+type fifoSink struct {
+	path string
+	fd   int // -1 while no reader is connected
+}
+
+// NewFIFOSink returns a sink writing to the named pipe at path, creating the
+// pipe if it does not exist yet.
+func NewFIFOSink(path string) (Sink, error) {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := syscall.Mkfifo(path, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to create FIFO %s: %w", path, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to stat FIFO %s: %w", path, err)
+	case info.Mode()&os.ModeNamedPipe == 0:
+		return nil, fmt.Errorf("%s exists and is not a named pipe", path)
+	}
+	return &fifoSink{path: path, fd: -1}, nil
+}
+
+func (f *fifoSink) Name() string { return "fifo" }
+
+func (f *fifoSink) Publish(s *Sensor, r ina260.Reading) error {
+	line, err := MarshalReadingJSON(s, r)
+	if err != nil {
+		return err
+	}
+	if f.fd < 0 {
+		fd, err := syscall.Open(f.path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if errors.Is(err, syscall.ENXIO) {
+			fifoDropped.Inc() // No reader yet
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open FIFO %s: %w", f.path, err)
+		}
+		f.fd = fd
+	}
+	if _, err := syscall.Write(f.fd, line); err != nil {
+		fifoDropped.Inc()
+		if errors.Is(err, syscall.EAGAIN) {
+			return nil // Reader is not keeping up; keep the pipe open
+		}
+		// The reader went away; reopen on the next reading
+		syscall.Close(f.fd)
+		f.fd = -1
+		if !errors.Is(err, syscall.EPIPE) {
+			return fmt.Errorf("failed to write FIFO %s: %w", f.path, err)
+		}
+	}
+	return nil
+}
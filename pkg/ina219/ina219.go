@@ -0,0 +1,125 @@
+// Package ina219 reads the TI INA219 power monitor. Like the INA226 it measures
+// an external shunt, so its Calibration register has to be programmed from the
+// shunt resistance before it reports current and power. It has no ID registers.
+package ina219
+
+import (
+	"fmt"
+	"math"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// Address is the INA219 I2C address with A0 and A1 tied to GND.
+const Address = uint16(0x40)
+
+// Register Addresses
+const (
+	RegConfig       byte = 0x00 // Configuration Register
+	RegShuntVoltage byte = 0x01 // Shunt Voltage Register
+	RegBusVoltage   byte = 0x02 // Bus Voltage Register
+	RegPower        byte = 0x03 // Power Register
+	RegCurrent      byte = 0x04 // Current Register
+	RegCalibration  byte = 0x05 // Calibration Register
+)
+
+// ConfigDefault is the power-on value of the Configuration register: 32 V bus
+// range, PGA /8, 12-bit conversions, continuous shunt and bus measurement.
+const ConfigDefault uint16 = 0x399F
+
+// Bus Voltage Register layout: the voltage is in bits 15:3, followed by status flags.
+const (
+	busVoltageShift = 3
+	BusVoltageCNVR  = 1 << 1 // Conversion Ready
+	BusVoltageOVF   = 1 << 0 // Math Overflow: current or power exceeded the calibrated range
+)
+
+// Scaling Factors. ShuntFullScale assumes the power-on configuration, whose PGA
+// gain of /8 measures up to ±320 mV across the shunt.
+const (
+	VoltageLSB       = 4.0     // mV/LSB for the Bus Voltage Register, after dropping bits 2:0
+	ShuntFullScale   = 0.32    // V, the largest shunt voltage measured at PGA /8
+	calibrationScale = 0.04096 // the fixed value of the datasheet's calibration equation
+	powerLSBRatio    = 20      // Power Register LSB as a multiple of the current LSB
+	maxCurrentCodes  = 1 << 15 // Current Register codes for the full positive range
+)
+
+// Calibration is the Calibration register value for one shunt, together with
+// the current LSB it sets.
+type Calibration struct {
+	CurrentLSB float64 // mA/LSB for Current Register
+	Register   uint16  // value written to the Calibration register
+}
+
+// NewCalibration returns the calibration for a shunt of shuntOhms carrying up to
+// maxCurrent Amperes; 0 uses the full shunt voltage range. The current LSB is
+// rounded up to a whole microamp, so ina260.Scale.Microamps stays exact.
+func NewCalibration(shuntOhms, maxCurrent float64) (Calibration, error) {
+	if shuntOhms <= 0 {
+		return Calibration{}, fmt.Errorf("shunt resistance must be positive, got %g Ohms", shuntOhms)
+	}
+	if maxCurrent < 0 {
+		return Calibration{}, fmt.Errorf("max current must not be negative, got %g A", maxCurrent)
+	}
+	if maxCurrent == 0 {
+		maxCurrent = ShuntFullScale / shuntOhms
+	} else if maxCurrent*shuntOhms > ShuntFullScale {
+		return Calibration{}, fmt.Errorf("max current %g A drops %g V across a %g Ohm shunt, beyond the %g V INA219 range", maxCurrent, maxCurrent*shuntOhms, shuntOhms, ShuntFullScale)
+	}
+	lsbMicroamps := math.Ceil(maxCurrent * 1e6 / maxCurrentCodes)
+	cal := math.Trunc(calibrationScale / (lsbMicroamps * 1e-6 * shuntOhms))
+	if cal < 2 || cal > math.MaxUint16 {
+		return Calibration{}, fmt.Errorf("shunt %g Ohms with max current %g A needs a calibration value outside the register range", shuntOhms, maxCurrent)
+	}
+	// Bit 0 of the Calibration register is read-only and always 0
+	return Calibration{CurrentLSB: lsbMicroamps / 1000, Register: uint16(cal) &^ 1}, nil
+}
+
+// Scale returns the LSB weights of the readings taken with c. Its VoltageLSB
+// applies to Reading.RawVoltage, which holds the bus voltage without the status bits.
+func (c Calibration) Scale() ina260.Scale {
+	return ina260.Scale{VoltageLSB: VoltageLSB, CurrentLSB: c.CurrentLSB, PowerLSB: powerLSBRatio * c.CurrentLSB}
+}
+
+// Sensor is one INA219 with its calibration. Regs provides the register access,
+// with its retries, timeouts and write verification; its Scale is not used.
+type Sensor struct {
+	Regs        *ina260.Sensor
+	Calibration Calibration
+}
+
+// Configure writes the Calibration register. The INA219 forgets it on power
+// loss, in which case current and power read as 0 until it is written again.
+func (s *Sensor) Configure() error {
+	if err := s.Regs.WriteReg(RegCalibration, s.Calibration.Register); err != nil {
+		return fmt.Errorf("failed to write INA219 Calibration register: %w", err)
+	}
+	return nil
+}
+
+// Read reads the Current, Bus Voltage and Power registers and scales them to SI
+// units. The returned reading has no Time; the caller stamps it. A set math
+// overflow flag is an error, since current and power are then meaningless.
+func (s *Sensor) Read() (ina260.Reading, error) {
+	var r ina260.Reading
+	var err error
+	if r.RawCurrent, err = s.Regs.ReadReg(RegCurrent); err != nil {
+		return r, fmt.Errorf("failed to read current: %w", err)
+	}
+	busVoltage, err := s.Regs.ReadReg(RegBusVoltage)
+	if err != nil {
+		return r, fmt.Errorf("failed to read bus voltage: %w", err)
+	}
+	if busVoltage&BusVoltageOVF != 0 {
+		return r, fmt.Errorf("math overflow: current exceeds the calibrated range (raise the max current)")
+	}
+	r.RawVoltage = busVoltage >> busVoltageShift
+	if r.RawPower, err = s.Regs.ReadReg(RegPower); err != nil {
+		return r, fmt.Errorf("failed to read power: %w", err)
+	}
+	scale := s.Calibration.Scale()
+	r.Current = scale.Milliamps(r.RawCurrent) / 1000.0
+	r.Voltage = scale.Millivolts(r.RawVoltage) / 1000.0
+	r.Power = scale.Milliwatts(r.RawPower) / 1000.0
+	return r, nil
+}
@@ -0,0 +1,59 @@
+package ina219
+
+import (
+	"math"
+	"testing"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+func TestNewCalibration(t *testing.T) {
+	tests := []struct {
+		name       string
+		shuntOhms  float64
+		maxCurrent float64
+		want       Calibration
+		scale      ina260.Scale
+	}{
+		// Datasheet calibration example: 2 A through 0.1 Ohm needs at least a
+		// 61.04 µA LSB, rounded up to 62 µA, so Cal = trunc(0.04096 / (62 µA * 0.1 Ohm))
+		{"datasheet 2 A through 0.1 Ohm", 0.1, 2, Calibration{CurrentLSB: 0.062, Register: 6606}, ina260.Scale{VoltageLSB: 4, CurrentLSB: 0.062, PowerLSB: 1.24}},
+		// 0.32 V / 0.1 Ohm = 3.2 A, a 97.66 µA LSB rounded up; Cal 4179 loses the read-only bit 0
+		{"full range of 0.1 Ohm", 0.1, 0, Calibration{CurrentLSB: 0.098, Register: 4178}, ina260.Scale{VoltageLSB: 4, CurrentLSB: 0.098, PowerLSB: 1.96}},
+		{"0.6 A through 0.5 Ohm", 0.5, 0.6, Calibration{CurrentLSB: 0.019, Register: 4310}, ina260.Scale{VoltageLSB: 4, CurrentLSB: 0.019, PowerLSB: 0.38}},
+		{"full range of 10 mOhm", 0.01, 0, Calibration{CurrentLSB: 0.977, Register: 4192}, ina260.Scale{VoltageLSB: 4, CurrentLSB: 0.977, PowerLSB: 19.54}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCalibration(tt.shuntOhms, tt.maxCurrent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("NewCalibration(%g, %g) = %+v, want %+v", tt.shuntOhms, tt.maxCurrent, got, tt.want)
+			}
+			// The power LSB is a multiple of the current LSB, rounded as a float64
+			if scale := got.Scale(); scale.VoltageLSB != tt.scale.VoltageLSB || scale.CurrentLSB != tt.scale.CurrentLSB || math.Abs(scale.PowerLSB-tt.scale.PowerLSB) > 1e-9 {
+				t.Errorf("Scale() = %+v, want %+v", scale, tt.scale)
+			}
+		})
+	}
+}
+
+func TestNewCalibrationErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		shuntOhms  float64
+		maxCurrent float64
+	}{
+		{"no shunt", 0, 1},
+		{"negative max current", 0.1, -1},
+		{"beyond the shunt range", 0.1, 3.3},
+		{"calibration beyond the register", 0.001, 0.001},
+	}
+	for _, tt := range tests {
+		if got, err := NewCalibration(tt.shuntOhms, tt.maxCurrent); err == nil {
+			t.Errorf("%s: NewCalibration(%g, %g) = %+v, want an error", tt.name, tt.shuntOhms, tt.maxCurrent, got)
+		}
+	}
+}
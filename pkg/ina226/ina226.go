@@ -0,0 +1,126 @@
+// Package ina226 reads the TI INA226 power monitor. Unlike the INA260 it measures
+// an external shunt, so its Calibration register has to be programmed from the
+// shunt resistance before it reports current and power.
+package ina226
+
+import (
+	"fmt"
+	"math"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// Address is the INA226 I2C address with A0 and A1 tied to GND.
+const Address = uint16(0x40)
+
+// Register Addresses
+const (
+	RegConfig       byte = 0x00 // Configuration Register
+	RegShuntVoltage byte = 0x01 // Shunt Voltage Register
+	RegBusVoltage   byte = 0x02 // Bus Voltage Register
+	RegPower        byte = 0x03 // Power Register
+	RegCurrent      byte = 0x04 // Current Register
+	RegCalibration  byte = 0x05 // Calibration Register
+	RegMaskEnable   byte = 0x06 // Mask/Enable Register
+	RegAlertLimit   byte = 0x07 // Alert Limit Register
+	RegManufID      byte = 0xFE // Manufacturer ID Register
+	RegDieID        byte = 0xFF // Die ID Register
+)
+
+// Identification register values
+const (
+	ManufacturerID uint16 = 0x5449 // "TI"
+	DieID          uint16 = 0x2260
+)
+
+// Scaling Factors
+const (
+	VoltageLSB       = 1.25    // mV/LSB for Bus Voltage Register
+	ShuntFullScale   = 0.08192 // V, the largest shunt voltage the INA226 measures
+	calibrationScale = 0.00512 // the fixed value of the datasheet's calibration equation
+	powerLSBRatio    = 25      // Power Register LSB as a multiple of the current LSB
+	maxCurrentCodes  = 1 << 15 // Current Register codes for the full positive range
+)
+
+// Calibration is the Calibration register value for one shunt, together with
+// the current LSB it sets.
+type Calibration struct {
+	CurrentLSB float64 // mA/LSB for Current Register
+	Register   uint16  // value written to the Calibration register
+}
+
+// NewCalibration returns the calibration for a shunt of shuntOhms carrying up to
+// maxCurrent Amperes; 0 uses the full shunt voltage range. The current LSB is
+// rounded up to a whole microamp, so ina260.Scale.Microamps stays exact.
+func NewCalibration(shuntOhms, maxCurrent float64) (Calibration, error) {
+	if shuntOhms <= 0 {
+		return Calibration{}, fmt.Errorf("shunt resistance must be positive, got %g Ohms", shuntOhms)
+	}
+	if maxCurrent < 0 {
+		return Calibration{}, fmt.Errorf("max current must not be negative, got %g A", maxCurrent)
+	}
+	if maxCurrent == 0 {
+		maxCurrent = ShuntFullScale / shuntOhms
+	} else if maxCurrent*shuntOhms > ShuntFullScale {
+		return Calibration{}, fmt.Errorf("max current %g A drops %g V across a %g Ohm shunt, beyond the %g V INA226 range", maxCurrent, maxCurrent*shuntOhms, shuntOhms, ShuntFullScale)
+	}
+	lsbMicroamps := math.Ceil(maxCurrent * 1e6 / maxCurrentCodes)
+	cal := math.Trunc(calibrationScale / (lsbMicroamps * 1e-6 * shuntOhms))
+	if cal < 1 || cal > math.MaxUint16 {
+		return Calibration{}, fmt.Errorf("shunt %g Ohms with max current %g A needs a calibration value outside the register range", shuntOhms, maxCurrent)
+	}
+	return Calibration{CurrentLSB: lsbMicroamps / 1000, Register: uint16(cal)}, nil
+}
+
+// Scale returns the LSB weights of the readings taken with c.
+func (c Calibration) Scale() ina260.Scale {
+	return ina260.Scale{VoltageLSB: VoltageLSB, CurrentLSB: c.CurrentLSB, PowerLSB: powerLSBRatio * c.CurrentLSB}
+}
+
+// Sensor is one INA226 with its calibration. Regs provides the register access,
+// with its retries, timeouts and write verification; its Scale is not used.
+type Sensor struct {
+	Regs        *ina260.Sensor
+	Calibration Calibration
+}
+
+// Identify reads the Manufacturer ID and Die ID registers.
+func (s *Sensor) Identify() (manufID, dieID uint16, err error) {
+	if manufID, err = s.Regs.ReadReg(RegManufID); err != nil {
+		return 0, 0, fmt.Errorf("failed to read INA226 Manufacturer ID: %w", err)
+	}
+	if dieID, err = s.Regs.ReadReg(RegDieID); err != nil {
+		return 0, 0, fmt.Errorf("failed to read INA226 Die ID: %w", err)
+	}
+	return manufID, dieID, nil
+}
+
+// Configure writes the Calibration register. The INA226 forgets it on power
+// loss, in which case current and power read as 0 until it is written again.
+func (s *Sensor) Configure() error {
+	if err := s.Regs.WriteReg(RegCalibration, s.Calibration.Register); err != nil {
+		return fmt.Errorf("failed to write INA226 Calibration register: %w", err)
+	}
+	return nil
+}
+
+// Read reads the Current, Bus Voltage and Power registers and scales them to SI
+// units. The returned reading has no Time; the caller stamps it.
+func (s *Sensor) Read() (ina260.Reading, error) {
+	var r ina260.Reading
+	var err error
+	if r.RawCurrent, err = s.Regs.ReadReg(RegCurrent); err != nil {
+		return r, fmt.Errorf("failed to read current: %w", err)
+	}
+	if r.RawVoltage, err = s.Regs.ReadReg(RegBusVoltage); err != nil {
+		return r, fmt.Errorf("failed to read bus voltage: %w", err)
+	}
+	if r.RawPower, err = s.Regs.ReadReg(RegPower); err != nil {
+		return r, fmt.Errorf("failed to read power: %w", err)
+	}
+	scale := s.Calibration.Scale()
+	r.Current = scale.Milliamps(r.RawCurrent) / 1000.0
+	r.Voltage = scale.Millivolts(r.RawVoltage) / 1000.0
+	r.Power = scale.Milliwatts(r.RawPower) / 1000.0
+	return r, nil
+}
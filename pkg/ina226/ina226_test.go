@@ -0,0 +1,60 @@
+package ina226
+
+import (
+	"math"
+	"testing"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+func TestNewCalibration(t *testing.T) {
+	tests := []struct {
+		name       string
+		shuntOhms  float64
+		maxCurrent float64
+		want       Calibration
+		scale      ina260.Scale
+	}{
+		// Datasheet calibration example: a 1 mA LSB with a 2 mOhm shunt gives
+		// CAL = 0.00512 / (1 mA * 2 mOhm) = 2560 and a 25 mW power LSB
+		{"datasheet 1 mA LSB with 2 mOhm", 0.002, 32.768, Calibration{CurrentLSB: 1, Register: 2560}, ina260.Scale{VoltageLSB: 1.25, CurrentLSB: 1, PowerLSB: 25}},
+		// 15 A needs at least a 457.8 µA LSB, rounded up to 458 µA
+		{"15 A through 2 mOhm", 0.002, 15, Calibration{CurrentLSB: 0.458, Register: 5589}, ina260.Scale{VoltageLSB: 1.25, CurrentLSB: 0.458, PowerLSB: 25 * 0.458}},
+		// 81.92 mV / 0.1 Ohm = 0.8192 A, exactly a 25 µA LSB
+		{"full range of 0.1 Ohm", 0.1, 0, Calibration{CurrentLSB: 0.025, Register: 2048}, ina260.Scale{VoltageLSB: 1.25, CurrentLSB: 0.025, PowerLSB: 0.625}},
+		{"1 A through 10 mOhm", 0.01, 1, Calibration{CurrentLSB: 0.031, Register: 16516}, ina260.Scale{VoltageLSB: 1.25, CurrentLSB: 0.031, PowerLSB: 0.775}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCalibration(tt.shuntOhms, tt.maxCurrent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("NewCalibration(%g, %g) = %+v, want %+v", tt.shuntOhms, tt.maxCurrent, got, tt.want)
+			}
+			// The power LSB is a multiple of the current LSB, rounded as a float64
+			if scale := got.Scale(); scale.VoltageLSB != tt.scale.VoltageLSB || scale.CurrentLSB != tt.scale.CurrentLSB || math.Abs(scale.PowerLSB-tt.scale.PowerLSB) > 1e-9 {
+				t.Errorf("Scale() = %+v, want %+v", scale, tt.scale)
+			}
+		})
+	}
+}
+
+func TestNewCalibrationErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		shuntOhms  float64
+		maxCurrent float64
+	}{
+		{"no shunt", 0, 1},
+		{"negative max current", 0.1, -1},
+		{"beyond the shunt range", 0.1, 0.9},
+		{"calibration beyond the register", 0.001, 0.001},
+	}
+	for _, tt := range tests {
+		if got, err := NewCalibration(tt.shuntOhms, tt.maxCurrent); err == nil {
+			t.Errorf("%s: NewCalibration(%g, %g) = %+v, want an error", tt.name, tt.shuntOhms, tt.maxCurrent, got)
+		}
+	}
+}
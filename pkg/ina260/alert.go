@@ -0,0 +1,118 @@
+package ina260
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Mask/Enable register alert bits, besides the alert functions of AlertFunctions
+const (
+	MaskEnableAFF  uint16 = 1 << 4 // Alert Function Flag
+	MaskEnableAPOL uint16 = 1 << 1 // Alert pin polarity: active high when set
+	MaskEnableLEN  uint16 = 1 << 0 // Alert latch enable
+)
+
+// AlertFunctions maps the alert function names accepted by Alert to their
+// Mask/Enable register bit. The chip compares the limit with one register only,
+// so one function is enabled at a time.
+var AlertFunctions = map[string]uint16{
+	"over-current":  1 << 15, // OCL
+	"under-current": 1 << 14, // UCL
+	"over-voltage":  1 << 13, // BOL
+	"under-voltage": 1 << 12, // BUL
+	"over-power":    1 << 11, // POL
+}
+
+// AlertFunctionNames returns the names of AlertFunctions, sorted.
+func AlertFunctionNames() []string {
+	names := make([]string, 0, len(AlertFunctions))
+	for name := range AlertFunctions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Alert sets up the ALERT pin: it is asserted while the reading the function
+// names is beyond Limit, or with Latch from then until the Mask/Enable register
+// is read.
+type Alert struct {
+	Function   string  // one of AlertFunctions
+	Limit      float64 // Amperes, Volts or Watts, by Function
+	ActiveHigh bool    // drive the pin high when asserted, rather than low
+	Latch      bool
+}
+
+// Quantity returns current, voltage or power: what the function compares.
+func (a Alert) Quantity() string {
+	_, quantity, _ := strings.Cut(a.Function, "-")
+	return quantity
+}
+
+// Over reports whether the function asserts the pin above the limit, rather
+// than below it.
+func (a Alert) Over() bool {
+	return strings.HasPrefix(a.Function, "over-")
+}
+
+// Registers returns the Mask/Enable and Alert Limit register values of a, with
+// the limit in the raw units of scale.
+func (a Alert) Registers(scale Scale) (maskEnable, limit uint16, err error) {
+	bit, ok := AlertFunctions[a.Function]
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid alert function %q: must be one of %s", a.Function, strings.Join(AlertFunctionNames(), ", "))
+	}
+	maskEnable = bit
+	if a.ActiveHigh {
+		maskEnable |= MaskEnableAPOL
+	}
+	if a.Latch {
+		maskEnable |= MaskEnableLEN
+	}
+	var raw, lo, hi float64
+	switch a.Quantity() {
+	case "current":
+		// Compared with the Current register, so negative limits are two's complement
+		raw, lo, hi = a.Limit*1000/scale.CurrentLSB, math.MinInt16, math.MaxInt16
+	case "voltage":
+		raw, lo, hi = a.Limit*1000/scale.VoltageLSB, 0, math.MaxInt16
+	default:
+		raw, lo, hi = a.Limit*1000/scale.PowerLSB, 0, math.MaxUint16
+	}
+	raw = math.Round(raw)
+	if raw < lo || raw > hi {
+		return 0, 0, fmt.Errorf("alert limit %g is out of the %s range", a.Limit, a.Quantity())
+	}
+	if raw < 0 {
+		return maskEnable, uint16(int16(raw)), nil
+	}
+	return maskEnable, uint16(raw), nil
+}
+
+// ConfigureAlert writes the Alert Limit register, then the Mask/Enable register
+// that enables the alert function.
+func (s *Sensor) ConfigureAlert(a Alert) error {
+	maskEnable, limit, err := a.Registers(s.scale())
+	if err != nil {
+		return err
+	}
+	if err := s.writeReg(RegAlertLimit, limit); err != nil {
+		return fmt.Errorf("failed to write Alert Limit register: %w", err)
+	}
+	if err := s.writeReg(RegMaskEnable, maskEnable); err != nil {
+		return fmt.Errorf("failed to write Mask/Enable register: %w", err)
+	}
+	return nil
+}
+
+// AlertFlag reads the Alert Function Flag of the Mask/Enable register. Reading
+// the register clears a latched alert.
+func (s *Sensor) AlertFlag() (bool, error) {
+	maskEnable, err := s.ReadReg(RegMaskEnable)
+	if err != nil {
+		return false, fmt.Errorf("failed to read Mask/Enable register: %w", err)
+	}
+	return maskEnable&MaskEnableAFF != 0, nil
+}
@@ -0,0 +1,84 @@
+package ina260
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CaptureConfig is the fastest setting of the Configuration register: no
+// averaging and the shortest conversion times, converting continuously, so a
+// new current and bus voltage result is ready every 280µs.
+var CaptureConfig = ConfigChange{Averaging: 1, BusConversion: 140 * time.Microsecond, ShuntConversion: 140 * time.Microsecond, Mode: "continuous"}
+
+// Sample is one capture sample.
+type Sample struct {
+	Offset  time.Duration // since the start of the capture
+	Current float64       // Amperes
+	Voltage float64       // Volts
+}
+
+// Capture switches the sensor to CaptureConfig and reads the Current and Bus
+// Voltage registers once per conversion for duration, or until ctx is
+// cancelled, then restores the Configuration register. Reading faster would
+// only repeat results; when the bus is slower than the conversions, as at
+// 100 kHz, samples are read back to back. The Power register is left out to
+// keep the rate up. The samples read before a failure are returned along with
+// the error. progress, if not nil, is called after each sample, so a caller
+// watching for a stuck bus can tell a long capture from a hung one.
+func (s *Sensor) Capture(ctx context.Context, duration time.Duration, progress func()) (samples []Sample, err error) {
+	saved, err := s.ReadReg(RegConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Configuration register: %w", err)
+	}
+	config, err := s.Configure(CaptureConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr := s.writeReg(RegConfig, saved); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to restore Configuration register: %w", rerr))
+		}
+	}()
+	time.Sleep(ConversionDuration(saved) + 280*time.Microsecond) // let the conversion under way in the old setting end
+	scale := s.scale()
+	period := ConversionDuration(config)
+	start := time.Now()
+	for next, elapsed := time.Duration(0), time.Duration(0); elapsed < duration && ctx.Err() == nil; elapsed = time.Since(start) {
+		if elapsed < next {
+			time.Sleep(next - elapsed)
+			elapsed = time.Since(start)
+		}
+		next = max(next, elapsed) + period
+		current, err := s.ReadReg(RegCurrent)
+		if err != nil {
+			return samples, fmt.Errorf("failed to read current: %w", err)
+		}
+		voltage, err := s.ReadReg(RegBusVoltage)
+		if err != nil {
+			return samples, fmt.Errorf("failed to read bus voltage: %w", err)
+		}
+		samples = append(samples, Sample{Offset: elapsed, Current: scale.Milliamps(current) / 1000, Voltage: scale.Millivolts(voltage) / 1000})
+		if progress != nil {
+			progress()
+		}
+	}
+	return samples, nil
+}
+
+// WriteCaptureCSV writes samples as seconds,voltage,current,power records after
+// a header line, where seconds is the offset from the start of the capture and
+// power is voltage times current.
+func WriteCaptureCSV(w io.Writer, samples []Sample) error {
+	if _, err := io.WriteString(w, "seconds,voltage,current,power\n"); err != nil {
+		return err
+	}
+	for _, s := range samples {
+		if _, err := fmt.Fprintf(w, "%.6f,%.5f,%.5f,%.5f\n", s.Offset.Seconds(), s.Voltage, s.Current, s.Voltage*s.Current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
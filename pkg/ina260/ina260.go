@@ -0,0 +1,363 @@
+// Package ina260 reads the TI INA260 precision current and power monitor over I2C:
+// its register map, raw register access and the scaling of raw values to SI units.
+package ina260
+
+import (
+	"encoding/binary" // For binary.BigEndian
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// Address is the default INA260 I2C address, with A0 and A1 tied to GND.
+const Address = uint16(0x40)
+
+// Register Addresses
+const (
+	RegConfig     byte = 0x00 // Configuration Register
+	RegCurrent    byte = 0x01 // Current Register
+	RegBusVoltage byte = 0x02 // Bus Voltage Register
+	RegPower      byte = 0x03 // Power Register
+	RegMaskEnable byte = 0x06 // Mask/Enable Register
+	RegAlertLimit byte = 0x07 // Alert Limit Register
+	RegManufID    byte = 0xFE // Manufacturer ID Register
+	RegDeviceID   byte = 0xFF // Device ID Register
+)
+
+// Identification register values this package expects. The Device ID register
+// carries the die revision in its low four bits, which RevisionMask clears
+// before comparing with DeviceID.
+const (
+	ManufacturerID uint16 = 0x5449 // "TI"
+	DeviceID       uint16 = 0x2270
+	RevisionMask   uint16 = 0x000F
+)
+
+// Field is a bit field of an INA260 register, spanning bits High..Low.
+type Field struct {
+	Name        string
+	High, Low   uint
+	Description string
+}
+
+// Register describes one INA260 register, as documented in the datasheet.
+type Register struct {
+	Addr        byte
+	Name        string
+	Access      string // "R" or "R/W"
+	Description string
+	Fields      []Field
+}
+
+// Registers lists every register of the INA260 in address order.
+var Registers = []Register{
+	{RegConfig, "config", "R/W", "Configuration: averaging, conversion times and operating mode (reset 0x6127)", []Field{
+		{"RST", 15, 15, "Write 1 to reset all registers to defaults"},
+		{"AVG", 11, 9, "Averaging count: 1, 4, 16, 64, 128, 256, 512, 1024"},
+		{"VBUSCT", 8, 6, "Bus voltage conversion time: 140us to 8.244ms"},
+		{"ISHCT", 5, 3, "Shunt current conversion time: 140us to 8.244ms"},
+		{"MODE", 2, 0, "Operating mode: power-down, triggered or continuous; current, voltage or both"},
+	}},
+	{RegCurrent, "current", "R", "Shunt current, two's complement, 1.25 mA/LSB", nil},
+	{RegBusVoltage, "bus_voltage", "R", "Bus voltage, 1.25 mV/LSB, D15 always 0 (full scale ~40.96 V)", nil},
+	{RegPower, "power", "R", "Power, 10 mW/LSB", nil},
+	{RegMaskEnable, "mask_enable", "R/W", "Alert function selection and status flags", []Field{
+		{"OCL", 15, 15, "Alert on over current limit"},
+		{"UCL", 14, 14, "Alert on under current limit"},
+		{"BOL", 13, 13, "Alert on bus voltage over limit"},
+		{"BUL", 12, 12, "Alert on bus voltage under limit"},
+		{"POL", 11, 11, "Alert on power over limit"},
+		{"CNVR", 10, 10, "Alert on conversion ready"},
+		{"AFF", 4, 4, "Alert function flag (read only)"},
+		{"CVRF", 3, 3, "Conversion ready flag (read only)"},
+		{"OVF", 2, 2, "Math overflow flag (read only)"},
+		{"APOL", 1, 1, "Alert pin polarity: 0 active low, 1 active high"},
+		{"LEN", 0, 0, "Alert latch enable"},
+	}},
+	{RegAlertLimit, "alert_limit", "R/W", "Limit compared against the alert function selected in mask_enable", nil},
+	{RegManufID, "manufacturer_id", "R", "Manufacturer ID, 0x5449 (\"TI\")", nil},
+	{RegDeviceID, "device_id", "R", "Die ID", []Field{
+		{"DID", 15, 4, "Device identification bits"},
+		{"RID", 3, 0, "Die revision"},
+	}},
+}
+
+// Bits formats the bit span of a field, e.g. "11:9" or "15".
+func (f Field) Bits() string {
+	if f.High == f.Low {
+		return strconv.Itoa(int(f.High))
+	}
+	return fmt.Sprintf("%d:%d", f.High, f.Low)
+}
+
+// PrintRegisters writes the register map as a table, one row per register
+// followed by one row per bit field.
+func PrintRegisters(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDR\tNAME\tACCESS\tBITS\tDESCRIPTION")
+	for _, reg := range Registers {
+		fmt.Fprintf(tw, "0x%02X\t%s\t%s\t15:0\t%s\n", reg.Addr, reg.Name, reg.Access, reg.Description)
+		for _, field := range reg.Fields {
+			fmt.Fprintf(tw, "\t  %s\t\t%s\t%s\n", field.Name, field.Bits(), field.Description)
+		}
+	}
+	return tw.Flush()
+}
+
+// RegisterByName looks up a register address by its Registers name.
+func RegisterByName(name string) (byte, bool) {
+	for _, reg := range Registers {
+		if reg.Name == name {
+			return reg.Addr, true
+		}
+	}
+	return 0, false
+}
+
+// Scaling Factors
+const (
+	VoltageLSB = 1.25 // mV/LSB for Bus Voltage Register
+	CurrentLSB = 1.25 // mA/LSB for Current Register
+	PowerLSB   = 10.0 // mW/LSB for Power Register
+
+	CurrentLSBMicroamps = 1250 // µA/LSB for Current Register, exact integer form of CurrentLSB
+)
+
+// Configuration and Mask/Enable register fields
+const (
+	ConfigModeMask    uint16 = 0x0007 // MODE bits 2:0
+	ModeTriggeredBoth uint16 = 0x0003 // Shunt current and bus voltage, triggered
+	MaskEnableCVRF    uint16 = 1 << 3 // Conversion Ready Flag
+)
+
+// WritableBits masks the bits of each writable register that read back as
+// written; RST self-clears, the Configuration bits 14:12 are fixed and the
+// Mask/Enable status flags are read-only.
+var WritableBits = map[byte]uint16{
+	RegConfig:     0x0FFF,
+	RegMaskEnable: 0xFC03,
+	RegAlertLimit: 0xFFFF,
+}
+
+// Averaging counts and conversion times, indexed by the 3-bit AVG, VBUSCT and
+// ISHCT field codes of the Configuration register.
+var (
+	averagingCounts = [8]int{1, 4, 16, 64, 128, 256, 512, 1024}
+	conversionTimes = [8]time.Duration{
+		140 * time.Microsecond, 204 * time.Microsecond, 332 * time.Microsecond, 588 * time.Microsecond,
+		1100 * time.Microsecond, 2116 * time.Microsecond, 4156 * time.Microsecond, 8244 * time.Microsecond,
+	}
+)
+
+// ConversionDuration returns how long one complete shunt-current plus
+// bus-voltage conversion takes with the given Configuration register value.
+func ConversionDuration(config uint16) time.Duration {
+	avg := averagingCounts[(config>>9)&0x7]
+	vbusct := conversionTimes[(config>>6)&0x7]
+	ishct := conversionTimes[(config>>3)&0x7]
+	return time.Duration(avg) * (vbusct + ishct)
+}
+
+// Configuration register field positions and the fixed bits 14:12, which read 110
+const (
+	configAvgShift    = 9
+	configVbusctShift = 6
+	configIshctShift  = 3
+	configFieldMask   = 0x7
+	configFixedBits   = 0x6000
+)
+
+// ModeNames maps the operating mode names accepted by ConfigChange to MODE field values.
+var ModeNames = map[string]uint16{
+	"power-down":       0x0,
+	"shunt-triggered":  0x1,
+	"bus-triggered":    0x2,
+	"triggered":        ModeTriggeredBoth,
+	"shunt-continuous": 0x5,
+	"bus-continuous":   0x6,
+	"continuous":       0x7,
+}
+
+// ConfigChange selects Configuration register fields to change at startup. Zero
+// fields keep the value the register already holds.
+type ConfigChange struct {
+	Averaging       int           // samples averaged: 1, 4, 16, 64, 128, 256, 512 or 1024
+	BusConversion   time.Duration // bus voltage conversion time: 140µs, 204µs, 332µs, 588µs, 1.1ms, 2.116ms, 4.156ms or 8.244ms
+	ShuntConversion time.Duration // shunt current conversion time, from the same list
+	Mode            string        // operating mode, one of ModeNames
+}
+
+// IsZero reports whether c changes nothing.
+func (c ConfigChange) IsZero() bool {
+	return c == ConfigChange{}
+}
+
+// fieldCode returns the index of want in table, the 3-bit code of that setting.
+func fieldCode[T comparable](table [8]T, want T, name string) (uint16, error) {
+	for code, v := range table {
+		if v == want {
+			return uint16(code), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported %s %v: must be one of %v", name, want, table)
+}
+
+// Apply returns config with the fields selected by c replaced, or an error if a
+// field has a value the INA260 does not support.
+func (c ConfigChange) Apply(config uint16) (uint16, error) {
+	set := func(shift int, code uint16) {
+		config = config&^(configFieldMask<<shift) | code<<shift
+	}
+	if c.Averaging != 0 {
+		code, err := fieldCode(averagingCounts, c.Averaging, "averaging count")
+		if err != nil {
+			return 0, err
+		}
+		set(configAvgShift, code)
+	}
+	if c.BusConversion != 0 {
+		code, err := fieldCode(conversionTimes, c.BusConversion, "bus voltage conversion time")
+		if err != nil {
+			return 0, err
+		}
+		set(configVbusctShift, code)
+	}
+	if c.ShuntConversion != 0 {
+		code, err := fieldCode(conversionTimes, c.ShuntConversion, "shunt current conversion time")
+		if err != nil {
+			return 0, err
+		}
+		set(configIshctShift, code)
+	}
+	if c.Mode != "" {
+		mode, ok := ModeNames[c.Mode]
+		if !ok {
+			return 0, fmt.Errorf("unknown operating mode %q", c.Mode)
+		}
+		config = config&^ConfigModeMask | mode
+	}
+	// Never set RST, and keep the fixed bits as the register reports them
+	return config&WritableBits[RegConfig] | configFixedBits, nil
+}
+
+// Scale holds the LSB weights used to convert one sensor's raw register values.
+// Clones with slightly off internal shunts can be corrected by overriding them.
+type Scale struct {
+	VoltageLSB float64 // mV/LSB for Bus Voltage Register
+	CurrentLSB float64 // mA/LSB for Current Register
+	PowerLSB   float64 // mW/LSB for Power Register
+}
+
+// DefaultScale uses the datasheet scaling factors.
+var DefaultScale = Scale{VoltageLSB: VoltageLSB, CurrentLSB: CurrentLSB, PowerLSB: PowerLSB}
+
+// Validate checks that every LSB weight is positive.
+func (s Scale) Validate() error {
+	if s.VoltageLSB <= 0 || s.CurrentLSB <= 0 || s.PowerLSB <= 0 {
+		return fmt.Errorf("LSB values must be positive, got voltage=%g mV current=%g mA power=%g mW", s.VoltageLSB, s.CurrentLSB, s.PowerLSB)
+	}
+	return nil
+}
+
+// Milliamps converts a raw Current Register value to milliamperes. The register
+// is a 16-bit two's complement signed integer, so it is cast to int16 to keep the sign.
+func (s Scale) Milliamps(raw uint16) float64 { return float64(int16(raw)) * s.CurrentLSB }
+
+// Direction returns the sign of a current in Amperes: 1 when it flows from IN+
+// to IN-, as into a load or out of a discharging battery, -1 when it flows back,
+// as into a charging battery, and 0 within deadband of zero, where the sign is
+// only noise.
+func Direction(current, deadband float64) int {
+	switch {
+	case current > deadband:
+		return 1
+	case current < -deadband:
+		return -1
+	}
+	return 0
+}
+
+// Millivolts converts a raw Bus Voltage Register value to millivolts.
+func (s Scale) Millivolts(raw uint16) float64 { return float64(raw) * s.VoltageLSB }
+
+// Milliwatts converts a raw Power Register value to milliwatts.
+func (s Scale) Milliwatts(raw uint16) float64 { return float64(raw) * s.PowerLSB }
+
+// Microamps converts a raw Current Register value to microamperes using
+// integer math, so the result is always an exact multiple of the LSB
+// (rounded to a whole microamp when the LSB is overridden).
+func (s Scale) Microamps(raw uint16) int64 {
+	lsb := int64(CurrentLSBMicroamps)
+	if s.CurrentLSB != CurrentLSB {
+		lsb = int64(math.Round(s.CurrentLSB * 1000))
+	}
+	return int64(int16(raw)) * lsb
+}
+
+// Bus Voltage Register range
+const (
+	BusVoltageFullScale uint16 = 0x7FFF // Full-scale code (D15 is always 0), ~40.96 V
+	BusVoltageSatMargin uint16 = 0x0020 // Codes within this margin of full scale count as saturated (~40 mV)
+)
+
+// IsVoltageSaturated reports whether a raw bus voltage code is at or approaching
+// the register's full-scale value, meaning the real voltage may be clamped.
+func IsVoltageSaturated(rawVoltage uint16) bool {
+	return rawVoltage >= BusVoltageFullScale-BusVoltageSatMargin
+}
+
+// ReadReg reads a 16-bit value from the specified INA260 register.
+// The INA260 returns data in Big-Endian format.
+func ReadReg(dev *i2c.Dev, reg byte) (uint16, error) {
+	writeBuf := []byte{reg}
+	readBuf := make([]byte, 2) // 16-bit (2 bytes)
+
+	// Perform the transaction: write register address, then read 2 bytes
+	if err := dev.Tx(writeBuf, readBuf); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(readBuf), nil
+}
+
+// WriteReg writes a 16-bit value to the specified INA260 register in Big-Endian format.
+func WriteReg(dev *i2c.Dev, reg byte, value uint16) error {
+	writeBuf := []byte{reg, 0, 0}
+	binary.BigEndian.PutUint16(writeBuf[1:], value)
+	return dev.Tx(writeBuf, nil)
+}
+
+// ReadRegTimeout is ReadReg bounded by timeout; 0 waits indefinitely.
+// A transfer that times out keeps running in the background; the kernel i2c-dev
+// driver serializes it with any later transfer on the same adapter.
+func ReadRegTimeout(dev *i2c.Dev, reg byte, timeout time.Duration) (uint16, error) {
+	if timeout <= 0 {
+		return ReadReg(dev, reg)
+	}
+	type result struct {
+		value uint16
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := ReadReg(dev, reg)
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("read of register 0x%02X timed out after %s", reg, timeout)
+	}
+}
+
+// Probe checks that the INA260 ACKs its address with a single-byte write
+// of the register pointer, which is cheaper than a full read and changes no settings.
+func Probe(dev *i2c.Dev) error {
+	return dev.Tx([]byte{RegManufID}, nil)
+}
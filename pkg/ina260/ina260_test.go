@@ -0,0 +1,90 @@
+package ina260
+
+import "testing"
+
+func TestScaleMicroamps(t *testing.T) {
+	custom := DefaultScale
+	custom.CurrentLSB = 1.3 // a clone with a trimmed shunt
+	tests := []struct {
+		name  string
+		scale Scale
+		raw   uint16
+		want  int64
+	}{
+		{"zero", DefaultScale, 0x0000, 0},
+		{"one LSB", DefaultScale, 0x0001, 1250},
+		{"positive full scale", DefaultScale, 0x7FFF, 32767 * 1250},
+		{"negative full scale", DefaultScale, 0x8000, -32768 * 1250},
+		{"minus one LSB", DefaultScale, 0xFFFF, -1250},
+		{"overridden one LSB", custom, 0x0001, 1300},
+		{"overridden positive full scale", custom, 0x7FFF, 32767 * 1300},
+		{"overridden negative full scale", custom, 0x8000, -32768 * 1300},
+		{"overridden minus one LSB", custom, 0xFFFF, -1300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.scale.Microamps(tt.raw)
+			if got != tt.want {
+				t.Errorf("Microamps(0x%04X) = %d, want %d", tt.raw, got, tt.want)
+			}
+			if lsb := int64(tt.scale.CurrentLSB * 1000); got%lsb != 0 {
+				t.Errorf("Microamps(0x%04X) = %d is not a multiple of the %d µA LSB", tt.raw, got, lsb)
+			}
+		})
+	}
+}
+
+func TestScaleMilliamps(t *testing.T) {
+	tests := []struct {
+		raw  uint16
+		want float64
+	}{
+		{0x0000, 0},
+		{0x0001, 1.25},
+		{0x7FFF, 32767 * 1.25},
+		{0x8000, -32768 * 1.25}, // most negative, not +32768
+		{0x8001, -32767 * 1.25},
+		{0xFFFF, -1.25},
+		{0xFF38, -200 * 1.25},
+	}
+	for _, tt := range tests {
+		if got := DefaultScale.Milliamps(tt.raw); got != tt.want {
+			t.Errorf("Milliamps(0x%04X) = %g, want %g", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestDirection(t *testing.T) {
+	const deadband = 0.01
+	tests := []struct {
+		name    string
+		current float64
+		want    int
+	}{
+		{"zero", 0, 0},
+		{"within the deadband", 0.005, 0},
+		{"within the deadband, negative", -0.005, 0},
+		{"at the deadband", deadband, 0},
+		{"at the deadband, negative", -deadband, 0},
+		{"above the deadband", 0.0125, 1},
+		{"below the deadband", -0.0125, -1},
+		{"one LSB", DefaultScale.Milliamps(0x0001) / 1000, 0},
+		{"minus one LSB", DefaultScale.Milliamps(0xFFFF) / 1000, 0},
+		{"positive full scale", DefaultScale.Milliamps(0x7FFF) / 1000, 1},
+		{"negative full scale", DefaultScale.Milliamps(0x8000) / 1000, -1},
+	}
+	for _, tt := range tests {
+		if got := Direction(tt.current, deadband); got != tt.want {
+			t.Errorf("%s: Direction(%g, %g) = %d, want %d", tt.name, tt.current, deadband, got, tt.want)
+		}
+	}
+	// Without a deadband any current other than zero has a sign
+	for _, tt := range []struct {
+		raw  uint16
+		want int
+	}{{0x0000, 0}, {0x0001, 1}, {0xFFFF, -1}, {0x8000, -1}} {
+		if got := Direction(DefaultScale.Milliamps(tt.raw)/1000, 0); got != tt.want {
+			t.Errorf("Direction of raw 0x%04X without a deadband = %d, want %d", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package ina260
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// Reading holds one set of raw register values and their scaled measurements.
+type Reading struct {
+	Time       time.Time // when the reading was taken; set by the caller, carries the monotonic clock
+	RawCurrent uint16
+	RawVoltage uint16
+	RawPower   uint16
+	Current    float64 // Amperes
+	Voltage    float64 // Volts
+	Power      float64 // Watts
+}
+
+// Timeouts holds the read timeout of each register.
+type Timeouts struct {
+	Global    time.Duration          // used for registers without an override; 0 waits indefinitely
+	Overrides map[byte]time.Duration // per-register timeouts
+}
+
+// ForRegister returns the timeout to use when reading reg.
+func (t Timeouts) ForRegister(reg byte) time.Duration {
+	if timeout, ok := t.Overrides[reg]; ok {
+		return timeout
+	}
+	return t.Global
+}
+
+// ParseTimeouts parses a comma-separated list of register=duration pairs
+// such as "current=5ms,power=10ms", using the register names of Registers.
+func ParseTimeouts(spec string) (map[byte]time.Duration, error) {
+	overrides := make(map[byte]time.Duration)
+	if spec == "" {
+		return overrides, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q: expected register=duration", pair)
+		}
+		addr, ok := RegisterByName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown register %q", name)
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for register %s: %w", name, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("timeout for register %s must be positive, got %s", name, timeout)
+		}
+		overrides[addr] = timeout
+	}
+	return overrides, nil
+}
+
+// Retry delays: the first retry of a failed register transaction waits
+// Sensor.RetryBackoff, or defaultRetryBackoff when that is unset, and each
+// further retry doubles the delay up to maxRetryBackoff.
+const (
+	defaultRetryBackoff = 10 * time.Millisecond
+	maxRetryBackoff     = time.Second
+)
+
+// Sensor is one INA260 together with the settings used to read it. The zero
+// value of every field but Dev is usable; Scale is then DefaultScale.
+//
+// A Sensor does not select a multiplexer channel or lock the bus; callers sharing
+// the bus with other devices do both around each call.
+type Sensor struct {
+	Dev      *i2c.Dev
+	Scale    Scale // LSB weights for this sensor
+	Retries  int   // extra attempts per register read or write after a failure
+	Timeouts Timeouts
+
+	// RetryBackoff is the delay before the first retry; it doubles for every
+	// further retry, up to one second. 0 uses 10ms.
+	RetryBackoff time.Duration
+
+	// VerifyWrites reads back every register write and reports a mismatch in
+	// the writable bits to OnWriteMismatch. Some clone chips silently ignore
+	// writes to certain bits; a mismatch is not treated as an error.
+	VerifyWrites    bool
+	OnWriteMismatch func(reg byte, wrote, readBack, mask uint16)
+
+	// OnConfigure, if set, is told about every Configuration register write of
+	// Configure, and whether reading the register back showed it took.
+	OnConfigure func(verified bool)
+
+	// OnReadRetries, if set, is told how many retries each successful register read needed.
+	OnReadRetries func(retries int)
+
+	// OnTransactionError, if set, is told about every failed register read or
+	// write attempt, including the ones a retry then recovers from.
+	OnTransactionError func(reg byte, err error)
+
+	// OnRegisterRead, if set, is told about every register read when it ends,
+	// with when it started, retries included, and its error, for tracing.
+	OnRegisterRead func(reg byte, start time.Time, err error)
+}
+
+func (s *Sensor) scale() Scale {
+	if s.Scale == (Scale{}) {
+		return DefaultScale
+	}
+	return s.Scale
+}
+
+// retry runs one register transaction, retrying up to s.Retries more times with
+// exponential backoff on error. It returns the number of retries used.
+func (s *Sensor) retry(reg byte, tx func() error) (int, error) {
+	delay := s.RetryBackoff
+	if delay <= 0 {
+		delay = defaultRetryBackoff
+	}
+	var err error
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay = min(2*delay, maxRetryBackoff)
+		}
+		if err = tx(); err == nil {
+			return attempt, nil
+		}
+		if s.OnTransactionError != nil {
+			s.OnTransactionError(reg, err)
+		}
+	}
+	return s.Retries, err
+}
+
+// ReadReg reads a register, retrying up to s.Retries more times on error.
+func (s *Sensor) ReadReg(reg byte) (uint16, error) {
+	var value uint16
+	start := time.Now()
+	retries, err := s.retry(reg, func() (err error) {
+		value, err = ReadRegTimeout(s.Dev, reg, s.Timeouts.ForRegister(reg))
+		return err
+	})
+	if s.OnRegisterRead != nil {
+		s.OnRegisterRead(reg, start, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if s.OnReadRetries != nil {
+		s.OnReadRetries(retries)
+	}
+	return value, nil
+}
+
+// writeReg writes a register, retrying up to s.Retries more times on error.
+func (s *Sensor) writeReg(reg byte, value uint16) error {
+	_, err := s.retry(reg, func() error { return WriteReg(s.Dev, reg, value) })
+	return err
+}
+
+// WriteReg writes a register, retrying up to s.Retries more times on error, and
+// if s.VerifyWrites is set, reads it back.
+func (s *Sensor) WriteReg(reg byte, value uint16) error {
+	if err := s.writeReg(reg, value); err != nil {
+		return err
+	}
+	if !s.VerifyWrites {
+		return nil
+	}
+	readBack, err := s.ReadReg(reg)
+	if err != nil {
+		return fmt.Errorf("failed to read back register 0x%02X: %w", reg, err)
+	}
+	mask, ok := WritableBits[reg]
+	if !ok {
+		mask = 0xFFFF
+	}
+	if readBack&mask != value&mask && s.OnWriteMismatch != nil {
+		s.OnWriteMismatch(reg, value, readBack, mask)
+	}
+	return nil
+}
+
+// Configure applies c to the Configuration register and reads it back, failing
+// if the writable bits did not take. It returns the value now in effect.
+func (s *Sensor) Configure(c ConfigChange) (uint16, error) {
+	config, err := s.ReadReg(RegConfig)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read Configuration register: %w", err)
+	}
+	want, err := c.Apply(config)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.writeReg(RegConfig, want); err != nil {
+		return 0, fmt.Errorf("failed to write Configuration register: %w", err)
+	}
+	got, err := s.ReadReg(RegConfig)
+	mask := WritableBits[RegConfig]
+	if s.OnConfigure != nil {
+		s.OnConfigure(err == nil && got&mask == want&mask)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read back Configuration register: %w", err)
+	}
+	if got&mask != want&mask {
+		return got, fmt.Errorf("wrote 0x%04X to the Configuration register but read back 0x%04X", want, got)
+	}
+	return got, nil
+}
+
+// Read reads the Current, Bus Voltage and Power registers and scales them to SI
+// units. The returned reading has no Time; the caller stamps it.
+func (s *Sensor) Read() (Reading, error) {
+	var r Reading
+	var err error
+
+	// Read Current (Register 0x01)
+	if r.RawCurrent, err = s.ReadReg(RegCurrent); err != nil {
+		return r, fmt.Errorf("failed to read current: %w", err)
+	}
+	// Read Voltage (Register 0x02)
+	if r.RawVoltage, err = s.ReadReg(RegBusVoltage); err != nil {
+		return r, fmt.Errorf("failed to read bus voltage: %w", err)
+	}
+	// Read Power (Register 0x03)
+	if r.RawPower, err = s.ReadReg(RegPower); err != nil {
+		return r, fmt.Errorf("failed to read power: %w", err)
+	}
+
+	// Convert the milli-unit register values to Amperes, Volts and Watts
+	scale := s.scale()
+	r.Current = scale.Milliamps(r.RawCurrent) / 1000.0
+	r.Voltage = scale.Millivolts(r.RawVoltage) / 1000.0
+	r.Power = scale.Milliwatts(r.RawPower) / 1000.0
+	return r, nil
+}
+
+// ReadCoincident triggers a single shunt-current and bus-voltage conversion and
+// reads its results, so current, voltage and power all come from the same
+// measurement window. config is the Configuration register value whose averaging
+// and conversion times are kept; its operating mode is replaced by triggered mode,
+// which the INA260 stays in afterwards.
+func (s *Sensor) ReadCoincident(config uint16) (Reading, error) {
+	// Writing the Configuration register with a triggered mode starts one conversion
+	trigger := config&^ConfigModeMask | ModeTriggeredBoth
+	if err := s.WriteReg(RegConfig, trigger); err != nil {
+		return Reading{}, fmt.Errorf("failed to trigger conversion: %w", err)
+	}
+
+	// Poll the Conversion Ready flag; reading Mask/Enable also clears it for the next trigger
+	expected := ConversionDuration(trigger)
+	deadline := time.Now().Add(2*expected + 10*time.Millisecond)
+	pollEvery := max(expected/8, 100*time.Microsecond)
+	for {
+		maskEnable, err := s.ReadReg(RegMaskEnable)
+		if err != nil {
+			return Reading{}, fmt.Errorf("failed to read conversion ready flag: %w", err)
+		}
+		if maskEnable&MaskEnableCVRF != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return Reading{}, fmt.Errorf("conversion not ready after %s (expected %s)", 2*expected+10*time.Millisecond, expected)
+		}
+		time.Sleep(pollEvery)
+	}
+	return s.Read()
+}
@@ -0,0 +1,46 @@
+package ina260
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/physic"
+)
+
+// fakeBus is an INA260 on a bus whose register writes either take or, with
+// ignoreWrites set, are silently dropped, as by some clone chips.
+type fakeBus struct {
+	regs         map[byte]uint16
+	ignoreWrites bool
+}
+
+func (b *fakeBus) String() string                  { return "fake" }
+func (b *fakeBus) SetSpeed(physic.Frequency) error { return nil }
+func (b *fakeBus) Tx(_ uint16, w, r []byte) error {
+	if len(w) == 3 {
+		if !b.ignoreWrites {
+			b.regs[w[0]] = binary.BigEndian.Uint16(w[1:])
+		}
+		return nil
+	}
+	binary.BigEndian.PutUint16(r, b.regs[w[0]])
+	return nil
+}
+
+// TestConfigureReportsVerification checks that Configure reports every write
+// of the Configuration register to OnConfigure, and whether it read back.
+func TestConfigureReportsVerification(t *testing.T) {
+	for _, ignoreWrites := range []bool{false, true} {
+		bus := &fakeBus{regs: map[byte]uint16{RegConfig: 0x6127}, ignoreWrites: ignoreWrites}
+		var calls []bool
+		s := &Sensor{Dev: &i2c.Dev{Bus: bus, Addr: Address}, OnConfigure: func(verified bool) { calls = append(calls, verified) }}
+		_, err := s.Configure(ConfigChange{Averaging: 16})
+		if (err != nil) != ignoreWrites {
+			t.Errorf("ignoreWrites=%v: Configure error = %v", ignoreWrites, err)
+		}
+		if len(calls) != 1 || calls[0] == ignoreWrites {
+			t.Errorf("ignoreWrites=%v: OnConfigure calls %v, want [%v]", ignoreWrites, calls, !ignoreWrites)
+		}
+	}
+}
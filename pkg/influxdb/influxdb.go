@@ -0,0 +1,291 @@
+// Package influxdb writes readings to an InfluxDB v2 server as line protocol, in
+// batches.
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/spool"
+)
+
+// Options configure an InfluxDB sink.
+type Options struct {
+	URL           string        // server URL, e.g. http://localhost:8086
+	Token         string        // API token with write access to the bucket
+	Org           string        // organization name or ID
+	Bucket        string        // bucket name or ID
+	Measurement   string        // measurement of every point; empty uses ina260
+	FlushInterval time.Duration // how often buffered points are written
+	BatchSize     int           // points per write; a full batch is written right away
+	Spool         *spool.Spool  // keeps the batches beyond the buffer, and the ones left at Close, for replay; nil drops them
+}
+
+// influxMaxBuffered bounds the points kept while the server is unreachable, in
+// batches; older points are dropped beyond it.
+const influxMaxBuffered = 10
+
+// influxWriteTimeout bounds each write request.
+const influxWriteTimeout = 10 * time.Second
+
+// influxSink writes readings to the InfluxDB v2 HTTP API in line protocol, e.g.
+//
+//	ina260,hostname=pi,device=cpu_rail voltage=5.01,current=0.52,power=2.6 1718000000123456000
+//
+// Points are buffered and written in batches from a background goroutine, so
+// polling never waits for the server. Points that fail to be written are kept for
+// the next attempt, up to influxMaxBuffered batches; the ones dropped beyond that
+// are counted as write errors. With a Spool, the oldest batch beyond that goes to
+// the spool instead, and spooled batches are written first on every flush. Their
+// points keep their timestamps, and InfluxDB overwrites a point it already has,
+// so a batch written twice does no harm.
+type influxSink struct {
+	opts     Options
+	writeURL string
+	client   *http.Client
+
+	mu      sync.Mutex
+	lines   [][]byte
+	dropped uint64        // points dropped from the front of lines so far
+	flushCh chan struct{} // signalled when a batch is full
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewSink returns a sink writing to the InfluxDB server in opts, and
+// starts flushing in the background. Nothing is sent before the first flush, so
+// an unreachable server does not fail startup.
+func NewSink(opts Options) (exporter.Sink, error) {
+	if opts.URL == "" {
+		return nil, errors.New("no InfluxDB URL given")
+	}
+	if opts.Org == "" || opts.Bucket == "" {
+		return nil, errors.New("the InfluxDB org and bucket are required")
+	}
+	if opts.FlushInterval <= 0 {
+		return nil, fmt.Errorf("flush interval must be positive, got %s", opts.FlushInterval)
+	}
+	if opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive, got %d", opts.BatchSize)
+	}
+	if opts.Measurement == "" {
+		opts.Measurement = "ina260"
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q: must be http:// or https:// with a host", opts.URL)
+	}
+	u = u.JoinPath("api", "v2", "write")
+	u.RawQuery = url.Values{"org": {opts.Org}, "bucket": {opts.Bucket}, "precision": {"ns"}}.Encode()
+	k := &influxSink{
+		opts:     opts,
+		writeURL: u.String(),
+		client:   &http.Client{Timeout: influxWriteTimeout},
+		flushCh:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go k.run()
+	return k, nil
+}
+
+func (k *influxSink) Name() string { return "influxdb" }
+
+// influxTagEscaper escapes tag values for line protocol.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func (k *influxSink) Publish(s *exporter.Sensor, r ina260.Reading) error {
+	return k.add(k.line(s, r))
+}
+
+// PublishBatch buffers the points of a batch in one step, so a flush takes all
+// of them or, beyond the batch size, writes them in consecutive requests.
+func (k *influxSink) PublishBatch(batch []exporter.Published) error {
+	lines := make([][]byte, len(batch))
+	for i, p := range batch {
+		lines[i] = k.line(p.Sensor, p.Reading)
+	}
+	return k.add(lines...)
+}
+
+// line returns the line protocol point of a reading.
+func (k *influxSink) line(s *exporter.Sensor, r ina260.Reading) []byte {
+	var b bytes.Buffer
+	b.WriteString(influxTagEscaper.Replace(k.opts.Measurement))
+	b.WriteString(",hostname=" + influxTagEscaper.Replace(s.Hostname))
+	b.WriteString(",device=" + influxTagEscaper.Replace(s.Device))
+	for _, name := range slices.Sorted(maps.Keys(s.Labels)) {
+		b.WriteString("," + influxTagEscaper.Replace(name) + "=" + influxTagEscaper.Replace(s.Labels[name]))
+	}
+	b.WriteString(" voltage=" + strconv.FormatFloat(r.Voltage, 'g', -1, 64))
+	b.WriteString(",current=" + strconv.FormatFloat(r.Current, 'g', -1, 64))
+	b.WriteString(",power=" + strconv.FormatFloat(r.Power, 'g', -1, 64))
+	b.WriteString(" " + strconv.FormatInt(r.Time.UnixNano(), 10) + "\n")
+	return b.Bytes()
+}
+
+// add buffers points for the next flush, dropping or spooling the oldest ones
+// beyond influxMaxBuffered batches.
+func (k *influxSink) add(lines ...[]byte) error {
+	k.mu.Lock()
+	before := len(k.lines)
+	k.lines = append(k.lines, lines...)
+	full := len(k.lines)/k.opts.BatchSize > before/k.opts.BatchSize // once per batch, not on every reading while the server is down
+	var dropped int
+	var spill []byte
+	if limit := influxMaxBuffered * k.opts.BatchSize; len(k.lines) > limit {
+		dropped = len(k.lines) - limit
+		if k.opts.Spool != nil {
+			dropped = max(dropped, k.opts.BatchSize)
+			spill = bytes.Join(k.lines[:dropped], nil)
+		}
+		k.lines = k.lines[dropped:]
+		k.dropped += uint64(dropped)
+	}
+	k.mu.Unlock()
+	if spill != nil {
+		dropped = 0
+		if err := k.opts.Spool.Push(spill); err != nil {
+			return fmt.Errorf("failed to spool points the InfluxDB server has not accepted: %w", err)
+		}
+	}
+	if full {
+		select {
+		case k.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("dropped %d buffered points the InfluxDB server has not accepted", dropped)
+	}
+	return nil
+}
+
+// run flushes every FlushInterval and whenever a batch fills up, until Close.
+func (k *influxSink) run() {
+	defer close(k.stopped)
+	ticker := time.NewTicker(k.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.done:
+			k.flush()
+			return
+		case <-ticker.C:
+		case <-k.flushCh:
+		}
+		k.flush()
+	}
+}
+
+// flush writes the spooled batches, then the buffered points in batches, stopping
+// at the first failure and keeping the points that were not written.
+func (k *influxSink) flush() {
+	if !k.replay() {
+		return
+	}
+	for {
+		k.mu.Lock()
+		n := min(len(k.lines), k.opts.BatchSize)
+		batch := bytes.Join(k.lines[:n], nil)
+		dropped := k.dropped
+		k.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if err := k.write(batch); err != nil {
+			slog.Warn("Failed to write to InfluxDB, keeping the points for the next flush", "url", k.opts.URL, "points", n, "err", err)
+			return
+		}
+		k.mu.Lock()
+		// Publish may have dropped some of the batch from the front in the meantime
+		k.lines = k.lines[max(0, n-int(k.dropped-dropped)):]
+		k.mu.Unlock()
+	}
+}
+
+// replay writes the spooled batches oldest first, and reports whether all of
+// them were written.
+func (k *influxSink) replay() bool {
+	if k.opts.Spool == nil {
+		return true
+	}
+	for {
+		batch, err := k.opts.Spool.Peek()
+		if err != nil {
+			slog.Warn("Failed to read spooled InfluxDB points", "err", err)
+			return false
+		}
+		if batch == nil {
+			return true
+		}
+		if err := k.write(batch); err != nil {
+			slog.Warn("Failed to write spooled points to InfluxDB, keeping them for the next flush", "url", k.opts.URL, "spooled", k.opts.Spool.Len(), "err", err)
+			return false
+		}
+		if err := k.opts.Spool.Pop(); err != nil {
+			slog.Warn("Failed to update the InfluxDB spool", "err", err)
+			return false
+		}
+	}
+}
+
+func (k *influxSink) write(batch []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), influxWriteTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.writeURL, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if k.opts.Token != "" {
+		req.Header.Set("Authorization", "Token "+k.opts.Token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Close writes the buffered points and stops flushing. With a Spool, the points
+// that could not be written are spooled for the next run.
+func (k *influxSink) Close() error {
+	close(k.done)
+	<-k.stopped
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.opts.Spool != nil {
+		defer k.opts.Spool.Close()
+		for len(k.lines) > 0 {
+			n := min(len(k.lines), k.opts.BatchSize)
+			if err := k.opts.Spool.Push(bytes.Join(k.lines[:n], nil)); err != nil {
+				return fmt.Errorf("%d points were neither written to InfluxDB nor spooled: %w", len(k.lines), err)
+			}
+			k.lines = k.lines[n:]
+		}
+	}
+	if len(k.lines) > 0 {
+		return fmt.Errorf("%d points were not written to InfluxDB", len(k.lines))
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package influxdb
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/spool"
+)
+
+// server is an InfluxDB write endpoint that records the body of every request
+// it accepts, and fails the ones while failing is set.
+type server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	failing bool
+	bodies  []string
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("Path = %q, want /api/v2/write", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("org") != "lab" || q.Get("bucket") != "power" || q.Get("precision") != "ns" {
+			t.Errorf("Query = %q, want org lab, bucket power and precision ns", r.URL.RawQuery)
+		}
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %q, want Token secret", got)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.bodies = append(s.bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) fail(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *server) written() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+// newSink returns a sink writing to s in batches of size. It flushes when a
+// batch fills up and on Close, never on its hour-long flush interval.
+func newSink(t *testing.T, s *server, size int, sp *spool.Spool) *influxSink {
+	t.Helper()
+	k, err := NewSink(Options{URL: s.URL, Token: "secret", Org: "lab", Bucket: "power", FlushInterval: time.Hour, BatchSize: size, Spool: sp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.(*influxSink)
+}
+
+// idleSink returns a sink like newSink's that does not flush in the background,
+// for the tests that flush it themselves. It is not closed.
+func idleSink(s *server, size int, sp *spool.Spool) *influxSink {
+	return &influxSink{
+		opts:     Options{URL: s.URL, Token: "secret", Org: "lab", Bucket: "power", Measurement: "ina260", BatchSize: size, Spool: sp},
+		writeURL: s.URL + "/api/v2/write?bucket=power&org=lab&precision=ns",
+		client:   s.Client(),
+		flushCh:  make(chan struct{}, 1),
+	}
+}
+
+func openSpool(t *testing.T, dir string) *spool.Spool {
+	t.Helper()
+	sp, err := spool.Open(dir, "influxdb_test", 1<<16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+var sensor = &exporter.Sensor{Hostname: "pi", Device: "cpu_rail"}
+
+// point returns a line protocol point of sensor for reading i.
+func point(i int) string {
+	return fmt.Sprintf("ina260,hostname=pi,device=cpu_rail voltage=5,current=0.5,power=2.5 %d\n", int64(i)*int64(time.Second))
+}
+
+func reading(i int) ina260.Reading {
+	return ina260.Reading{Time: time.Unix(int64(i), 0), Voltage: 5, Current: 0.5, Power: 2.5}
+}
+
+// batch returns the readings n to m-1 of sensor.
+func batch(n, m int) []exporter.Published {
+	var b []exporter.Published
+	for i := n; i < m; i++ {
+		b = append(b, exporter.Published{Sensor: sensor, Reading: reading(i)})
+	}
+	return b
+}
+
+func points(n, m int) string {
+	var b strings.Builder
+	for i := n; i < m; i++ {
+		b.WriteString(point(i))
+	}
+	return b.String()
+}
+
+func TestLineEscapesTags(t *testing.T) {
+	k := &influxSink{opts: Options{Measurement: "power monitor"}}
+	s := &exporter.Sensor{Hostname: "pi", Device: "cpu rail", Labels: map[string]string{"slot": "a=1", "rack": "row 2,left"}}
+	r := ina260.Reading{Time: time.Unix(1718000000, 123456000), Voltage: 5.01, Current: 0.52, Power: 2.6}
+	// The labels follow the device in name order
+	want := `power\ monitor,hostname=pi,device=cpu\ rail,rack=row\ 2\,left,slot=a\=1 voltage=5.01,current=0.52,power=2.6 1718000000123456000` + "\n"
+	if got := string(k.line(s, r)); got != want {
+		t.Errorf("line = %q, want %q", got, want)
+	}
+}
+
+func TestFlushSplitsBatches(t *testing.T) {
+	s := newServer(t)
+	k := newSink(t, s, 2, nil)
+	if err := k.PublishBatch(batch(0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{points(0, 2), points(2, 4), points(4, 5)}
+	if got := s.written(); !slices.Equal(got, want) {
+		t.Errorf("Written %q, want %q", got, want)
+	}
+}
+
+func TestFlushKeepsPointsOnFailure(t *testing.T) {
+	s := newServer(t)
+	k := idleSink(s, 2, nil)
+	s.fail(true)
+	if err := k.Publish(sensor, reading(0)); err != nil {
+		t.Fatal(err)
+	}
+	k.flush()
+	if len(s.written()) != 0 || len(k.lines) != 1 {
+		t.Fatalf("After a failed flush: written %q, %d points buffered; want none written, 1 buffered", s.written(), len(k.lines))
+	}
+	s.fail(false)
+	k.flush()
+	if got, want := s.written(), []string{point(0)}; !slices.Equal(got, want) || len(k.lines) != 0 {
+		t.Errorf("After the retry: written %q, %d points buffered; want %q, none buffered", got, len(k.lines), want)
+	}
+}
+
+func TestAddDropsOldest(t *testing.T) {
+	s := newServer(t)
+	k := idleSink(s, 1, nil)
+	for i := range influxMaxBuffered {
+		if err := k.Publish(sensor, reading(i)); err != nil {
+			t.Fatalf("Publish(%d): %v", i, err)
+		}
+	}
+	if err := k.Publish(sensor, reading(influxMaxBuffered)); err == nil || !strings.Contains(err.Error(), "dropped 1 ") {
+		t.Errorf("Publish beyond the buffer = %v, want an error dropping 1 point", err)
+	}
+	if len(k.lines) != influxMaxBuffered || string(k.lines[0]) != point(1) || k.dropped != 1 {
+		t.Errorf("Buffered %d points from %q, %d dropped; want %d from %q, 1 dropped", len(k.lines), k.lines[0], k.dropped, influxMaxBuffered, point(1))
+	}
+}
+
+func TestAddSpillsToSpool(t *testing.T) {
+	s := newServer(t)
+	sp := openSpool(t, t.TempDir())
+	defer sp.Close()
+	k := idleSink(s, 2, sp)
+	n := influxMaxBuffered*2 + 1
+	for i := range n {
+		if err := k.Publish(sensor, reading(i)); err != nil {
+			t.Fatalf("Publish(%d): %v", i, err)
+		}
+	}
+	// The oldest batch is spooled as a whole, not just the one point too many
+	if sp.Len() != 1 || len(k.lines) != n-2 {
+		t.Fatalf("Spooled %d batches, buffered %d points; want 1 and %d", sp.Len(), len(k.lines), n-2)
+	}
+	k.flush()
+	want := []string{points(0, 2)} // the spooled batch first
+	for i := 2; i < n; i += 2 {
+		want = append(want, points(i, min(i+2, n)))
+	}
+	if got := s.written(); !slices.Equal(got, want) {
+		t.Errorf("Written %q, want %q", got, want)
+	}
+	if sp.Len() != 0 {
+		t.Errorf("Spool holds %d batches after the flush, want none", sp.Len())
+	}
+}
+
+func TestCloseSpoolsUnwritten(t *testing.T) {
+	s := newServer(t)
+	s.fail(true)
+	dir := t.TempDir()
+	k := newSink(t, s, 2, openSpool(t, dir))
+	if err := k.PublishBatch(batch(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sp := openSpool(t, dir)
+	defer sp.Close()
+	for _, want := range []string{points(0, 2), points(2, 3)} {
+		got, err := sp.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("Spooled %q, want %q", got, want)
+		}
+		if err := sp.Pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sp.Len() != 0 {
+		t.Errorf("Spool holds %d more batches, want none", sp.Len())
+	}
+}
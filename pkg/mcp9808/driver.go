@@ -0,0 +1,35 @@
+package mcp9808
+
+import (
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/driver"
+)
+
+func init() {
+	driver.Register(driver.Driver{
+		Name:    "mcp9808",
+		Address: Address,
+		New:     func(dev *i2c.Dev) driver.Sensor { return &Sensor{Dev: dev} },
+	})
+}
+
+// metrics are the values of Read, shared with the TMP117.
+var metrics = []driver.Metric{
+	{Name: "temperature_celsius", Help: "Temperature measured by an MCP9808 or TMP117 sensor in degrees Celsius."},
+}
+
+// Probe is Init, for driver.Sensor.
+func (s *Sensor) Probe() error { return s.Init() }
+
+// Read returns the temperature, for driver.Sensor.
+func (s *Sensor) Read() ([]float64, error) {
+	celsius, err := s.Temperature()
+	if err != nil {
+		return nil, err
+	}
+	return []float64{celsius}, nil
+}
+
+// Metrics describes the value of Read: temperature_celsius.
+func (s *Sensor) Metrics() []driver.Metric { return metrics }
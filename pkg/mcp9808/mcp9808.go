@@ -0,0 +1,104 @@
+// Package mcp9808 reads the Microchip MCP9808 digital temperature sensor. The
+// sensor converts continuously, so a reading is the last finished conversion.
+package mcp9808
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// I2C addresses: 0x18 with A2-A0 tied to GND, up to 0x1F.
+const (
+	Address     = uint16(0x18)
+	LastAddress = uint16(0x1F)
+)
+
+// Register Addresses
+const (
+	RegConfig     byte = 0x01 // Configuration Register
+	RegAmbient    byte = 0x05 // Ambient temperature Register
+	RegManufID    byte = 0x06 // Manufacturer ID Register
+	RegDeviceID   byte = 0x07 // Device ID and revision Register
+	RegResolution byte = 0x08 // Resolution Register
+)
+
+// Expected ID values
+const (
+	ManufacturerID = 0x0054
+	DeviceID       = 0x04 // upper byte of the Device ID register; the lower one is the revision
+)
+
+// Resolutions are the temperature steps in degrees Celsius, indexed by the code
+// of the Resolution register. Finer steps take longer: 30, 65, 130 and 250 ms per
+// conversion.
+var Resolutions = [4]float64{0.5, 0.25, 0.125, 0.0625}
+
+// DefaultResolution is the power-up setting.
+const DefaultResolution = 0.0625
+
+// ResolutionCode returns the Resolution register code of a step in degrees
+// Celsius, or an error if the MCP9808 does not support it.
+func ResolutionCode(step float64) (byte, error) {
+	for code, v := range Resolutions {
+		if v == step {
+			return byte(code), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported resolution %g °C: must be one of %v", step, Resolutions)
+}
+
+// Sensor is one MCP9808. Call Init before Temperature.
+type Sensor struct {
+	Dev        *i2c.Dev
+	Resolution float64 // degrees Celsius per step, one of Resolutions; 0 for DefaultResolution
+}
+
+// Init checks the ID registers, wakes the sensor from shutdown and sets the resolution.
+func (s *Sensor) Init() error {
+	buf := make([]byte, 2)
+	if err := s.Dev.Tx([]byte{RegManufID}, buf); err != nil {
+		return fmt.Errorf("failed to read manufacturer ID: %w", err)
+	}
+	if id := binary.BigEndian.Uint16(buf); id != ManufacturerID {
+		return fmt.Errorf("unexpected manufacturer ID 0x%04X: expected 0x%04X", id, ManufacturerID)
+	}
+	if err := s.Dev.Tx([]byte{RegDeviceID}, buf); err != nil {
+		return fmt.Errorf("failed to read device ID: %w", err)
+	}
+	if buf[0] != DeviceID {
+		return fmt.Errorf("unexpected device ID 0x%02X: expected 0x%02X", buf[0], DeviceID)
+	}
+	resolution := s.Resolution
+	if resolution == 0 {
+		resolution = DefaultResolution
+	}
+	code, err := ResolutionCode(resolution)
+	if err != nil {
+		return err
+	}
+	// Continuous conversion, with the alert output and limits at their power-up defaults
+	if err := s.Dev.Tx([]byte{RegConfig, 0x00, 0x00}, nil); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := s.Dev.Tx([]byte{RegResolution, code}, nil); err != nil {
+		return fmt.Errorf("failed to write resolution: %w", err)
+	}
+	return nil
+}
+
+// Temperature returns the ambient temperature in degrees Celsius.
+func (s *Sensor) Temperature() (float64, error) {
+	buf := make([]byte, 2)
+	if err := s.Dev.Tx([]byte{RegAmbient}, buf); err != nil {
+		return 0, fmt.Errorf("failed to read temperature: %w", err)
+	}
+	return Celsius(binary.BigEndian.Uint16(buf)), nil
+}
+
+// Celsius converts an Ambient temperature register value: bits 15:13 are the
+// alert flags, and bits 12:0 the temperature in 1/16 °C, two's complement.
+func Celsius(raw uint16) float64 {
+	return float64(int16(raw<<3)>>3) / 16
+}
@@ -0,0 +1,241 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	dto "github.com/prometheus/client_model/go"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/spool"
+)
+
+// message is one publish of fakeClient.
+type message struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  string
+}
+
+// fakeClient records the publishes of a sink. Its embedded paho.Client is nil,
+// so a test fails loudly when the sink calls any other method.
+type fakeClient struct {
+	paho.Client
+
+	mu        sync.Mutex
+	connected bool
+	err       error // what every publish fails with, if set
+	published []message
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload any) paho.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return fakeToken{c.err}
+	}
+	var p string
+	switch v := payload.(type) {
+	case string:
+		p = v
+	case []byte:
+		p = string(v)
+	}
+	c.published = append(c.published, message{topic, qos, retained, p})
+	return fakeToken{}
+}
+
+func (c *fakeClient) messages() []message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.published)
+}
+
+// fakeToken is a publish that has completed with err.
+type fakeToken struct{ err error }
+
+func (fakeToken) Wait() bool                     { return true }
+func (fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (fakeToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (t fakeToken) Error() error { return t.err }
+
+// newSink returns a sink of pi publishing through c.
+func newSink(c *fakeClient, opts Options) *mqttSink {
+	opts.TopicPrefix = "ina260"
+	return &mqttSink{opts: opts, hostname: "pi", client: c, announced: make(map[*exporter.Sensor]bool)}
+}
+
+func openSpool(t *testing.T) *spool.Spool {
+	t.Helper()
+	sp, err := spool.Open(t.TempDir(), "mqtt_test", 1<<16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sp.Close() })
+	return sp
+}
+
+var sensor = &exporter.Sensor{Hostname: "pi.local", Device: "cpu_rail"}
+
+func reading(i int) ina260.Reading {
+	return ina260.Reading{Time: time.Date(2024, 6, 10, 12, 0, i, 0, time.UTC), Voltage: 5, Current: 0.5, Power: 2.5}
+}
+
+// state returns the state payload of reading i of sensor.
+func state(i int) string {
+	return fmt.Sprintf(`{"time":"2024-06-10T12:00:%02d.000000Z","hostname":"pi.local","device":"cpu_rail","voltage":5,"current":0.5,"power":2.5}`, i)
+}
+
+const stateTopic = "ina260/pi.local/cpu_rail/state"
+
+func writeErrors(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := exporter.WriteErrors("mqtt").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestPublishState(t *testing.T) {
+	c := &fakeClient{connected: true}
+	m := newSink(c, Options{})
+	if err := m.Publish(sensor, reading(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PublishBatch([]exporter.Published{{Sensor: sensor, Reading: reading(2)}, {Sensor: sensor, Reading: reading(3)}}); err != nil {
+		t.Fatal(err)
+	}
+	// Readings go out with QoS 0 and are not retained
+	want := []message{{stateTopic, 0, false, state(1)}, {stateTopic, 0, false, state(2)}, {stateTopic, 0, false, state(3)}}
+	if got := c.messages(); !slices.Equal(got, want) {
+		t.Errorf("Published %v, want %v", got, want)
+	}
+}
+
+func TestAnnounceOncePerConnection(t *testing.T) {
+	c := &fakeClient{connected: true}
+	m := newSink(c, Options{DiscoveryPrefix: "homeassistant"})
+	for i := range 2 {
+		if err := m.Publish(sensor, reading(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := c.messages()
+	if len(got) != 5 {
+		t.Fatalf("Published %d messages, want 3 discovery messages and 2 readings: %v", len(got), got)
+	}
+	for i, key := range []string{"voltage", "current", "power"} {
+		msg := got[i]
+		// The dot of the hostname is not allowed in a node ID
+		if msg.topic != "homeassistant/sensor/pi_local_cpu_rail/"+key+"/config" || msg.qos != 1 || !msg.retained {
+			t.Errorf("Discovery message %d = %s, QoS %d, retained %t; want homeassistant/sensor/pi_local_cpu_rail/%s/config, QoS 1, retained", i, msg.topic, msg.qos, msg.retained, key)
+		}
+		var config haSensorConfig
+		if err := json.Unmarshal([]byte(msg.payload), &config); err != nil {
+			t.Fatal(err)
+		}
+		if config.StateTopic != stateTopic || config.AvailabilityTopic != "ina260/pi/status" || config.ValueTemplate != "{{ value_json."+key+" }}" {
+			t.Errorf("Discovery config %d = %+v", i, config)
+		}
+	}
+	if got[3].topic != stateTopic || got[4].topic != stateTopic {
+		t.Errorf("Published %s and %s after the discovery messages, want the state topic twice", got[3].topic, got[4].topic)
+	}
+	// A new connection announces the device again, on its next reading
+	m.onConnect(c)
+	if err := m.Publish(sensor, reading(2)); err != nil {
+		t.Fatal(err)
+	}
+	got = c.messages()[5:]
+	if len(got) != 5 || got[0] != (message{"ina260/pi/status", 1, true, "online"}) || got[1].topic != "homeassistant/sensor/pi_local_cpu_rail/voltage/config" {
+		t.Errorf("Published %v after reconnecting, want the online status, the discovery messages and the reading", got)
+	}
+}
+
+func TestPublishDisconnected(t *testing.T) {
+	c := &fakeClient{}
+	m := newSink(c, Options{})
+	if err := m.Publish(sensor, reading(0)); err == nil {
+		t.Error("Publish while disconnected without a spool succeeded")
+	}
+	if got := c.messages(); len(got) != 0 {
+		t.Errorf("Published %v while disconnected", got)
+	}
+}
+
+func TestReplaySpoolInOrder(t *testing.T) {
+	c := &fakeClient{}
+	sp := openSpool(t)
+	m := newSink(c, Options{Spool: sp})
+	for i := range 3 {
+		if err := m.Publish(sensor, reading(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sp.Len() != 3 || len(c.messages()) != 0 {
+		t.Fatalf("Spooled %d readings and published %d while disconnected, want 3 and none", sp.Len(), len(c.messages()))
+	}
+	c.connected = true
+	m.replay() // as onConnect does in the background
+	// Replayed readings go out with QoS 1, oldest first
+	want := []message{{stateTopic, 1, false, state(0)}, {stateTopic, 1, false, state(1)}, {stateTopic, 1, false, state(2)}}
+	if got := c.messages(); !slices.Equal(got, want) {
+		t.Errorf("Replayed %v, want %v", got, want)
+	}
+	if sp.Len() != 0 {
+		t.Errorf("Spool holds %d readings after the replay, want none", sp.Len())
+	}
+}
+
+func TestReplayKeepsSpoolOnFailure(t *testing.T) {
+	c := &fakeClient{}
+	sp := openSpool(t)
+	m := newSink(c, Options{Spool: sp})
+	if err := m.PublishBatch([]exporter.Published{{Sensor: sensor, Reading: reading(0)}, {Sensor: sensor, Reading: reading(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	c.connected, c.err = true, errors.New("broken pipe")
+	m.replay()
+	if sp.Len() != 2 {
+		t.Errorf("Spool holds %d readings after a failed replay, want 2", sp.Len())
+	}
+}
+
+func TestPublishFailureSpooled(t *testing.T) {
+	c := &fakeClient{connected: true, err: errors.New("broken pipe")}
+	sp := openSpool(t)
+	m := newSink(c, Options{Spool: sp})
+	counted := writeErrors(t)
+	if err := m.Publish(sensor, reading(0)); err != nil {
+		t.Fatalf("Publish = %v, want the reading spooled", err)
+	}
+	if got := writeErrors(t) - counted; got != 1 {
+		t.Errorf("Counted %g write errors, want 1", got)
+	}
+	rec, err := sp.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic, payload, ok := unspool(rec); !ok || topic != stateTopic || string(payload) != state(0) {
+		t.Errorf("Spooled %q, %q; want %q, %q", topic, payload, stateTopic, state(0))
+	}
+}
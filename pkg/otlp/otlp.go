@@ -0,0 +1,412 @@
+// Package otlp sends readings as OpenTelemetry metrics, and sensor reads as
+// traces, to an OTLP/HTTP receiver.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// Options configure an OTLP sink.
+type Options struct {
+	Endpoint string            // base URL of the OTLP/HTTP receiver, e.g. http://localhost:4318
+	Interval time.Duration     // how often buffered readings and spans are sent
+	Headers  map[string]string // added to every request, e.g. an API key
+	Resource map[string]string // resource attributes, e.g. service.name and host.name
+}
+
+// otlpMaxBuffered bounds the readings, and separately the traces, kept while the
+// receiver is unreachable; the oldest are dropped beyond it.
+const otlpMaxBuffered = 10000
+
+// otlpTimeout bounds each export request.
+const otlpTimeout = 10 * time.Second
+
+// otlpScope is the instrumentation scope of every metric and span.
+const otlpScope = "all4dich/rbp-control-i2c-multiplexer"
+
+// Exporter sends readings as OpenTelemetry metrics, and the traces given to
+// RecordTrace as spans, to an OTLP/HTTP receiver such as the OpenTelemetry
+// Collector, encoded as protobuf. Every reading is one data point of the
+// ina260.voltage, ina260.current and ina260.power gauges, with the device as
+// attribute, so the receiver gets the full poll rate. Readings and traces are
+// buffered and sent every interval from a background goroutine, so polling never
+// waits for the receiver. What fails with a server or network error is kept for
+// the next attempt, up to otlpMaxBuffered; what the receiver rejects with a client
+// error is dropped. Both count as write errors of the otlp sink.
+type Exporter struct {
+	opts   Options
+	client *http.Client
+
+	mu            sync.Mutex
+	points        []otlpPoint
+	traces        []*Trace
+	pointsDropped uint64 // readings dropped from the front of points so far
+	tracesDropped uint64
+	done          chan struct{}
+	stopped       chan struct{}
+}
+
+// otlpPoint is one buffered reading.
+type otlpPoint struct {
+	device string
+	labels map[string]string
+	r      ina260.Reading
+}
+
+// New checks opts and starts sending in the background. Nothing is sent
+// before the first interval, so an unreachable receiver does not fail startup.
+func New(opts Options) (*Exporter, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be http:// or https:// with a host", opts.Endpoint)
+	}
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive, got %s", opts.Interval)
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	o := &Exporter{
+		opts:    opts,
+		client:  &http.Client{Timeout: otlpTimeout},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go o.run()
+	return o, nil
+}
+
+func (o *Exporter) Name() string { return "otlp" }
+
+func (o *Exporter) Publish(s *exporter.Sensor, r ina260.Reading) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.points = append(o.points, otlpPoint{s.Device, s.Labels, r})
+	if dropped := len(o.points) - otlpMaxBuffered; dropped > 0 {
+		o.points = o.points[dropped:]
+		o.pointsDropped += uint64(dropped)
+		return fmt.Errorf("dropped %d buffered readings the OTLP receiver has not accepted", dropped)
+	}
+	return nil
+}
+
+// RecordTrace queues the spans of an ended trace. It does nothing if o or t is nil.
+func (o *Exporter) RecordTrace(t *Trace) {
+	if o == nil || t == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.traces = append(o.traces, t)
+	if dropped := len(o.traces) - otlpMaxBuffered; dropped > 0 {
+		o.traces = o.traces[dropped:]
+		o.tracesDropped += uint64(dropped)
+		exporter.WriteErrors(o.Name()).Add(float64(dropped))
+	}
+}
+
+// run sends every interval, until Close.
+func (o *Exporter) run() {
+	defer close(o.stopped)
+	ticker := time.NewTicker(o.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.done:
+			o.flush()
+			return
+		case <-ticker.C:
+		}
+		o.flush()
+	}
+}
+
+// flush sends the buffered readings, then the buffered traces, keeping what can
+// be retried.
+func (o *Exporter) flush() {
+	o.mu.Lock()
+	points, pointsDropped := o.points, o.pointsDropped
+	traces, tracesDropped := o.traces, o.tracesDropped
+	o.mu.Unlock()
+	// Publish and RecordTrace may drop some of what is sent from the front in the meantime
+	if len(points) > 0 && o.export("readings", "/v1/metrics", o.encodeMetrics(points), len(points)) {
+		o.mu.Lock()
+		o.points = o.points[max(0, len(points)-int(o.pointsDropped-pointsDropped)):]
+		o.mu.Unlock()
+	}
+	if len(traces) > 0 && o.export("traces", "/v1/traces", o.encodeTraces(traces), len(traces)) {
+		o.mu.Lock()
+		o.traces = o.traces[max(0, len(traces)-int(o.tracesDropped-tracesDropped)):]
+		o.mu.Unlock()
+	}
+}
+
+// export posts one request of n readings or traces. It returns false when the
+// request can be retried, so they are kept.
+func (o *Exporter) export(what, path string, req []byte, n int) bool {
+	retry, err := o.send(path, req)
+	if err != nil {
+		exporter.WriteErrors(o.Name()).Inc()
+		if retry {
+			slog.Warn("Failed to export to the OTLP receiver, keeping the "+what+" for the next attempt", "endpoint", o.opts.Endpoint, what, n, "err", err)
+			return false
+		}
+		slog.Warn("OTLP receiver rejected the "+what+", dropping them", "endpoint", o.opts.Endpoint, what, n, "err", err)
+	}
+	return true
+}
+
+// send posts one export request. retry is false when the receiver rejected it
+// for good, with a 4xx status other than 429.
+func (o *Exporter) send(path string, req []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.opts.Endpoint+path, bytes.NewReader(req))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range o.opts.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("receiver returned %s: %s", resp.Status, bytes.TrimSpace(body))
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+	}
+	return false, nil
+}
+
+// Close sends what is buffered and stops.
+func (o *Exporter) Close() error {
+	close(o.done)
+	<-o.stopped
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.points) > 0 || len(o.traces) > 0 {
+		return fmt.Errorf("%d readings and %d traces were not exported to the OTLP receiver", len(o.points), len(o.traces))
+	}
+	return nil
+}
+
+// Trace is the spans of one operation, such as a sensor read: a root span that
+// lasts from NewTrace to End, and the spans of its steps. A nil *Trace records
+// nothing, so callers need no checks when tracing is off.
+type Trace struct {
+	id    [16]byte
+	spans []span // the root first
+}
+
+// span is one span of a Trace.
+type span struct {
+	id, parent [8]byte
+	name       string
+	start, end time.Time
+	attributes []string // key, value pairs
+	err        error
+}
+
+// NewTrace starts a trace with a root span named name, with attributes as key,
+// value pairs.
+func NewTrace(name string, attributes ...string) *Trace {
+	t := &Trace{}
+	binary.BigEndian.PutUint64(t.id[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(t.id[8:], rand.Uint64())
+	t.spans = []span{{id: newSpanID(), name: name, start: time.Now(), attributes: attributes}}
+	return t
+}
+
+// Span adds a step that started at start and ended now, as a child of the root.
+func (t *Trace) Span(name string, start time.Time, err error, attributes ...string) {
+	if t == nil {
+		return
+	}
+	t.spans = append(t.spans, span{id: newSpanID(), parent: t.spans[0].id, name: name, start: start, end: time.Now(), attributes: attributes, err: err})
+}
+
+// End ends the root span with err and returns t.
+func (t *Trace) End(err error) *Trace {
+	if t == nil {
+		return nil
+	}
+	t.spans[0].end, t.spans[0].err = time.Now(), err
+	return t
+}
+
+func newSpanID() (id [8]byte) {
+	for id == [8]byte{} {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// OTLP protobuf field numbers, from opentelemetry-proto.
+const (
+	otlpResourceMetrics  = 1 // ExportMetricsServiceRequest
+	otlpResourceSpans    = 1 // ExportTraceServiceRequest
+	otlpResource         = 1 // ResourceMetrics, ResourceSpans
+	otlpScopeItems       = 2 // ResourceMetrics.scope_metrics, ResourceSpans.scope_spans
+	otlpScopeField       = 1 // ScopeMetrics, ScopeSpans
+	otlpItems            = 2 // ScopeMetrics.metrics, ScopeSpans.spans
+	otlpAttributes       = 1 // Resource
+	otlpMetricName       = 1
+	otlpMetricUnit       = 3
+	otlpMetricGauge      = 5
+	otlpGaugeDataPoints  = 1
+	otlpPointTime        = 3
+	otlpPointAsDouble    = 4
+	otlpPointAttributes  = 7
+	otlpSpanTraceID      = 1
+	otlpSpanID           = 2
+	otlpSpanParentID     = 4
+	otlpSpanName         = 5
+	otlpSpanKind         = 6
+	otlpSpanStart        = 7
+	otlpSpanEnd          = 8
+	otlpSpanAttributes   = 9
+	otlpSpanStatus       = 15
+	otlpStatusMessage    = 2
+	otlpStatusCode       = 3
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+// otlpGauges are the gauges of a reading, with their units.
+var otlpGauges = []struct {
+	name, unit string
+	value      func(ina260.Reading) float64
+}{
+	{"ina260.voltage", "V", func(r ina260.Reading) float64 { return r.Voltage }},
+	{"ina260.current", "A", func(r ina260.Reading) float64 { return r.Current }},
+	{"ina260.power", "W", func(r ina260.Reading) float64 { return r.Power }},
+}
+
+// encodeMetrics encodes points as an ExportMetricsServiceRequest.
+func (o *Exporter) encodeMetrics(points []otlpPoint) []byte {
+	var metrics []byte
+	for _, g := range otlpGauges {
+		var gauge []byte
+		for _, p := range points {
+			var dp []byte
+			dp = protowire.AppendTag(dp, otlpPointTime, protowire.Fixed64Type)
+			dp = protowire.AppendFixed64(dp, uint64(p.r.Time.UnixNano()))
+			dp = protowire.AppendTag(dp, otlpPointAsDouble, protowire.Fixed64Type)
+			dp = protowire.AppendFixed64(dp, math.Float64bits(g.value(p.r)))
+			dp = appendKeyValue(dp, otlpPointAttributes, "device", p.device)
+			for _, name := range slices.Sorted(maps.Keys(p.labels)) {
+				dp = appendKeyValue(dp, otlpPointAttributes, name, p.labels[name])
+			}
+			gauge = appendMessage(gauge, otlpGaugeDataPoints, dp)
+		}
+		var m []byte
+		m = protowire.AppendTag(m, otlpMetricName, protowire.BytesType)
+		m = protowire.AppendString(m, g.name)
+		m = protowire.AppendTag(m, otlpMetricUnit, protowire.BytesType)
+		m = protowire.AppendString(m, g.unit)
+		m = appendMessage(m, otlpMetricGauge, gauge)
+		metrics = appendMessage(metrics, otlpItems, m)
+	}
+	return appendMessage(nil, otlpResourceMetrics, o.encodeResource(metrics))
+}
+
+// encodeTraces encodes the spans of traces as an ExportTraceServiceRequest.
+func (o *Exporter) encodeTraces(traces []*Trace) []byte {
+	var spans []byte
+	for _, t := range traces {
+		for _, s := range t.spans {
+			var b []byte
+			b = protowire.AppendTag(b, otlpSpanTraceID, protowire.BytesType)
+			b = protowire.AppendBytes(b, t.id[:])
+			b = protowire.AppendTag(b, otlpSpanID, protowire.BytesType)
+			b = protowire.AppendBytes(b, s.id[:])
+			if s.parent != [8]byte{} {
+				b = protowire.AppendTag(b, otlpSpanParentID, protowire.BytesType)
+				b = protowire.AppendBytes(b, s.parent[:])
+			}
+			b = protowire.AppendTag(b, otlpSpanName, protowire.BytesType)
+			b = protowire.AppendString(b, s.name)
+			b = protowire.AppendTag(b, otlpSpanKind, protowire.VarintType)
+			b = protowire.AppendVarint(b, otlpSpanKindInternal)
+			b = protowire.AppendTag(b, otlpSpanStart, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, uint64(s.start.UnixNano()))
+			b = protowire.AppendTag(b, otlpSpanEnd, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, uint64(s.end.UnixNano()))
+			for i := 0; i+1 < len(s.attributes); i += 2 {
+				b = appendKeyValue(b, otlpSpanAttributes, s.attributes[i], s.attributes[i+1])
+			}
+			if s.err != nil {
+				var status []byte
+				status = protowire.AppendTag(status, otlpStatusMessage, protowire.BytesType)
+				status = protowire.AppendString(status, s.err.Error())
+				status = protowire.AppendTag(status, otlpStatusCode, protowire.VarintType)
+				status = protowire.AppendVarint(status, otlpStatusCodeError)
+				b = appendMessage(b, otlpSpanStatus, status)
+			}
+			spans = appendMessage(spans, otlpItems, b)
+		}
+	}
+	return appendMessage(nil, otlpResourceSpans, o.encodeResource(spans))
+}
+
+// encodeResource wraps the encoded metrics or spans of the scope in a
+// ResourceMetrics or ResourceSpans, which share their layout.
+func (o *Exporter) encodeResource(items []byte) []byte {
+	var resource []byte
+	keys := make([]string, 0, len(o.opts.Resource))
+	for k := range o.opts.Resource {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		resource = appendKeyValue(resource, otlpAttributes, k, o.opts.Resource[k])
+	}
+	var scope []byte
+	scope = protowire.AppendTag(scope, 1, protowire.BytesType) // InstrumentationScope.name
+	scope = protowire.AppendString(scope, otlpScope)
+	var scoped []byte
+	scoped = appendMessage(scoped, otlpScopeField, scope)
+	scoped = append(scoped, items...)
+
+	var b []byte
+	b = appendMessage(b, otlpResource, resource)
+	return appendMessage(b, otlpScopeItems, scoped)
+}
+
+// appendKeyValue appends a KeyValue with a string AnyValue as field num.
+func appendKeyValue(b []byte, num protowire.Number, key, value string) []byte {
+	var anyValue, kv []byte
+	anyValue = protowire.AppendTag(anyValue, 1, protowire.BytesType) // AnyValue.string_value
+	anyValue = protowire.AppendString(anyValue, value)
+	kv = protowire.AppendTag(kv, 1, protowire.BytesType) // KeyValue.key
+	kv = protowire.AppendString(kv, key)
+	kv = appendMessage(kv, 2, anyValue) // KeyValue.value
+	return appendMessage(b, num, kv)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
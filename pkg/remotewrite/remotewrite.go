@@ -0,0 +1,345 @@
+// Package remotewrite pushes the gathered Prometheus metrics to a remote write
+// receiver, for hosts that cannot be scraped.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/spool"
+)
+
+// Options configure a Writer.
+type Options struct {
+	URL            string              // receiver endpoint, e.g. https://mimir.example.com/api/v1/push
+	Interval       time.Duration       // how often every series is gathered and sent
+	Username       string              // basic auth user; empty sends no credentials
+	Password       string              // basic auth password
+	TLS            *tls.Config         // for https URLs; nil uses the system roots
+	ExternalLabels map[string]string   // added to every series that lacks them, e.g. job and instance
+	Gatherer       prometheus.Gatherer // nil gathers Gatherer, with the extra labels of the devices
+	Spool          *spool.Spool        // keeps the snapshots beyond the buffer, and the ones left at Close, for replay; nil drops them
+}
+
+// remoteWriteMaxBuffered bounds the snapshots kept while the receiver is
+// unreachable; the oldest are dropped beyond it.
+const remoteWriteMaxBuffered = 20
+
+// remoteWriteTimeout bounds each push request.
+const remoteWriteTimeout = 30 * time.Second
+
+// Writer pushes the series of a Gatherer to a Prometheus remote_write
+// receiver such as Mimir, Thanos Receive or Prometheus itself, for hosts that
+// cannot be scraped, e.g. behind NAT. Every interval it takes a snapshot of all
+// the series, as a scrape would, and sends it as a snappy-compressed protobuf
+// WriteRequest (remote write 1.0). Snapshots that fail with a server error or a
+// network error are kept and sent first on the next attempt, up to
+// remoteWriteMaxBuffered; one the receiver rejects with a client error is
+// dropped. Both count as write errors of the remote_write sink. With a Spool,
+// the oldest snapshot beyond that goes to the spool instead, and spooled
+// snapshots are sent first, with the timestamps they were taken at.
+type Writer struct {
+	opts   Options
+	client *http.Client
+
+	mu      sync.Mutex
+	pending [][]byte // encoded, uncompressed WriteRequests, oldest first
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New checks opts and starts pushing in the background. Nothing is sent before
+// the first interval, so an unreachable receiver does not fail startup.
+func New(opts Options) (*Writer, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote write URL %q: must be http:// or https:// with a host", opts.URL)
+	}
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive, got %s", opts.Interval)
+	}
+	if opts.Password != "" && opts.Username == "" {
+		return nil, errors.New("a password needs a username")
+	}
+	if opts.Gatherer == nil {
+		opts.Gatherer = exporter.Gatherer
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.TLS != nil {
+		transport.TLSClientConfig = opts.TLS
+	}
+	w := &Writer{
+		opts:    opts,
+		client:  &http.Client{Timeout: remoteWriteTimeout, Transport: transport},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// run takes a snapshot and pushes every interval, until Close.
+func (w *Writer) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			w.snapshot()
+			w.push()
+			return
+		case <-ticker.C:
+		}
+		w.snapshot()
+		w.push()
+	}
+}
+
+// snapshot gathers every series into a new pending WriteRequest.
+func (w *Writer) snapshot() {
+	families, err := w.opts.Gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could alongside the error, as a scrape does
+		slog.Warn("Failed to gather some metrics for remote write", "err", err)
+	}
+	req := encodeWriteRequest(families, w.opts.ExternalLabels, time.Now())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, req)
+	if dropped := len(w.pending) - remoteWriteMaxBuffered; dropped > 0 {
+		if w.opts.Spool != nil {
+			w.spill(dropped)
+			return
+		}
+		w.pending = w.pending[dropped:]
+		exporter.WriteErrors("remote_write").Add(float64(dropped))
+		slog.Warn("Dropped remote write snapshots the receiver has not accepted", "url", w.opts.URL, "dropped", dropped)
+	}
+}
+
+// spill moves the n oldest pending snapshots to the spool. The caller holds mu.
+func (w *Writer) spill(n int) {
+	for range n {
+		if err := w.opts.Spool.Push(w.pending[0]); err != nil {
+			exporter.WriteErrors("remote_write").Inc()
+			slog.Warn("Failed to spool a remote write snapshot, dropping it", "err", err)
+		}
+		w.pending = w.pending[1:]
+	}
+}
+
+// push sends the spooled, then the pending snapshots oldest first, so every
+// series stays in time order, stopping at the first one that can be retried.
+func (w *Writer) push() {
+	if !w.replay() {
+		return
+	}
+	for {
+		w.mu.Lock()
+		if len(w.pending) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		req := w.pending[0]
+		backlog := len(w.pending)
+		w.mu.Unlock()
+
+		retry, err := w.send(req)
+		if err != nil {
+			exporter.WriteErrors("remote_write").Inc()
+			if retry {
+				slog.Warn("Failed to push to the remote write receiver, keeping the samples for the next attempt", "url", w.opts.URL, "snapshots", backlog, "err", err)
+				return
+			}
+			slog.Warn("Remote write receiver rejected the samples, dropping them", "url", w.opts.URL, "err", err)
+		}
+		w.mu.Lock()
+		w.pending = w.pending[1:]
+		w.mu.Unlock()
+	}
+}
+
+// replay sends the spooled snapshots oldest first, and reports whether none is
+// left to retry.
+func (w *Writer) replay() bool {
+	if w.opts.Spool == nil {
+		return true
+	}
+	for {
+		req, err := w.opts.Spool.Peek()
+		if err != nil {
+			slog.Warn("Failed to read spooled remote write snapshots", "err", err)
+			return false
+		}
+		if req == nil {
+			return true
+		}
+		retry, err := w.send(req)
+		if err != nil {
+			exporter.WriteErrors("remote_write").Inc()
+			if retry {
+				slog.Warn("Failed to push spooled samples to the remote write receiver, keeping them for the next attempt", "url", w.opts.URL, "spooled", w.opts.Spool.Len(), "err", err)
+				return false
+			}
+			slog.Warn("Remote write receiver rejected spooled samples, dropping them", "url", w.opts.URL, "err", err)
+		}
+		if err := w.opts.Spool.Pop(); err != nil {
+			slog.Warn("Failed to update the remote write spool", "err", err)
+			return false
+		}
+	}
+}
+
+// send posts one WriteRequest. retry is false when the receiver rejected it
+// for good, with a 4xx status other than 429.
+func (w *Writer) send(req []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteWriteTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.URL, bytes.NewReader(snappy.Encode(nil, req)))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.opts.Username != "" {
+		httpReq.SetBasicAuth(w.opts.Username, w.opts.Password)
+	}
+	resp, err := w.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("receiver returned %s: %s", resp.Status, bytes.TrimSpace(body))
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+	}
+	return false, nil
+}
+
+// Close takes a last snapshot, pushes what is pending and stops. With a Spool,
+// the snapshots that could not be pushed are spooled for the next run.
+func (w *Writer) Close() error {
+	close(w.done)
+	<-w.stopped
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.Spool != nil {
+		w.spill(len(w.pending))
+		return w.opts.Spool.Close()
+	}
+	if len(w.pending) > 0 {
+		return fmt.Errorf("%d snapshots were not pushed to the remote write receiver", len(w.pending))
+	}
+	return nil
+}
+
+// remoteLabel is one label of a remote write series.
+type remoteLabel struct{ name, value string }
+
+// encodeWriteRequest encodes families as a remote write WriteRequest, one
+// TimeSeries per series with one sample at now, or at the timestamp of the
+// metric if it has one. Histograms and summaries are split into the _bucket,
+// quantile, _sum and _count series a scrape would store.
+func encodeWriteRequest(families []*dto.MetricFamily, external map[string]string, now time.Time) []byte {
+	var b []byte
+	for _, f := range families {
+		name := f.GetName()
+		for _, m := range f.GetMetric() {
+			labels := make([]remoteLabel, 0, len(m.GetLabel())+len(external)+2)
+			for _, l := range m.GetLabel() {
+				labels = append(labels, remoteLabel{l.GetName(), l.GetValue()})
+			}
+			for n, v := range external {
+				if !slices.ContainsFunc(labels, func(l remoteLabel) bool { return l.name == n }) {
+					labels = append(labels, remoteLabel{n, v})
+				}
+			}
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			series := func(suffix string, value float64, extra ...remoteLabel) {
+				b = protowire.AppendTag(b, 1, protowire.BytesType)
+				b = protowire.AppendBytes(b, encodeTimeSeries(name+suffix, slices.Concat(labels, extra), value, ts))
+			}
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				series("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				series("", m.GetGauge().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					series("_bucket", float64(bucket.GetCumulativeCount()), remoteLabel{"le", formatFloat(bucket.GetUpperBound())})
+				}
+				series("_bucket", float64(h.GetSampleCount()), remoteLabel{"le", "+Inf"})
+				series("_sum", h.GetSampleSum())
+				series("_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					series("", q.GetValue(), remoteLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				series("_sum", s.GetSampleSum())
+				series("_count", float64(s.GetSampleCount()))
+			default:
+				series("", m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return b
+}
+
+// encodeTimeSeries encodes one TimeSeries message, with its labels sorted by
+// name as receivers require.
+func encodeTimeSeries(name string, labels []remoteLabel, value float64, ts int64) []byte {
+	labels = append(labels, remoteLabel{"__name__", name})
+	slices.SortFunc(labels, func(a, b remoteLabel) int { return strings.Compare(a.name, b.name) })
+	var b []byte
+	for _, l := range labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, lb)
+	}
+	var sb []byte
+	sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+	sb = protowire.AppendFixed64(sb, math.Float64bits(value))
+	sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+	sb = protowire.AppendVarint(sb, uint64(ts))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, sb)
+}
+
+// formatFloat formats a bucket bound or quantile like the text exposition format.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,310 @@
+package remotewrite
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+)
+
+// sample is one decoded TimeSeries of a WriteRequest, which the Writer always
+// sends with one sample.
+type sample struct {
+	labels []remoteLabel
+	value  float64
+	ts     int64
+}
+
+// fields returns the fields of a protobuf message as their numbers and raw
+// values: the bytes of a length-delimited field, the varint or the fixed64.
+func fields(t *testing.T, b []byte) (nums []protowire.Number, values []any) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("Bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v any
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		default:
+			t.Fatalf("Unexpected wire type %d of field %d", typ, num)
+		}
+		if n < 0 {
+			t.Fatalf("Bad field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		nums, values = append(nums, num), append(values, v)
+	}
+	return nums, values
+}
+
+// decode decodes a WriteRequest into its series.
+func decode(t *testing.T, req []byte) []sample {
+	t.Helper()
+	var samples []sample
+	nums, values := fields(t, req)
+	for i, num := range nums {
+		if num != 1 {
+			t.Fatalf("Unexpected WriteRequest field %d", num)
+		}
+		var s sample
+		tsNums, tsValues := fields(t, values[i].([]byte))
+		for j, tsNum := range tsNums {
+			fs, vs := fields(t, tsValues[j].([]byte))
+			switch tsNum {
+			case 1: // Label
+				var l remoteLabel
+				for k, f := range fs {
+					switch f {
+					case 1:
+						l.name = string(vs[k].([]byte))
+					case 2:
+						l.value = string(vs[k].([]byte))
+					}
+				}
+				s.labels = append(s.labels, l)
+			case 2: // Sample
+				for k, f := range fs {
+					switch f {
+					case 1:
+						s.value = math.Float64frombits(vs[k].(uint64))
+					case 2:
+						s.ts = int64(vs[k].(uint64))
+					}
+				}
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// receiver is a remote write endpoint that decodes every request it accepts,
+// and answers the ones while status is set with that status instead.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests [][]sample
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if got := req.Header.Get("Content-Encoding"); got != "snappy" {
+			t.Errorf("Content-Encoding = %q, want snappy", got)
+		}
+		if got := req.Header.Get("Content-Type"); got != "application/x-protobuf" {
+			t.Errorf("Content-Type = %q, want application/x-protobuf", got)
+		}
+		if got := req.Header.Get("X-Prometheus-Remote-Write-Version"); got != "0.1.0" {
+			t.Errorf("X-Prometheus-Remote-Write-Version = %q, want 0.1.0", got)
+		}
+		if user, password, _ := req.BasicAuth(); user != "pi" || password != "secret" {
+			t.Errorf("Basic auth = %q, %q; want pi, secret", user, password)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		decoded, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("Body is not snappy-compressed: %v", err)
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.status != 0 {
+			http.Error(w, "try later", r.status)
+			return
+		}
+		r.requests = append(r.requests, decode(t, decoded))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) answer(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() [][]sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.requests)
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// gauge returns a family of one gauge series with labels in the order given.
+func gauge(name string, value float64, labels ...string) *dto.MetricFamily {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: ptr(value)}}
+	for i := 0; i < len(labels); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{Name: ptr(labels[i]), Value: ptr(labels[i+1])})
+	}
+	return &dto.MetricFamily{Name: ptr(name), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{m}}
+}
+
+// writer returns a Writer pushing the families of gather to r, which does not
+// push in the background, for the tests that push it themselves.
+func writer(r *receiver, gather func() ([]*dto.MetricFamily, error)) *Writer {
+	return &Writer{
+		opts:   Options{URL: r.URL, Username: "pi", Password: "secret", Gatherer: prometheus.GathererFunc(gather)},
+		client: r.Client(),
+	}
+}
+
+func writeErrors(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := exporter.WriteErrors("remote_write").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestWriterSendsSeries(t *testing.T) {
+	r := newReceiver(t)
+	voltage := gauge("ina260_voltage_volts", 5.01, "hostname", "pi", "device", "cpu_rail")
+	stamped := gauge("ina260_last_reading_time", 1, "device", "cpu_rail")
+	stamped.Metric[0].TimestampMs = ptr(int64(1718000000123))
+	histogram := &dto.MetricFamily{Name: ptr("ina260_read_seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{{
+		Histogram: &dto.Histogram{SampleCount: ptr(uint64(3)), SampleSum: ptr(0.5), Bucket: []*dto.Bucket{{UpperBound: ptr(0.1), CumulativeCount: ptr(uint64(2))}}},
+	}}}
+	w, err := New(Options{URL: r.URL, Interval: time.Hour, Username: "pi", Password: "secret",
+		ExternalLabels: map[string]string{"job": "ina260", "hostname": "gateway"},
+		Gatherer: prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return []*dto.MetricFamily{voltage, stamped, histogram}, nil
+		})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().UnixMilli()
+	// Close takes the one snapshot of the test, the interval being an hour
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().UnixMilli()
+	requests := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("Received %d requests, want 1", len(requests))
+	}
+	got := requests[0]
+	// The labels are sorted by name, and an external label does not replace one of the series
+	want := []sample{
+		{labels: []remoteLabel{{"__name__", "ina260_voltage_volts"}, {"device", "cpu_rail"}, {"hostname", "pi"}, {"job", "ina260"}}, value: 5.01},
+		{labels: []remoteLabel{{"__name__", "ina260_last_reading_time"}, {"device", "cpu_rail"}, {"hostname", "gateway"}, {"job", "ina260"}}, value: 1, ts: 1718000000123},
+		{labels: []remoteLabel{{"__name__", "ina260_read_seconds_bucket"}, {"hostname", "gateway"}, {"job", "ina260"}, {"le", "0.1"}}, value: 2},
+		{labels: []remoteLabel{{"__name__", "ina260_read_seconds_bucket"}, {"hostname", "gateway"}, {"job", "ina260"}, {"le", "+Inf"}}, value: 3},
+		{labels: []remoteLabel{{"__name__", "ina260_read_seconds_sum"}, {"hostname", "gateway"}, {"job", "ina260"}}, value: 0.5},
+		{labels: []remoteLabel{{"__name__", "ina260_read_seconds_count"}, {"hostname", "gateway"}, {"job", "ina260"}}, value: 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Received %d series, want %d: %v", len(got), len(want), got)
+	}
+	for i, s := range got {
+		if !slices.Equal(s.labels, want[i].labels) || s.value != want[i].value {
+			t.Errorf("Series %d = %v %g, want %v %g", i, s.labels, s.value, want[i].labels, want[i].value)
+		}
+		if want[i].ts != 0 {
+			if s.ts != want[i].ts {
+				t.Errorf("Series %d has timestamp %d, want the one of the metric, %d", i, s.ts, want[i].ts)
+			}
+		} else if s.ts < before || s.ts > after {
+			t.Errorf("Series %d has timestamp %d, want the snapshot time, between %d and %d", i, s.ts, before, after)
+		}
+	}
+}
+
+func TestPushRetriesPending(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		retry  bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadRequest, false},
+	} {
+		t.Run(fmt.Sprint(tc.status), func(t *testing.T) {
+			r := newReceiver(t)
+			n := 0.0
+			w := writer(r, func() ([]*dto.MetricFamily, error) {
+				n++ // tells the snapshots apart
+				return []*dto.MetricFamily{gauge("ina260_voltage_volts", n)}, nil
+			})
+			counted := writeErrors(t)
+			r.answer(tc.status)
+			w.snapshot()
+			w.push()
+			if got := writeErrors(t) - counted; got != 1 {
+				t.Errorf("Counted %g write errors, want 1", got)
+			}
+			wantPending := 0
+			if tc.retry {
+				wantPending = 1
+			}
+			if len(w.pending) != wantPending {
+				t.Fatalf("%d snapshots pending after the failure, want %d", len(w.pending), wantPending)
+			}
+			r.answer(0)
+			w.snapshot()
+			w.push()
+			var values []float64
+			for _, req := range r.received() {
+				values = append(values, req[0].value)
+			}
+			// A kept snapshot is sent before the newer one
+			want := []float64{2}
+			if tc.retry {
+				want = []float64{1, 2}
+			}
+			if !slices.Equal(values, want) || len(w.pending) != 0 {
+				t.Errorf("Received the snapshots %v with %d pending, want %v with none", values, len(w.pending), want)
+			}
+		})
+	}
+}
+
+func TestSnapshotDropsOldest(t *testing.T) {
+	r := newReceiver(t)
+	n := 0.0
+	w := writer(r, func() ([]*dto.MetricFamily, error) {
+		n++
+		return []*dto.MetricFamily{gauge("ina260_voltage_volts", n)}, nil
+	})
+	counted := writeErrors(t)
+	for range remoteWriteMaxBuffered + 1 {
+		w.snapshot()
+	}
+	if got := writeErrors(t) - counted; got != 1 {
+		t.Errorf("Counted %g write errors, want 1", got)
+	}
+	if len(w.pending) != remoteWriteMaxBuffered {
+		t.Fatalf("%d snapshots pending, want %d", len(w.pending), remoteWriteMaxBuffered)
+	}
+	if got := decode(t, w.pending[0])[0].value; got != 2 {
+		t.Errorf("Oldest pending snapshot has value %g, want 2", got)
+	}
+}
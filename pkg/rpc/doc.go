@@ -0,0 +1,4 @@
+// Package rpc is the gRPC API of the exporter, generated from exporter.proto:
+// the Exporter service with its ListDevices, GetReading, StreamReadings and
+// SelectChannel calls, for clients in Go.
+package rpc
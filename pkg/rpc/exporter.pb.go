@@ -0,0 +1,603 @@
+// The gRPC API of rbp-control-i2c-multiplexer, served with --grpc.listen-address.
+// It carries the same data as the JSON API under /api/v1.
+//
+// The Go code next to this file is generated with buf (see buf.gen.yaml):
+//
+//	buf generate
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: exporter.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ListDevicesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesRequest) Reset() {
+	*x = ListDevicesRequest{}
+	mi := &file_exporter_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesRequest) ProtoMessage() {}
+
+func (x *ListDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesRequest.ProtoReflect.Descriptor instead.
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{0}
+}
+
+type ListDevicesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Devices       []*Device              `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesResponse) Reset() {
+	*x = ListDevicesResponse{}
+	mi := &file_exporter_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesResponse) ProtoMessage() {}
+
+func (x *ListDevicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesResponse.ProtoReflect.Descriptor instead.
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListDevicesResponse) GetDevices() []*Device {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+// Device is a polled power monitor, as in GET /api/v1/devices.
+type Device struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // device label
+	Hostname      string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Chip          string                 `protobuf:"bytes,3,opt,name=chip,proto3" json:"chip,omitempty"`              // ina260, ina226 or ina219
+	Bus           string                 `protobuf:"bytes,4,opt,name=bus,proto3" json:"bus,omitempty"`                // empty for a sensor on the --bus bus
+	Mux           string                 `protobuf:"bytes,5,opt,name=mux,proto3" json:"mux,omitempty"`                // e.g. 0x70; empty for a directly connected sensor
+	Channel       *int32                 `protobuf:"varint,6,opt,name=channel,proto3,oneof" json:"channel,omitempty"` // channel of that mux; unset for a directly connected sensor
+	Up            bool                   `protobuf:"varint,7,opt,name=up,proto3" json:"up,omitempty"`
+	Readings      uint64                 `protobuf:"varint,8,opt,name=readings,proto3" json:"readings,omitempty"`
+	ReadErrors    uint64                 `protobuf:"varint,9,opt,name=read_errors,json=readErrors,proto3" json:"read_errors,omitempty"`
+	LastReading   *Reading               `protobuf:"bytes,10,opt,name=last_reading,json=lastReading,proto3" json:"last_reading,omitempty"` // unset before the first reading
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_exporter_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Device) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Device) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Device) GetChip() string {
+	if x != nil {
+		return x.Chip
+	}
+	return ""
+}
+
+func (x *Device) GetBus() string {
+	if x != nil {
+		return x.Bus
+	}
+	return ""
+}
+
+func (x *Device) GetMux() string {
+	if x != nil {
+		return x.Mux
+	}
+	return ""
+}
+
+func (x *Device) GetChannel() int32 {
+	if x != nil && x.Channel != nil {
+		return *x.Channel
+	}
+	return 0
+}
+
+func (x *Device) GetUp() bool {
+	if x != nil {
+		return x.Up
+	}
+	return false
+}
+
+func (x *Device) GetReadings() uint64 {
+	if x != nil {
+		return x.Readings
+	}
+	return 0
+}
+
+func (x *Device) GetReadErrors() uint64 {
+	if x != nil {
+		return x.ReadErrors
+	}
+	return 0
+}
+
+func (x *Device) GetLastReading() *Reading {
+	if x != nil {
+		return x.LastReading
+	}
+	return nil
+}
+
+type Reading struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Hostname      string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Device        string                 `protobuf:"bytes,3,opt,name=device,proto3" json:"device,omitempty"`
+	Voltage       float64                `protobuf:"fixed64,4,opt,name=voltage,proto3" json:"voltage,omitempty"` // Volts
+	Current       float64                `protobuf:"fixed64,5,opt,name=current,proto3" json:"current,omitempty"` // Amperes
+	Power         float64                `protobuf:"fixed64,6,opt,name=power,proto3" json:"power,omitempty"`     // Watts
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Reading) Reset() {
+	*x = Reading{}
+	mi := &file_exporter_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Reading) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reading) ProtoMessage() {}
+
+func (x *Reading) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reading.ProtoReflect.Descriptor instead.
+func (*Reading) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Reading) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Reading) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Reading) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *Reading) GetVoltage() float64 {
+	if x != nil {
+		return x.Voltage
+	}
+	return 0
+}
+
+func (x *Reading) GetCurrent() float64 {
+	if x != nil {
+		return x.Current
+	}
+	return 0
+}
+
+func (x *Reading) GetPower() float64 {
+	if x != nil {
+		return x.Power
+	}
+	return 0
+}
+
+type GetReadingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        string                 `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	Fresh         bool                   `protobuf:"varint,2,opt,name=fresh,proto3" json:"fresh,omitempty"` // read the sensor now; the reading is not published to the outputs
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetReadingRequest) Reset() {
+	*x = GetReadingRequest{}
+	mi := &file_exporter_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetReadingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetReadingRequest) ProtoMessage() {}
+
+func (x *GetReadingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetReadingRequest.ProtoReflect.Descriptor instead.
+func (*GetReadingRequest) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetReadingRequest) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *GetReadingRequest) GetFresh() bool {
+	if x != nil {
+		return x.Fresh
+	}
+	return false
+}
+
+type StreamReadingsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        string                 `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"` // only readings of this device; empty for every device
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamReadingsRequest) Reset() {
+	*x = StreamReadingsRequest{}
+	mi := &file_exporter_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamReadingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamReadingsRequest) ProtoMessage() {}
+
+func (x *StreamReadingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamReadingsRequest.ProtoReflect.Descriptor instead.
+func (*StreamReadingsRequest) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *StreamReadingsRequest) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+type SelectChannelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Channel       *int32                 `protobuf:"varint,1,opt,name=channel,proto3,oneof" json:"channel,omitempty"` // numbered across the muxes, as --channels; unset deselects every channel
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelectChannelRequest) Reset() {
+	*x = SelectChannelRequest{}
+	mi := &file_exporter_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelectChannelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelectChannelRequest) ProtoMessage() {}
+
+func (x *SelectChannelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelectChannelRequest.ProtoReflect.Descriptor instead.
+func (*SelectChannelRequest) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SelectChannelRequest) GetChannel() int32 {
+	if x != nil && x.Channel != nil {
+		return *x.Channel
+	}
+	return 0
+}
+
+type SelectChannelResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelectChannelResponse) Reset() {
+	*x = SelectChannelResponse{}
+	mi := &file_exporter_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelectChannelResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelectChannelResponse) ProtoMessage() {}
+
+func (x *SelectChannelResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_exporter_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelectChannelResponse.ProtoReflect.Descriptor instead.
+func (*SelectChannelResponse) Descriptor() ([]byte, []int) {
+	return file_exporter_proto_rawDescGZIP(), []int{7}
+}
+
+var File_exporter_proto protoreflect.FileDescriptor
+
+const file_exporter_proto_rawDesc = "" +
+	"\n" +
+	"\x0eexporter.proto\x12\x12ina260.exporter.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x14\n" +
+	"\x12ListDevicesRequest\"K\n" +
+	"\x13ListDevicesResponse\x124\n" +
+	"\adevices\x18\x01 \x03(\v2\x1a.ina260.exporter.v1.DeviceR\adevices\"\xa8\x02\n" +
+	"\x06Device\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x12\n" +
+	"\x04chip\x18\x03 \x01(\tR\x04chip\x12\x10\n" +
+	"\x03bus\x18\x04 \x01(\tR\x03bus\x12\x10\n" +
+	"\x03mux\x18\x05 \x01(\tR\x03mux\x12\x1d\n" +
+	"\achannel\x18\x06 \x01(\x05H\x00R\achannel\x88\x01\x01\x12\x0e\n" +
+	"\x02up\x18\a \x01(\bR\x02up\x12\x1a\n" +
+	"\breadings\x18\b \x01(\x04R\breadings\x12\x1f\n" +
+	"\vread_errors\x18\t \x01(\x04R\n" +
+	"readErrors\x12>\n" +
+	"\flast_reading\x18\n" +
+	" \x01(\v2\x1b.ina260.exporter.v1.ReadingR\vlastReadingB\n" +
+	"\n" +
+	"\b_channel\"\xb7\x01\n" +
+	"\aReading\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x16\n" +
+	"\x06device\x18\x03 \x01(\tR\x06device\x12\x18\n" +
+	"\avoltage\x18\x04 \x01(\x01R\avoltage\x12\x18\n" +
+	"\acurrent\x18\x05 \x01(\x01R\acurrent\x12\x14\n" +
+	"\x05power\x18\x06 \x01(\x01R\x05power\"A\n" +
+	"\x11GetReadingRequest\x12\x16\n" +
+	"\x06device\x18\x01 \x01(\tR\x06device\x12\x14\n" +
+	"\x05fresh\x18\x02 \x01(\bR\x05fresh\"/\n" +
+	"\x15StreamReadingsRequest\x12\x16\n" +
+	"\x06device\x18\x01 \x01(\tR\x06device\"A\n" +
+	"\x14SelectChannelRequest\x12\x1d\n" +
+	"\achannel\x18\x01 \x01(\x05H\x00R\achannel\x88\x01\x01B\n" +
+	"\n" +
+	"\b_channel\"\x17\n" +
+	"\x15SelectChannelResponse2\xfe\x02\n" +
+	"\bExporter\x12^\n" +
+	"\vListDevices\x12&.ina260.exporter.v1.ListDevicesRequest\x1a'.ina260.exporter.v1.ListDevicesResponse\x12P\n" +
+	"\n" +
+	"GetReading\x12%.ina260.exporter.v1.GetReadingRequest\x1a\x1b.ina260.exporter.v1.Reading\x12Z\n" +
+	"\x0eStreamReadings\x12).ina260.exporter.v1.StreamReadingsRequest\x1a\x1b.ina260.exporter.v1.Reading0\x01\x12d\n" +
+	"\rSelectChannel\x12(.ina260.exporter.v1.SelectChannelRequest\x1a).ina260.exporter.v1.SelectChannelResponseB.Z,all4dich/rbp-control-i2c-multiplexer/pkg/rpcb\x06proto3"
+
+var (
+	file_exporter_proto_rawDescOnce sync.Once
+	file_exporter_proto_rawDescData []byte
+)
+
+func file_exporter_proto_rawDescGZIP() []byte {
+	file_exporter_proto_rawDescOnce.Do(func() {
+		file_exporter_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_exporter_proto_rawDesc), len(file_exporter_proto_rawDesc)))
+	})
+	return file_exporter_proto_rawDescData
+}
+
+var file_exporter_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_exporter_proto_goTypes = []any{
+	(*ListDevicesRequest)(nil),    // 0: ina260.exporter.v1.ListDevicesRequest
+	(*ListDevicesResponse)(nil),   // 1: ina260.exporter.v1.ListDevicesResponse
+	(*Device)(nil),                // 2: ina260.exporter.v1.Device
+	(*Reading)(nil),               // 3: ina260.exporter.v1.Reading
+	(*GetReadingRequest)(nil),     // 4: ina260.exporter.v1.GetReadingRequest
+	(*StreamReadingsRequest)(nil), // 5: ina260.exporter.v1.StreamReadingsRequest
+	(*SelectChannelRequest)(nil),  // 6: ina260.exporter.v1.SelectChannelRequest
+	(*SelectChannelResponse)(nil), // 7: ina260.exporter.v1.SelectChannelResponse
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_exporter_proto_depIdxs = []int32{
+	2, // 0: ina260.exporter.v1.ListDevicesResponse.devices:type_name -> ina260.exporter.v1.Device
+	3, // 1: ina260.exporter.v1.Device.last_reading:type_name -> ina260.exporter.v1.Reading
+	8, // 2: ina260.exporter.v1.Reading.time:type_name -> google.protobuf.Timestamp
+	0, // 3: ina260.exporter.v1.Exporter.ListDevices:input_type -> ina260.exporter.v1.ListDevicesRequest
+	4, // 4: ina260.exporter.v1.Exporter.GetReading:input_type -> ina260.exporter.v1.GetReadingRequest
+	5, // 5: ina260.exporter.v1.Exporter.StreamReadings:input_type -> ina260.exporter.v1.StreamReadingsRequest
+	6, // 6: ina260.exporter.v1.Exporter.SelectChannel:input_type -> ina260.exporter.v1.SelectChannelRequest
+	1, // 7: ina260.exporter.v1.Exporter.ListDevices:output_type -> ina260.exporter.v1.ListDevicesResponse
+	3, // 8: ina260.exporter.v1.Exporter.GetReading:output_type -> ina260.exporter.v1.Reading
+	3, // 9: ina260.exporter.v1.Exporter.StreamReadings:output_type -> ina260.exporter.v1.Reading
+	7, // 10: ina260.exporter.v1.Exporter.SelectChannel:output_type -> ina260.exporter.v1.SelectChannelResponse
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_exporter_proto_init() }
+func file_exporter_proto_init() {
+	if File_exporter_proto != nil {
+		return
+	}
+	file_exporter_proto_msgTypes[2].OneofWrappers = []any{}
+	file_exporter_proto_msgTypes[6].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_exporter_proto_rawDesc), len(file_exporter_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_exporter_proto_goTypes,
+		DependencyIndexes: file_exporter_proto_depIdxs,
+		MessageInfos:      file_exporter_proto_msgTypes,
+	}.Build()
+	File_exporter_proto = out.File
+	file_exporter_proto_goTypes = nil
+	file_exporter_proto_depIdxs = nil
+}
@@ -0,0 +1,266 @@
+// The gRPC API of rbp-control-i2c-multiplexer, served with --grpc.listen-address.
+// It carries the same data as the JSON API under /api/v1.
+//
+// The Go code next to this file is generated with buf (see buf.gen.yaml):
+//
+//	buf generate
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: exporter.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Exporter_ListDevices_FullMethodName    = "/ina260.exporter.v1.Exporter/ListDevices"
+	Exporter_GetReading_FullMethodName     = "/ina260.exporter.v1.Exporter/GetReading"
+	Exporter_StreamReadings_FullMethodName = "/ina260.exporter.v1.Exporter/StreamReadings"
+	Exporter_SelectChannel_FullMethodName  = "/ina260.exporter.v1.Exporter/SelectChannel"
+)
+
+// ExporterClient is the client API for Exporter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExporterClient interface {
+	// ListDevices returns every polled power monitor.
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// GetReading returns the latest reading of a device, or a new one with fresh.
+	// It fails with NOT_FOUND for an unknown device, and UNAVAILABLE before the
+	// first reading or when a fresh reading fails.
+	GetReading(ctx context.Context, in *GetReadingRequest, opts ...grpc.CallOption) (*Reading, error)
+	// StreamReadings sends every reading as it is published, until the client
+	// cancels. A client that falls behind misses readings.
+	StreamReadings(ctx context.Context, in *StreamReadingsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Reading], error)
+	// SelectChannel selects a mux channel, or deselects every channel, for
+	// talking to a device on it from another bus master. It needs
+	// --debug-i2c-token-file and an "authorization: Bearer <token>" metadata
+	// entry. The selection lasts until the exporter next reads a sensor.
+	SelectChannel(ctx context.Context, in *SelectChannelRequest, opts ...grpc.CallOption) (*SelectChannelResponse, error)
+}
+
+type exporterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExporterClient(cc grpc.ClientConnInterface) ExporterClient {
+	return &exporterClient{cc}
+}
+
+func (c *exporterClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, Exporter_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exporterClient) GetReading(ctx context.Context, in *GetReadingRequest, opts ...grpc.CallOption) (*Reading, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Reading)
+	err := c.cc.Invoke(ctx, Exporter_GetReading_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exporterClient) StreamReadings(ctx context.Context, in *StreamReadingsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Reading], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Exporter_ServiceDesc.Streams[0], Exporter_StreamReadings_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamReadingsRequest, Reading]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Exporter_StreamReadingsClient = grpc.ServerStreamingClient[Reading]
+
+func (c *exporterClient) SelectChannel(ctx context.Context, in *SelectChannelRequest, opts ...grpc.CallOption) (*SelectChannelResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SelectChannelResponse)
+	err := c.cc.Invoke(ctx, Exporter_SelectChannel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExporterServer is the server API for Exporter service.
+// All implementations must embed UnimplementedExporterServer
+// for forward compatibility.
+type ExporterServer interface {
+	// ListDevices returns every polled power monitor.
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// GetReading returns the latest reading of a device, or a new one with fresh.
+	// It fails with NOT_FOUND for an unknown device, and UNAVAILABLE before the
+	// first reading or when a fresh reading fails.
+	GetReading(context.Context, *GetReadingRequest) (*Reading, error)
+	// StreamReadings sends every reading as it is published, until the client
+	// cancels. A client that falls behind misses readings.
+	StreamReadings(*StreamReadingsRequest, grpc.ServerStreamingServer[Reading]) error
+	// SelectChannel selects a mux channel, or deselects every channel, for
+	// talking to a device on it from another bus master. It needs
+	// --debug-i2c-token-file and an "authorization: Bearer <token>" metadata
+	// entry. The selection lasts until the exporter next reads a sensor.
+	SelectChannel(context.Context, *SelectChannelRequest) (*SelectChannelResponse, error)
+	mustEmbedUnimplementedExporterServer()
+}
+
+// UnimplementedExporterServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedExporterServer struct{}
+
+func (UnimplementedExporterServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedExporterServer) GetReading(context.Context, *GetReadingRequest) (*Reading, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReading not implemented")
+}
+func (UnimplementedExporterServer) StreamReadings(*StreamReadingsRequest, grpc.ServerStreamingServer[Reading]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamReadings not implemented")
+}
+func (UnimplementedExporterServer) SelectChannel(context.Context, *SelectChannelRequest) (*SelectChannelResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SelectChannel not implemented")
+}
+func (UnimplementedExporterServer) mustEmbedUnimplementedExporterServer() {}
+func (UnimplementedExporterServer) testEmbeddedByValue()                  {}
+
+// UnsafeExporterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExporterServer will
+// result in compilation errors.
+type UnsafeExporterServer interface {
+	mustEmbedUnimplementedExporterServer()
+}
+
+func RegisterExporterServer(s grpc.ServiceRegistrar, srv ExporterServer) {
+	// If the following call pancis, it indicates UnimplementedExporterServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Exporter_ServiceDesc, srv)
+}
+
+func _Exporter_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExporterServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exporter_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExporterServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exporter_GetReading_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReadingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExporterServer).GetReading(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exporter_GetReading_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExporterServer).GetReading(ctx, req.(*GetReadingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Exporter_StreamReadings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamReadingsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExporterServer).StreamReadings(m, &grpc.GenericServerStream[StreamReadingsRequest, Reading]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Exporter_StreamReadingsServer = grpc.ServerStreamingServer[Reading]
+
+func _Exporter_SelectChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SelectChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExporterServer).SelectChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Exporter_SelectChannel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExporterServer).SelectChannel(ctx, req.(*SelectChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Exporter_ServiceDesc is the grpc.ServiceDesc for Exporter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Exporter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ina260.exporter.v1.Exporter",
+	HandlerType: (*ExporterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDevices",
+			Handler:    _Exporter_ListDevices_Handler,
+		},
+		{
+			MethodName: "GetReading",
+			Handler:    _Exporter_GetReading_Handler,
+		},
+		{
+			MethodName: "SelectChannel",
+			Handler:    _Exporter_SelectChannel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamReadings",
+			Handler:       _Exporter_StreamReadings_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "exporter.proto",
+}
@@ -0,0 +1,292 @@
+// Package simulate provides a fake I2C bus with TCA9548A multiplexers and INA260
+// sensors, for developing and demonstrating the exporter without hardware.
+package simulate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/physic"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+// Waveforms the simulated current can follow, by name.
+const (
+	WaveformConstant = "constant"
+	WaveformSine     = "sine"
+	WaveformSquare   = "square"
+	WaveformTriangle = "triangle"
+)
+
+// Options describe the simulated hardware and its readings. The current swings
+// by half its nominal value along the waveform, and the bus voltage sags by up
+// to 2% as it does; both then get Gaussian noise.
+type Options struct {
+	Muxes       []uint16      // TCA9548A addresses, with an INA260 on every channel; none puts one INA260 on the bus itself
+	Cascaded    []Cascade     // further muxes behind channels of those, with an INA260 on every channel of their own
+	MuxChannels int           // channels of each mux, 4 for a TCA9546A; 0 means the 8 of a TCA9548A
+	Waveform    string        // one of the Waveform* names
+	Period      time.Duration // period of the waveform
+	Voltage     float64       // nominal bus voltage in Volts
+	Current     float64       // nominal current in Amperes
+	Noise       float64       // standard deviation of the noise, relative to the nominal values
+}
+
+// Cascade is a simulated mux on a channel of another one, which holds no INA260
+// of its own then. Every simulated mux has a different address.
+type Cascade struct {
+	Parent  uint16 // address of the mux it sits behind
+	Channel int
+	Addr    uint16
+}
+
+// errNACK is returned for transfers to an address nothing answers on.
+var errNACK = errors.New("simulated I2C: no ACK")
+
+// location is a sensor's position: a mux address and channel, or 0 and -1 on the bus itself.
+type location struct {
+	mux     uint16
+	channel int
+}
+
+// Bus is a simulated I2C bus implementing i2c.BusCloser.
+type Bus struct {
+	opts    Options
+	start   time.Time
+	mu      sync.Mutex
+	control map[uint16]byte     // control register of each mux
+	parents map[uint16]location // where each cascaded mux sits
+	sensors map[location]*sensor
+}
+
+// NewBus returns a simulated bus with the hardware described by opts.
+func NewBus(opts Options) (*Bus, error) {
+	switch opts.Waveform {
+	case WaveformConstant, WaveformSine, WaveformSquare, WaveformTriangle:
+	default:
+		return nil, fmt.Errorf("invalid waveform %q: must be %s, %s, %s or %s", opts.Waveform, WaveformConstant, WaveformSine, WaveformSquare, WaveformTriangle)
+	}
+	if opts.Period <= 0 && opts.Waveform != WaveformConstant {
+		return nil, fmt.Errorf("waveform period must be positive, got %s", opts.Period)
+	}
+	if opts.Noise < 0 {
+		return nil, fmt.Errorf("noise must not be negative, got %g", opts.Noise)
+	}
+	if opts.MuxChannels == 0 {
+		opts.MuxChannels = tca9548a.Channels
+	}
+	if opts.MuxChannels < 1 || opts.MuxChannels > tca9548a.Channels {
+		return nil, fmt.Errorf("mux channels must be between 1 and %d, got %d", tca9548a.Channels, opts.MuxChannels)
+	}
+	b := &Bus{opts: opts, start: time.Now(), control: make(map[uint16]byte), parents: make(map[uint16]location), sensors: make(map[location]*sensor)}
+	if len(opts.Muxes) == 0 {
+		if len(opts.Cascaded) > 0 {
+			return nil, errors.New("cascaded muxes need a mux to sit behind")
+		}
+		b.sensors[location{0, -1}] = newSensor(0)
+	}
+	muxes := slices.Clone(opts.Muxes)
+	for _, c := range opts.Cascaded {
+		if !slices.Contains(muxes, c.Parent) {
+			return nil, fmt.Errorf("cascaded mux 0x%02X: no mux at 0x%02X to sit behind", c.Addr, c.Parent)
+		}
+		if slices.Contains(muxes, c.Addr) {
+			return nil, fmt.Errorf("cascaded mux 0x%02X: another mux has that address", c.Addr)
+		}
+		if c.Channel < 0 || c.Channel >= opts.MuxChannels {
+			return nil, fmt.Errorf("cascaded mux 0x%02X: channel must be between 0 and %d, got %d", c.Addr, opts.MuxChannels-1, c.Channel)
+		}
+		b.parents[c.Addr] = location{c.Parent, c.Channel}
+		muxes = append(muxes, c.Addr)
+	}
+	for i, addr := range muxes {
+		b.control[addr] = 0x00
+		for ch := 0; ch < opts.MuxChannels; ch++ {
+			// Spread the sensors over the period, so each shows a different value
+			n := i*opts.MuxChannels + ch
+			b.sensors[location{addr, ch}] = newSensor(2 * math.Pi * float64(n) / float64(len(muxes)*opts.MuxChannels))
+		}
+	}
+	for _, at := range b.parents {
+		delete(b.sensors, at)
+	}
+	return b, nil
+}
+
+func (b *Bus) String() string { return "simulated I2C bus" }
+
+func (b *Bus) SetSpeed(physic.Frequency) error { return nil }
+
+func (b *Bus) Close() error { return nil }
+
+// Tx handles one transfer: a control register write or read for a mux, and a
+// register pointer write followed by a read, or a register write, for an INA260.
+func (b *Bus) Tx(addr uint16, w, r []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.control[addr]; ok {
+		if !b.reachable(addr) {
+			return errNACK
+		}
+		if len(w) > 0 {
+			// Bits beyond the last channel, the interrupt flags of a PCA9545A, are not writable
+			b.control[addr] = w[len(w)-1] & byte(1<<b.opts.MuxChannels-1)
+		}
+		if len(r) > 0 {
+			r[0] = b.control[addr]
+		}
+		return nil
+	}
+	if addr != ina260.Address {
+		return errNACK
+	}
+	s, err := b.routed()
+	if err != nil {
+		return err
+	}
+	return s.tx(b.measure(s.phase), w, r)
+}
+
+// routed returns the INA260 the selected mux channels connect to.
+func (b *Bus) routed() (*sensor, error) {
+	if len(b.control) == 0 {
+		return b.sensors[location{0, -1}], nil
+	}
+	var found *sensor
+	for addr, mask := range b.control {
+		if !b.reachable(addr) {
+			continue
+		}
+		for ch := 0; ch < b.opts.MuxChannels; ch++ {
+			s, ok := b.sensors[location{addr, ch}]
+			if mask&(1<<ch) == 0 || !ok {
+				continue // not routed, or a cascaded mux instead of an INA260
+			}
+			if found != nil {
+				return nil, fmt.Errorf("simulated I2C: several INA260s at 0x%02X answer at once", ina260.Address)
+			}
+			found = s
+		}
+	}
+	if found == nil {
+		return nil, errNACK
+	}
+	return found, nil
+}
+
+// reachable reports whether the mux at addr is on the bus itself, or behind
+// channels that are all routed.
+func (b *Bus) reachable(addr uint16) bool {
+	at, ok := b.parents[addr]
+	if !ok {
+		return true
+	}
+	return b.control[at.mux]&(1<<at.channel) != 0 && b.reachable(at.mux)
+}
+
+// measurement is the current, voltage and power of one sensor at one moment.
+type measurement struct {
+	current, voltage, power float64 // Amperes, Volts and Watts
+}
+
+// measure returns what a sensor at the given phase of the waveform reads now.
+func (b *Bus) measure(phase float64) measurement {
+	w := 0.0 // position along the waveform, between -1 and 1
+	if b.opts.Waveform != WaveformConstant {
+		x := math.Mod(2*math.Pi*time.Since(b.start).Seconds()/b.opts.Period.Seconds()+phase, 2*math.Pi)
+		switch b.opts.Waveform {
+		case WaveformSine:
+			w = math.Sin(x)
+		case WaveformSquare:
+			if x < math.Pi {
+				w = 1
+			} else {
+				w = -1
+			}
+		case WaveformTriangle:
+			w = 2*math.Abs(x/math.Pi-1) - 1
+		}
+	}
+	m := measurement{
+		current: b.opts.Current*(1+0.5*w) + b.opts.Current*b.opts.Noise*rand.NormFloat64(),
+		voltage: b.opts.Voltage*(1-0.01*(1+w)) + b.opts.Voltage*b.opts.Noise*rand.NormFloat64(),
+	}
+	m.power = m.current * m.voltage
+	return m
+}
+
+// sensor holds the writable registers of one simulated INA260.
+type sensor struct {
+	phase   float64
+	pointer byte
+	regs    map[byte]uint16
+}
+
+// Power-on values of the writable registers.
+const (
+	resetConfig = 0x6127
+	configRST   = 0x8000
+)
+
+func newSensor(phase float64) *sensor {
+	return &sensor{phase: phase, regs: map[byte]uint16{ina260.RegConfig: resetConfig, ina260.RegMaskEnable: 0, ina260.RegAlertLimit: 0}}
+}
+
+func (s *sensor) tx(m measurement, w, r []byte) error {
+	if len(w) > 0 {
+		s.pointer = w[0]
+	}
+	if len(w) == 3 {
+		value := binary.BigEndian.Uint16(w[1:])
+		switch {
+		case s.pointer == ina260.RegConfig && value&configRST != 0:
+			s.regs = newSensor(s.phase).regs
+		case s.pointer == ina260.RegConfig || s.pointer == ina260.RegMaskEnable || s.pointer == ina260.RegAlertLimit:
+			s.regs[s.pointer] = value
+		default:
+			return fmt.Errorf("simulated I2C: register 0x%02X is read-only", s.pointer)
+		}
+	} else if len(w) > 1 {
+		return fmt.Errorf("simulated I2C: %d-byte write not supported", len(w))
+	}
+	if len(r) == 0 {
+		return nil
+	}
+	if len(r) != 2 {
+		return fmt.Errorf("simulated I2C: %d-byte read not supported", len(r))
+	}
+	var value uint16
+	switch s.pointer {
+	case ina260.RegCurrent:
+		value = uint16(int16(clamp(m.current*1000/ina260.CurrentLSB, math.MinInt16, math.MaxInt16)))
+	case ina260.RegBusVoltage:
+		value = uint16(clamp(m.voltage*1000/ina260.VoltageLSB, 0, 0x7FFF))
+	case ina260.RegPower:
+		value = uint16(clamp(math.Abs(m.power)*1000/ina260.PowerLSB, 0, math.MaxUint16))
+	case ina260.RegMaskEnable:
+		value = s.regs[ina260.RegMaskEnable] | ina260.MaskEnableCVRF // every triggered conversion is done at once
+	case ina260.RegManufID:
+		value = ina260.ManufacturerID
+	case ina260.RegDeviceID:
+		value = ina260.DeviceID // die revision 0
+	default:
+		var ok bool
+		if value, ok = s.regs[s.pointer]; !ok {
+			return fmt.Errorf("simulated I2C: no register 0x%02X", s.pointer)
+		}
+	}
+	binary.BigEndian.PutUint16(r, value)
+	return nil
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Round(math.Max(lo, math.Min(hi, v)))
+}
@@ -0,0 +1,53 @@
+package smbus
+
+import (
+	"fmt"
+	"slices"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// PECError is returned for a read whose PEC does not match its bytes. It wraps
+// ErrPEC.
+type PECError struct {
+	Addr      uint16
+	Command   byte // first byte written, the register or command code of most chips
+	Got, Want byte
+}
+
+func (e *PECError) Error() string {
+	return fmt.Sprintf("read of command 0x%02X from 0x%02X: %v: got 0x%02X, want 0x%02X", e.Command, e.Addr, ErrPEC, e.Got, e.Want)
+}
+
+func (e *PECError) Unwrap() error { return ErrPEC }
+
+// Bus adds packet error checking to every transaction on an i2c.Bus, for the
+// devices of a chip that supports it: a write carries the PEC of its bytes as
+// one more byte, for the device to check before it takes them, and a read takes
+// the PEC byte the device sends after the data, failing with a *PECError if it
+// does not match. Only use it for such chips; any other one sends no PEC, so
+// every read fails its check.
+type Bus struct {
+	i2c.Bus
+}
+
+func (b *Bus) String() string { return b.Bus.String() + " (PEC)" }
+
+func (b *Bus) Tx(addr uint16, w, r []byte) error {
+	if len(r) == 0 {
+		return b.Bus.Tx(addr, append(slices.Clip(w), PEC(addr, w, nil)), nil)
+	}
+	buf := make([]byte, len(r)+1)
+	if err := b.Bus.Tx(addr, w, buf); err != nil {
+		return err
+	}
+	if want := PEC(addr, w, buf[:len(r)]); buf[len(r)] != want {
+		e := &PECError{Addr: addr, Got: buf[len(r)], Want: want}
+		if len(w) > 0 {
+			e.Command = w[0]
+		}
+		return e
+	}
+	copy(r, buf)
+	return nil
+}
@@ -0,0 +1,72 @@
+package smbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"periph.io/x/conn/v3/physic"
+)
+
+// fakeBus records the bytes of the last write and answers every read with data.
+type fakeBus struct {
+	wrote []byte
+	data  []byte
+}
+
+func (b *fakeBus) String() string                  { return "fake" }
+func (b *fakeBus) SetSpeed(physic.Frequency) error { return nil }
+func (b *fakeBus) Tx(_ uint16, w, r []byte) error {
+	b.wrote = append([]byte(nil), w...)
+	copy(r, b.data)
+	return nil
+}
+
+const addr = 0x58
+
+func TestBusWriteAppendsPEC(t *testing.T) {
+	fake := &fakeBus{}
+	w := []byte{0x01, 0xAB, 0xCD}
+	if err := (&Bus{Bus: fake}).Tx(addr, w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := append(bytes.Clone(w), PEC(addr, w, nil)); !bytes.Equal(fake.wrote, want) {
+		t.Errorf("wrote % X, want % X", fake.wrote, want)
+	}
+	if len(w) != 3 {
+		t.Errorf("Tx changed the length of the caller's write to %d", len(w))
+	}
+}
+
+func TestBusReadChecksPEC(t *testing.T) {
+	w, data := []byte{0x02}, []byte{0x12, 0x34}
+	fake := &fakeBus{data: append(bytes.Clone(data), PEC(addr, w, data))}
+	r := make([]byte, len(data))
+	if err := (&Bus{Bus: fake}).Tx(addr, w, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, data) {
+		t.Errorf("read % X, want % X", r, data)
+	}
+	if !bytes.Equal(fake.wrote, w) {
+		t.Errorf("wrote % X for a read, want % X without a PEC", fake.wrote, w)
+	}
+}
+
+func TestBusReadBadPEC(t *testing.T) {
+	w, data := []byte{0x02}, []byte{0x12, 0x34}
+	want := PEC(addr, w, data)
+	fake := &fakeBus{data: append(bytes.Clone(data), want^0x01)}
+	r := make([]byte, len(data))
+	err := (&Bus{Bus: fake}).Tx(addr, w, r)
+	if !errors.Is(err, ErrPEC) {
+		t.Fatalf("Tx error = %v, want ErrPEC", err)
+	}
+	var pe *PECError
+	if !errors.As(err, &pe) || pe.Addr != addr || pe.Command != 0x02 || pe.Got != want^0x01 || pe.Want != want {
+		t.Errorf("Tx error = %#v, want a PECError for command 0x02 with 0x%02X", pe, want)
+	}
+	if !bytes.Equal(r, make([]byte, len(data))) {
+		t.Errorf("read % X on a PEC mismatch, want it left untouched", r)
+	}
+}
@@ -0,0 +1,43 @@
+// Package smbus computes the SMBus Packet Error Code (PEC), the CRC-8 a device
+// that supports packet error checking appends to each transaction, so a bit
+// flipped on the wire is caught instead of read as a valid value, and Bus adds it
+// to the transactions of a bus.
+package smbus
+
+import "errors"
+
+// ErrPEC is returned, wrapped, for a transaction whose PEC does not match its bytes.
+var ErrPEC = errors.New("PEC mismatch")
+
+// CRC8 extends crc with data, using the SMBus polynomial x^8 + x^2 + x + 1. A
+// PEC starts from 0.
+func CRC8(crc byte, data ...byte) byte {
+	for _, b := range data {
+		crc ^= b
+		for range 8 {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// PEC returns the PEC of a transaction with the 7-bit address addr that writes w
+// and, unless r is empty, then reads r after a repeated start. It covers every
+// byte on the wire: unless w is empty the address with the write bit and w, and
+// with a read the address with the read bit and r.
+func PEC(addr uint16, w, r []byte) byte {
+	var crc byte
+	if len(w) > 0 {
+		crc = CRC8(crc, byte(addr<<1))
+		crc = CRC8(crc, w...)
+	}
+	if len(r) > 0 {
+		crc = CRC8(crc, byte(addr<<1|1))
+		crc = CRC8(crc, r...)
+	}
+	return crc
+}
@@ -0,0 +1,34 @@
+package smbus
+
+import "testing"
+
+func TestCRC8CheckVector(t *testing.T) {
+	// The check value of CRC-8/SMBUS, from the catalogue of parametrised CRCs.
+	if got := CRC8(0, []byte("123456789")...); got != 0xF4 {
+		t.Errorf("CRC8(\"123456789\") = 0x%02X, want 0xF4", got)
+	}
+}
+
+func TestCRC8Extends(t *testing.T) {
+	data := []byte("123456789")
+	if got, want := CRC8(CRC8(0, data[:4]...), data[4:]...), CRC8(0, data...); got != want {
+		t.Errorf("CRC8 in two parts = 0x%02X, want 0x%02X", got, want)
+	}
+}
+
+func TestPEC(t *testing.T) {
+	tests := []struct {
+		name string
+		w, r []byte
+		want byte
+	}{
+		{"write", []byte{0x05, 0x12, 0x34}, nil, CRC8(0, 0xB0, 0x05, 0x12, 0x34)},
+		{"read", []byte{0x05}, []byte{0x12, 0x34}, CRC8(0, 0xB0, 0x05, 0xB1, 0x12, 0x34)},
+		{"receive", nil, []byte{0x12}, CRC8(0, 0xB1, 0x12)},
+	}
+	for _, tt := range tests {
+		if got := PEC(0x58, tt.w, tt.r); got != tt.want {
+			t.Errorf("%s: PEC = 0x%02X, want 0x%02X", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Package spool is an on-disk ring buffer of records, where the push sinks keep
+// what they could not send while their server is unreachable, for replay once it
+// answers again, after a restart too.
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+)
+
+var spooledRecords = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ina260_output_spooled_records",
+	Help: "Number of writes kept on disk for an output sink that could not send them, waiting to be replayed.",
+}, []string{"sink"})
+
+// spoolMagic starts every spool file, with the format version.
+const spoolMagic = "INASPOL1"
+
+// spoolHeaderSize is the size of the header: the magic, then the size of the
+// data region, the head and tail positions and the record count, as big-endian
+// uint64s.
+const spoolHeaderSize = 8 + 4*8
+
+// spoolRecordHeader is the length and CRC-32 of the payload in front of each record.
+const spoolRecordHeader = 8
+
+// Spool is a bounded ring of records in one file, for the sinks to keep what
+// they could not send while their server is unreachable, across restarts, and
+// replay it oldest first once it answers again. The data region has a fixed
+// size; a record that does not fit drops the oldest ones, which count as write
+// errors of the sink. Each record carries its CRC-32, and a spool found
+// inconsistent, e.g. after a power cut in the middle of a write, is emptied
+// rather than replayed. A Spool is safe for concurrent use.
+type Spool struct {
+	sink string
+	path string
+	size int64 // of the data region
+
+	mu         sync.Mutex
+	f          *os.File
+	head, tail uint64 // positions of the oldest record and of the end of the newest, growing without wrapping
+	count      uint64
+	peeked     uint64 // head when Peek last returned a record, if hasPeeked
+	hasPeeked  bool   // a Peek returned a record that has not been popped
+	gauge      prometheus.Gauge
+}
+
+// Open opens the spool of sink in dir, <dir>/<sink>.spool, with a data
+// region of size bytes, creating it if needed. The records of an existing file
+// of the same size are kept for replay; a file of another size, or one that is
+// not a spool, is started afresh.
+func Open(dir, sink string, size int64) (*Spool, error) {
+	if size < spoolRecordHeader+1 {
+		return nil, fmt.Errorf("spool size must be at least %d bytes, got %d", spoolRecordHeader+1, size)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, sink+".spool")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{sink: sink, path: path, size: size, f: f, gauge: spooledRecords.WithLabelValues(sink)}
+	if err := s.load(); err != nil {
+		slog.Warn("Starting a new spool file", "sink", sink, "path", path, "reason", err)
+		if err := s.reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if s.count > 0 {
+		slog.Info("Found spooled writes to replay", "sink", sink, "path", path, "records", s.count)
+	}
+	s.gauge.Set(float64(s.count))
+	return s, nil
+}
+
+// load reads the header of an existing file.
+func (s *Spool) load() error {
+	header := make([]byte, spoolHeaderSize)
+	if _, err := s.f.ReadAt(header, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("empty or truncated file")
+		}
+		return err
+	}
+	if string(header[:8]) != spoolMagic {
+		return errors.New("not a spool file")
+	}
+	if size := int64(binary.BigEndian.Uint64(header[8:])); size != s.size {
+		return fmt.Errorf("size %d instead of %d", size, s.size)
+	}
+	s.head = binary.BigEndian.Uint64(header[16:])
+	s.tail = binary.BigEndian.Uint64(header[24:])
+	s.count = binary.BigEndian.Uint64(header[32:])
+	if s.head > s.tail || s.tail-s.head > uint64(s.size) || (s.count == 0) != (s.head == s.tail) {
+		return errors.New("inconsistent header")
+	}
+	return nil
+}
+
+// reset empties the spool. The caller holds mu, or owns s.
+func (s *Spool) reset() error {
+	s.head, s.tail, s.count = 0, 0, 0
+	s.hasPeeked = false
+	if err := s.f.Truncate(spoolHeaderSize + s.size); err != nil {
+		return err
+	}
+	return s.writeHeader()
+}
+
+func (s *Spool) writeHeader() error {
+	header := make([]byte, spoolHeaderSize)
+	copy(header, spoolMagic)
+	binary.BigEndian.PutUint64(header[8:], uint64(s.size))
+	binary.BigEndian.PutUint64(header[16:], s.head)
+	binary.BigEndian.PutUint64(header[24:], s.tail)
+	binary.BigEndian.PutUint64(header[32:], s.count)
+	_, err := s.f.WriteAt(header, 0)
+	s.gauge.Set(float64(s.count))
+	return err
+}
+
+// writeAt writes b at position pos of the ring, wrapping around its end.
+func (s *Spool) writeAt(b []byte, pos uint64) error {
+	off := int64(pos % uint64(s.size))
+	n := min(int64(len(b)), s.size-off)
+	if _, err := s.f.WriteAt(b[:n], spoolHeaderSize+off); err != nil {
+		return err
+	}
+	if n < int64(len(b)) {
+		_, err := s.f.WriteAt(b[n:], spoolHeaderSize)
+		return err
+	}
+	return nil
+}
+
+// readAt fills b from position pos of the ring, wrapping around its end.
+func (s *Spool) readAt(b []byte, pos uint64) error {
+	off := int64(pos % uint64(s.size))
+	n := min(int64(len(b)), s.size-off)
+	if _, err := s.f.ReadAt(b[:n], spoolHeaderSize+off); err != nil {
+		return err
+	}
+	if n < int64(len(b)) {
+		_, err := s.f.ReadAt(b[n:], spoolHeaderSize)
+		return err
+	}
+	return nil
+}
+
+// length returns the payload length of the oldest record, checking it against
+// the used space.
+func (s *Spool) length() (uint64, error) {
+	header := make([]byte, spoolRecordHeader)
+	if err := s.readAt(header, s.head); err != nil {
+		return 0, err
+	}
+	n := uint64(binary.BigEndian.Uint32(header))
+	if spoolRecordHeader+n > s.tail-s.head {
+		return 0, fmt.Errorf("record of %d bytes is longer than the spooled data", n)
+	}
+	return n, nil
+}
+
+// corrupted empties a spool found inconsistent, counting its records as lost.
+func (s *Spool) corrupted(err error) error {
+	exporter.WriteErrors(s.sink).Add(float64(s.count))
+	slog.Warn("Discarding corrupted spool", "sink", s.sink, "path", s.path, "records", s.count, "err", err)
+	if rerr := s.reset(); rerr != nil {
+		return rerr
+	}
+	return fmt.Errorf("discarded corrupted spool %s: %w", s.path, err)
+}
+
+// Push appends a record, dropping the oldest ones if it does not fit otherwise.
+func (s *Spool) Push(rec []byte) error {
+	need := uint64(spoolRecordHeader + len(rec))
+	if need > uint64(s.size) {
+		exporter.WriteErrors(s.sink).Inc()
+		return fmt.Errorf("write of %d bytes does not fit in spool %s", len(rec), s.path)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dropped int
+	for uint64(s.size)-(s.tail-s.head) < need {
+		n, err := s.length()
+		if err != nil {
+			return s.corrupted(err)
+		}
+		s.head += spoolRecordHeader + n
+		s.count--
+		dropped++
+	}
+	if dropped > 0 {
+		exporter.WriteErrors(s.sink).Add(float64(dropped))
+		slog.Warn("Dropped the oldest spooled writes to make room", "sink", s.sink, "path", s.path, "dropped", dropped)
+	}
+	b := make([]byte, need)
+	binary.BigEndian.PutUint32(b, uint32(len(rec)))
+	binary.BigEndian.PutUint32(b[4:], crc32.ChecksumIEEE(rec))
+	copy(b[spoolRecordHeader:], rec)
+	if err := s.writeAt(b, s.tail); err != nil {
+		return err
+	}
+	s.tail += need
+	s.count++
+	return s.writeHeader()
+}
+
+// Peek returns the oldest record, or nil if the spool is empty.
+func (s *Spool) Peek() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 {
+		return nil, nil
+	}
+	n, err := s.length()
+	if err != nil {
+		return nil, s.corrupted(err)
+	}
+	b := make([]byte, spoolRecordHeader+n)
+	if err := s.readAt(b, s.head); err != nil {
+		return nil, err
+	}
+	rec := b[spoolRecordHeader:]
+	if crc32.ChecksumIEEE(rec) != binary.BigEndian.Uint32(b[4:]) {
+		return nil, s.corrupted(errors.New("CRC mismatch"))
+	}
+	s.peeked, s.hasPeeked = s.head, true
+	return rec, nil
+}
+
+// Pop removes the record Peek returned, once it has been sent or given up on.
+// It does nothing without such a record, or if a Push has dropped it to make
+// room meanwhile.
+func (s *Spool) Pop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 || !s.hasPeeked || s.head != s.peeked {
+		return nil
+	}
+	n, err := s.length()
+	if err != nil {
+		return s.corrupted(err)
+	}
+	s.head += spoolRecordHeader + n
+	s.count--
+	s.hasPeeked = false
+	return s.writeHeader()
+}
+
+// Len returns the number of spooled records.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.count)
+}
+
+// Close syncs the file to disk and closes it.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
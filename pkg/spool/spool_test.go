@@ -0,0 +1,188 @@
+package spool
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+)
+
+// writeErrors returns the write errors counted for sink.
+func writeErrors(t *testing.T, sink string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := exporter.WriteErrors(sink).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// record returns a record of 10 bytes, 18 in the spool with its header.
+func record(i int) []byte { return []byte(fmt.Sprintf("record%04d", i)) }
+
+func open(t *testing.T, dir, sink string, size int64) *Spool {
+	t.Helper()
+	s, err := Open(dir, sink, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func push(t *testing.T, s *Spool, recs ...int) {
+	t.Helper()
+	for _, i := range recs {
+		if err := s.Push(record(i)); err != nil {
+			t.Fatalf("Push(%d): %v", i, err)
+		}
+	}
+}
+
+// pop peeks and pops the oldest record, checking that it is record i.
+func pop(t *testing.T, s *Spool, i int) {
+	t.Helper()
+	rec, err := s.Peek()
+	if err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	if !bytes.Equal(rec, record(i)) {
+		t.Fatalf("Peek = %q, want %q", rec, record(i))
+	}
+	if err := s.Pop(); err != nil {
+		t.Fatalf("Pop: %v", err)
+	}
+}
+
+func TestSpoolWraps(t *testing.T) {
+	s := open(t, t.TempDir(), "wrap", 64)
+	defer s.Close()
+	push(t, s, 1, 2, 3)
+	pop(t, s, 1)
+	pop(t, s, 2)
+	push(t, s, 4, 5) // the second one starts at 72, past the end of the 64 bytes
+	for _, i := range []int{3, 4, 5} {
+		pop(t, s, i)
+	}
+	if rec, err := s.Peek(); rec != nil || err != nil {
+		t.Errorf("Peek of an empty spool = %q, %v", rec, err)
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len = %d, want 0", s.Len())
+	}
+}
+
+func TestSpoolDropsOldest(t *testing.T) {
+	s := open(t, t.TempDir(), "drop", 64)
+	defer s.Close()
+	before := writeErrors(t, "drop")
+	push(t, s, 1, 2, 3, 4, 5) // room for three
+	if got := writeErrors(t, "drop") - before; got != 2 {
+		t.Errorf("%g write errors for the dropped records, want 2", got)
+	}
+	if s.Len() != 3 {
+		t.Errorf("Len = %d, want 3", s.Len())
+	}
+	for _, i := range []int{3, 4, 5} {
+		pop(t, s, i)
+	}
+	if err := s.Push(make([]byte, 64)); err == nil {
+		t.Error("Push of a record larger than the spool succeeded")
+	}
+	if got := writeErrors(t, "drop") - before; got != 3 {
+		t.Errorf("%g write errors after a record that does not fit, want 3", got)
+	}
+}
+
+func TestSpoolPopOnlyPeeked(t *testing.T) {
+	s := open(t, t.TempDir(), "pop", 64)
+	defer s.Close()
+	push(t, s, 1)
+	if err := s.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 {
+		t.Fatalf("Pop without a Peek removed a record, Len = %d", s.Len())
+	}
+	pop(t, s, 1)
+	push(t, s, 2)
+	if err := s.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("a second Pop after one Peek removed a record, Len = %d", s.Len())
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, "reopen", 64)
+	push(t, s, 1, 2, 3)
+	pop(t, s, 1)
+	if _, err := s.Peek(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, dir, "reopen", 64)
+	if s.Len() != 2 {
+		t.Fatalf("Len after reopening = %d, want 2", s.Len())
+	}
+	if err := s.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("Pop after reopening removed the record peeked before, Len = %d", s.Len())
+	}
+	pop(t, s, 2)
+	pop(t, s, 3)
+	s.Close()
+
+	push(t, open(t, dir, "reopen", 64), 4)
+	s = open(t, dir, "reopen", 128)
+	defer s.Close()
+	if s.Len() != 0 {
+		t.Errorf("Len after reopening with another size = %d, want 0", s.Len())
+	}
+}
+
+func TestSpoolCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, "corrupt", 64)
+	defer s.Close()
+	push(t, s, 1, 2)
+	before := writeErrors(t, "corrupt")
+
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{'R'}, spoolHeaderSize+spoolRecordHeader); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if rec, err := s.Peek(); err == nil {
+		t.Fatalf("Peek of a flipped byte = %q, want an error", rec)
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len after the corruption = %d, want 0", s.Len())
+	}
+	if got := writeErrors(t, "corrupt") - before; got != 2 {
+		t.Errorf("%g write errors for the discarded records, want 2", got)
+	}
+
+	push(t, s, 3)
+	if err := s.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Pop after the reset removed a record that was never peeked, Len = %d", s.Len())
+	}
+	pop(t, s, 3)
+}
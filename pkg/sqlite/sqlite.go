@@ -0,0 +1,163 @@
+// Package sqlite stores readings in a SQLite history database and exports them
+// as JSON lines.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver; pure Go, so cross-compiling needs no cgo
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// Options configure a SQLite history sink.
+type Options struct {
+	Path      string        // database file, created if missing
+	Retention time.Duration // readings older than this are deleted; 0 keeps every reading
+}
+
+// sqlitePruneInterval is how often readings past the retention window are deleted.
+const sqlitePruneInterval = time.Minute
+
+// sqliteSchema creates the readings table. time is in Unix microseconds, so the
+// index orders readings and ranges are cheap to select and delete.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS readings (
+	time     INTEGER NOT NULL,
+	hostname TEXT    NOT NULL,
+	device   TEXT    NOT NULL,
+	voltage  REAL    NOT NULL,
+	current  REAL    NOT NULL,
+	power    REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS readings_time ON readings (time);
+`
+
+// sqliteSink stores every reading in a local SQLite database, so the history
+// survives an unreachable Prometheus server and can be exported later. The
+// database uses write-ahead logging, so Export can read it while the
+// exporter writes.
+type sqliteSink struct {
+	opts      Options
+	db        *sql.DB
+	insert    *sql.Stmt
+	lastPrune time.Time
+}
+
+// NewSQLiteSink opens or creates the database in opts and returns a sink writing to it.
+func NewSink(opts Options) (exporter.Sink, error) {
+	if opts.Path == "" {
+		return nil, errors.New("no SQLite database given")
+	}
+	if opts.Retention < 0 {
+		return nil, fmt.Errorf("retention must not be negative, got %s", opts.Retention)
+	}
+	db, err := openSQLite(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create tables in %s: %w", opts.Path, err)
+	}
+	insert, err := db.Prepare("INSERT INTO readings (time, hostname, device, voltage, current, power) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare insert into %s: %w", opts.Path, err)
+	}
+	return &sqliteSink{opts: opts, db: db, insert: insert}, nil
+}
+
+// openSQLite opens the database at path with a single connection, waiting for
+// the lock rather than failing while another process writes.
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func (q *sqliteSink) Name() string { return "sqlite" }
+
+func (q *sqliteSink) Publish(s *exporter.Sensor, r ina260.Reading) error {
+	if _, err := q.insert.Exec(r.Time.UnixMicro(), s.Hostname, s.Device, r.Voltage, r.Current, r.Power); err != nil {
+		return fmt.Errorf("failed to insert reading: %w", err)
+	}
+	if q.opts.Retention == 0 || time.Since(q.lastPrune) < sqlitePruneInterval {
+		return nil
+	}
+	q.lastPrune = time.Now()
+	if _, err := q.db.Exec("DELETE FROM readings WHERE time < ?", time.Now().Add(-q.opts.Retention).UnixMicro()); err != nil {
+		return fmt.Errorf("failed to delete readings past the retention window: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (q *sqliteSink) Close() error {
+	q.insert.Close()
+	return q.db.Close()
+}
+
+// Export writes the readings stored in the database at path to w as JSON
+// lines in the MarshalReadingJSON format, oldest first. It covers the readings
+// taken at or after since, of every device or only of device if it is not empty,
+// and returns how many readings it wrote.
+func Export(w io.Writer, path string, since time.Time, device string) (int, error) {
+	// Opening would create a missing database
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	db, err := openSQLite(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	query := "SELECT time, hostname, device, voltage, current, power FROM readings WHERE time >= ?"
+	args := []any{since.UnixMicro()}
+	if device != "" {
+		query += " AND device = ?"
+		args = append(args, device)
+	}
+	rows, err := db.Query(query+" ORDER BY time", args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s: %w", path, err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var us int64
+		var s exporter.Sensor
+		var r ina260.Reading
+		if err := rows.Scan(&us, &s.Hostname, &s.Device, &r.Voltage, &r.Current, &r.Power); err != nil {
+			return n, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		r.Time = time.UnixMicro(us)
+		line, err := exporter.MarshalReadingJSON(&s, r)
+		if err != nil {
+			return n, err
+		}
+		if _, err := w.Write(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return n, nil
+}
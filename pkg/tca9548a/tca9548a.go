@@ -0,0 +1,374 @@
+// Package tca9548a drives the TI TCA9548A 8-channel I2C multiplexer, which routes
+// the upstream bus to any combination of its downstream channels, and the
+// compatible TCA9546A, PCA9548A, PCA9546A and PCA9545A; see Model.
+package tca9548a
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/conn/v3/i2c"
+)
+
+// DefaultAddress is the TCA9548A I2C address with A0-A2 tied to GND.
+const DefaultAddress = uint16(0x70)
+
+// Channels is the number of downstream channels of the TCA9548A.
+const Channels = 8
+
+// Model is a multiplexer of the family. Each has one control register with a
+// bit per channel, enabling the channel when set; they differ in how many
+// channels and address pins they have.
+type Model struct {
+	Name       string // lowercase part number, e.g. "tca9546a"
+	Channels   int    // downstream channels, 4 or 8
+	MaxAddress uint16 // highest address the address pins select; the lowest is DefaultAddress
+}
+
+// The supported models. The PCA9545A reports its four interrupt inputs in the
+// upper nibble of the control register; ReadControl masks them out.
+var (
+	TCA9548A = Model{Name: "tca9548a", Channels: 8, MaxAddress: 0x77}
+	PCA9548A = Model{Name: "pca9548a", Channels: 8, MaxAddress: 0x77}
+	TCA9546A = Model{Name: "tca9546a", Channels: 4, MaxAddress: 0x77}
+	PCA9546A = Model{Name: "pca9546a", Channels: 4, MaxAddress: 0x77}
+	PCA9545A = Model{Name: "pca9545a", Channels: 4, MaxAddress: 0x73} // only A0 and A1
+)
+
+// Models lists the supported models, for ModelByName and help texts.
+var Models = []Model{TCA9548A, PCA9548A, TCA9546A, PCA9546A, PCA9545A}
+
+// ModelByName returns the model with the given lowercase part number.
+func ModelByName(name string) (Model, error) {
+	var names []string
+	for _, m := range Models {
+		if m.Name == name {
+			return m, nil
+		}
+		names = append(names, m.Name)
+	}
+	return Model{}, fmt.Errorf("unknown multiplexer %q: must be one of %s", name, strings.Join(names, ", "))
+}
+
+// String returns the part number as printed on the chip, e.g. "TCA9546A".
+func (m Model) String() string { return strings.ToUpper(m.Name) }
+
+// channelBits is the control register bits that enable channels.
+func (m Model) channelBits() byte { return byte(1<<m.Channels - 1) }
+
+// ChannelMask returns the control register byte that enables only the given
+// channel. The range is checked before shifting, since shifting past the last
+// channel would enable nothing, or on a TCA9548A wrap to 0 when truncated to a byte.
+func (m Model) ChannelMask(channel int) (byte, error) {
+	if channel < 0 || channel >= m.Channels {
+		return 0, fmt.Errorf("channel number must be between 0 and %d on the %s, got %d", m.Channels-1, m, channel)
+	}
+	return byte(1 << channel), nil
+}
+
+// CheckAddress reports an error if the model cannot be strapped to addr.
+func (m Model) CheckAddress(addr uint16) error {
+	if addr < DefaultAddress || addr > m.MaxAddress {
+		return fmt.Errorf("the %s answers at 0x%02X-0x%02X, not 0x%02X", m, DefaultAddress, m.MaxAddress, addr)
+	}
+	return nil
+}
+
+// ReadControl returns the channels enabled in the control register of the mux at dev.
+func (m Model) ReadControl(dev *i2c.Dev) (byte, error) {
+	control := make([]byte, 1)
+	if err := dev.Tx(nil, control); err != nil {
+		return 0, fmt.Errorf("failed to read %s control register: %w", m, err)
+	}
+	return control[0] & m.channelBits(), nil
+}
+
+// ChannelMask returns the TCA9548A control register byte that enables only the given channel.
+func ChannelMask(channel int) (byte, error) {
+	return TCA9548A.ChannelMask(channel)
+}
+
+// Probe checks that the mux acknowledges its address by reading back its
+// control register. periph skips zero-length transactions entirely, so a
+// one-byte read is the cheapest transfer that actually reaches the bus.
+func Probe(dev *i2c.Dev) error {
+	if err := dev.Tx(nil, make([]byte, 1)); err != nil {
+		return fmt.Errorf("no ACK from the multiplexer at address 0x%X: %w", dev.Addr, err)
+	}
+	return nil
+}
+
+// RESET timing. The datasheet only requires a 6 ns low pulse and 500 ns until
+// channels are deselected; both are padded generously here.
+const (
+	resetPulse    = 1 * time.Microsecond
+	resetRecovery = 1 * time.Microsecond
+)
+
+// Reset returns the mux to its power-on state with all channels off.
+// If resetPin is set, the active-low RESET line is pulsed; otherwise the
+// control register is cleared over I2C.
+func Reset(dev *i2c.Dev, resetPin string) error {
+	if resetPin == "" {
+		if err := dev.Tx([]byte{0x00}, nil); err != nil {
+			return fmt.Errorf("failed to clear the multiplexer control register: %w", err)
+		}
+		return nil
+	}
+	pin := gpioreg.ByName(resetPin)
+	if pin == nil {
+		return fmt.Errorf("unknown GPIO pin %q", resetPin)
+	}
+	if err := pin.Out(gpio.Low); err != nil {
+		return fmt.Errorf("failed to drive TCA9548A reset pin %s low: %w", resetPin, err)
+	}
+	time.Sleep(resetPulse)
+	if err := pin.Out(gpio.High); err != nil {
+		return fmt.Errorf("failed to release TCA9548A reset pin %s: %w", resetPin, err)
+	}
+	time.Sleep(resetRecovery)
+	return nil
+}
+
+// unknown marks the control byte of a Mux as unknown, e.g. after a failed write.
+// It never equals a single-channel mask, so the next Select writes again.
+const unknown byte = 0xFF
+
+// ErrControlMismatch is returned by a verifying Mux whose control register does
+// not hold the channels it selected, e.g. because another bus master changed them.
+var ErrControlMismatch = errors.New("control register does not match the selected channels")
+
+// Mux is a TCA9548A, or another Model, shared by the devices behind it. It
+// remembers the control byte last written, so channels are only switched when
+// another one is needed. A Mux is not safe for concurrent use; callers
+// serialize access to the bus.
+type Mux struct {
+	Dev   *i2c.Dev
+	Model Model
+	// Verify reads the control register back after every write, and before
+	// relying on the remembered channels, so a selection changed behind the Mux's
+	// back fails with ErrControlMismatch instead of reaching the wrong device.
+	Verify bool
+	// Observe, if set, is called with the control register's channels whenever
+	// they are written or read back.
+	Observe func(control byte)
+
+	selected byte
+}
+
+// New returns a TCA9548A Mux for dev whose current channel selection is
+// unknown, so the first Select always writes.
+func New(dev *i2c.Dev) *Mux {
+	return TCA9548A.New(dev)
+}
+
+// New returns a Mux of the model for dev, like the package-level New.
+func (m Model) New(dev *i2c.Dev) *Mux {
+	return &Mux{Dev: dev, Model: m, selected: unknown}
+}
+
+// Select routes the bus to the channels in mask, skipping the write if they are
+// already selected. It reports whether a write was made. Bits beyond the
+// model's channels are rejected rather than written.
+func (m *Mux) Select(mask byte) (bool, error) {
+	if m.selected == mask {
+		if !m.Verify {
+			return false, nil
+		}
+		if err := m.verify(mask); err != nil {
+			return false, fmt.Errorf("%s channel mask 0x%02X changed since it was selected: %w", m.Model, mask, err)
+		}
+		return false, nil
+	}
+	if mask&^m.Model.channelBits() != 0 {
+		return false, fmt.Errorf("channel mask 0x%02X enables channels the %d-channel %s does not have", mask, m.Model.Channels, m.Model)
+	}
+	if err := m.Dev.Tx([]byte{mask}, nil); err != nil {
+		m.selected = unknown
+		return true, fmt.Errorf("failed to select %s channel mask 0x%02X: %w", m.Model, mask, err)
+	}
+	if err := m.written(mask); err != nil {
+		return true, fmt.Errorf("failed to select %s channel mask 0x%02X: %w", m.Model, mask, err)
+	}
+	return true, nil
+}
+
+// Deselect disconnects every channel.
+func (m *Mux) Deselect() error {
+	if err := m.Dev.Tx([]byte{0x00}, nil); err != nil {
+		m.selected = unknown
+		return fmt.Errorf("failed to deselect %s channels: %w", m.Model, err)
+	}
+	if err := m.written(0x00); err != nil {
+		return fmt.Errorf("failed to deselect %s channels: %w", m.Model, err)
+	}
+	return nil
+}
+
+// written records a successful write of mask, verifying it first if m.Verify is set.
+func (m *Mux) written(mask byte) error {
+	if m.Verify {
+		return m.verify(mask)
+	}
+	m.selected = mask
+	if m.Observe != nil {
+		m.Observe(mask)
+	}
+	return nil
+}
+
+// verify reads the control register back and checks that it holds mask. Any
+// other outcome leaves the selection unknown, so the next Select writes again.
+func (m *Mux) verify(mask byte) error {
+	control, err := m.Model.ReadControl(m.Dev)
+	if err != nil {
+		m.selected = unknown
+		return err
+	}
+	if m.Observe != nil {
+		m.Observe(control)
+	}
+	if control != mask {
+		m.selected = unknown
+		return fmt.Errorf("%w: reads 0x%02X, expected 0x%02X", ErrControlMismatch, control, mask)
+	}
+	m.selected = mask
+	return nil
+}
+
+// Group is several muxes of one model on one bus, at different addresses. The sensors
+// behind them usually share an address, so only one mux of the group may route
+// a channel at a time: selecting a channel on one mux first deselects all the
+// others. Further muxes may sit behind their channels, see Cascade. A Group is
+// not safe for concurrent use.
+type Group struct {
+	Muxes    []*Mux
+	Cascaded []*Cascaded // muxes behind a channel of another, added by Cascade
+}
+
+// Cascaded is a mux on a downstream channel of another mux, for trees such as
+// a second TCA9548A on channel 3 of the first. It only answers while that
+// channel is routed.
+type Cascaded struct {
+	*Mux
+	Parent  *Mux // a mux of the Group, or another Cascaded one
+	Channel int  // channel of Parent it sits behind
+}
+
+// Hop is one cascaded mux on the way to a device, and the channels it routes.
+type Hop struct {
+	Mux  *Mux
+	Mask byte
+}
+
+// Cascade returns the mux at dev behind channel of parent, adding it to the
+// group the first time. It is of the model of parent.
+func (g *Group) Cascade(parent *Mux, channel int, dev *i2c.Dev) (*Mux, error) {
+	if channel < 0 || channel >= parent.Model.Channels {
+		return nil, fmt.Errorf("channel number must be between 0 and %d on the %s, got %d", parent.Model.Channels-1, parent.Model, channel)
+	}
+	for _, c := range g.Cascaded {
+		if c.Parent == parent && c.Channel == channel && c.Dev.Addr == dev.Addr {
+			return c.Mux, nil
+		}
+	}
+	m := parent.Model.New(dev)
+	g.Cascaded = append(g.Cascaded, &Cascaded{Mux: m, Parent: parent, Channel: channel})
+	return m, nil
+}
+
+// NewGroup returns a Group of the TCA9548As at devs, in order. Their channel
+// selection is unknown, as with New.
+func NewGroup(devs ...*i2c.Dev) *Group {
+	return TCA9548A.NewGroup(devs...)
+}
+
+// NewGroup returns a Group of muxes of the model at devs, like the package-level NewGroup.
+func (m Model) NewGroup(devs ...*i2c.Dev) *Group {
+	g := &Group{}
+	for _, dev := range devs {
+		g.Muxes = append(g.Muxes, m.New(dev))
+	}
+	return g
+}
+
+// Select routes the bus to the channels in mask of the i-th mux, after
+// deselecting every other mux. Muxes already in the wanted state are not
+// written to; it reports whether any write was made.
+func (g *Group) Select(i int, mask byte) (bool, error) {
+	return g.SelectPath(i, mask)
+}
+
+// SelectPath routes the bus to the channels in mask of the i-th mux, like
+// Select, then through each cascaded mux of path in turn, ending with the
+// channels of the last one. Cascaded muxes behind a routed channel that are not
+// on the path are deselected while it is routed, so their devices do not
+// answer alongside the wanted one. The caller holds the bus for the whole
+// transaction, so the path cannot change halfway through.
+func (g *Group) SelectPath(i int, mask byte, path ...Hop) (bool, error) {
+	var wrote bool
+	for j, m := range g.Muxes {
+		if j == i {
+			continue
+		}
+		w, err := m.Select(0x00)
+		wrote = wrote || w
+		if err != nil {
+			return wrote, fmt.Errorf("%s at 0x%X: %w", m.Model, m.Dev.Addr, err)
+		}
+	}
+	hops := append([]Hop{{g.Muxes[i], mask}}, path...)
+	for k, hop := range hops {
+		w, err := hop.Mux.Select(hop.Mask)
+		wrote = wrote || w
+		if err != nil {
+			if k == 0 {
+				return wrote, err
+			}
+			return wrote, fmt.Errorf("%s at 0x%X: %w", hop.Mux.Model, hop.Mux.Dev.Addr, err)
+		}
+		var next *Mux
+		if k+1 < len(hops) {
+			next = hops[k+1].Mux
+		}
+		for _, c := range g.Cascaded {
+			if c.Parent != hop.Mux || hop.Mask&(1<<c.Channel) == 0 || c.Mux == next {
+				continue
+			}
+			w, err := c.Select(0x00)
+			wrote = wrote || w
+			if err != nil {
+				return wrote, fmt.Errorf("%s at 0x%X behind channel %d of 0x%X: %w", c.Model, c.Dev.Addr, c.Channel, c.Parent.Dev.Addr, err)
+			}
+		}
+	}
+	return wrote, nil
+}
+
+// DeselectPath disconnects every channel of the cascaded muxes of path, the
+// last one first while the ones before still route to it, and then of every
+// mux of the group, as Deselect.
+func (g *Group) DeselectPath(path ...Hop) error {
+	for k := len(path) - 1; k >= 0; k-- {
+		m := path[k].Mux
+		if _, err := m.Select(0x00); err != nil {
+			return fmt.Errorf("%s at 0x%X: %w", m.Model, m.Dev.Addr, err)
+		}
+	}
+	return g.Deselect()
+}
+
+// Deselect disconnects every channel of every mux in the group. Muxes known to
+// have no channel selected are not written to.
+func (g *Group) Deselect() error {
+	for _, m := range g.Muxes {
+		if _, err := m.Select(0x00); err != nil {
+			return fmt.Errorf("%s at 0x%X: %w", m.Model, m.Dev.Addr, err)
+		}
+	}
+	return nil
+}
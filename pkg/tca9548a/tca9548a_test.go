@@ -0,0 +1,58 @@
+package tca9548a
+
+import "testing"
+
+func TestChannelMask(t *testing.T) {
+	type test struct {
+		model   Model
+		channel int
+		want    byte
+		wantErr bool
+	}
+	tests := []test{
+		{TCA9548A, 0, 0x01, false},
+		{TCA9548A, 1, 0x02, false},
+		{TCA9548A, 2, 0x04, false},
+		{TCA9548A, 3, 0x08, false},
+		{TCA9548A, 4, 0x10, false},
+		{TCA9548A, 5, 0x20, false},
+		{TCA9548A, 6, 0x40, false},
+		{TCA9548A, 7, 0x80, false},
+		{TCA9548A, 8, 0, true},
+		{TCA9548A, -1, 0, true},
+		{PCA9548A, 7, 0x80, false},
+		{PCA9548A, 8, 0, true},
+	}
+	// The 4-channel models take channels 0-3 only
+	for _, m := range []Model{TCA9546A, PCA9546A, PCA9545A} {
+		for ch := range 4 {
+			tests = append(tests, test{m, ch, 1 << ch, false})
+		}
+		for ch := 4; ch <= 8; ch++ {
+			tests = append(tests, test{m, ch, 0, true})
+		}
+	}
+	for _, tt := range tests {
+		got, err := tt.model.ChannelMask(tt.channel)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s.ChannelMask(%d) = 0x%02X, want an error", tt.model, tt.channel, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s.ChannelMask(%d) = 0x%02X, %v; want 0x%02X", tt.model, tt.channel, got, err, tt.want)
+		}
+	}
+}
+
+func TestPackageChannelMask(t *testing.T) {
+	for ch := range Channels {
+		if got, err := ChannelMask(ch); err != nil || got != 1<<ch {
+			t.Errorf("ChannelMask(%d) = 0x%02X, %v; want 0x%02X", ch, got, err, 1<<ch)
+		}
+	}
+	if got, err := ChannelMask(Channels); err == nil {
+		t.Errorf("ChannelMask(%d) = 0x%02X, want an error", Channels, got)
+	}
+}
@@ -0,0 +1,101 @@
+// Package tmp117 reads the Texas Instruments TMP117 digital temperature sensor.
+// The sensor converts continuously, once a second, so a reading is the last
+// finished conversion.
+package tmp117
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// I2C addresses: 0x48 with ADD0 tied to GND, up to 0x4B.
+const (
+	Address     = uint16(0x48)
+	LastAddress = uint16(0x4B)
+)
+
+// Register Addresses
+const (
+	RegTemp     byte = 0x00 // Temperature result Register
+	RegConfig   byte = 0x01 // Configuration Register
+	RegDeviceID byte = 0x0F // Device ID and revision Register
+)
+
+// DeviceID is bits 11:0 of the Device ID register; bits 15:12 are the revision.
+const DeviceID = 0x0117
+
+// TempLSB is the weight of the Temperature result register in degrees Celsius.
+const TempLSB = 0.0078125
+
+// Configuration register fields: continuous conversion with a 1 s cycle, whose
+// length does not depend on the averaging.
+const (
+	configConv1s   = 0x4 << 7
+	configAvgShift = 5
+)
+
+// resetTemp is the Temperature result register until the first conversion ends.
+const resetTemp = -0x8000
+
+// DefaultAveraging is the power-up setting.
+const DefaultAveraging = 8
+
+// averagingCounts are the conversions averaged per result, indexed by the
+// 2-bit AVG field code.
+var averagingCounts = [4]int{1, 8, 32, 64}
+
+// AveragingCode returns the AVG field code of an averaging count, or an error
+// if the TMP117 does not support it.
+func AveragingCode(count int) (uint16, error) {
+	for code, v := range averagingCounts {
+		if v == count {
+			return uint16(code), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported averaging count %d: must be one of %v", count, averagingCounts)
+}
+
+// Sensor is one TMP117. Call Init before Temperature.
+type Sensor struct {
+	Dev       *i2c.Dev
+	Averaging int // conversions averaged per result: 1, 8, 32 or 64; 0 for DefaultAveraging
+}
+
+// Init checks the Device ID register and sets continuous conversion with the averaging.
+func (s *Sensor) Init() error {
+	buf := make([]byte, 2)
+	if err := s.Dev.Tx([]byte{RegDeviceID}, buf); err != nil {
+		return fmt.Errorf("failed to read device ID: %w", err)
+	}
+	if id := binary.BigEndian.Uint16(buf) & 0x0FFF; id != DeviceID {
+		return fmt.Errorf("unexpected device ID 0x%03X: expected 0x%03X", id, DeviceID)
+	}
+	averaging := s.Averaging
+	if averaging == 0 {
+		averaging = DefaultAveraging
+	}
+	code, err := AveragingCode(averaging)
+	if err != nil {
+		return err
+	}
+	config := uint16(configConv1s) | code<<configAvgShift
+	if err := s.Dev.Tx([]byte{RegConfig, byte(config >> 8), byte(config)}, nil); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// Temperature returns the last temperature result in degrees Celsius.
+func (s *Sensor) Temperature() (float64, error) {
+	buf := make([]byte, 2)
+	if err := s.Dev.Tx([]byte{RegTemp}, buf); err != nil {
+		return 0, fmt.Errorf("failed to read temperature: %w", err)
+	}
+	raw := int16(binary.BigEndian.Uint16(buf))
+	if raw == resetTemp {
+		return 0, fmt.Errorf("no conversion finished yet")
+	}
+	return float64(raw) * TempLSB, nil
+}
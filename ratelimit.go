@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"periph.io/x/conn/v3/i2c"
+)
+
+var busRateLimitWait = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "i2c_rate_limit_wait_seconds_total",
+	Help: "Time I2C transactions waited for their turn under --bus-max-rate.",
+})
+
+// txLimiter spaces the I2C transactions of every bus of the process at least
+// 1/rate apart, for --bus-max-rate. A transaction that comes after a pause goes
+// out right away; there is no burst allowance beyond that.
+type txLimiter struct {
+	spacing time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next transaction
+}
+
+func newTxLimiter(rate int) *txLimiter {
+	return &txLimiter{spacing: time.Second / time.Duration(rate)}
+}
+
+// wait blocks until the next transaction may start.
+func (l *txLimiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if l.next.After(now) {
+		start = l.next
+	}
+	l.next = start.Add(l.spacing)
+	l.mu.Unlock()
+	if d := start.Sub(now); d > 0 {
+		busRateLimitWait.Add(d.Seconds())
+		time.Sleep(d)
+	}
+}
+
+// limitedBus is a bus whose transactions wait for a txLimiter.
+type limitedBus struct {
+	i2c.BusCloser
+	limiter *txLimiter
+}
+
+func (b *limitedBus) Tx(addr uint16, w, r []byte) error {
+	b.limiter.wait()
+	return b.BusCloser.Tx(addr, w, r)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/simulate"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+)
+
+// quantityStats summarizes the samples of one quantity.
+type quantityStats struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"` // population standard deviation
+}
+
+// readStats is the --samples summary of the read subcommand, in V, A and W.
+type readStats struct {
+	Hostname string        `json:"hostname"`
+	Device   string        `json:"device"`
+	Samples  int           `json:"samples"` // successful samples
+	Failed   int           `json:"failed"`
+	Voltage  quantityStats `json:"voltage"`
+	Current  quantityStats `json:"current"`
+	Power    quantityStats `json:"power"`
+}
+
+func newQuantityStats(values []float64) quantityStats {
+	s := quantityStats{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range values {
+		s.Min, s.Max = min(s.Min, v), max(s.Max, v)
+		s.Mean += v
+	}
+	s.Mean /= float64(len(values))
+	for _, v := range values {
+		s.Stddev += (v - s.Mean) * (v - s.Mean)
+	}
+	s.Stddev = math.Sqrt(s.Stddev / float64(len(values)))
+	return s
+}
+
+// sensorFlags are the flags of the subcommands that talk to one INA260, such as
+// read and capture.
+type sensorFlags struct {
+	bus, muxType, tcaAddress *string
+	channel, readRetries     *int
+	withoutMux, skipHostInit *bool
+	simulate                 *bool
+}
+
+func addSensorFlags(fs *flag.FlagSet, verb string) sensorFlags {
+	return sensorFlags{
+		bus:          fs.String("bus", "/dev/i2c-1", "I2C bus to use (default: /dev/i2c-1)"),
+		muxType:      fs.String("mux.type", tca9548a.TCA9548A.Name, "Multiplexer model at the --tca-address addresses: tca9548a, pca9548a, tca9546a, pca9546a or pca9545a (default: tca9548a)"),
+		tcaAddress:   fs.String("tca-address", "0x70", "I2C address of the TCA9548A multiplexer, or a comma-separated list for several muxes (default: 0x70)"),
+		channel:      fs.Int("channel", 0, "Mux channel of the INA260, numbered across the muxes as in normal operation (default: 0)"),
+		withoutMux:   fs.Bool("without-multiplexer", false, verb+" an INA260 connected directly (default: false)"),
+		skipHostInit: fs.Bool("skip-host-init", false, "Do not call periph host.Init, for environments where it was already done (default: false)"),
+		simulate:     fs.Bool("simulate", false, verb+" from a simulated bus with the default --simulate settings (default: false)"),
+		readRetries:  fs.Int("read-retries", 0, "Extra attempts for each register read after a failure (default: 0)"),
+	}
+}
+
+// open opens the bus, selects the --channel sensor and checks that it is an
+// INA260. release deselects the mux channels and closes the bus. On failure it
+// logs why and returns the exit code: 2 for invalid flags, 1 otherwise.
+func (f sensorFlags) open() (sensor *ina260.Sensor, label string, release func(), code int) {
+	muxModel, err := tca9548a.ModelByName(*f.muxType)
+	if err != nil {
+		slog.Error("Invalid --mux.type", "err", err)
+		return nil, "", nil, 2
+	}
+	var muxAddresses []muxAddress
+	if !*f.withoutMux {
+		if muxAddresses, err = parseMuxAddresses(*f.tcaAddress, muxModel); err != nil {
+			slog.Error("Invalid --tca-address", "err", err)
+			return nil, "", nil, 2
+		}
+		if *f.channel < 0 || *f.channel >= len(muxAddresses)*muxModel.Channels {
+			slog.Error("Invalid --channel", "channel", *f.channel, "max", len(muxAddresses)*muxModel.Channels-1)
+			return nil, "", nil, 2
+		}
+	}
+	if *f.readRetries < 0 {
+		slog.Error("Invalid --read-retries: must not be negative")
+		return nil, "", nil, 2
+	}
+
+	var bus i2c.BusCloser
+	if *f.simulate {
+		opts := simulate.Options{MuxChannels: muxModel.Channels, Waveform: simulate.WaveformSine, Period: time.Minute, Voltage: 5, Current: 0.5, Noise: 0.01}
+		for _, a := range muxAddresses {
+			opts.Muxes = append(opts.Muxes, a.addr)
+		}
+		bus, err = simulate.NewBus(opts)
+	} else {
+		bus, err = initializeI2C(context.Background(), *f.bus, *f.skipHostInit, 0, 0)
+	}
+	if err != nil {
+		slog.Error("Failed to initialize I2C", "bus", *f.bus, "err", err)
+		return nil, "", nil, 1
+	}
+	release = func() { bus.Close() }
+
+	label = "ina260"
+	if len(muxAddresses) > 0 {
+		var tcas []*i2c.Dev
+		for _, a := range muxAddresses {
+			tcas = append(tcas, &i2c.Dev{Bus: bus, Addr: a.addr})
+		}
+		muxes := muxModel.NewGroup(tcas...)
+		mux, local := *f.channel/muxModel.Channels, *f.channel%muxModel.Channels
+		mask, _ := muxModel.ChannelMask(local)
+		if _, err := muxes.Select(mux, mask); err != nil {
+			slog.Error("Failed to select mux channel", append(muxAttrs(muxAddresses[mux].spec, local), "err", err)...)
+			bus.Close()
+			return nil, "", nil, 1
+		}
+		// Leave nothing routed for whoever uses the bus next
+		release = func() {
+			if err := muxes.Deselect(); err != nil {
+				slog.Warn("Failed to deselect mux channels", "err", err)
+			}
+			bus.Close()
+		}
+		label = fmt.Sprintf("%s_%s_ch%d_%s", muxModel.Name, muxAddresses[mux].spec, local, chipINA260)
+	}
+
+	sensor = &ina260.Sensor{Dev: &i2c.Dev{Bus: bus, Addr: ina260.Address}, Scale: ina260.DefaultScale, Retries: *f.readRetries, RetryBackoff: 10 * time.Millisecond}
+	// A different chip at the address would return plausible-looking garbage
+	manufID, err := sensor.ReadReg(ina260.RegManufID)
+	if err == nil && manufID != ina260.ManufacturerID {
+		err = fmt.Errorf("unexpected manufacturer ID 0x%04X, expected 0x%04X", manufID, ina260.ManufacturerID)
+	}
+	if err != nil {
+		slog.Error("No INA260 found", "device", label, "err", err)
+		release()
+		return nil, "", nil, 1
+	}
+	return sensor, label, release, 0
+}
+
+// runRead implements the read subcommand: it selects the --channel sensor, takes
+// one reading or --samples readings, prints the reading or their statistics, and
+// exits 0 only if every sample succeeded. Nothing is served or published.
+func runRead(args []string) int {
+	fs := flag.NewFlagSet("read", flag.ExitOnError)
+	sf := addSensorFlags(fs, "Read")
+	samplesFlag := fs.Int("samples", 1, "Number of readings to take; more than one prints min, max, mean and standard deviation (default: 1)")
+	intervalFlag := fs.Duration("interval", 100*time.Millisecond, "Time between --samples readings (default: 100ms)")
+	jsonFlag := fs.Bool("json", false, "Print JSON: the reading in the --fifo format, or the statistics (default: false)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s read [flags]\n\nTake one reading, or --samples readings, of an INA260 and exit: 0 if every reading succeeded, 1 otherwise, 2 on invalid flags.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *samplesFlag < 1 {
+		slog.Error("Invalid --samples: must be at least 1", "samples", *samplesFlag)
+		return 2
+	}
+	if *intervalFlag < 0 {
+		slog.Error("Invalid --interval: must not be negative")
+		return 2
+	}
+
+	sensor, label, closeSensor, code := sf.open()
+	if sensor == nil {
+		return code
+	}
+	defer closeSensor()
+	hostname, _ := os.Hostname()
+	export := exporter.NewSensor(hostname, label, ina260.DefaultScale)
+	var readings []ina260.Reading
+	failed := 0
+	for i := 0; i < *samplesFlag; i++ {
+		if i > 0 {
+			time.Sleep(*intervalFlag)
+		}
+		r, err := sensor.Read()
+		if err != nil {
+			slog.Error("Failed to read sensor", "device", label, "sample", i+1, "err", err)
+			failed++
+			continue
+		}
+		r.Time = time.Now()
+		readings = append(readings, r)
+	}
+	if len(readings) == 0 {
+		return 1
+	}
+
+	if *samplesFlag == 1 {
+		if *jsonFlag {
+			line, err := exporter.MarshalReadingJSON(export, readings[0])
+			if err != nil {
+				slog.Error("Failed to encode reading", "err", err)
+				return 1
+			}
+			os.Stdout.Write(line)
+		} else if err := exporter.NewTextSink("stdout", os.Stdout, exporter.TextOptions{}).Publish(export, readings[0]); err != nil {
+			slog.Error("Failed to print reading", "err", err)
+			return 1
+		}
+		return 0
+	}
+
+	voltages, currents, powers := make([]float64, len(readings)), make([]float64, len(readings)), make([]float64, len(readings))
+	for i, r := range readings {
+		voltages[i], currents[i], powers[i] = r.Voltage, r.Current, r.Power
+	}
+	stats := readStats{Hostname: hostname, Device: label, Samples: len(readings), Failed: failed,
+		Voltage: newQuantityStats(voltages), Current: newQuantityStats(currents), Power: newQuantityStats(powers)}
+	if *jsonFlag {
+		if err := json.NewEncoder(os.Stdout).Encode(stats); err != nil {
+			slog.Error("Failed to encode statistics", "err", err)
+			return 1
+		}
+	} else {
+		fmt.Printf("%s: %d samples, %d failed\n", label, stats.Samples, stats.Failed)
+		for _, q := range []struct {
+			name, unit string
+			stats      quantityStats
+		}{{"Voltage", "V", stats.Voltage}, {"Current", "A", stats.Current}, {"Power", "W", stats.Power}} {
+			fmt.Printf("%-8s min %.3f %s, max %.3f %s, mean %.3f %s, stddev %.4f %s\n", q.name+":", q.stats.Min, q.unit, q.stats.Max, q.unit, q.stats.Mean, q.unit, q.stats.Stddev, q.unit)
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// fleet is the set of polled power monitors. A SIGHUP reload of the config file
+// adds and removes monitors while the HTTP server and the other sensors keep
+// running, so everything else gets the current list from it.
+type fleet struct {
+	run func(ctx context.Context, m *monitor) // polls m until ctx is cancelled
+	// removed, if set, is called with the device label of each stopped monitor,
+	// once it no longer publishes
+	removed func(device string)
+
+	mu       sync.Mutex
+	monitors []*monitor
+	stops    map[*monitor]func() // cancels the monitor's polling and waits for it to end
+	wg       sync.WaitGroup
+	closed   bool // set by wait
+}
+
+// list returns the current monitors.
+func (f *fleet) list() []*monitor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.monitors)
+}
+
+// add adds m to the list, before it is set up and polled.
+func (f *fleet) add(m *monitor) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.monitors = append(f.monitors, m)
+}
+
+// start starts polling m until ctx is cancelled or stop is called.
+func (f *fleet) start(ctx context.Context, m *monitor) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		cancel()
+		return
+	}
+	if f.stops == nil {
+		f.stops = make(map[*monitor]func())
+	}
+	f.stops[m] = func() { cancel(); <-done }
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer close(done)
+		f.run(ctx, m)
+	}()
+}
+
+// stop stops polling m, waits for an ongoing reading to finish, and removes it
+// along with its metric series.
+func (f *fleet) stop(m *monitor) {
+	f.mu.Lock()
+	stop := f.stops[m]
+	delete(f.stops, m)
+	f.monitors = slices.DeleteFunc(f.monitors, func(o *monitor) bool { return o == m })
+	f.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+	m.export.Metrics.Remove()
+	if f.removed != nil {
+		f.removed(m.export.Device)
+	}
+}
+
+// wait blocks until every monitor has stopped polling, once ctx of start is
+// cancelled. Monitors started afterwards are not polled.
+func (f *fleet) wait() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+// reloader applies the power monitors of the --config file to the fleet on
+// SIGHUP. Only the sensors list of the main bus is reloaded: monitors of removed,
+// renamed or rescheduled sensors stop, and the new ones are set up and start
+// polling. Every other setting needs a restart.
+type reloader struct {
+	path     string
+	failFast bool        // as --fail-fast-on-config-error
+	cfg      *fileConfig // the config file in effect
+	fleet    *fleet
+	channels map[int]*monitor // by mux channel, numbered across the muxes
+	total    int              // channels across the muxes
+	// create sets up the monitor of a new sensor, returning an error when the sensor does not answer
+	create func(channel int, label string, labels map[string]string, schedule pollSchedule) (*monitor, error)
+	// label returns the device label of a sensor without a name
+	label func(channel int) string
+	// schedule is the poll schedule of a sensor without its own interval and jitter
+	schedule pollSchedule
+}
+
+// watch reloads the config file every time the process receives SIGHUP, until ctx is cancelled.
+func (r *reloader) watch(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+			}
+			if err := r.reload(ctx); err != nil {
+				slog.Error("Failed to reload config file, keeping the running configuration", "config", r.path, "err", err)
+			}
+		}
+	}()
+}
+
+// reload reads the config file and diff-applies its power monitors.
+func (r *reloader) reload(ctx context.Context) error {
+	cfg, skipped, err := loadConfig(r.path, r.failFast)
+	if err != nil {
+		return err
+	}
+	if cfg.Mux == nil {
+		return fmt.Errorf("the mux was removed; restart to poll a directly connected sensor")
+	}
+	if cfg.powerSensors()[0].Chip != r.cfg.powerSensors()[0].Chip {
+		return fmt.Errorf("the power monitor chip changed; restart to apply it")
+	}
+	if !reflect.DeepEqual(withoutPowerSensors(cfg), withoutPowerSensors(r.cfg)) {
+		slog.Warn("Config file changes other than the power monitors need a restart", "config", r.path)
+	}
+
+	type sensor struct {
+		label    string
+		labels   map[string]string
+		schedule pollSchedule
+	}
+	wanted := make(map[int]sensor) // by channel
+	for _, s := range cfg.powerSensors() {
+		if *s.Channel >= r.total {
+			slog.Warn("Skipping sensor of the config file: no such mux channel", "device", s.Name, "channel", *s.Channel, "max", r.total-1)
+			continue
+		}
+		label := s.Name
+		if label == "" {
+			label = r.label(*s.Channel)
+		}
+		wanted[*s.Channel] = sensor{label: label, labels: s.Labels, schedule: withSchedule(r.schedule, s.Interval, s.Jitter)}
+	}
+	var added, removed int
+	for ch, m := range r.channels {
+		if w, ok := wanted[ch]; ok && w.label == m.export.Device && maps.Equal(w.labels, m.export.Labels) && w.schedule == m.schedule {
+			continue
+		}
+		r.fleet.stop(m)
+		delete(r.channels, ch)
+		m.logger.Info("Stopped polling sensor removed from the config file")
+		removed++
+	}
+	channels := make([]int, 0, len(wanted))
+	for ch := range wanted {
+		channels = append(channels, ch)
+	}
+	slices.Sort(channels)
+	for _, ch := range channels {
+		if _, ok := r.channels[ch]; ok {
+			continue
+		}
+		m, err := r.create(ch, wanted[ch].label, wanted[ch].labels, wanted[ch].schedule)
+		if err != nil {
+			slog.Warn("Skipping sensor added to the config file", "device", wanted[ch].label, "err", err)
+			continue
+		}
+		r.channels[ch] = m
+		r.fleet.add(m)
+		r.fleet.start(ctx, m)
+		m.logger.Info("Started polling sensor added to the config file")
+		added++
+	}
+	r.cfg = cfg
+	logSkipped(r.path, skipped)
+	slog.Info("Reloaded config file", "config", r.path, "added", added, "removed", removed, "sensors", len(r.channels))
+	return nil
+}
+
+// warnOnSIGHUP keeps SIGHUP from ending the process when the config file cannot be
+// reloaded: without a mux, with --chip ina3221 or --discover-interval, or with
+// --channel or --channels given on the command line, which take precedence over its sensors.
+func warnOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			slog.Warn("Not reloading the config file: its sensors are only reloaded behind a mux, without --chip ina3221, without --discover-interval and without --channel or --channels on the command line")
+		}
+	}()
+}
+
+// withoutPowerSensors returns a copy of cfg without its power monitors on the main
+// bus, for comparing the settings a reload does not apply.
+func withoutPowerSensors(cfg *fileConfig) fileConfig {
+	c := *cfg
+	c.Sensors = slices.DeleteFunc(slices.Clone(cfg.Sensors), func(s sensorConfig) bool { return !isDriverChip(s.Chip) && s.Bus == "" && s.MuxPath == "" })
+	return c
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// deviceSeries returns the names of the metrics of the default registry with a
+// series for device.
+func deviceSeries(t *testing.T, device string) map[string]bool {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "device" && l.GetValue() == device {
+					names[mf.GetName()] = true
+				}
+			}
+		}
+	}
+	return names
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadRemovesSeries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+mux:
+  address: 0x70
+sensors:
+  - channel: 0
+    name: reload_kept
+  - channel: 1
+    name: reload_removed
+`)
+	cfg, _, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := exporter.NewPrometheusSink(exporter.PrometheusOptions{ExportMicroamps: true, CompatMetrics: true, AverageWindow: 4, StatsWindow: time.Minute, ExportDelta: true})
+	start := time.Now()
+	r := &reloader{
+		path:     path,
+		cfg:      cfg,
+		fleet:    &fleet{run: func(ctx context.Context, _ *monitor) { <-ctx.Done() }},
+		channels: make(map[int]*monitor),
+		total:    8,
+		label:    func(channel int) string { return "unnamed" },
+		create: func(channel int, label string, labels map[string]string, schedule pollSchedule) (*monitor, error) {
+			// Every kind of series a polled sensor has: gauges, counters and histograms
+			export := exporter.NewSensor("test", label, ina260.DefaultScale)
+			for i := range 2 {
+				raw := ina260.Reading{Time: start.Add(time.Duration(i) * time.Second), RawCurrent: 400, RawVoltage: 4000, RawPower: 200, Current: 0.5, Voltage: 5, Power: 2}
+				if err := sink.Publish(export, raw); err != nil {
+					t.Fatal(err)
+				}
+			}
+			export.Metrics.Up().Set(1)
+			export.Metrics.AlertLimit().Set(0)
+			export.Metrics.VoltageSaturated().Set(0)
+			export.Metrics.SetCurrentDirection(1)
+			export.Metrics.TransactionFailed(ina260.RegCurrent, nil)
+			export.Metrics.WriteVerifyFailed()
+			export.Metrics.ConfigWritten(false)
+			export.Metrics.MuxExtraWrites().Inc()
+			export.Metrics.ObserveReadRetries(0)
+			export.Metrics.ObserveBusTime(time.Millisecond)
+			return &monitor{export: export, logger: slog.With("device", label), schedule: schedule}, nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := r.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	before := deviceSeries(t, "reload_removed")
+	for _, name := range []string{"ina260_up", "ina260_current", "ina260_current_milliamps", "ina260_energy_wh_total", "ina260_config_verify_failures_total", "i2c_transaction_errors_total", "ina260_read_retries", "ina260_bus_time_seconds"} {
+		if !before[name] {
+			t.Errorf("%s missing before the reload", name)
+		}
+	}
+
+	writeConfig(t, path, `
+mux:
+  address: 0x70
+sensors:
+  - channel: 0
+    name: reload_kept
+`)
+	if err := r.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if left := deviceSeries(t, "reload_removed"); len(left) > 0 {
+		t.Errorf("series of the removed sensor left after the reload: %v", left)
+	}
+	if kept := deviceSeries(t, "reload_kept"); len(kept) != len(before) {
+		t.Errorf("the kept sensor has %d series after the reload, want %d: %v", len(kept), len(before), kept)
+	}
+	if _, ok := r.channels[1]; ok || len(r.fleet.list()) != 1 {
+		t.Errorf("the removed sensor is still polled: channels %v, fleet %d", r.channels, len(r.fleet.list()))
+	}
+}
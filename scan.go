@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina219"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina226"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/mcp9808"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tmp117"
+)
+
+// Addresses probed by scan: the 7-bit range outside the reserved addresses, as i2cdetect does.
+const (
+	scanFirstAddress = 0x03
+	scanLastAddress  = 0x77
+)
+
+// Die IDs of the TI power monitors scan recognizes, as read from register 0xFF.
+// The low four bits are the die revision and are ignored.
+var tiDieIDs = map[uint16]string{
+	ina260.DeviceID: "INA260",
+	ina226.DieID:    "INA226",
+	0x3220:          "INA3221",
+}
+
+// Bosch chip IDs, read from register 0xD0 of a device at 0x76 or 0x77.
+var boschChipIDs = map[byte]string{
+	0x58: "BMP280",
+	0x60: "BME280",
+	0x61: "BME680",
+}
+
+// runScan implements the scan subcommand: it probes every address on the bus
+// with all mux channels off, then on each channel of each mux, and prints a
+// table of what answered. Devices already seen with the channels off are on the
+// main bus and answer on every channel, so they are only listed once.
+func runScan(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	busFlag := fs.String("bus", "/dev/i2c-1", "I2C bus to scan (default: /dev/i2c-1)")
+	tcaAddressFlag := fs.String("tca-address", "0x70", "I2C address of the TCA9548A multiplexer, or a comma-separated list for several muxes (default: 0x70)")
+	muxTypeFlag := fs.String("mux.type", tca9548a.TCA9548A.Name, "Multiplexer model at the --tca-address addresses: tca9548a, pca9548a, tca9546a, pca9546a or pca9545a (default: tca9548a)")
+	withoutMultiplexerFlag := fs.Bool("without-multiplexer", false, "Only scan the main bus (default: false)")
+	skipHostInitFlag := fs.Bool("skip-host-init", false, "Do not call periph host.Init, for environments where it was already done (default: false)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s scan [flags]\n\nProbe addresses 0x%02X-0x%02X on the bus and on every TCA9548A channel.\n\n", os.Args[0], scanFirstAddress, scanLastAddress)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	muxModel, err := tca9548a.ModelByName(*muxTypeFlag)
+	if err != nil {
+		slog.Error("Invalid --mux.type", "err", err)
+		return 2
+	}
+	var muxAddresses []muxAddress
+	if !*withoutMultiplexerFlag {
+		var err error
+		if muxAddresses, err = parseMuxAddresses(*tcaAddressFlag, muxModel); err != nil {
+			slog.Error("Invalid --tca-address", "err", err)
+			return 2
+		}
+	}
+	bus, err := initializeI2C(context.Background(), *busFlag, *skipHostInitFlag, 0, 0)
+	if err != nil {
+		slog.Error("Failed to initialize I2C", "bus", *busFlag, "err", err)
+		return 1
+	}
+	defer bus.Close()
+
+	var tcas []*i2c.Dev
+	isMux := make(map[uint16]bool)
+	for _, a := range muxAddresses {
+		tcas = append(tcas, &i2c.Dev{Bus: bus, Addr: a.addr})
+		isMux[a.addr] = true
+	}
+	muxes := muxModel.NewGroup(tcas...)
+	defer func() {
+		if err := muxes.Deselect(); err != nil {
+			slog.Warn("Failed to deselect mux channels", "err", err)
+		}
+	}()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MUX\tCHANNEL\tADDRESS\tDEVICE")
+	found := 0
+	if err := muxes.Deselect(); err != nil {
+		slog.Warn("Failed to deselect mux channels", "err", err)
+	}
+	onMainBus := make(map[uint16]bool)
+	for _, addr := range scanAddresses(bus) {
+		onMainBus[addr] = true
+		device := identifyDevice(bus, addr)
+		if isMux[addr] {
+			device = muxModel.String()
+		}
+		fmt.Fprintf(w, "-\t-\t0x%02X\t%s\n", addr, device)
+		found++
+	}
+	for i, a := range muxAddresses {
+		if !onMainBus[a.addr] {
+			slog.Warn("Mux did not answer; skipping its channels", "mux", a.spec, "model", muxModel.String())
+			continue
+		}
+		for ch := 0; ch < muxModel.Channels; ch++ {
+			mask, _ := muxModel.ChannelMask(ch)
+			if _, err := muxes.Select(i, mask); err != nil {
+				slog.Warn("Skipping mux channel", "mux", a.spec, "channel", ch, "err", err)
+				continue
+			}
+			for _, addr := range scanAddresses(bus) {
+				if onMainBus[addr] {
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%d\t0x%02X\t%s\n", a.spec, ch, addr, identifyDevice(bus, addr))
+				found++
+			}
+		}
+	}
+	w.Flush()
+	fmt.Printf("%d device(s) found\n", found)
+	return 0
+}
+
+// scanAddresses returns the addresses that ACK a one-byte read. periph skips
+// zero-length transfers, so the SMBus quick write i2cdetect uses is not available.
+func scanAddresses(bus i2c.Bus) []uint16 {
+	var found []uint16
+	for addr := uint16(scanFirstAddress); addr <= scanLastAddress; addr++ {
+		dev := &i2c.Dev{Bus: bus, Addr: addr}
+		if err := dev.Tx(nil, make([]byte, 1)); err == nil {
+			found = append(found, addr)
+		}
+	}
+	return found
+}
+
+// identifyDevice names the chip at addr from its ID registers, using reads only.
+// It returns "unknown" for anything it does not recognize.
+func identifyDevice(bus i2c.Bus, addr uint16) string {
+	dev := &i2c.Dev{Bus: bus, Addr: addr}
+	switch {
+	case addr >= 0x40 && addr <= 0x4F:
+		// TI power monitors keep their Manufacturer ID and Die ID at 0xFE and 0xFF
+		if manufID, err := ina260.ReadReg(dev, ina260.RegManufID); err == nil && manufID == ina260.ManufacturerID {
+			dieID, err := ina260.ReadReg(dev, ina260.RegDeviceID)
+			if name, ok := tiDieIDs[dieID&^ina260.RevisionMask]; ok && err == nil {
+				return name
+			}
+			return fmt.Sprintf("TI device (die ID 0x%04X)", dieID)
+		}
+		if id, err := ina260.ReadReg(dev, tmp117.RegDeviceID); err == nil && id&0x0FFF == tmp117.DeviceID {
+			return "TMP117"
+		}
+		// The INA219 has no ID registers; a Configuration register at its reset value is a good hint
+		if config, err := ina260.ReadReg(dev, ina219.RegConfig); err == nil && config == ina219.ConfigDefault {
+			return "INA219 (probably)"
+		}
+	case addr >= mcp9808.Address && addr <= mcp9808.LastAddress:
+		if manufID, err := ina260.ReadReg(dev, mcp9808.RegManufID); err == nil && manufID == mcp9808.ManufacturerID {
+			if id, err := ina260.ReadReg(dev, mcp9808.RegDeviceID); err == nil && id>>8 == mcp9808.DeviceID {
+				return "MCP9808"
+			}
+		}
+	case addr == 0x76 || addr == 0x77:
+		id := make([]byte, 1)
+		if err := dev.Tx([]byte{0xD0}, id); err == nil {
+			if name, ok := boschChipIDs[id[0]]; ok {
+				return name
+			}
+		}
+	}
+	return "unknown"
+}
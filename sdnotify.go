@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// lastPollDone is when the last sensor poll finished, successful or not, in Unix
+// nanoseconds. The watchdog takes it as the sign that polling makes progress.
+var lastPollDone atomic.Int64
+
+// sdNotify sends a state such as READY=1 to systemd, for a Type=notify unit. It
+// does nothing when the process was not started by systemd.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// A leading @ is an abstract socket, which net maps to the leading NUL byte
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Failed to notify systemd", "state", state, "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Failed to notify systemd", "state", state, "err", err)
+	}
+}
+
+// sdWatchdogTimeout returns the WatchdogSec of the unit, or 0 if the watchdog is
+// off or meant for another process.
+func sdWatchdogTimeout() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// sdWatchdog sends WATCHDOG=1 at half the watchdog timeout, until ctx is
+// cancelled, as long as polling makes progress: a poll finished within
+// pollWindow, or the bus is idle because no poll is due, as with --read-on-scrape
+// between scrapes. An access that never returns holds busMu without finishing a
+// poll, so the pings stop and systemd restarts the service.
+func sdWatchdog(ctx context.Context, timeout, pollWindow time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	stalled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, lastPollDone.Load())) < pollWindow || busMu.idle() {
+			sdNotify("WATCHDOG=1")
+			stalled = false
+		} else if !stalled {
+			slog.Error("Polling is stuck on the bus; no longer feeding the systemd watchdog", "last_poll", time.Unix(0, lastPollDone.Load()))
+			stalled = true
+		}
+	}
+}
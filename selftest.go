@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/bme280"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina226"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/mcp9808"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/simulate"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tmp117"
+)
+
+// Status of a selftest check.
+const (
+	selftestPass = "pass"
+	selftestFail = "fail"
+	selftestSkip = "skip" // an earlier check it depends on failed, or it does not apply to the chip
+)
+
+// selftestCheck is one check of the selftest report: a mux that must ACK, a mux
+// channel that must select, a sensor that must identify as its chip, or a
+// reading that must be within bounds.
+type selftestCheck struct {
+	Check   string   `json:"check"` // bus, mux, select, identity or reading
+	Status  string   `json:"status"`
+	Bus     string   `json:"bus"`
+	Mux     string   `json:"mux,omitempty"`
+	Channel *int     `json:"channel,omitempty"` // channel of that mux
+	Device  string   `json:"device,omitempty"`
+	Chip    string   `json:"chip,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Found   string   `json:"found,omitempty"`   // what identified at the address
+	Voltage *float64 `json:"voltage,omitempty"` // in V
+	Current *float64 `json:"current,omitempty"` // in A
+	Error   string   `json:"error,omitempty"`
+}
+
+// selftestReport is the JSON the selftest subcommand prints.
+type selftestReport struct {
+	Hostname string          `json:"hostname"`
+	Passed   bool            `json:"passed"`
+	Failed   int             `json:"failed"`
+	Skipped  int             `json:"skipped"`
+	Checks   []selftestCheck `json:"checks"`
+}
+
+// selftestTarget is one sensor of the topology under test.
+type selftestTarget struct {
+	name    string // from the config file, "" for a generated label
+	chip    string
+	bus     string
+	channel int // numbered across the muxes, -1 when connected directly
+}
+
+// selftestBounds are the limits a reading must be within.
+type selftestBounds struct {
+	minVoltage, maxVoltage, maxCurrent float64
+}
+
+// selftestIDs are the names identifyDevice returns for the chips without IDs of
+// their own in the exporter, and the addresses the chip is looked for at.
+var selftestIDs = map[string]struct {
+	names     []string
+	addresses []uint16
+}{
+	chipINA3221: {[]string{"INA3221"}, []uint16{ina260.Address}},
+	chipBME280:  {[]string{"BME280", "BMP280"}, []uint16{bme280.Address, bme280.AlternateAddress}},
+	chipMCP9808: {[]string{"MCP9808"}, []uint16{mcp9808.Address}},
+	chipTMP117:  {[]string{"TMP117"}, []uint16{tmp117.Address}},
+}
+
+// selftest runs the checks against one bus and collects them.
+type selftest struct {
+	report   *selftestReport
+	muxModel tca9548a.Model
+	muxes    []muxAddress
+	bounds   selftestBounds
+}
+
+func (t *selftest) add(c selftestCheck, logger *slog.Logger) {
+	switch c.Status {
+	case selftestFail:
+		t.report.Failed++
+		logger.Error("Self-test check failed", "check", c.Check, "err", c.Error)
+	case selftestSkip:
+		t.report.Skipped++
+		logger.Warn("Self-test check skipped", "check", c.Check, "reason", c.Error)
+	default:
+		logger.Info("Self-test check passed", "check", c.Check)
+	}
+	t.report.Checks = append(t.report.Checks, c)
+}
+
+// runBus checks the muxes of a bus, then each of its targets in turn. Every
+// check is made, so the report lists every fault of a board at once; a sensor
+// behind a mux that did not answer is skipped.
+func (t *selftest) runBus(bus i2c.Bus, busName string, targets []selftestTarget) {
+	var group *tca9548a.Group
+	muxUp := make([]bool, len(t.muxes))
+	if slices.ContainsFunc(targets, func(s selftestTarget) bool { return s.channel >= 0 }) {
+		var tcas []*i2c.Dev
+		for i, a := range t.muxes {
+			tca := &i2c.Dev{Bus: bus, Addr: a.addr}
+			tcas = append(tcas, tca)
+			c := selftestCheck{Check: "mux", Status: selftestPass, Bus: busName, Mux: a.spec, Address: fmt.Sprintf("0x%02X", a.addr)}
+			if err := tca9548a.Probe(tca); err != nil {
+				c.Status, c.Error = selftestFail, err.Error()
+			} else {
+				muxUp[i] = true
+			}
+			t.add(c, slog.With("sensor_bus", busName, "mux", a.spec))
+		}
+		group = t.muxModel.NewGroup(tcas...)
+		defer func() {
+			if err := group.Deselect(); err != nil {
+				slog.Warn("Failed to deselect mux channels", "sensor_bus", busName, "err", err)
+			}
+		}()
+	}
+
+	for _, s := range targets {
+		base := selftestCheck{Bus: busName, Chip: s.chip, Device: s.name}
+		logger := slog.With("sensor_bus", busName)
+		if s.channel >= 0 {
+			mux, local := s.channel/t.muxModel.Channels, s.channel%t.muxModel.Channels
+			base.Mux, base.Channel = t.muxes[mux].spec, &local
+			if base.Device == "" {
+				base.Device = fmt.Sprintf("%s_%s_ch%d_%s", t.muxModel.Name, base.Mux, local, s.chip)
+			}
+			logger = logger.With(muxAttrs(base.Mux, local)...).With("device", base.Device)
+			c := base
+			c.Check, c.Status = "select", selftestPass
+			if !muxUp[mux] {
+				c.Status, c.Error = selftestSkip, "the mux did not answer"
+			} else if err := t.selectChannel(group, mux, local); err != nil {
+				c.Status, c.Error = selftestFail, err.Error()
+			}
+			t.add(c, logger)
+			if c.Status != selftestPass {
+				t.skip(base, logger, "the mux channel was not selected")
+				continue
+			}
+		} else {
+			if base.Device == "" {
+				base.Device = s.chip
+			}
+			logger = logger.With("device", base.Device)
+			if group != nil {
+				// A directly connected sensor must not see the devices behind the channels
+				if err := group.Deselect(); err != nil {
+					slog.Warn("Failed to deselect mux channels", "sensor_bus", busName, "err", err)
+				}
+			}
+		}
+		t.checkSensor(bus, base, logger)
+	}
+}
+
+// selectChannel selects a channel and reads the control register back, since a
+// different model answering at the address may ignore the write.
+func (t *selftest) selectChannel(group *tca9548a.Group, mux, channel int) error {
+	mask, err := t.muxModel.ChannelMask(channel)
+	if err != nil {
+		return err
+	}
+	if _, err := group.Select(mux, mask); err != nil {
+		return err
+	}
+	control, err := t.muxModel.ReadControl(group.Muxes[mux].Dev)
+	if err != nil {
+		return err
+	}
+	if control != mask {
+		return fmt.Errorf("control register reads 0x%02X after writing 0x%02X; check --mux.type", control, mask)
+	}
+	return nil
+}
+
+// skip adds the identity and reading checks of a sensor as skipped.
+func (t *selftest) skip(base selftestCheck, logger *slog.Logger, reason string) {
+	for _, check := range []string{"identity", "reading"} {
+		c := base
+		c.Check, c.Status, c.Error = check, selftestSkip, reason
+		t.add(c, logger)
+	}
+}
+
+// checkSensor checks the identity of the sensor on the selected channel, then
+// takes a reading of an INA260 and checks it against the bounds.
+func (t *selftest) checkSensor(bus i2c.Bus, base selftestCheck, logger *slog.Logger) {
+	c := base
+	c.Check, c.Status = "identity", selftestFail
+	switch base.Chip {
+	case chipINA219:
+		// The INA219 has no ID registers, so an ACK is all that can be checked
+		c.Address = fmt.Sprintf("0x%02X", ina260.Address)
+		if err := ina260.Probe(&i2c.Dev{Bus: bus, Addr: ina260.Address}); err != nil {
+			c.Error = err.Error()
+		} else {
+			c.Status, c.Found = selftestPass, identifyDevice(bus, ina260.Address)
+		}
+	case chipINA260, chipINA226:
+		// The IDs the exporter checks at startup, ignoring the die revision
+		manufacturer, device := ina260.ManufacturerID, ina260.DeviceID
+		if base.Chip == chipINA226 {
+			manufacturer, device = ina226.ManufacturerID, ina226.DieID
+		}
+		c.Address = fmt.Sprintf("0x%02X", ina260.Address)
+		dev := &i2c.Dev{Bus: bus, Addr: ina260.Address}
+		manufID, err := ina260.ReadReg(dev, ina260.RegManufID)
+		var deviceID uint16
+		if err == nil {
+			deviceID, err = ina260.ReadReg(dev, ina260.RegDeviceID)
+		}
+		switch {
+		case err != nil:
+			c.Error = err.Error()
+		case manufID != manufacturer || deviceID&^ina260.RevisionMask != device:
+			c.Found = fmt.Sprintf("0x%04X/0x%04X", manufID, deviceID)
+			c.Error = fmt.Sprintf("expected manufacturer/device ID 0x%04X/0x%04X, got %s", manufacturer, device, c.Found)
+		default:
+			c.Status, c.Found = selftestPass, fmt.Sprintf("0x%04X/0x%04X", manufID, deviceID)
+		}
+	default:
+		ids := selftestIDs[base.Chip]
+		for _, addr := range ids.addresses {
+			c.Address = fmt.Sprintf("0x%02X", addr)
+			if c.Found = identifyDevice(bus, addr); slices.Contains(ids.names, c.Found) {
+				c.Status = selftestPass
+				break
+			}
+		}
+		if c.Status != selftestPass {
+			c.Error = fmt.Sprintf("expected %s, found %s", ids.names[0], c.Found)
+		}
+	}
+	t.add(c, logger)
+
+	r := base
+	r.Check, r.Address = "reading", c.Address
+	switch {
+	case c.Status != selftestPass:
+		r.Status, r.Error = selftestSkip, "the sensor was not identified"
+	case base.Chip != chipINA260:
+		r.Status, r.Error = selftestSkip, "readings are only checked on an INA260"
+	default:
+		r.Status = selftestPass
+		sensor := &ina260.Sensor{Dev: &i2c.Dev{Bus: bus, Addr: ina260.Address}, Scale: ina260.DefaultScale}
+		reading, err := sensor.Read()
+		if err != nil {
+			r.Status, r.Error = selftestFail, err.Error()
+			break
+		}
+		r.Voltage, r.Current = &reading.Voltage, &reading.Current
+		if err := t.bounds.check(reading); err != nil {
+			r.Status, r.Error = selftestFail, err.Error()
+		}
+	}
+	t.add(r, logger)
+}
+
+func (b selftestBounds) check(r ina260.Reading) error {
+	switch {
+	case r.Voltage < b.minVoltage:
+		return fmt.Errorf("voltage %.3f V is below --min-voltage %g V", r.Voltage, b.minVoltage)
+	case r.Voltage > b.maxVoltage:
+		return fmt.Errorf("voltage %.3f V is above --max-voltage %g V", r.Voltage, b.maxVoltage)
+	case math.Abs(r.Current) > b.maxCurrent:
+		return fmt.Errorf("current %.3f A is beyond --max-current %g A", r.Current, b.maxCurrent)
+	}
+	return nil
+}
+
+// runSelftest implements the selftest subcommand: it walks the topology of a
+// --config file, or of the flags, checks that every mux ACKs and selects its
+// channels, that every sensor identifies as its chip and that every INA260
+// reads within bounds, and prints a JSON report. It exits 0 if every check
+// passed, 1 if any failed and 2 on invalid flags, for factory bring-up scripts.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	configFlag := fs.String("config", "", "YAML file with the topology to test: its bus, mux and sensors, in place of the flags below (default: none)")
+	busFlag := fs.String("bus", "/dev/i2c-1", "I2C bus to test (default: /dev/i2c-1)")
+	muxTypeFlag := fs.String("mux.type", tca9548a.TCA9548A.Name, "Multiplexer model at the --tca-address addresses: tca9548a, pca9548a, tca9546a, pca9546a or pca9545a (default: tca9548a)")
+	tcaAddressFlag := fs.String("tca-address", "0x70", "I2C address of the TCA9548A multiplexer, or a comma-separated list for several muxes (default: 0x70)")
+	channelsFlag := fs.String("channels", "0", "Mux channels with a sensor, e.g. 0-7 or 0,2,5, numbered across the muxes (default: 0)")
+	chipFlag := fs.String("chip", chipINA260, "Chip of every sensor: ina260, ina219, ina226 or ina3221 (default: ina260)")
+	withoutMuxFlag := fs.Bool("without-multiplexer", false, "Test one sensor connected directly (default: false)")
+	skipHostInitFlag := fs.Bool("skip-host-init", false, "Do not call periph host.Init, for environments where it was already done (default: false)")
+	simulateFlag := fs.Bool("simulate", false, "Test a simulated bus with the default --simulate settings (default: false)")
+	minVoltageFlag := fs.Float64("min-voltage", 0, "Lowest bus voltage in V an INA260 may read (default: 0)")
+	maxVoltageFlag := fs.Float64("max-voltage", 36, "Highest bus voltage in V an INA260 may read (default: 36)")
+	maxCurrentFlag := fs.Float64("max-current", 15, "Largest current in A, in either direction, an INA260 may read (default: 15)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s selftest [flags]\n\nCheck the muxes, sensor IDs and readings of a topology and print a JSON report; exit 0 if every check passed, 1 otherwise, 2 on invalid flags.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *minVoltageFlag > *maxVoltageFlag || *maxCurrentFlag < 0 {
+		slog.Error("Invalid bounds: --min-voltage must not exceed --max-voltage, and --max-current must not be negative")
+		return 2
+	}
+	mainBus, muxType, muxSpec, withoutMux := *busFlag, *muxTypeFlag, *tcaAddressFlag, *withoutMuxFlag
+	var targets []selftestTarget
+	var nested []sensorConfig // behind nested muxes, which are not checked
+	if *configFlag != "" {
+		cfg, _, err := loadConfig(*configFlag, true)
+		if err != nil {
+			slog.Error("Failed to load --config", "err", err)
+			return 2
+		}
+		if cfg.Bus != "" {
+			mainBus = cfg.Bus
+		}
+		withoutMux = cfg.Mux == nil
+		if cfg.Mux != nil && cfg.Mux.Type != "" {
+			muxType = cfg.Mux.Type
+		}
+		if cfg.Mux != nil && cfg.Mux.Address != "" {
+			muxSpec = cfg.Mux.Address
+		}
+		for _, s := range cfg.Sensors {
+			if s.MuxPath != "" {
+				nested = append(nested, s)
+				continue
+			}
+			t := selftestTarget{name: s.Name, chip: s.Chip, bus: s.Bus, channel: -1}
+			if t.chip == "" {
+				t.chip = chipINA260
+			}
+			if t.bus == "" {
+				t.bus = mainBus
+			}
+			if s.Channel != nil {
+				t.channel = *s.Channel
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	muxModel, err := tca9548a.ModelByName(muxType)
+	if err != nil {
+		slog.Error("Invalid --mux.type", "err", err)
+		return 2
+	}
+	var muxAddresses []muxAddress
+	if !withoutMux {
+		if muxAddresses, err = parseMuxAddresses(muxSpec, muxModel); err != nil {
+			slog.Error("Invalid --tca-address", "err", err)
+			return 2
+		}
+	}
+	if *configFlag == "" {
+		if _, ok := selftestIDs[*chipFlag]; !ok && *chipFlag != chipINA260 && *chipFlag != chipINA219 && *chipFlag != chipINA226 {
+			slog.Error("Invalid --chip", "chip", *chipFlag)
+			return 2
+		}
+		if withoutMux {
+			targets = []selftestTarget{{chip: *chipFlag, bus: mainBus, channel: -1}}
+		} else {
+			channels, err := parseChannels(*channelsFlag, len(muxAddresses)*muxModel.Channels)
+			if err != nil {
+				slog.Error("Invalid --channels", "err", err)
+				return 2
+			}
+			for _, ch := range channels {
+				targets = append(targets, selftestTarget{chip: *chipFlag, bus: mainBus, channel: ch})
+			}
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	report := &selftestReport{Hostname: hostname, Checks: []selftestCheck{}}
+	t := &selftest{report: report, muxModel: muxModel, muxes: muxAddresses,
+		bounds: selftestBounds{minVoltage: *minVoltageFlag, maxVoltage: *maxVoltageFlag, maxCurrent: *maxCurrentFlag}}
+	byBus := make(map[string][]selftestTarget)
+	var buses []string
+	for _, s := range targets {
+		if _, ok := byBus[s.bus]; !ok {
+			buses = append(buses, s.bus)
+		}
+		byBus[s.bus] = append(byBus[s.bus], s)
+	}
+	for i, name := range buses {
+		var bus i2c.BusCloser
+		if *simulateFlag {
+			opts := simulate.Options{MuxChannels: muxModel.Channels, Waveform: simulate.WaveformSine, Period: time.Minute, Voltage: 5, Current: 0.5, Noise: 0.01}
+			for _, a := range muxAddresses {
+				opts.Muxes = append(opts.Muxes, a.addr)
+			}
+			bus, err = simulate.NewBus(opts)
+		} else {
+			// host.Init is only needed once
+			bus, err = initializeI2C(context.Background(), name, *skipHostInitFlag || i > 0, 0, 0)
+		}
+		if err != nil {
+			t.add(selftestCheck{Check: "bus", Status: selftestFail, Bus: name, Error: err.Error()}, slog.With("sensor_bus", name))
+			continue
+		}
+		if name != mainBus {
+			// Label the devices of another bus as the exporter does
+			for j, s := range byBus[name] {
+				if s.name == "" && s.channel >= 0 {
+					byBus[name][j].name = fmt.Sprintf("%s_%s_%s_ch%d_%s", filepath.Base(name), muxModel.Name, muxAddresses[s.channel/muxModel.Channels].spec, s.channel%muxModel.Channels, s.chip)
+				}
+			}
+		}
+		t.runBus(bus, name, byBus[name])
+		bus.Close()
+	}
+
+	for _, s := range nested {
+		t.add(selftestCheck{Check: "identity", Status: selftestSkip, Bus: mainBus, Mux: s.MuxPath, Device: s.Name, Chip: s.Chip,
+			Error: "sensors behind nested muxes are not checked"}, slog.With("device", s.Name, "mux_path", s.MuxPath))
+	}
+
+	report.Passed = report.Failed == 0
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		slog.Error("Failed to encode the self-test report", "err", err)
+		return 1
+	}
+	if !report.Passed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// sensorStatus tracks what a SIGUSR1 snapshot reports about one sensor. The
+// polling loop updates it and the signal handler reads it, hence the mutex.
+type sensorStatus struct {
+	mu         sync.Mutex
+	last       ina260.Reading // most recent successful reading
+	readings   uint64         // successful readings
+	readErrors uint64         // failed readings
+	lastErr    error          // error of the last poll, nil if it succeeded
+}
+
+func (s *sensorStatus) recordReading(r ina260.Reading) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = r
+	s.readings++
+	s.lastErr = nil
+}
+
+func (s *sensorStatus) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readErrors++
+	s.lastErr = err
+}
+
+// lastPoll reports whether the sensor has been polled at all, and the error of the last poll.
+func (s *sensorStatus) lastPoll() (polled bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readings+s.readErrors > 0, s.lastErr
+}
+
+// snapshot returns the most recent successful reading and the reading counts.
+func (s *sensorStatus) snapshot() (last ina260.Reading, readings, readErrors uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.readings, s.readErrors
+}
+
+// logState writes one snapshot of the sensor to the log.
+func (s *sensorStatus) logState(logger *slog.Logger, health *sensorHealth) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	up := health.isUp()
+
+	if s.readings == 0 {
+		logger.Info("State (no reading yet)", "up", up, "readings", 0, "read_errors", s.readErrors)
+		return
+	}
+	logger.Info("State", "up", up, "readings", s.readings, "read_errors", s.readErrors, "last", s.last.Time.Format(exporter.TextTimeFormat),
+		"voltage", s.last.Voltage, "current", s.last.Current, "power", s.last.Power)
+}
+
+// logCounters writes the current value of every ina260_*_total counter registered
+// with Prometheus, one line per series.
+func logCounters() {
+	families, err := exporter.Gatherer.Gather()
+	if err != nil {
+		slog.Warn("State: failed to gather metrics", "err", err)
+		return
+	}
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "ina260_") || !strings.HasSuffix(family.GetName(), "_total") {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			slog.Info("State", "counter", family.GetName()+"{"+strings.Join(labels, ",")+"}", "value", m.GetCounter().GetValue())
+		}
+	}
+}
+
+// dumpStateOnSIGUSR1 logs the uptime, a snapshot of each sensor and the error
+// counters every time the process receives SIGUSR1, without interrupting polling.
+func dumpStateOnSIGUSR1(started time.Time, monitors *fleet) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			slog.Info("State", "uptime", time.Since(started).Round(time.Second))
+			for _, m := range monitors.list() {
+				m.status.logState(m.logger, m.health)
+			}
+			logCounters()
+		}
+	}()
+}
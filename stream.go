@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+)
+
+// Timing of the stream connections.
+const (
+	streamWriteTimeout = 10 * time.Second
+	streamPingInterval = 30 * time.Second
+	streamPongTimeout  = streamPingInterval + streamWriteTimeout // a WebSocket client that misses a ping is dropped
+)
+
+// streamBuffered bounds the readings queued for one client; a client that falls
+// further behind misses readings rather than holding up polling.
+const streamBuffered = 64
+
+// readingStream serves GET /api/v1/stream, which pushes every published reading
+// in the --fifo JSON format: one message per reading over a WebSocket, or one
+// event per reading as Server-Sent Events for any other request. ?device= limits
+// the stream to one device label. It is a sink, so clients see the same readings
+// as the other outputs, as soon as they are read. The StreamReadings gRPC call
+// subscribes to it as well.
+type readingStream struct {
+	upgrader websocket.Upgrader // rejects cross-origin requests, so other sites cannot read the stream
+
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+	closed  bool
+}
+
+// streamClient is one connected client.
+type streamClient struct {
+	device   string               // only readings of this device, "" for every device
+	readings chan streamedReading // closed by Close
+}
+
+// streamedReading is one published reading with the sensor it came from.
+type streamedReading struct {
+	sensor  *exporter.Sensor
+	reading ina260.Reading
+}
+
+func newReadingStream() *readingStream {
+	return &readingStream{clients: make(map[*streamClient]struct{})}
+}
+
+func (s *readingStream) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/stream", s.handle)
+}
+
+func (s *readingStream) handle(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.handleWebSocket(w, r)
+	} else {
+		s.handleEvents(w, r)
+	}
+}
+
+// subscribe adds a client of the readings of device, or of every device when it
+// is empty. It returns nil once the stream is closed.
+func (s *readingStream) subscribe(device string) *streamClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	c := &streamClient{device: device, readings: make(chan streamedReading, streamBuffered)}
+	s.clients[c] = struct{}{}
+	return c
+}
+
+// unsubscribe forgets a client, unless Close already did.
+func (s *readingStream) unsubscribe(c *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+// handleWebSocket upgrades the request and writes each reading as one text
+// message, until the client goes away or the stream is closed.
+func (s *readingStream) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Debug("Failed to upgrade stream to a WebSocket", "remote", r.RemoteAddr, "err", err)
+		return // Upgrade has replied with the error
+	}
+	defer conn.Close()
+	c := s.subscribe(r.URL.Query().Get("device"))
+	if c == nil {
+		return
+	}
+	defer s.unsubscribe(c)
+
+	// The client sends nothing but control frames; reading handles the pongs and
+	// notices when it closes the connection
+	gone := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(streamPongTimeout))
+	conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(streamPongTimeout)) })
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case sr, ok := <-c.readings:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(streamWriteTimeout))
+				return
+			}
+			msg, err := exporter.MarshalReadingJSON(sr.sensor, sr.reading)
+			if err != nil {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleEvents writes each reading as a Server-Sent Event with a data line,
+// and a comment line every streamPingInterval so proxies keep the connection
+// open, until the client goes away or the stream is closed.
+func (s *readingStream) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	c := s.subscribe(r.URL.Query().Get("device"))
+	if c == nil {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "shutting down"})
+		return
+	}
+	defer s.unsubscribe(c)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would otherwise hold the events back
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		var event []byte
+		select {
+		case <-r.Context().Done():
+			return
+		case sr, ok := <-c.readings:
+			if !ok {
+				return
+			}
+			msg, err := exporter.MarshalReadingJSON(sr.sensor, sr.reading)
+			if err != nil {
+				return
+			}
+			event = append(append([]byte("data: "), bytes.TrimSuffix(msg, []byte("\n"))...), "\n\n"...)
+		case <-ping.C:
+			event = []byte(": ping\n\n")
+		}
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := w.Write(event); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *readingStream) Name() string { return "stream" }
+
+func (s *readingStream) Publish(sensor *exporter.Sensor, r ina260.Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		if c.device != "" && c.device != sensor.Device {
+			continue
+		}
+		select {
+		case c.readings <- streamedReading{sensor, r}:
+		default: // a slow client misses this reading
+		}
+	}
+	return nil
+}
+
+// Close ends every stream, with a close message for the WebSocket clients.
+func (s *readingStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for c := range s.clients {
+		close(c.readings)
+		delete(s.clients, c)
+	}
+	return nil
+}
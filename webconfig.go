@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// webConfig is the --web.config.file, in the format of the Prometheus
+// exporter-toolkit, so the files of other exporters on the host can be reused:
+//
+//	tls_server_config:
+//	  cert_file: server.crt
+//	  key_file: server.key
+//	basic_auth_users:
+//	  alice: $2y$10$...
+//
+// Only the fields below are supported; any other is an error, so a setting is
+// never silently ignored.
+type webConfig struct {
+	TLSServerConfig  *webTLSConfig     `yaml:"tls_server_config"`
+	HTTPServerConfig webHTTPConfig     `yaml:"http_server_config"`
+	BasicAuthUsers   map[string]string `yaml:"basic_auth_users"` // user to bcrypt hash
+}
+
+// webTLSConfig holds the TLS settings. Relative paths are relative to the
+// directory of the file.
+type webTLSConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	ClientAuthType string `yaml:"client_auth_type"` // e.g. RequireAndVerifyClientCert
+	MinVersion     string `yaml:"min_version"`      // TLS10 to TLS13; TLS12 by default
+}
+
+// webHTTPConfig holds the response headers added to every response.
+type webHTTPConfig struct {
+	Headers map[string]string `yaml:"headers"`
+}
+
+// TLS versions and client auth types, named as in the exporter-toolkit.
+var (
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+)
+
+// loadWebConfig reads and checks a --web.config.file.
+func loadWebConfig(path string) (*webConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var c webConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("basic auth user %q: invalid bcrypt hash: %w", user, err)
+		}
+	}
+	if t := c.TLSServerConfig; t != nil {
+		dir := filepath.Dir(path)
+		for _, p := range []*string{&t.CertFile, &t.KeyFile, &t.ClientCAFile} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+	}
+	return &c, nil
+}
+
+// serverTLS returns the TLS settings of t. The certificate and key are read again
+// on every handshake, so a renewed certificate is served without a restart.
+func (t *webTLSConfig) serverTLS() (*tls.Config, error) {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file are required")
+	}
+	// Fail at startup rather than on the first handshake
+	if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+			return &cert, err
+		},
+	}
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid min_version %q: must be TLS10, TLS11, TLS12 or TLS13", t.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	authType, ok := clientAuthTypes[t.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q", t.ClientAuthType)
+	}
+	config.ClientAuth = authType
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", t.ClientCAFile)
+		}
+	} else if authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s needs client_ca_file", t.ClientAuthType)
+	}
+	return config, nil
+}
+
+// basicAuthCacheSize bounds the credentials remembered as checked.
+const basicAuthCacheSize = 100
+
+// basicAuth requires one of the users of a web config on every request. A bcrypt
+// comparison takes tens of milliseconds on a Pi, so the credentials that passed
+// are remembered, by hash, and a Prometheus scraping every few seconds costs one
+// comparison in all.
+type basicAuth struct {
+	users   map[string]string
+	headers map[string]string
+	next    http.Handler
+	// bypass, if set, lets a request through without a user, for a route that
+	// checks credentials of its own in the same Authorization header
+	bypass func(r *http.Request) bool
+
+	mu     sync.Mutex
+	passed map[[sha256.Size]byte]bool
+}
+
+// dummyHash is compared for an unknown user, so the response time does not
+// tell which users exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// handler wraps next with the basic auth and headers of c. Requests for which
+// bypass returns true skip the basic auth; bypass may be nil.
+func (c *webConfig) handler(next http.Handler, bypass func(r *http.Request) bool) http.Handler {
+	return &basicAuth{users: c.BasicAuthUsers, headers: c.HTTPServerConfig.Headers, next: next, bypass: bypass, passed: map[[sha256.Size]byte]bool{}}
+}
+
+// auth returns the basic auth of c without a handler, for the gRPC server.
+func (c *webConfig) auth() *basicAuth {
+	return &basicAuth{users: c.BasicAuthUsers, passed: map[[sha256.Size]byte]bool{}}
+}
+
+func (a *basicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for name, value := range a.headers {
+		w.Header().Set(name, value)
+	}
+	if len(a.users) > 0 && (a.bypass == nil || !a.bypass(r)) && !a.allowed(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rbp-control-i2c-multiplexer", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	a.next.ServeHTTP(w, r)
+}
+
+func (a *basicAuth) allowed(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	return ok && a.check(user, password)
+}
+
+// check reports whether password is the one of user.
+func (a *basicAuth) check(user, password string) bool {
+	hash, known := a.users[user]
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	a.mu.Lock()
+	passed := a.passed[key]
+	a.mu.Unlock()
+	if passed {
+		return true
+	}
+	if !known {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.passed) >= basicAuthCacheSize {
+		clear(a.passed)
+	}
+	a.passed[key] = true
+	return true
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+
+	"all4dich/rbp-control-i2c-multiplexer/pkg/driver"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/exporter"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina219"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina226"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/ina260"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/mcp9808"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/simulate"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tca9548a"
+	"all4dich/rbp-control-i2c-multiplexer/pkg/tmp117"
+)
+
+// target is one power monitor to poll: the mux channel it sits behind and its device label.
+type target struct {
+	dev      *i2c.Dev
+	bus      string          // bus from the config file, "" for --bus
+	muxes    *tca9548a.Group // the muxes of that bus; nil when the channel stays selected
+	mux      int             // index into the muxes
+	channel  int             // channel of that mux, -1 when connected directly
+	label    string
+	labels   map[string]string // extra labels from the config file
+	schedule pollSchedule
+	path     []tca9548a.Hop // cascaded muxes behind that channel, for a sensor behind nested muxes
+	pathMux  string         // the muxes of path, e.g. "0x70/3 -> 0x71", for logs and the API
+	pathChan int            // channel of the last mux of path the sensor is on
+}
+
+// monitorFactory makes the monitor of each target with the settings of the
+// command line, at startup and for the sensors added by discovery and reloads.
+type monitorFactory struct {
+	hostname   string
+	logHandler slog.Handler // for the loggers of sensors on other buses
+	chip       string
+	model      tca9548a.Model
+	addresses  []muxAddress
+	deselect   bool // --disable-after-read
+
+	scale             ina260.Scale
+	ina219Calibration ina219.Calibration
+	ina226Calibration ina226.Calibration
+	configChange      ina260.ConfigChange
+	alert             *ina260.Alert
+
+	retries        int
+	retryBackoff   time.Duration
+	timeouts       ina260.Timeouts
+	verifyWrites   bool
+	downAfter      int
+	upAfter        int
+	logTransitions bool
+	setUpRetry     time.Duration
+}
+
+// newMonitor returns the monitor of t, with its metrics, logger and mux gate.
+func (f *monitorFactory) newMonitor(t target) *monitor {
+	export := exporter.NewSensor(f.hostname, t.label, f.scale)
+	export.Labels = t.labels
+	exporter.SetDeviceLabels(t.label, t.labels)
+	logger, muxSpec, channel := slog.With("device", t.label), "", t.channel
+	if t.bus != "" {
+		logger = slog.New(f.logHandler).With("bus", t.bus, "device", t.label)
+	}
+	if t.channel >= 0 {
+		muxSpec = f.addresses[t.mux].spec
+		if t.path != nil {
+			muxSpec, channel = t.pathMux, t.pathChan
+		}
+		logger = logger.With(muxAttrs(muxSpec, channel)...)
+	}
+	logger = logger.With(labelAttrs(t.labels)...)
+	m := &monitor{
+		logger:  logger,
+		bus:     t.bus,
+		muxSpec: muxSpec,
+		channel: channel,
+		sensor: &ina260.Sensor{Dev: t.dev, Scale: f.scale, Retries: f.retries, RetryBackoff: f.retryBackoff,
+			Timeouts: f.timeouts, VerifyWrites: f.verifyWrites,
+			OnReadRetries: export.Metrics.ObserveReadRetries,
+			OnConfigure:   export.Metrics.ConfigWritten,
+			OnTransactionError: func(reg byte, err error) {
+				export.Metrics.TransactionFailed(reg, err)
+				logger.Debug("Register transaction failed", "register", fmt.Sprintf("0x%02X", reg), "err", err)
+			},
+			OnWriteMismatch: func(reg byte, wrote, readBack, mask uint16) {
+				export.Metrics.WriteVerifyFailed()
+				logger.Warn("Write to INA260 register did not stick", "register", fmt.Sprintf("0x%02X", reg),
+					"wrote", fmt.Sprintf("0x%04X", wrote), "read_back", fmt.Sprintf("0x%04X", readBack), "mask", fmt.Sprintf("0x%04X", mask))
+			}},
+		export: export,
+		health: &sensorHealth{up: true, downAfter: f.downAfter, upAfter: f.upAfter, gauge: export.Metrics.Up(),
+			logger: logger, logTransitions: f.logTransitions},
+		status:       &sensorStatus{},
+		schedule:     t.schedule,
+		configChange: f.configChange,
+		alert:        f.alert,
+		setUpRetry:   f.setUpRetry,
+		lastSuccess:  time.Now(),
+	}
+	m.sensor.OnRegisterRead = m.traceRegisterRead
+	switch f.chip {
+	case chipINA219:
+		m.shunt = &ina219.Sensor{Regs: m.sensor, Calibration: f.ina219Calibration}
+	case chipINA226:
+		m.shunt = &ina226.Sensor{Regs: m.sensor, Calibration: f.ina226Calibration}
+	}
+	if t.muxes != nil && t.channel >= 0 {
+		mask, _ := f.model.ChannelMask(t.channel) // Already validated by getDevice or on the other bus
+		m.gate = &muxGate{muxes: t.muxes, index: t.mux, mask: mask, path: t.path, deselect: f.deselect}
+		if m.gate.deselect {
+			m.gate.extra = export.Metrics.MuxExtraWrites()
+		}
+	}
+	return m
+}
+
+// channelWiring places the sensors that sit on a mux channel of their own,
+// away from the power monitors: the ADCs and the sensors of registered drivers,
+// such as the BME280 and the temperature sensors.
+type channelWiring struct {
+	hostname  string
+	bus       i2c.Bus // --bus
+	model     tca9548a.Model
+	addresses []muxAddress
+	muxes     *tca9548a.Group
+	deselect  bool // --disable-after-read
+	quiet     bool // do not print readings to stdout
+	// tune applies the command line settings of a chip, such as
+	// --mcp9808-resolution, to each sensor of a driver before it is probed
+	tune func(driver.Sensor)
+}
+
+// place returns the device label of the chip on channel ch, numbered across the
+// muxes, or name if given; the gate selecting that channel; and a logger with
+// both and the extra labels of the config file, which the metrics get as well.
+func (w *channelWiring) place(ch int, chip, name string, labels map[string]string) (string, *muxGate, *slog.Logger) {
+	mux, local := ch/w.model.Channels, ch%w.model.Channels
+	spec := w.addresses[mux].spec
+	label := fmt.Sprintf("%s_%s_ch%d_%s", w.model.Name, spec, local, chip)
+	if name != "" {
+		label = name
+	}
+	exporter.SetDeviceLabels(label, labels)
+	mask, _ := w.model.ChannelMask(local)
+	gate := &muxGate{muxes: w.muxes, index: mux, mask: mask, deselect: w.deselect}
+	if gate.deselect {
+		gate.extra = exporter.NewMetrics(w.hostname, label).MuxExtraWrites()
+	}
+	return label, gate, slog.With(slices.Concat([]any{"device", label}, muxAttrs(spec, local), labelAttrs(labels))...)
+}
+
+// channelSensors are the monitors of the sensors placed by channelWiring.
+type channelSensors struct {
+	adcs    []*adcMonitor
+	drivers []*driverMonitor
+}
+
+// init sets up every sensor, recording each in inventory. A sensor that does
+// not answer is skipped, like an unreachable --channels entry.
+func (c *channelSensors) init(inventory *startupInventory) {
+	adcs := c.adcs[:0]
+	for _, a := range c.adcs {
+		err := a.init()
+		inventory.record(a.device, false, err)
+		if err != nil {
+			a.logger.Warn("Skipping ADC", "err", err)
+			continue
+		}
+		a.logger.Info("Connected to sensor", "model", a.sensor.Model.String(), "full_scale", a.sensor.FullScale, "data_rate", a.sensor.DataRate)
+		adcs = append(adcs, a)
+	}
+	c.adcs = adcs
+	drivers := c.drivers[:0]
+	for _, d := range c.drivers {
+		err := d.init()
+		inventory.record(d.device, false, err)
+		if err != nil {
+			d.logger.Warn("Skipping sensor", "chip", d.chip, "err", err)
+			continue
+		}
+		d.logger.Info("Connected to sensor", "chip", d.chip)
+		drivers = append(drivers, d)
+	}
+	c.drivers = drivers
+}
+
+// run polls every sensor on its own schedule until ctx ends.
+func (c *channelSensors) run(ctx context.Context, errorBackoff time.Duration) {
+	var wg sync.WaitGroup
+	for _, a := range c.adcs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.run(ctx, errorBackoff)
+		}()
+	}
+	for _, d := range c.drivers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(ctx, errorBackoff)
+		}()
+	}
+	wg.Wait()
+}
+
+// hardware is what openHardware found on the buses: the muxes, the monitors of
+// the power monitors and the sensors on mux channels of their own.
+type hardware struct {
+	bus            i2c.BusCloser   // --bus, limited to --bus-max-rate
+	others         []i2c.BusCloser // the other buses of the config file
+	hostname       string
+	tcas           []*i2c.Dev // the TCA9548As, in --tca-address order; nil when they did not answer
+	otherTCAs      []*i2c.Dev // the TCA9548As on the other buses
+	muxes          *tca9548a.Group
+	targets        []target
+	monitors       []*monitor // of targets, then of the sensors on other buses
+	factory        *monitorFactory
+	polled         *fleet
+	channelSensors channelSensors
+	reloadable     bool // the sensors of the config file are reloaded on SIGHUP
+}
+
+// openHardware opens the buses of o and finds the muxes and sensors on them. It
+// returns errInitCancelled when a signal interrupts opening --bus.
+func openHardware(o *options) (hw *hardware, err error) {
+	if o.skipHostInit {
+		slog.Info("Skipping periph host initialization (--skip-host-init)")
+	}
+	hw = &hardware{}
+	defer func() {
+		if err != nil {
+			hw.Close()
+			hw = nil
+		}
+	}()
+	// Let SIGINT/SIGTERM interrupt startup while the bus is being opened
+	initCtx, stopInit := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	var bus i2c.BusCloser
+	var simOpts simulate.Options // the simulated buses of --simulate
+	if o.simulate {
+		simOpts = simulate.Options{MuxChannels: o.muxModel.Channels, Waveform: o.simulateWaveform, Period: o.simulatePeriod, Voltage: o.simulateVoltage, Current: o.simulateCurrent, Noise: o.simulateNoise}
+		if !o.withoutMultiplexer {
+			for _, a := range o.muxAddresses {
+				simOpts.Muxes = append(simOpts.Muxes, a.addr)
+			}
+		}
+		// The nested muxes of the config file are only on the main bus
+		mainOpts := simOpts
+		for _, s := range o.nested {
+			hops, _ := parseMuxPath(s.MuxPath) // Already validated by loadConfig
+			for k, h := range hops[1:] {
+				c := simulate.Cascade{Parent: hops[k].addr, Channel: hops[k].channel, Addr: h.addr}
+				if !slices.Contains(mainOpts.Cascaded, c) {
+					mainOpts.Cascaded = append(mainOpts.Cascaded, c)
+				}
+			}
+		}
+		if bus, err = simulate.NewBus(mainOpts); err != nil {
+			stopInit()
+			return nil, fmt.Errorf("Invalid simulation settings: %v", err)
+		}
+		slog.Warn("Using a simulated I2C bus (--simulate); readings are not real")
+	} else {
+		bus, err = initializeI2C(initCtx, o.bus, o.skipHostInit, o.initRetries, o.initRetryInterval) // Initialize I2C bus
+	}
+	stopInit()
+	if errors.Is(err, errInitCancelled) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize I2C: %v", err)
+	}
+	hw.bus = bus           // Closed by Close
+	var limiter *txLimiter // shared by every bus, for --bus-max-rate
+	if o.busMaxRate > 0 {
+		limiter = newTxLimiter(o.busMaxRate)
+		bus = &limitedBus{BusCloser: bus, limiter: limiter}
+		hw.bus = bus
+		slog.Info("Limiting the rate of I2C transactions", "bus_max_rate", o.busMaxRate)
+	}
+	if o.busLockDir != "" {
+		if err := busMu.addFile(o.busLockDir, o.bus); err != nil {
+			return nil, fmt.Errorf("Failed to set up --bus-lock-dir: %v", err)
+		}
+	}
+
+	// -------------------- Set Hostname Label --------------------
+	if hw.hostname, err = os.Hostname(); err != nil {
+		return nil, fmt.Errorf("Failed to get hostname: %v", err)
+	}
+	hostname, muxModel, muxAddresses := hw.hostname, o.muxModel, o.muxAddresses
+
+	var tcaAddressStr string = ""
+	var channelStr string = ""
+	if o.withoutMultiplexer {
+		slog.Info("Running without TCA9548A multiplexer, using the sensor directly")
+	} else {
+		// If TCA address and channel are provided, use them. --channel counts across
+		// the muxes, so it is resolved to one mux and its own channel number.
+		tcaAddressStr = muxAddresses[o.channel/muxModel.Channels].spec
+		channelStr = strconv.Itoa(o.channel % muxModel.Channels)
+		slog.Info("Running with TCA9548A multiplexer", "muxes", o.tcaAddress, "mux", tcaAddressStr, "channel", channelStr)
+
+		// Check that the multiplexers themselves are present before talking to the sensors behind them
+		for i, a := range muxAddresses {
+			tca := &i2c.Dev{Bus: bus, Addr: a.addr}
+			// Start from a known mux state: hardware reset if a pin is wired, software clear
+			// otherwise. The reset pin is pulsed once; the other muxes are cleared over I2C.
+			resetPin := ""
+			if i == 0 {
+				resetPin = o.muxResetGPIO
+			}
+			if err := tca9548a.Reset(tca, resetPin); err != nil {
+				if resetPin != "" {
+					return nil, fmt.Errorf("Failed to reset TCA9548A: %v", err)
+				}
+				slog.Warn("Failed to reset TCA9548A", "mux", a.spec, "err", err)
+			} else if resetPin != "" {
+				slog.Info("Reset TCA9548A via GPIO", "mux", a.spec, "gpio", resetPin)
+			}
+			if err := tca9548a.Probe(tca); err != nil {
+				if o.exitOnNoMuxAck {
+					return nil, fmt.Errorf("TCA9548A multiplexer not found: %v (check the --tca-address value, the A0-A2 strapping and the mux power supply)", err)
+				}
+				slog.Warn("TCA9548A multiplexer not found", "mux", a.spec, "err", err)
+			}
+			hw.tcas = append(hw.tcas, tca)
+		}
+	}
+
+	unreachable := 0 // targets whose sensor did not answer
+	if len(o.channels) > 0 {
+		for _, ch := range o.channels {
+			mux, local := ch/muxModel.Channels, ch%muxModel.Channels
+			dev, err := getDevice(bus, muxModel, muxAddresses[mux].spec, strconv.Itoa(local))
+			if len(hw.tcas) > 1 || o.disableAfterRead {
+				// Sensors behind different muxes share an address, so leave no channel
+				// routed here while the next mux is probed, nor with all channels off
+				// between accesses
+				if err := tca9548a.Reset(hw.tcas[mux], ""); err != nil {
+					slog.Warn("Failed to clear TCA9548A", "mux", muxAddresses[mux].spec, "err", err)
+				}
+			}
+			if err != nil {
+				// Polled in degraded mode, down until it is set up
+				slog.Warn("Sensor not reachable; polling the others and retrying it", "mux", muxAddresses[mux].spec, "channel", local, "retry_in", o.setUpRetryInterval, "err", err)
+				dev = &i2c.Dev{Bus: bus, Addr: ina260.Address}
+				unreachable++
+			} else {
+				slog.Info("Connected to sensor", "mux", muxAddresses[mux].spec, "channel", local)
+			}
+			label := fmt.Sprintf("%s_%s_ch%d_%s", muxModel.Name, muxAddresses[mux].spec, local, o.chip)
+			if name, ok := o.deviceNames[ch]; ok {
+				label = name
+			}
+			hw.targets = append(hw.targets, target{dev: dev, mux: mux, channel: local, label: label, labels: o.deviceLabels[ch], schedule: o.scheduleOf(ch)})
+		}
+		if unreachable == len(hw.targets) {
+			return nil, fmt.Errorf("No INA260 found on any of channels %s", o.channelsSpec)
+		}
+	} else {
+		dev, err := getDevice(bus, muxModel, tcaAddressStr, channelStr)
+		if err != nil {
+			if o.withoutMultiplexer {
+				return nil, fmt.Errorf("Failed to get INA260 device directly: %v", err)
+			}
+			slog.Warn("Failed to reach the sensor through TCA9548A, retrying without multiplexer", "mux", tcaAddressStr, "channel", channelStr, "err", err)
+			hw.tcas = nil
+			if dev, err = getDevice(bus, muxModel, "", ""); err != nil {
+				return nil, fmt.Errorf("Failed to get INA260 device directly: %v", err)
+			}
+			slog.Info("Connected to sensor directly")
+		} else {
+			slog.Info("Connected to sensor", muxAttrs(tcaAddressStr, o.channel%muxModel.Channels)...)
+		}
+		channel, mux := -1, 0
+		if hw.tcas != nil {
+			channel, mux = o.channel%muxModel.Channels, o.channel/muxModel.Channels
+		}
+		// -------------------- Set Device Label --------------------
+		label := fmt.Sprintf("%s_%s_ch%s_%s", muxModel.Name, tcaAddressStr, channelStr, o.chip)
+		configured := -1 // the channel the config file names the sensor by, even after falling back to a direct connection
+		if channelStr != "" {
+			configured = o.channel
+		}
+		if name, ok := o.deviceNames[configured]; ok {
+			label = name
+		}
+		hw.targets = append(hw.targets, target{dev: dev, mux: mux, channel: channel, label: label, labels: o.deviceLabels[configured], schedule: o.scheduleOf(configured)})
+	}
+
+	// The channel has to be selected before every access when several sensors share
+	// the muxes, or when --disable-after-read deselects it in between
+	if len(o.envChannels) > 0 && hw.tcas == nil {
+		return nil, fmt.Errorf("--bme280-channels, --mcp9808-channels, --tmp117-channels and ADCs on a channel require the TCA9548A multiplexer, which did not answer")
+	}
+	if o.discoverInterval > 0 && hw.tcas == nil {
+		return nil, fmt.Errorf("--discover-interval requires the TCA9548A multiplexer, which did not answer")
+	}
+	if len(o.nested) > 0 && hw.tcas == nil {
+		return nil, fmt.Errorf("Sensors with a mux_path require the TCA9548A multiplexer, which did not answer")
+	}
+	// The sensors of the config file are reloaded on SIGHUP, unless the command line or discovery picks the channels
+	hw.reloadable = o.cfg != nil && hw.tcas != nil && o.chip != chipINA3221 && !o.setFlags["channel"] && !o.setFlags["channels"] && o.discoverInterval == 0
+	if hw.tcas != nil && (len(o.channels) > 0 || len(o.envChannels) > 0 || o.disableAfterRead || o.muxVerify || hw.reloadable || o.discoverInterval > 0 || o.debugI2CToken != nil || len(o.nested) > 0) {
+		hw.muxes = muxModel.NewGroup(hw.tcas...)
+		// Sensors behind nested muxes take the channel of a mux of the group, then
+		// one of each mux cascaded behind it
+		for _, s := range o.nested {
+			hops, _ := parseMuxPath(s.MuxPath)
+			mux, _ := checkMuxPath(hops, muxAddresses, muxModel) // Both already checked
+			t := target{dev: &i2c.Dev{Bus: bus, Addr: ina260.Address}, mux: mux, channel: hops[0].channel,
+				label: fmt.Sprintf("%s_%s_ch%d", muxModel.Name, muxAddresses[mux].spec, hops[0].channel), labels: s.Labels, schedule: withSchedule(o.defaultSchedule, s.Interval, s.Jitter)}
+			parent := hw.muxes.Muxes[mux]
+			for k, h := range hops[1:] {
+				mask, _ := muxModel.ChannelMask(h.channel)
+				m, _ := hw.muxes.Cascade(parent, hops[k].channel, &i2c.Dev{Bus: bus, Addr: h.addr})
+				t.path = append(t.path, tca9548a.Hop{Mux: m, Mask: mask})
+				t.label += fmt.Sprintf("_0x%02X_ch%d", h.addr, h.channel)
+				parent = m
+			}
+			last := hops[len(hops)-1]
+			t.pathMux = fmt.Sprintf("%s -> 0x%02X", formatMuxPath(hops[:len(hops)-1]), last.addr)
+			t.pathChan = last.channel
+			t.label += "_" + o.chip
+			if s.Name != "" {
+				t.label = s.Name
+			}
+			hw.targets = append(hw.targets, t)
+		}
+		instrumentMuxes(hw.muxes, hostname, o.bus, o.muxVerify)
+	} else if o.disableAfterRead {
+		slog.Warn("--disable-after-read has no effect without a TCA9548A multiplexer")
+	}
+	for i := range hw.targets {
+		hw.targets[i].muxes = hw.muxes
+	}
+	hw.factory = &monitorFactory{
+		hostname:          hostname,
+		logHandler:        o.logHandler,
+		chip:              o.chip,
+		model:             muxModel,
+		addresses:         muxAddresses,
+		deselect:          o.disableAfterRead,
+		scale:             o.scale,
+		ina219Calibration: o.ina219Calibration,
+		ina226Calibration: o.ina226Calibration,
+		configChange:      o.configChange,
+		alert:             o.pinAlert,
+		retries:           o.readRetries,
+		retryBackoff:      o.retryBackoff,
+		timeouts:          o.timeouts,
+		verifyWrites:      o.verifyWrites,
+		downAfter:         o.downAfterCycles,
+		upAfter:           o.upAfterCycles,
+		logTransitions:    o.logTransitions,
+		setUpRetry:        o.setUpRetryInterval,
+	}
+	hw.monitors = make([]*monitor, 0, len(hw.targets))
+	for _, t := range hw.targets {
+		hw.monitors = append(hw.monitors, hw.factory.newMonitor(t))
+	}
+	// Power monitors of the config file on other buses; the ones with a channel sit
+	// behind muxes at the --tca-address addresses on their bus
+	if o.cfg != nil {
+		buses := make(map[string]i2c.Bus)
+		groups := make(map[string]*tca9548a.Group)
+		for _, s := range o.cfg.otherBusSensors() {
+			if _, ok := buses[s.Bus]; !ok {
+				var b i2c.BusCloser
+				var err error
+				if o.simulate {
+					opts := simOpts
+					if s.Channel == nil {
+						opts.Muxes = nil // One INA260 on the bus itself
+					}
+					b, err = simulate.NewBus(opts)
+				} else {
+					b, err = initializeI2C(context.Background(), s.Bus, o.skipHostInit, o.initRetries, o.initRetryInterval)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("Failed to initialize I2C bus %s: %v", s.Bus, err)
+				}
+				hw.others = append(hw.others, b)
+				if limiter != nil {
+					b = &limitedBus{BusCloser: b, limiter: limiter}
+				}
+				if o.busLockDir != "" {
+					if err := busMu.addFile(o.busLockDir, s.Bus); err != nil {
+						return nil, fmt.Errorf("Failed to set up --bus-lock-dir: %v", err)
+					}
+				}
+				var devs []*i2c.Dev
+				for _, a := range muxAddresses {
+					tca := &i2c.Dev{Bus: b, Addr: a.addr}
+					if err := tca9548a.Probe(tca); err != nil {
+						slog.Warn("TCA9548A multiplexer not found", "sensor_bus", s.Bus, "mux", a.spec, "err", err)
+					}
+					devs = append(devs, tca)
+				}
+				hw.otherTCAs = append(hw.otherTCAs, devs...)
+				buses[s.Bus], groups[s.Bus] = b, muxModel.NewGroup(devs...)
+				instrumentMuxes(groups[s.Bus], hostname, s.Bus, o.muxVerify)
+				slog.Info("Opened I2C bus for the sensors of the config file", "sensor_bus", s.Bus)
+			}
+			dev := &i2c.Dev{Bus: buses[s.Bus], Addr: ina260.Address}
+			t := target{dev: dev, bus: s.Bus, channel: -1, label: fmt.Sprintf("%s_%s", filepath.Base(s.Bus), o.chip),
+				labels: s.Labels, schedule: withSchedule(o.defaultSchedule, s.Interval, s.Jitter)}
+			if s.Channel != nil {
+				if *s.Channel >= len(muxAddresses)*muxModel.Channels {
+					return nil, fmt.Errorf("Invalid channel %d of the sensor on bus %s: must be between 0 and %d", *s.Channel, s.Bus, len(muxAddresses)*muxModel.Channels-1)
+				}
+				t.muxes, t.mux, t.channel = groups[s.Bus], *s.Channel/muxModel.Channels, *s.Channel%muxModel.Channels
+				t.label = fmt.Sprintf("%s_%s_%s_ch%d_%s", filepath.Base(s.Bus), muxModel.Name, muxAddresses[t.mux].spec, t.channel, o.chip)
+			}
+			if s.Name != "" {
+				t.label = s.Name
+			}
+			hw.monitors = append(hw.monitors, hw.factory.newMonitor(t))
+		}
+	}
+	hw.polled = &fleet{monitors: slices.Clone(hw.monitors)}
+	wiring := &channelWiring{hostname: hostname, bus: bus, model: muxModel, addresses: muxAddresses, muxes: hw.muxes, deselect: o.disableAfterRead, quiet: o.quiet,
+		tune: func(sensor driver.Sensor) {
+			switch s := sensor.(type) {
+			case *mcp9808.Sensor:
+				s.Resolution = o.mcp9808Resolution
+			case *tmp117.Sensor:
+				s.Averaging = o.tmp117Averaging
+			}
+		}}
+	for _, a := range o.adcs {
+		hw.channelSensors.adcs = append(hw.channelSensors.adcs, newADCMonitor(wiring, a, withSchedule(o.defaultSchedule, a.Interval, a.Jitter)))
+	}
+	for _, sc := range o.driverSensors {
+		d, err := newDriverMonitor(wiring, sc, o.pec, o.scheduleOf(*sc.Channel))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to set up the metrics of the %s on channel %d: %v", sc.Chip, *sc.Channel, err)
+		}
+		hw.channelSensors.drivers = append(hw.channelSensors.drivers, d)
+	}
+	if hw.muxes != nil && o.disableAfterRead {
+		// Nothing is routed until the first access selects a channel
+		busMu.Lock()
+		if err := hw.monitors[0].gate.close(); err != nil {
+			slog.Warn("Failed to deselect mux channels", "err", err)
+		}
+		busMu.Unlock()
+	}
+	return hw, nil
+}
+
+// Close closes the buses, the other buses of the config file first.
+func (hw *hardware) Close() {
+	for _, b := range slices.Backward(hw.others) {
+		b.Close()
+	}
+	if hw.bus != nil {
+		hw.bus.Close()
+	}
+}
+
+// watchChannels adds and removes the power monitors behind the mux channels
+// while running: those --discover-interval finds, and those of the config file
+// on SIGHUP when it is reloadable.
+func (hw *hardware) watchChannels(ctx context.Context, o *options) {
+	muxModel, muxAddresses := o.muxModel, o.muxAddresses
+	// Sensors added while running sit behind a mux channel, numbered across the muxes
+	polledChannels := make(map[int]*monitor)
+	for i, t := range hw.targets {
+		if t.channel >= 0 && t.path == nil {
+			polledChannels[t.mux*muxModel.Channels+t.channel] = hw.monitors[i]
+		}
+	}
+	channelLabel := func(channel int) string {
+		return fmt.Sprintf("%s_%s_ch%d_%s", muxModel.Name, muxAddresses[channel/muxModel.Channels].spec, channel%muxModel.Channels, o.chip)
+	}
+	setUp := func(channel int, label string, labels map[string]string, schedule pollSchedule) (*monitor, error) {
+		m := hw.factory.newMonitor(target{dev: &i2c.Dev{Bus: hw.bus, Addr: ina260.Address}, mux: channel / muxModel.Channels, channel: channel % muxModel.Channels, label: label, labels: labels, schedule: schedule})
+		if err := m.setUpSensor(o.coincident); err != nil {
+			return nil, err
+		}
+		m.health.gauge.Set(1)
+		return m, nil
+	}
+	if o.discoverInterval > 0 {
+		d := &discoverer{bus: hw.bus, muxes: hw.muxes, model: muxModel, deselect: o.disableAfterRead, hostname: hw.hostname, chip: o.chip,
+			fleet: hw.polled, channels: polledChannels, skip: make(map[int]bool), create: func(channel int) (*monitor, error) {
+				label := channelLabel(channel)
+				if name, ok := o.deviceNames[channel]; ok {
+					label = name
+				}
+				return setUp(channel, label, o.deviceLabels[channel], o.scheduleOf(channel))
+			}}
+		d.label = func(channel int) string {
+			if name, ok := o.deviceNames[channel]; ok {
+				return name
+			}
+			return channelLabel(channel)
+		}
+		for _, a := range muxAddresses {
+			d.specs = append(d.specs, a.spec)
+		}
+		for _, ch := range o.envChannels {
+			d.skip[ch] = true
+		}
+		for _, t := range hw.targets {
+			if t.path != nil {
+				d.skip[t.mux*muxModel.Channels+t.channel] = true // leads to a nested mux
+			}
+		}
+		slog.Info("Discovering sensors on every mux channel", "discover_interval", o.discoverInterval)
+		d.watch(ctx, o.discoverInterval)
+	}
+	if hw.reloadable {
+		r := &reloader{path: o.config, failFast: o.failFastOnConfigError, cfg: o.cfg, fleet: hw.polled, channels: polledChannels, total: len(hw.tcas) * muxModel.Channels,
+			label: channelLabel, create: setUp, schedule: o.defaultSchedule}
+		r.watch(ctx)
+	} else if o.cfg != nil {
+		warnOnSIGHUP()
+	}
+}